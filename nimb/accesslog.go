@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxRequestIDLen bounds a client-supplied X-Request-Id
+const maxRequestIDLen = 128
+
+// requestIDKey holds the request id in a request's context
+type requestIDKey struct{}
+
+// requestIDFrom returns the id of the request ctx belongs to, empty
+// outside a request
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID makes a random request id
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether a client-supplied id can be used as is.
+// Anything that could break a log line is refused.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' || c == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+// accessWriter records the status and size of a response. It passes
+// Flush and Hijack through so streams and upgrades keep working.
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (aw *accessWriter) WriteHeader(code int) {
+	if aw.status == 0 {
+		aw.status = code
+	}
+	aw.ResponseWriter.WriteHeader(code)
+}
+
+func (aw *accessWriter) Write(b []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	n, err := aw.ResponseWriter.Write(b)
+	aw.bytes += int64(n)
+	return n, err
+}
+
+func (aw *accessWriter) Flush() {
+	if f, ok := aw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (aw *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := aw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijacking not supported")
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (aw *accessWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
+
+// quietRequest reports whether a request is only logged at debug level:
+// static UI files, health polling and NIMB's own tunnel checks, as long
+// as they succeed
+func quietRequest(r *http.Request, status int) bool {
+	if status >= 400 {
+		return false
+	}
+	path := r.URL.Path
+	if path == "/health" || path == "/api/health" || isTunnelProbe(r) {
+		return true
+	}
+	return !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/v1/") && path != "/metrics"
+}
+
+// accessLog gives every request an id, taken from the client's
+// X-Request-Id when it sends a usable one, returns it in X-Request-Id and
+// logs one line per request once it is answered
+func (a *App) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-Id")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-Id", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+		aw := &accessWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r)
+
+		status := aw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := LogLevelInfo
+		if quietRequest(r, status) {
+			level = LogLevelDebug
+		}
+		if !logs.enabled(level) {
+			return
+		}
+		logs.write(level, fmt.Sprintf("%s %s status=%d duration=%dms bytes=%d ip=%s id=%s",
+			r.Method, r.URL.Path, status, time.Since(start).Milliseconds(), aw.bytes, a.clientIP(r), id))
+	})
+}
+
+// logRequestError adds an error log entry for a failed request, carrying
+// its route and id
+func (a *App) logRequestError(r *http.Request, msg string, code int) {
+	a.logErrorItem(ErrorItem{Message: msg, Code: code, Route: r.URL.Path, ClientRequestID: requestIDFrom(r.Context())})
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookie  = "nimb_session"
+	sessionTTL     = 7 * 24 * time.Hour
+	maxSessions    = 10
+	minPassword    = 8
+	maxLoginDelay  = 5 * time.Minute
+	maxLoginIPs    = 1024
+	adminSaltBytes = 16
+)
+
+// publicAPIRoutes are the /api/ paths served without logging in: the
+// login flow itself and the Ollama-compatible proxy endpoints, which
+// check the proxy key instead
+var publicAPIRoutes = map[string]bool{
+	"/api/session":  true,
+	"/api/setup":    true,
+	"/api/login":    true,
+	"/api/logout":   true,
+	"/api/tls/cert": true,
+	"/api/chat":     true,
+	"/api/tags":     true,
+}
+
+// adminSecret is the stored form of the admin password
+type adminSecret struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Hash       []byte `json:"hash"`
+}
+
+func hashPassword(password string) (*adminSecret, error) {
+	salt := make([]byte, adminSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &adminSecret{
+		KDF:        vaultKDF,
+		Iterations: vaultIterations,
+		Salt:       salt,
+		Hash:       pbkdf2SHA256([]byte(password), salt, vaultIterations, 32),
+	}, nil
+}
+
+func (s *adminSecret) check(password string) bool {
+	if s.KDF != vaultKDF || s.Iterations < 1 {
+		return false
+	}
+	return hmac.Equal(pbkdf2SHA256([]byte(password), s.Salt, s.Iterations, 32), s.Hash)
+}
+
+// loginFailures tracks wrong passwords from one address
+type loginFailures struct {
+	count   int
+	retryAt time.Time
+}
+
+// session is a login, stored under the SHA-256 of its token so the file
+// on disk can't be used to log in
+type session struct {
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// adminAuth guards the management API with a password and sessions
+type adminAuth struct {
+	mu     sync.Mutex
+	secret *adminSecret
+	// verified is the SHA-256 of the last password that checked out, so
+	// Basic auth clients don't pay for the key derivation on every request
+	verified []byte
+	sessions map[string]session
+	failures map[string]*loginFailures
+}
+
+func (a *App) adminPath() string {
+	return filepath.Join(a.settingsDir, "admin.json")
+}
+
+func (a *App) sessionsPath() string {
+	return filepath.Join(a.settingsDir, "sessions.json")
+}
+
+// tokenHash is the key a session token is stored under
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadSessions restores the logins that were live at the last shutdown
+func (a *App) loadSessions() {
+	data, err := os.ReadFile(a.sessionsPath())
+	if err != nil {
+		return
+	}
+	sessions := map[string]session{}
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		logWarnf("Ignoring unreadable sessions file: %v", err)
+		return
+	}
+	a.admin.mu.Lock()
+	a.admin.sessions = sessions
+	a.pruneSessionsLocked()
+	a.admin.mu.Unlock()
+}
+
+// saveSessionsLocked writes the session hashes. Callers must hold
+// a.admin.mu.
+func (a *App) saveSessionsLocked() {
+	data, err := json.MarshalIndent(a.admin.sessions, "", "  ")
+	if err == nil {
+		err = os.WriteFile(a.sessionsPath(), data, 0600)
+	}
+	if err != nil {
+		logWarnf("Could not save sessions: %v", err)
+	}
+}
+
+// pruneSessionsLocked drops expired sessions, then the oldest ones until
+// there is room for a new one
+func (a *App) pruneSessionsLocked() {
+	now := time.Now()
+	for hash, s := range a.admin.sessions {
+		if now.After(s.Expires) {
+			delete(a.admin.sessions, hash)
+		}
+	}
+	for len(a.admin.sessions) >= maxSessions {
+		oldest := ""
+		for hash, s := range a.admin.sessions {
+			if oldest == "" || s.Created.Before(a.admin.sessions[oldest].Created) {
+				oldest = hash
+			}
+		}
+		delete(a.admin.sessions, oldest)
+	}
+}
+
+// loadAdmin sets up the admin password from NIMB_ADMIN_PASSWORD or
+// admin.json. Without either the API waits for POST /api/setup.
+func (a *App) loadAdmin(envPassword string) {
+	if envPassword != "" {
+		secret, err := hashPassword(envPassword)
+		if err != nil {
+			logErrorf("Could not use NIMB_ADMIN_PASSWORD: %v", err)
+			return
+		}
+		a.admin.secret = secret
+		a.loadSessions()
+		return
+	}
+
+	data, err := os.ReadFile(a.adminPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		logWarnf("No admin password yet; open the UI on this device to set one")
+		// Sessions from before a password reset must not carry over
+		os.Remove(a.sessionsPath())
+		return
+	}
+	var secret adminSecret
+	if err == nil {
+		err = json.Unmarshal(data, &secret)
+	}
+	if err != nil {
+		logWarnf("Ignoring unreadable admin password file: %v", err)
+		return
+	}
+	a.admin.secret = &secret
+	a.loadSessions()
+}
+
+func (a *App) adminConfigured() bool {
+	a.admin.mu.Lock()
+	defer a.admin.mu.Unlock()
+	return a.admin.secret != nil
+}
+
+// checkPassword verifies a password from ip. A wrong one doubles that
+// address's wait before the next try; wait is set while it lasts.
+func (a *App) checkPassword(ip, password string) (ok bool, wait time.Duration) {
+	a.admin.mu.Lock()
+	f := a.admin.failures[ip]
+	if f != nil {
+		if wait := time.Until(f.retryAt); wait > 0 {
+			a.admin.mu.Unlock()
+			return false, wait
+		}
+	}
+	secret, verified := a.admin.secret, a.admin.verified
+	a.admin.mu.Unlock()
+	if secret == nil {
+		return false, 0
+	}
+
+	sum := sha256.Sum256([]byte(password))
+	ok = verified != nil && subtle.ConstantTimeCompare(sum[:], verified) == 1
+	if !ok {
+		ok = secret.check(password)
+	}
+
+	a.admin.mu.Lock()
+	defer a.admin.mu.Unlock()
+	if ok {
+		a.admin.verified = sum[:]
+		delete(a.admin.failures, ip)
+		return true, 0
+	}
+	if a.admin.failures == nil {
+		a.admin.failures = map[string]*loginFailures{}
+	}
+	if f == nil {
+		if len(a.admin.failures) >= maxLoginIPs {
+			a.pruneFailuresLocked()
+		}
+		f = &loginFailures{}
+		a.admin.failures[ip] = f
+	}
+	f.count++
+	f.retryAt = time.Now().Add(min(time.Second<<min(f.count-1, 16), maxLoginDelay))
+	logWarnf("Wrong admin password from %s (%d in a row)", ip, f.count)
+	return false, 0
+}
+
+// pruneFailuresLocked forgets addresses whose back-off has run out
+func (a *App) pruneFailuresLocked() {
+	now := time.Now()
+	for ip, f := range a.admin.failures {
+		if now.Sub(f.retryAt) > maxLoginDelay {
+			delete(a.admin.failures, ip)
+		}
+	}
+}
+
+// newSession issues a session token and sets it as a cookie. Past
+// maxSessions the oldest session is logged out.
+func (a *App) newSession(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	a.admin.mu.Lock()
+	if a.admin.sessions == nil {
+		a.admin.sessions = map[string]session{}
+	}
+	a.pruneSessionsLocked()
+	now := time.Now()
+	a.admin.sessions[tokenHash(token)] = session{Created: now, Expires: now.Add(sessionTTL)}
+	a.saveSessionsLocked()
+	a.admin.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// currentSession returns the live session the request's cookie belongs to
+func (a *App) currentSession(r *http.Request) (session, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return session{}, false
+	}
+	a.admin.mu.Lock()
+	defer a.admin.mu.Unlock()
+	s, ok := a.admin.sessions[tokenHash(c.Value)]
+	return s, ok && time.Now().Before(s.Expires)
+}
+
+// authenticate checks a session cookie or Basic auth credentials. Any user
+// name is accepted with Basic auth; only the password counts.
+func (a *App) authenticate(r *http.Request) (ok bool, wait time.Duration) {
+	if _, ok := a.currentSession(r); ok {
+		return true, 0
+	}
+	if _, password, found := r.BasicAuth(); found {
+		return a.checkPassword(a.clientIP(r), password)
+	}
+	return false, 0
+}
+
+// requireAdmin protects the management API. Until a password is set only
+// the login flow answers, so nothing can be read or changed before setup.
+func (a *App) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || publicAPIRoutes[r.URL.Path] || r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !a.adminConfigured() {
+			writeAPIError(w, 401, "Set an admin password first, from the UI on this device or with NIMB_ADMIN_PASSWORD", "setup_required")
+			return
+		}
+		ok, wait := a.authenticate(r)
+		if wait > 0 {
+			writeLoginRetry(w, wait)
+			return
+		}
+		if !ok {
+			writeAPIError(w, 401, "Login required", "auth_required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeLoginRetry(w http.ResponseWriter, wait time.Duration) {
+	secs := int(wait.Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeAPIError(w, 429, fmt.Sprintf("Too many wrong passwords, retry in %ds", secs), "rate_limit_error")
+}
+
+// readPassword decodes a {password} request body
+func readPassword(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return req.Password, true
+}
+
+// handleSession tells the UI whether to show setup, login or the app
+func (a *App) handleSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resp := map[string]interface{}{"configured": a.adminConfigured()}
+	if s, ok := a.currentSession(r); ok {
+		resp["authenticated"] = true
+		resp["expiresAt"] = s.Expires.Format(time.RFC3339)
+	} else {
+		ok, _ := a.authenticate(r)
+		resp["authenticated"] = ok
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleSetup sets the first admin password. It only answers on this
+// device, so a tunnel or LAN client can't claim a fresh install.
+func (a *App) handleSetup(w http.ResponseWriter, r *http.Request) {
+	password, ok := readPassword(w, r)
+	if !ok {
+		return
+	}
+	if !isLoopback(a.clientIP(r)) {
+		writeAPIError(w, 403, "Setup is only allowed from this device", "permission_error")
+		return
+	}
+	if len(password) < minPassword {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "password must be at least " + strconv.Itoa(minPassword) + " characters",
+		})
+		return
+	}
+
+	if a.adminConfigured() {
+		writeAPIError(w, 409, "An admin password is already set", "invalid_request_error")
+		return
+	}
+
+	secret, err := hashPassword(password)
+	var data []byte
+	if err == nil {
+		data, err = json.MarshalIndent(secret, "", "  ")
+	}
+	if err == nil {
+		err = os.WriteFile(a.adminPath(), data, 0600)
+	}
+	if err != nil {
+		writeAPIError(w, 500, "Could not save the password: "+err.Error(), "server_error")
+		return
+	}
+	a.admin.mu.Lock()
+	a.admin.secret = secret
+	a.admin.sessions = map[string]session{}
+	a.admin.mu.Unlock()
+
+	logInfof("Admin password set")
+	a.newSession(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// handleLogin exchanges the admin password for a session cookie
+func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
+	password, ok := readPassword(w, r)
+	if !ok {
+		return
+	}
+	if !a.adminConfigured() {
+		writeAPIError(w, 401, "No admin password is set yet", "setup_required")
+		return
+	}
+
+	ok, wait := a.checkPassword(a.clientIP(r), password)
+	if wait > 0 {
+		writeLoginRetry(w, wait)
+		return
+	}
+	if !ok {
+		a.logErrorItem(ErrorItem{Message: "Failed admin login from " + a.clientIP(r), Code: 401, Route: r.URL.Path})
+		writeAPIError(w, 401, "Wrong password", "authentication_error")
+		return
+	}
+
+	a.newSession(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// handleLogout ends the current session
+func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		a.admin.mu.Lock()
+		delete(a.admin.sessions, tokenHash(c.Value))
+		a.saveSessionsLocked()
+		a.admin.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
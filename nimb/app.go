@@ -1,18 +1,16 @@
 package main
 
 import (
-	"bytes"
-	"context"
-	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"io"
-	"log"
-	"net"
+	"io/fs"
+	"maps"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -20,27 +18,199 @@ import (
 
 // Config holds the app configuration
 type Config struct {
-	ShowReasoning    bool    `json:"showReasoning"`
-	EnableThinking   bool    `json:"enableThinking"`
-	LogRequests      bool    `json:"logRequests"`
-	ContextSize      int     `json:"contextSize"`
-	MaxTokens        int     `json:"maxTokens"`
-	Temperature      float64 `json:"temperature"`
-	StreamingEnabled bool    `json:"streamingEnabled"`
-	CurrentModel     string  `json:"currentModel"`
-	APIKey           string  `json:"apiKey,omitempty"`
+	ShowReasoning          bool              `json:"showReasoning"`
+	EnableThinking         bool              `json:"enableThinking"`
+	LogRequests            bool              `json:"logRequests"`
+	LogLevel               string            `json:"logLevel"`
+	LogFormat              string            `json:"logFormat"`
+	LogToFile              bool              `json:"logToFile"`
+	LogFile                string            `json:"logFile"`
+	LogFileMaxBytes        int64             `json:"logFileMaxBytes"`
+	LogFileKeep            int               `json:"logFileKeep"`
+	ContextSize            int               `json:"contextSize"`
+	MaxTokens              int               `json:"maxTokens"`
+	Temperature            float64           `json:"temperature"`
+	StreamingEnabled       bool              `json:"streamingEnabled"`
+	CurrentModel           string            `json:"currentModel"`
+	UpstreamBaseURL        string            `json:"upstreamBaseUrl"`
+	MaxRetries             int               `json:"maxRetries"`
+	RetryBaseDelayMs       int               `json:"retryBaseDelayMs"`
+	DisableToolForwarding  bool              `json:"disableToolForwarding"`
+	ModelOverrideMode      string            `json:"modelOverrideMode"`
+	ModelAliases           map[string]string `json:"modelAliases"`
+	CompletionModels       []string          `json:"completionModels"`
+	AllowClientKeys        bool              `json:"allowClientKeys"`
+	MaxRequestBytes        int64             `json:"maxRequestBytes"`
+	MaxConcurrentRequests  int               `json:"maxConcurrentRequests"`
+	QueueMode              string            `json:"queueMode"`
+	QueueTimeoutMs         int               `json:"queueTimeoutMs"`
+	RateLimitRPM           int               `json:"rateLimitRpm"`
+	RateLimitBurst         int               `json:"rateLimitBurst"`
+	RateLimitExemptLocal   bool              `json:"rateLimitExemptLocal"`
+	DailyTokenBudget       int               `json:"dailyTokenBudget"`
+	IdempotencyWindowSec   int               `json:"idempotencyWindowSec"`
+	FallbackModels         []string          `json:"fallbackModels"`
+	BreakerThreshold       int               `json:"breakerThreshold"`
+	BreakerWindowSec       int               `json:"breakerWindowSec"`
+	BreakerCooldownSec     int               `json:"breakerCooldownSec"`
+	ContextMode            string            `json:"contextMode"`
+	SystemPrompt           string            `json:"systemPrompt"`
+	SystemPromptMode       string            `json:"systemPromptMode"`
+	PassthroughPrefix      string            `json:"passthroughPrefix"`
+	ImageMaxBytes          int               `json:"imageMaxBytes"`
+	TextOnlyModels         []string          `json:"textOnlyModels"`
+	CABundlePath           string            `json:"caBundlePath"`
+	AllowInsecureTLS       bool              `json:"allowInsecureTLS"`
+	DNSMode                string            `json:"dnsMode"`
+	DNSServers             []string          `json:"dnsServers"`
+	DoHURL                 string            `json:"dohUrl"`
+	OutboundProxyURL       string            `json:"outboundProxyUrl"`
+	ConnectTimeoutSec      int               `json:"connectTimeoutSec"`
+	RequestTimeoutSec      int               `json:"requestTimeoutSec"`
+	StreamFirstByteSec     int               `json:"streamFirstByteSec"`
+	UpstreamHeaders        map[string]string `json:"upstreamHeaders"`
+	AuthHeaderStyle        string            `json:"authHeaderStyle"`
+	StreamKeepaliveSec     int               `json:"streamKeepaliveSec"`
+	UpstreamStreamMode     string            `json:"upstreamStreamMode"`
+	ForwardHeaders         []string          `json:"forwardHeaders"`
+	LogMessageContent      bool              `json:"logMessageContent"`
+	RequestLogMaxBytes     int64             `json:"requestLogMaxBytes"`
+	RequestLogKeep         int               `json:"requestLogKeep"`
+	HistorySize            int               `json:"historySize"`
+	KeyRotation            string            `json:"keyRotation"`
+	KeyCooldownSec         int               `json:"keyCooldownSec"`
+	MockMode               bool              `json:"mockMode"`
+	MockDelayMs            int               `json:"mockDelayMs"`
+	JobTTLSec              int               `json:"jobTtlSec"`
+	MaxPendingJobs         int               `json:"maxPendingJobs"`
+	GuardModel             string            `json:"guardModel"`
+	GuardFailClosed        bool              `json:"guardFailClosed"`
+	Rules                  []Rule            `json:"rules"`
+	Providers              []Provider        `json:"providers"`
+	RoutingPolicy          string            `json:"routingPolicy"`
+	ProviderPins           map[string]string `json:"providerPins"`
+	EnablePprof            bool              `json:"enablePprof"`
+	WatchSettings          bool              `json:"watchSettings"`
+	ListenAddress          string            `json:"listenAddress"`
+	WebhookURL             string            `json:"webhookUrl"`
+	WebhookEvents          []string          `json:"webhookEvents"`
+	WebhookFormat          string            `json:"webhookFormat"`
+	ProxyAPIKey            string            `json:"proxyApiKey"`
+	ProxyKeyLocalBypass    bool              `json:"proxyKeyLocalBypass"`
+	CORSOrigins            []string          `json:"corsOrigins"`
+	CORSAllowAll           bool              `json:"corsAllowAll"`
+	TunnelExposure         string            `json:"tunnelExposure"`
+	AllowedIPs             []string          `json:"allowedIps"`
+	BlockedIPs             []string          `json:"blockedIps"`
+	TLSEnabled             bool              `json:"tlsEnabled"`
+	TLSCertPath            string            `json:"tlsCertPath"`
+	TLSKeyPath             string            `json:"tlsKeyPath"`
+	TLSHostname            string            `json:"tlsHostname"`
+	HTTPRedirectPort       int               `json:"httpRedirectPort"`
+	TunnelAutoStart        bool              `json:"tunnelAutoStart"`
+	ShutdownGraceSec       int               `json:"shutdownGraceSec"`
+	CheckUpdates           bool              `json:"checkUpdates"`
+	TunnelAutoRestart      bool              `json:"tunnelAutoRestart"`
+	TunnelMaxRestarts      int               `json:"tunnelMaxRestarts"`
+	TunnelStartTimeoutSec  int               `json:"tunnelStartTimeoutSec"`
+	TunnelProbeIntervalSec int               `json:"tunnelProbeIntervalSec"`
+	TunnelProbeRestart     bool              `json:"tunnelProbeRestart"`
+	TunnelToken            string            `json:"tunnelToken"`
+	TunnelCredentialsFile  string            `json:"tunnelCredentialsFile"`
+	TunnelHostname         string            `json:"tunnelHostname"`
+	TunnelProvider         string            `json:"tunnelProvider"`
+	NgrokAuthtoken         string            `json:"ngrokAuthtoken"`
+	TunnelSSHTarget        string            `json:"tunnelSshTarget"`
+	TunnelSSHRemotePort    int               `json:"tunnelSshRemotePort"`
+	TunnelSSHKeyPath       string            `json:"tunnelSshKeyPath"`
+	TunnelTargetURL        string            `json:"tunnelTargetUrl"`
+	NotifyOnTunnelURL      bool              `json:"notifyOnTunnelUrl"`
+	APIKeys                []string          `json:"apiKeys,omitempty"`
+
+	// APIKey is the key a request is sent with, picked from APIKeys or
+	// taken from the client. It is never saved; settings files from before
+	// key pools had a single "apiKey", which UnmarshalJSON moves into APIKeys.
+	APIKey string `json:"-"`
+}
+
+// UnmarshalJSON decodes a config, accepting the legacy single "apiKey" field
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type plain Config
+	aux := struct {
+		*plain
+		LegacyKey string `json:"apiKey"`
+	}{plain: (*plain)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	// A masked key is the one from GET /api/config sent back unchanged
+	if aux.LegacyKey != "" && !isMaskedKey(aux.LegacyKey) && !slices.Contains(c.APIKeys, aux.LegacyKey) {
+		c.APIKeys = append([]string{aux.LegacyKey}, c.APIKeys...)
+	}
+	return nil
 }
 
 // Stats holds usage statistics
 type Stats struct {
-	MessageCount     int         `json:"messageCount"`
-	PromptTokens     int         `json:"promptTokens"`
-	CompletionTokens int         `json:"completionTokens"`
-	TotalTokens      int         `json:"totalTokens"`
-	ErrorCount       int         `json:"errorCount"`
-	LastRequestTime  string      `json:"lastRequestTime"`
-	StartTime        string      `json:"startTime"`
-	ErrorLog         []ErrorItem `json:"errorLog"`
+	MessageCount     int `json:"messageCount"`
+	PromptTokens     int `json:"promptTokens"`
+	CompletionTokens int `json:"completionTokens"`
+	TotalTokens      int `json:"totalTokens"`
+	ReasoningTokens  int `json:"reasoningTokens"`
+
+	// Estimated* count tokens guessed with tokenCounter for responses
+	// that came back without usage; they are not part of the totals above
+	EstimatedPromptTokens     int `json:"estimatedPromptTokens"`
+	EstimatedCompletionTokens int `json:"estimatedCompletionTokens"`
+
+	ErrorCount      int                  `json:"errorCount"`
+	RetryCount      int                  `json:"retryCount"`
+	FallbackCount   int                  `json:"fallbackCount"`
+	TrimCount       int                  `json:"trimCount"`
+	ImageMessages   int                  `json:"imageMessages"`
+	BenchmarkRuns   int                  `json:"benchmarkRuns"`
+	BlockedRequests int                  `json:"blockedRequests"`
+	RejectedKeys    int                  `json:"rejectedKeys"`
+	LastRequestTime string               `json:"lastRequestTime"`
+	StartTime       string               `json:"startTime"`
+	ErrorLog        []ErrorItem          `json:"errorLog"`
+	UpstreamErrors  map[string]int       `json:"upstreamErrors"`
+	StatusClasses   map[string]int       `json:"statusClasses"`
+	Routes          map[string]int       `json:"routes"`
+	KeyUsage        map[string]*KeyUsage `json:"keyUsage"`
+	ModelUsage      map[string]*KeyUsage `json:"modelUsage"`
+	InFlight        int                  `json:"inFlight"`
+	Queued          int                  `json:"queued"`
+	BudgetUsed      int                  `json:"budgetUsed"`
+	BudgetRemaining int                  `json:"budgetRemaining"`
+	BudgetResetAt   string               `json:"resetAt"`
+	Latency         LatencyStats         `json:"latency"`
+	Guard           GuardStats           `json:"guard"`
+
+	latencySamples []LatencySample
+}
+
+// KeyUsage holds per-upstream-key usage, keyed by a hash prefix of the key.
+// Stats.ModelUsage uses the same counters per served model.
+type KeyUsage struct {
+	Requests         int `json:"requests"`
+	PromptTokens     int `json:"promptTokens"`
+	CompletionTokens int `json:"completionTokens"`
+	TotalTokens      int `json:"totalTokens"`
+	EstimatedTokens  int `json:"estimatedTokens"`
+}
+
+// newStats returns empty stats starting now
+func newStats() Stats {
+	return Stats{
+		StartTime:      time.Now().Format(time.RFC3339),
+		ErrorLog:       []ErrorItem{},
+		UpstreamErrors: map[string]int{},
+		StatusClasses:  map[string]int{},
+		Routes:         map[string]int{},
+		KeyUsage:       map[string]*KeyUsage{},
+		ModelUsage:     map[string]*KeyUsage{},
+	}
 }
 
 // ErrorItem represents an error log entry
@@ -48,14 +218,32 @@ type ErrorItem struct {
 	Timestamp string `json:"timestamp"`
 	Message   string `json:"message"`
 	Code      int    `json:"code"`
+	Model     string `json:"model,omitempty"`
+	Route     string `json:"route,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	// ClientRequestID is the X-Request-Id NIMB gave the failed request
+	ClientRequestID string `json:"clientRequestId,omitempty"`
+
+	// Count is how many identical errors this entry stands for; repeats
+	// within errorRepeatWindow refresh the entry instead of adding one
+	Count int `json:"count"`
 }
 
-// TunnelState holds cloudflare tunnel state
+// TunnelState holds tunnel state
 type TunnelState struct {
-	URL     string `json:"url"`
-	Status  string `json:"status"`
-	process *exec.Cmd
-	mu      sync.Mutex
+	RestartCount  int       `json:"restartCount"`
+	LastExitError string    `json:"lastExitError"`
+	NextRetryAt   time.Time `json:"nextRetryAt"`
+	// provider is the running tunnel, nil when stopped
+	provider TunnelProvider
+	// probe is what probeTunnel found out about the public URL
+	probe tunnelProbe
+	// errored is set when a start timed out, see startDeadline
+	errored bool
+	// failures counts unexpected exits since the tunnel last came up
+	failures   int
+	retryTimer *time.Timer
+	mu         sync.Mutex
 }
 
 // App struct
@@ -65,238 +253,549 @@ type App struct {
 	tunnel      TunnelState
 	startTime   time.Time
 	settingsDir string
-	mu          sync.RWMutex
+	listen      options
+	budget      budgetState
+	tunnelLog   tunnelLog
+	install     installState
+	// update is the last check for a new NIMB release
+	update updateState
+	// tunnelNotice is the last tunnel URL announced
+	tunnelNotice tunnelNotice
+	// tunnelTransitions feeds /api/tunnel/events
+	tunnelTransitions tunnelTransitions
+	// handler serves every request; the tunnel backend shares it
+	handler        http.Handler
+	tunnelListener struct {
+		sync.Mutex
+		addr   string
+		server *http.Server
+	}
+	// draining is closed when shutdown begins, ending event streams
+	draining chan struct{}
+	mu       sync.RWMutex
+
+	client         *http.Client
+	clientSettings transportSettings
+	tlsMode        string
+	resolver       *dnsResolver
+	clientMu       sync.Mutex
+
+	models      modelCache
+	limiter     limiter
+	rateLimiter rateLimiter
+	idempotency idempotencyStore
+	breaker     circuitBreaker
+	keys        keyPool
+	requestLog  requestLogger
+	vkeys       virtualKeyStore
+	auditLog    requestLogger
+	history     requestHistory
+	jobs        jobStore
+	router      providerRouter
+	series      usageSeries
+	// usageDirty is set when series or the budget changed since they were
+	// last written; guarded by mu
+	usageDirty bool
+	statsHub   statsHub
+	webhooks   webhookState
+	runtime    runtimeCache
+	pprof      pprofServer
+
+	// lastKeyCheck is the result of the last /api/test call
+	lastKeyCheck *KeyCheck
+	// activeProfile is the profile last activated, if any
+	activeProfile string
+	// env holds the config fields fixed by NIMB_ variables
+	env envOverrides
+	// vault holds the key for encrypted credentials
+	vault vault
+	// admin guards the management API
+	admin adminAuth
+	// settingsModTime is when settings.json was last read or written
+	settingsModTime time.Time
 }
 
-// NewApp creates a new App
-func NewApp() *App {
-	homeDir, _ := os.UserHomeDir()
-	settingsDir := filepath.Join(homeDir, ".nimb")
-	os.MkdirAll(settingsDir, 0755)
+// defaultConfig is the config of a fresh install
+func defaultConfig() Config {
+	return Config{
+		ShowReasoning:         false,
+		EnableThinking:        false,
+		LogRequests:           true,
+		ContextSize:           128000,
+		MaxTokens:             0,
+		Temperature:           0.7,
+		StreamingEnabled:      true,
+		CurrentModel:          "deepseek-ai/deepseek-v3.2",
+		UpstreamBaseURL:       defaultUpstreamBaseURL,
+		MaxRetries:            2,
+		RetryBaseDelayMs:      500,
+		ModelOverrideMode:     ModelModeForce,
+		ModelAliases:          map[string]string{},
+		MaxRequestBytes:       defaultMaxRequestBytes,
+		MaxConcurrentRequests: defaultMaxConcurrent,
+		QueueMode:             QueueModeWait,
+		StreamKeepaliveSec:    15,
+		UpstreamStreamMode:    StreamModeAuto,
+		DNSMode:               DNSModeUDP,
+		QueueTimeoutMs:        30000,
+		ContextMode:           ContextModePassthrough,
+		SystemPromptMode:      SystemPromptOff,
+		TextOnlyModels:        defaultTextOnlyModels,
+		TunnelExposure:        TunnelExposureProxyOnly,
+		TunnelProvider:        TunnelProviderCloudflare,
+	}
+}
 
+// NewApp creates a new App keeping its files in opts.DataDir
+func NewApp(opts options) *App {
 	app := &App{
 		startTime:   time.Now(),
-		settingsDir: settingsDir,
-		config: Config{
-			ShowReasoning:    false,
-			EnableThinking:   false,
-			LogRequests:      true,
-			ContextSize:      128000,
-			MaxTokens:        0,
-			Temperature:      0.7,
-			StreamingEnabled: true,
-			CurrentModel:     "deepseek-ai/deepseek-v3.2",
-		},
-		stats: Stats{
-			StartTime: time.Now().Format(time.RFC3339),
-			ErrorLog:  []ErrorItem{},
-		},
-		tunnel: TunnelState{
-			Status: "stopped",
-		},
+		settingsDir: opts.DataDir,
+		config:      defaultConfig(),
+		stats:       newStats(),
+		draining:    make(chan struct{}),
 	}
 
 	app.loadSettings()
+	app.loadAdmin(opts.AdminPassword)
+	if opts.Passphrase != "" && app.vault.isLocked() {
+		if _, err := app.unlock(opts.Passphrase); err != nil {
+			logErrorf("NIMB_PASSPHRASE did not unlock the credentials: %v", err)
+		}
+	}
+	app.loadBudget()
+	app.loadSeries()
+	app.loadVirtualKeys()
+	logScrubber.useConfig(app.config)
+	logs.useConfig(app.config)
+	app.applyLogFile(app.config)
 	return app
 }
 
 // Settings persistence
-func (a *App) loadSettings() {
-	path := filepath.Join(a.settingsDir, "settings.json")
+func (a *App) settingsPath() string {
+	return filepath.Join(a.settingsDir, "settings.json")
+}
+
+// settingsFile is settings.json as read from disk
+type settingsFile struct {
+	Config  Config
+	Profile string
+	Version int
+	ModTime time.Time
+}
+
+// readSettings parses and migrates settings.json
+func (a *App) readSettings() (settingsFile, error) {
+	path := a.settingsPath()
+	info, err := os.Stat(path)
+	if err != nil {
+		return settingsFile{}, err
+	}
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return
+		return settingsFile{}, err
+	}
+
+	// Fields missing from the file, such as ones added since it was
+	// written, keep their defaults
+	saved, err := patchConfig(defaultConfig(), data)
+	if err != nil {
+		return settingsFile{}, err
 	}
+	var meta struct {
+		Version int    `json:"settingsVersion"`
+		Profile string `json:"activeProfile"`
+	}
+	json.Unmarshal(data, &meta)
+	migrateSettings(&saved, meta.Version)
+
+	// Invalid values from older versions or hand edits fall back to
+	// their defaults rather than failing later upstream
+	if errs := saved.validate(); len(errs) > 0 {
+		for _, e := range errs {
+			logWarnf("Settings: %s %s, using the default", e.Field, e.Error)
+		}
+		resetInvalid(&saved, errs)
+	}
+	return settingsFile{saved, meta.Profile, meta.Version, info.ModTime()}, nil
+}
 
-	var saved Config
-	if err := json.Unmarshal(data, &saved); err != nil {
+func (a *App) loadSettings() {
+	file, err := a.readSettings()
+	if errors.Is(err, fs.ErrNotExist) {
+		// First run: protect the /v1 endpoints from the start
+		a.mu.Lock()
+		issueProxyKey(&a.config)
+		a.mu.Unlock()
+		a.loadAPIKeys(false)
+		a.saveSettings()
+		return
+	}
+	if err != nil {
+		logWarnf("Ignoring unreadable settings: %v", err)
+		a.loadAPIKeys(false)
 		return
 	}
 
 	a.mu.Lock()
-	a.config = saved
+	a.config = file.Config
+	a.activeProfile = file.Profile
+	a.settingsModTime = file.ModTime
 	a.mu.Unlock()
-	log.Println("Loaded settings from:", path)
+	logInfof("Loaded settings from: %s", a.settingsPath())
+	a.loadAPIKeys(len(file.Config.APIKeys) > 0)
+	if file.Version < settingsVersion {
+		// Write the migrated file so each migration runs once
+		a.saveSettings()
+	}
+}
+
+// configChanged applies the parts of a new live config that need more
+// than the config itself being replaced
+func (a *App) configChanged(cfg Config) {
+	logScrubber.useConfig(cfg)
+	logs.useConfig(cfg)
+	a.applyLogFile(cfg)
+	// History holds prompts; drop it as soon as content logging is off
+	if !cfg.LogMessageContent {
+		a.history.clear()
+	}
+	a.applyPprof(cfg.EnablePprof)
+}
+
+// settingsVersion is the format of settings.json. Bump it and add a step
+// to migrateSettings when a change needs more than new fields with defaults.
+const settingsVersion = 2
+
+// migrateSettings upgrades a config loaded from an older settings file
+func migrateSettings(cfg *Config, from int) {
+	switch from {
+	case 0:
+		// Files from before versioning only lack newer fields, which the
+		// defaults already filled in
+		fallthrough
+	case 1:
+		// Version 2 added the proxy key; existing installs get one so the
+		// /v1 endpoints are not left open
+		issueProxyKey(cfg)
+	}
 }
 
 func (a *App) saveSettings() error {
 	a.mu.RLock()
-	data, err := json.MarshalIndent(a.config, "", "  ")
+	cfg := a.config
+	logScrubber.useConfig(cfg)
+	a.env.restore(&cfg)
+	keys := cfg.APIKeys
+	cfg.APIKeys = nil
+	data, err := json.MarshalIndent(struct {
+		Version int    `json:"settingsVersion"`
+		Profile string `json:"activeProfile,omitempty"`
+		Config
+	}{settingsVersion, a.activeProfile, cfg}, "", "  ")
 	a.mu.RUnlock()
 	if err != nil {
 		return err
 	}
+	// Keys go to their own owner-only file, unless they come from the
+	// environment and must not be saved at all
+	if _, fromEnv := a.env.locked("apiKeys"); !fromEnv {
+		if err := a.saveCredentials(keys); err != nil {
+			return err
+		}
+	}
+
+	path := a.settingsPath()
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	// Our own writes are not edits for the watcher to reload
+	if info, err := os.Stat(path); err == nil {
+		a.mu.Lock()
+		a.settingsModTime = info.ModTime()
+		a.mu.Unlock()
+	}
+	return nil
+}
 
-	path := filepath.Join(a.settingsDir, "settings.json")
-	return os.WriteFile(path, data, 0644)
+// statsSnapshot copies the stats so they can be encoded after the lock is
+// released, filling in live load figures. Callers must hold a.mu.
+func (a *App) statsSnapshot() Stats {
+	stats := a.stats
+	stats.KeyUsage = cloneUsage(a.stats.KeyUsage)
+	stats.ModelUsage = cloneUsage(a.stats.ModelUsage)
+	stats.UpstreamErrors = maps.Clone(a.stats.UpstreamErrors)
+	stats.StatusClasses = maps.Clone(a.stats.StatusClasses)
+	stats.Routes = maps.Clone(a.stats.Routes)
+	stats.Latency = latencyStats(a.stats.latencySamples)
+	stats.latencySamples = nil
+	stats.InFlight, stats.Queued = a.limiter.load()
+	a.budgetStatusLocked(&stats)
+	return stats
 }
 
 // GetHealth returns current health status
 func (a *App) GetHealth() map[string]interface{} {
+	upstream := a.upstreamBaseURL()
+	tlsMode := a.upstreamTLSMode()
+	dns := a.dnsStatus()
+	rt := a.runtimeStats()
+	encrypted, locked := a.vault.status()
+	exposure := a.tunnelExposure()
+	tunnelStatus, tunnelURL := a.tunnelStatus()
+	tunnelTarget, customTarget := a.tunnelTarget()
+	versionInfo := a.versionInfo()
+	a.tunnel.mu.Lock()
+	tunnelError, tunnelLatency := a.tunnel.LastExitError, a.tunnel.probe.LatencyMs
+	a.tunnel.mu.Unlock()
+
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 
+	proxyKey := ""
+	if a.config.ProxyAPIKey != "" {
+		proxyKey = maskKey(a.config.ProxyAPIKey)
+	}
+
 	return map[string]interface{}{
 		"status":             "ok",
 		"service":            "NIMB Mobile",
 		"model":              a.config.CurrentModel,
-		"api_key_configured": a.config.APIKey != "",
-		"config":             a.config,
-		"stats":              a.stats,
-		"tunnel": map[string]string{
-			"url":    a.tunnel.URL,
-			"status": a.tunnel.Status,
+		"upstream":           upstream,
+		"tls":                tlsMode,
+		"dns":                dns,
+		"breaker":            a.breaker.status(),
+		"providers":          a.router.status(a.config),
+		"api_key_configured": len(a.config.APIKeys) > 0,
+		"lastKeyCheck":       a.lastKeyCheck,
+		"config":             redactedConfig(a.config),
+		"stats":              a.statsSnapshot(),
+		"tunnel": map[string]interface{}{
+			"url":          tunnelURL,
+			"status":       tunnelStatus,
+			"exposure":     exposure,
+			"lastError":    tunnelError,
+			"latencyMs":    tunnelLatency,
+			"target":       tunnelTarget,
+			"customTarget": customTarget,
+		},
+		"uptime":  int(time.Since(a.startTime).Seconds()),
+		"runtime": rt,
+		"logFile": logs.fileStatus(),
+		"profile": a.activeProfile,
+		"proxyAuth": map[string]interface{}{
+			"enabled":     a.config.ProxyAPIKey != "",
+			"key":         proxyKey,
+			"localBypass": a.config.ProxyKeyLocalBypass,
+		},
+		"credentials": map[string]bool{
+			"encrypted": encrypted,
+			"locked":    locked,
 		},
-		"uptime":        int(time.Since(a.startTime).Seconds()),
-		"setupComplete": a.config.APIKey != "",
+		"listen": map[string]interface{}{
+			"address":       a.listen.addr(),
+			"port":          a.listen.Port,
+			"requestedPort": a.listen.RequestedPort,
+			"lanExposed":    a.listen.exposed(),
+			"lanUrls":       a.lanURLs(),
+			"tls":           a.listen.TLS,
+		},
+		"setupComplete": len(a.config.APIKeys) > 0,
+		"version":       versionInfo,
+	}
+}
+
+// tunnelURLPattern matches a quick tunnel's public address
+var tunnelURLPattern = regexp.MustCompile(`https://[a-z0-9-]+\.trycloudflare\.com`)
+
+// tunnelAPIURL is the service cloudflared asks for a quick tunnel; it shows
+// up in error lines and is not the tunnel itself
+const tunnelAPIURL = "https://api.trycloudflare.com"
+
+// tunnelURLFromLine finds the tunnel URL in one line of cloudflared output,
+// either plain text or a JSON log record
+func tunnelURLFromLine(line string) string {
+	if strings.HasPrefix(line, "{") {
+		var record map[string]interface{}
+		if json.Unmarshal([]byte(line), &record) == nil {
+			for _, field := range []string{"url", "message", "msg"} {
+				if s, ok := record[field].(string); ok {
+					if url := tunnelURLPattern.FindString(s); url != "" && url != tunnelAPIURL {
+						return url
+					}
+				}
+			}
+		}
+	}
+	if url := tunnelURLPattern.FindString(line); url != tunnelAPIURL {
+		return url
 	}
+	return ""
 }
 
-// StartTunnel starts cloudflare tunnel
+// StartTunnel starts the tunnel selected by Config.TunnelProvider
 func (a *App) StartTunnel() map[string]interface{} {
+	provider, providerErr := a.newTunnelProvider()
+	startTimeout := a.tunnelStartTimeout()
+
 	a.tunnel.mu.Lock()
 	defer a.tunnel.mu.Unlock()
 
-	if a.tunnel.Status == "running" {
+	if status, url := a.tunnelStatusLocked(); status == "running" || status == "connected" || status == "degraded" {
 		return map[string]interface{}{
 			"success": true,
-			"url":     a.tunnel.URL,
-			"status":  "running",
+			"url":     url,
+			"status":  status,
 		}
 	}
-
-	// Find cloudflared binary
-	var cfPath string
-	if runtime.GOOS == "windows" {
-		exePath, _ := os.Executable()
-		exeDir := filepath.Dir(exePath)
-		cfPath = filepath.Join(exeDir, "cloudflared.exe")
-		if _, err := os.Stat(cfPath); os.IsNotExist(err) {
-			return map[string]interface{}{
-				"success": false,
-				"error":   "cloudflared not found. Place it next to the executable.",
-			}
-		}
-	} else {
-		// On Linux/Termux, use absolute path to avoid exec.LookPath syscall crash
-		// exec.Command internally calls LookPath which uses faccessat2 - not available on Android
-		termuxPath := "/data/data/com.termux/files/usr/bin/cloudflared"
-		if _, err := os.Stat(termuxPath); err == nil {
-			cfPath = termuxPath
-		} else {
-			// Fallback to common Linux paths
-			for _, p := range []string{"/usr/bin/cloudflared", "/usr/local/bin/cloudflared"} {
-				if _, err := os.Stat(p); err == nil {
-					cfPath = p
-					break
-				}
-			}
-			if cfPath == "" {
-				return map[string]interface{}{
-					"success": false,
-					"error":   "cloudflared not found. Install with: pkg install cloudflared",
-				}
-			}
+	a.tunnelLog.reset()
+	if providerErr != nil {
+		a.tunnelLog.add("nimb", providerErr.Error())
+		a.tunnelTransitions.publish(TunnelEventError, "", providerErr.Error())
+		return map[string]interface{}{
+			"success": false,
+			"error":   providerErr.Error(),
 		}
-		log.Println("Using cloudflared at:", cfPath)
+	}
+	// Starting now makes a scheduled restart redundant
+	a.disarmRestartLocked()
+	// A start that never got a URL is replaced
+	if old := a.tunnel.provider; old != nil {
+		a.tunnel.provider = nil
+		go old.Stop()
+	}
+	a.tunnel.probe = tunnelProbe{}
+	if a.tunnel.errored {
+		a.tunnel.errored = false
+		a.tunnel.LastExitError = ""
 	}
 
-	a.tunnel.Status = "starting"
-
-	cmd := exec.Command(cfPath, "tunnel", "--url", "http://localhost:3000")
-
-	// Capture both stdout and stderr
-	stdout, _ := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
-
-	if err := cmd.Start(); err != nil {
-		a.tunnel.Status = "stopped"
+	err := provider.Start(tunnelEvents{
+		up:     func(url string) { a.tunnelUp(provider, url) },
+		exited: func(err error) { a.tunnelExited(provider, err) },
+		output: a.tunnelLog.add,
+	})
+	if err != nil {
+		a.tunnelLog.add("nimb", err.Error())
+		a.tunnelTransitions.publish(TunnelEventError, "", err.Error())
 		return map[string]interface{}{
 			"success": false,
-			"error":   "Failed to start cloudflared: " + err.Error(),
+			"error":   err.Error(),
 		}
 	}
+	a.tunnel.provider = provider
+	a.tunnelTransitions.publish(TunnelEventStarting, "", "")
+	a.startDeadline(provider, startTimeout)
 
-	a.tunnel.process = cmd
-
-	// Helper to scan output for tunnel URL
-	scanForURL := func(output string) {
-		if strings.Contains(output, "trycloudflare.com") {
-			start := strings.Index(output, "https://")
-			if start != -1 {
-				end := strings.Index(output[start:], " ")
-				if end == -1 {
-					end = len(output) - start
-				}
-				url := strings.TrimSpace(output[start : start+end])
-				if strings.HasSuffix(url, ".") {
-					url = url[:len(url)-1]
-				}
-				a.tunnel.mu.Lock()
-				a.tunnel.URL = url
-				a.tunnel.Status = "running"
-				a.tunnel.mu.Unlock()
-				log.Println("Tunnel URL:", url)
-			}
-		}
+	status, url := a.tunnelStatusLocked()
+	return map[string]interface{}{
+		"success": true,
+		"url":     url,
+		"status":  status,
 	}
+}
 
-	// Read from stderr
-	go func() {
-		buf := make([]byte, 4096)
-		for {
-			n, err := stderr.Read(buf)
-			if err != nil {
-				break
-			}
-			output := string(buf[:n])
-			log.Println("Cloudflared:", output)
-			scanForURL(output)
-		}
-	}()
-
-	// Read from stdout (cloudflared may output to either)
-	go func() {
-		buf := make([]byte, 4096)
-		for {
-			n, err := stdout.Read(buf)
-			if err != nil {
-				break
-			}
-			output := string(buf[:n])
-			log.Println("Cloudflared:", output)
-			scanForURL(output)
-		}
-	}()
-
-	// Wait for process to exit
-	go func() {
-		cmd.Wait()
-		a.tunnel.mu.Lock()
-		a.tunnel.Status = "stopped"
-		a.tunnel.URL = ""
-		a.tunnel.process = nil
+// tunnelUp records that provider's public URL is known and starts
+// probing it
+func (a *App) tunnelUp(provider TunnelProvider, url string) {
+	a.tunnel.mu.Lock()
+	if a.tunnel.provider != provider {
 		a.tunnel.mu.Unlock()
-	}()
+		return
+	}
+	a.tunnel.failures = 0
+	a.tunnel.LastExitError = ""
+	a.tunnelTransitions.publish(TunnelEventURL, url, "")
+	a.tunnel.mu.Unlock()
+	logInfof("Tunnel URL: %s", url)
+	a.tunnelLog.add("nimb", "Tunnel URL: "+url)
+	a.announceTunnelURL(url)
+	go a.probeTunnel(provider, url)
+}
+
+// tunnelExited handles provider's process dying on its own
+func (a *App) tunnelExited(provider TunnelProvider, err error) {
+	autoRestart, maxRestarts := a.tunnelRestartSettings()
+	a.tunnel.mu.Lock()
+	defer a.tunnel.mu.Unlock()
+	// StopTunnel clears provider first, so only a match means the tunnel
+	// died
+	if a.tunnel.provider != provider {
+		return
+	}
+	a.notify(EventTunnelDown, "Tunnel "+provider.URL()+" exited unexpectedly")
+	a.tunnel.provider = nil
+	a.tunnel.probe = tunnelProbe{}
+	a.tunnelLog.add("nimb", err.Error())
+	a.tunnelTransitions.publish(TunnelEventError, "", err.Error())
+	a.tunnelFailedLocked(err.Error(), autoRestart, maxRestarts)
+}
 
+// StopTunnel stops the tunnel and waits for it to exit. "clean" is false
+// when it had to be killed.
+func (a *App) StopTunnel() map[string]interface{} {
+	a.tunnel.mu.Lock()
+	if status, _ := a.tunnelStatusLocked(); status != "stopped" || a.tunnel.retryTimer != nil {
+		a.tunnelTransitions.publish(TunnelEventStopped, "", "")
+	}
+	provider := a.tunnel.provider
+	a.tunnel.provider = nil
+	a.disarmRestartLocked()
+	a.tunnel.failures = 0
+	a.tunnel.probe = tunnelProbe{}
+	a.tunnel.errored = false
+	a.tunnel.mu.Unlock()
+
+	if provider == nil {
+		return map[string]interface{}{
+			"success":    true,
+			"wasRunning": false,
+		}
+	}
+	// Outside the lock: a slow shutdown must not hold up status reads
+	clean := provider.Stop()
+	if clean {
+		a.tunnelLog.add("nimb", "Tunnel stopped")
+	} else {
+		a.tunnelLog.add("nimb", "Tunnel killed; it did not shut down cleanly")
+	}
 	return map[string]interface{}{
-		"success": true,
-		"status":  "starting",
+		"success":    true,
+		"wasRunning": true,
+		"clean":      clean,
 	}
 }
 
-// StopTunnel stops cloudflare tunnel
-func (a *App) StopTunnel() bool {
+// tunnelStatus returns the tunnel's status and public URL
+func (a *App) tunnelStatus() (string, string) {
 	a.tunnel.mu.Lock()
 	defer a.tunnel.mu.Unlock()
+	return a.tunnelStatusLocked()
+}
 
-	if a.tunnel.process != nil {
-		a.tunnel.process.Process.Kill()
-		a.tunnel.process = nil
+// tunnelStatusLocked is tunnelStatus for callers holding a.tunnel.mu. A
+// running tunnel reads "connected" or "degraded" as probeTunnel finds it,
+// one that never got a URL reads "error" until it is started or stopped
+// again.
+func (a *App) tunnelStatusLocked() (string, string) {
+	provider := a.tunnel.provider
+	if provider == nil {
+		if a.tunnel.errored {
+			return "error", ""
+		}
+		return "stopped", ""
+	}
+	status := provider.Status()
+	if status == "running" {
+		switch {
+		case a.tunnel.probe.Reachable:
+			status = "connected"
+		case a.tunnel.probe.Failures >= tunnelDegradedAfter:
+			status = "degraded"
+		}
 	}
-	a.tunnel.Status = "stopped"
-	a.tunnel.URL = ""
-	return true
+	return status, provider.URL()
 }
 
 // HTTP API Handlers
@@ -306,16 +805,28 @@ func (a *App) handleHealth(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(a.GetHealth())
 }
 
+// handleHealthJSON is the unauthenticated liveness check at /health, which
+// the tunnel probe and uptime monitors use. It only says NIMB is up; the
+// details are at /api/health, behind the admin login.
 func (a *App) handleHealthJSON(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(a.GetHealth())
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok"})
 }
 
 func (a *App) handleConfig(w http.ResponseWriter, r *http.Request) {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(a.config)
+	masked := ""
+	if len(a.config.APIKeys) > 0 {
+		masked = maskKey(a.config.APIKeys[0])
+	}
+	json.NewEncoder(w).Encode(struct {
+		Config
+		APIKey           string            `json:"apiKey"`
+		APIKeyConfigured bool              `json:"apiKeyConfigured"`
+		ReadOnly         map[string]string `json:"readOnly,omitempty"`
+	}{redactedConfig(a.config), masked, masked != "", a.env.vars})
 }
 
 func (a *App) handleSaveConfig(w http.ResponseWriter, r *http.Request) {
@@ -324,18 +835,38 @@ func (a *App) handleSaveConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var cfg Config
-	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.mu.RLock()
+	current := a.config
+	a.mu.RUnlock()
+
+	// Only the fields sent are changed, so partial updates are safe
+	cfg, err := patchConfig(current, body)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// Fields set from the environment are read-only
+	a.env.apply(&cfg)
+	if errs := cfg.validate(); len(errs) > 0 {
+		writeConfigErrors(w, errs)
+		return
+	}
 
 	a.mu.Lock()
-	if cfg.APIKey == "" {
-		cfg.APIKey = a.config.APIKey
+	if cfg.APIKeys == nil {
+		cfg.APIKeys = a.config.APIKeys
 	}
+	restoreMaskedSecrets(&cfg, a.config)
+	old := a.config
 	a.config = cfg
 	a.mu.Unlock()
+	a.configChanged(cfg)
+	a.audit(a.clientIP(r), AuditConfigSave, "", old, cfg)
 
 	if err := a.saveSettings(); err != nil {
 		w.Header().Set("Content-Type", "application/json")
@@ -360,24 +891,61 @@ func (a *App) handleSetModel(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if varName, ok := a.env.locked("currentModel"); ok {
+		writeLocked(w, "currentModel", varName)
+		return
+	}
 
 	a.mu.Lock()
-	a.config.CurrentModel = req.Model
+	cfg := a.config
+	cfg.CurrentModel = req.Model
+	if errs := cfg.validate(); len(errs) > 0 {
+		a.mu.Unlock()
+		writeConfigErrors(w, errs)
+		return
+	}
+	old := a.config
+	a.config = cfg
 	a.mu.Unlock()
+	a.audit(a.clientIP(r), AuditModel, "", old, cfg)
 
 	success := a.saveSettings() == nil
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"success": success})
 }
 
-func (a *App) handleSetAPIKey(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
+// handleAPIKeys lists the stored keys (masked) on GET, adds a key or
+// replaces the whole list on POST, and removes a key on DELETE
+func (a *App) handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		a.mu.RLock()
+		keys, rotation := a.config.APIKeys, a.config.KeyRotation
+		a.mu.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys":     a.keys.status(keys),
+			"rotation": rotation,
+		})
+		return
+	}
+	if r.Method != "POST" && r.Method != "DELETE" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if varName, ok := a.env.locked("apiKeys"); ok {
+		writeLocked(w, "apiKeys", varName)
+		return
+	}
+	if a.vault.isLocked() {
+		writeLockedError(w)
+		return
+	}
 
 	var req struct {
-		Key string `json:"key"`
+		Key  string   `json:"key"`
+		Keys []string `json:"keys"`
+		ID   string   `json:"id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -385,8 +953,77 @@ func (a *App) handleSetAPIKey(w http.ResponseWriter, r *http.Request) {
 	}
 
 	a.mu.Lock()
-	a.config.APIKey = req.Key
+	keys := slices.Clone(a.config.APIKeys)
+	switch {
+	case r.Method == "DELETE":
+		keys = slices.DeleteFunc(keys, func(k string) bool {
+			return k == strings.TrimSpace(req.Key) || keyID(k) == req.ID
+		})
+	case req.Keys != nil:
+		keys = nil
+		for _, k := range req.Keys {
+			if k = strings.TrimSpace(k); k != "" && !slices.Contains(keys, k) {
+				keys = append(keys, k)
+			}
+		}
+	default:
+		if k := strings.TrimSpace(req.Key); k != "" && !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	old := a.config
+	a.config.APIKeys = keys
+	cfg := a.config
 	a.mu.Unlock()
+	a.audit(a.clientIP(r), AuditAPIKey, "", old, cfg)
+
+	success := a.saveSettings() == nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": success, "count": len(keys)})
+}
+
+func (a *App) handleAliases(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		a.mu.RLock()
+		defer a.mu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.config.ModelAliases)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if varName, ok := a.env.locked("modelAliases"); ok {
+		writeLocked(w, "modelAliases", varName)
+		return
+	}
+
+	var aliases map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&aliases); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Blank rows are dropped; half-filled ones fail validation
+	for alias, target := range aliases {
+		if strings.TrimSpace(alias) == "" && strings.TrimSpace(target) == "" {
+			delete(aliases, alias)
+		}
+	}
+
+	a.mu.Lock()
+	cfg := a.config
+	cfg.ModelAliases = aliases
+	if errs := cfg.validate(); len(errs) > 0 {
+		a.mu.Unlock()
+		writeConfigErrors(w, errs)
+		return
+	}
+	old := a.config
+	a.config = cfg
+	a.mu.Unlock()
+	a.audit(a.clientIP(r), AuditAliases, "", old, cfg)
 
 	success := a.saveSettings() == nil
 	w.Header().Set("Content-Type", "application/json")
@@ -395,9 +1032,10 @@ func (a *App) handleSetAPIKey(w http.ResponseWriter, r *http.Request) {
 
 func (a *App) handleStats(w http.ResponseWriter, r *http.Request) {
 	a.mu.RLock()
-	defer a.mu.RUnlock()
+	stats := a.statsSnapshot()
+	a.mu.RUnlock()
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(a.stats)
+	json.NewEncoder(w).Encode(stats)
 }
 
 func (a *App) handleResetStats(w http.ResponseWriter, r *http.Request) {
@@ -407,11 +1045,11 @@ func (a *App) handleResetStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	a.mu.Lock()
-	a.stats = Stats{
-		StartTime: time.Now().Format(time.RFC3339),
-		ErrorLog:  []ErrorItem{},
-	}
+	a.stats = newStats()
+	a.series = usageSeries{}
 	a.mu.Unlock()
+	a.statsHub.publish()
+	a.saveSeries()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
@@ -434,217 +1072,68 @@ func (a *App) handleStopTunnel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a.StopTunnel()
+	result := a.StopTunnel()
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	json.NewEncoder(w).Encode(result)
 }
 
 func (a *App) handleTunnelStatus(w http.ResponseWriter, r *http.Request) {
+	exposure := a.tunnelExposure()
+	provider := a.tunnelProvider()
+	target, customTarget := a.tunnelTarget()
 	a.tunnel.mu.Lock()
 	defer a.tunnel.mu.Unlock()
+	status, url := a.tunnelStatusLocked()
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"url":    a.tunnel.URL,
-		"status": a.tunnel.Status,
+	var nextRetryAt string
+	if !a.tunnel.NextRetryAt.IsZero() {
+		nextRetryAt = a.tunnel.NextRetryAt.Format(time.RFC3339)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":           url,
+		"status":        status,
+		"provider":      provider,
+		"exposure":      exposure,
+		"target":        target,
+		"customTarget":  customTarget,
+		"probe":         a.tunnel.probe.status(),
+		"notification":  a.tunnelNotice.status(),
+		"restartCount":  a.tunnel.RestartCount,
+		"lastExitError": a.tunnel.LastExitError,
+		"nextRetryAt":   nextRetryAt,
 	})
 }
 
-func (a *App) handleModels(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"object":"list","data":[]}`))
+func (a *App) logError(msg string, code int) {
+	a.logErrorItem(ErrorItem{Message: msg, Code: code})
 }
 
-func (a *App) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	a.mu.RLock()
-	apiKey := a.config.APIKey
-	config := a.config
-	a.mu.RUnlock()
-
-	if apiKey == "" {
-		a.logError("API key not configured", 500)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(500)
-		w.Write([]byte(`{"error":{"message":"API key not configured","type":"configuration_error","code":500}}`))
-		return
-	}
-
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		a.logError(err.Error(), 400)
-		http.Error(w, err.Error(), 400)
-		return
-	}
-
-	var reqBody map[string]interface{}
-	if err := json.Unmarshal(body, &reqBody); err != nil {
-		a.logError(err.Error(), 400)
-		http.Error(w, err.Error(), 400)
-		return
-	}
-
-	nimReq := map[string]interface{}{
-		"model":    config.CurrentModel,
-		"messages": reqBody["messages"],
-	}
-
-	if temp, ok := reqBody["temperature"].(float64); ok {
-		nimReq["temperature"] = temp
-	} else {
-		nimReq["temperature"] = config.Temperature
-	}
-
-	if maxTok, ok := reqBody["max_tokens"].(float64); ok {
-		nimReq["max_tokens"] = int(maxTok)
-	} else {
-		nimReq["max_tokens"] = config.MaxTokens
-	}
-
-	if stream, ok := reqBody["stream"].(bool); ok {
-		nimReq["stream"] = stream
-	} else {
-		nimReq["stream"] = config.StreamingEnabled
-	}
-
-	passthroughParams := []string{"top_p", "top_k", "frequency_penalty", "presence_penalty", "repetition_penalty", "min_p", "seed", "stop", "n", "context_length", "context_window", "truncate"}
-	for _, p := range passthroughParams {
-		if v, ok := reqBody[p]; ok {
-			nimReq[p] = v
-		}
-	}
-
-	if config.LogRequests {
-		log.Printf("[NIMB] %v -> %s", reqBody["model"], config.CurrentModel)
-	}
-
-	nimBody, _ := json.Marshal(nimReq)
-
-	// Create custom dialer with explicit DNS resolver (fixes Android IPv6 DNS issue)
-	dialer := &net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
-		Resolver: &net.Resolver{
-			PreferGo: true,
-			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				// Force IPv4 Google DNS
-				d := net.Dialer{Timeout: 10 * time.Second}
-				return d.DialContext(ctx, "udp", "8.8.8.8:53")
-			},
-		},
-	}
-
-	transport := &http.Transport{
-		DialContext:           dialer.DialContext,
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: runtime.GOOS != "windows", // Skip on Android/Linux where system CAs aren't available to Go
-		},
-	}
-
-	client := &http.Client{
-		Timeout:   120 * time.Second,
-		Transport: transport,
-	}
-
-	nimReqHTTP, _ := http.NewRequest("POST", "https://integrate.api.nvidia.com/v1/chat/completions", bytes.NewReader(nimBody))
-	nimReqHTTP.Header.Set("Authorization", "Bearer "+apiKey)
-	nimReqHTTP.Header.Set("Content-Type", "application/json")
-
-	resp, err := client.Do(nimReqHTTP)
-	if err != nil {
-		a.logError(err.Error(), 500)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(500)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": map[string]interface{}{
-				"message": err.Error(),
-				"type":    "api_error",
-				"code":    500,
-			},
-		})
-		return
-	}
-	defer resp.Body.Close()
-
+// logErrorItem adds an entry carrying request details to the error log
+func (a *App) logErrorItem(item ErrorItem) {
 	a.mu.Lock()
-	a.stats.MessageCount++
-	a.stats.LastRequestTime = time.Now().Format(time.RFC3339)
+	a.logErrorLocked(item)
 	a.mu.Unlock()
+}
 
-	isStream := nimReq["stream"].(bool)
-
-	if isStream {
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "Streaming not supported", 500)
-			return
-		}
+// logErrorLocked adds an entry to the error log, folding it into a recent
+// identical entry if there is one. Callers must hold a.mu.
+func (a *App) logErrorLocked(item ErrorItem) {
+	now := time.Now()
+	item.Message = scrubSecrets(item.Message)
+	item.Timestamp = now.Format(time.RFC3339)
+	a.stats.ErrorCount++
+	a.recordSeriesLocked(func(b *usageBucket) { b.Errors++ })
+	a.statsHub.publish()
 
-		buf := make([]byte, 4096)
-		for {
-			n, err := resp.Body.Read(buf)
-			if n > 0 {
-				w.Write(buf[:n])
-				flusher.Flush()
-			}
-			if err != nil {
-				break
-			}
-		}
+	if i := recentRepeat(a.stats.ErrorLog, item, now); i >= 0 {
+		item.Count = a.stats.ErrorLog[i].Count + 1
+		a.stats.ErrorLog = append(a.stats.ErrorLog[:i], a.stats.ErrorLog[i+1:]...)
 	} else {
-		respBody, _ := io.ReadAll(resp.Body)
-
-		var nimResp map[string]interface{}
-		json.Unmarshal(respBody, &nimResp)
-
-		if usage, ok := nimResp["usage"].(map[string]interface{}); ok {
-			a.mu.Lock()
-			if pt, ok := usage["prompt_tokens"].(float64); ok {
-				a.stats.PromptTokens += int(pt)
-			}
-			if ct, ok := usage["completion_tokens"].(float64); ok {
-				a.stats.CompletionTokens += int(ct)
-			}
-			if tt, ok := usage["total_tokens"].(float64); ok {
-				a.stats.TotalTokens += int(tt)
-			}
-			a.mu.Unlock()
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(resp.StatusCode)
-		w.Write(respBody)
-	}
-
-	if config.LogRequests {
-		log.Println("[NIMB] Done")
+		item.Count = 1
 	}
-}
+	a.stats.ErrorLog = append([]ErrorItem{item}, a.stats.ErrorLog...)
 
-func (a *App) logError(msg string, code int) {
-	a.mu.Lock()
-	defer a.mu.Unlock()
-
-	a.stats.ErrorCount++
-	a.stats.ErrorLog = append([]ErrorItem{{
-		Timestamp: time.Now().Format(time.RFC3339),
-		Message:   msg,
-		Code:      code,
-	}}, a.stats.ErrorLog...)
-
-	if len(a.stats.ErrorLog) > 50 {
-		a.stats.ErrorLog = a.stats.ErrorLog[:50]
+	if len(a.stats.ErrorLog) > maxErrorLog {
+		a.stats.ErrorLog = a.stats.ErrorLog[:maxErrorLog]
 	}
 }
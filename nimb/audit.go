@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+const (
+	// The audit log is small and rarely written, so it gets a smaller
+	// budget than the request log
+	auditMaxBytes     = 1 << 20
+	auditKeep         = 3
+	defaultAuditLimit = 50
+)
+
+// Audit actions
+const (
+	AuditConfigSave      = "config.save"
+	AuditModel           = "model"
+	AuditAPIKey          = "apikey"
+	AuditProfileActivate = "profile.activate"
+	AuditProxyKey        = "proxykey.rotate"
+	AuditAliases         = "aliases"
+)
+
+// auditChange is one changed config field
+type auditChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// auditEntry is one line of ~/.nimb/audit.jsonl
+type auditEntry struct {
+	Timestamp string        `json:"timestamp"`
+	Remote    string        `json:"remote"`
+	Action    string        `json:"action"`
+	Profile   string        `json:"profile,omitempty"`
+	Changes   []auditChange `json:"changes"`
+}
+
+func (a *App) auditPath() string {
+	return filepath.Join(a.settingsDir, "audit.jsonl")
+}
+
+// auditView is a config as the audit log shows it, with secrets masked and
+// keys shown the way /api/apikey lists them
+func auditView(c Config) Config {
+	keys := make([]string, len(c.APIKeys))
+	for i, key := range c.APIKeys {
+		keys[i] = keyID(key)
+	}
+	proxyKey := ""
+	if c.ProxyAPIKey != "" {
+		proxyKey = keyID(c.ProxyAPIKey)
+	}
+	c = redactedConfig(c)
+	c.APIKeys = keys
+	c.ProxyAPIKey = proxyKey
+	return c
+}
+
+// diffConfig lists the fields that differ between two configs
+func diffConfig(before, after Config) []auditChange {
+	old := map[string]interface{}{}
+	b := auditView(before)
+	configFields(&b, func(name string, v reflect.Value) { old[name] = v.Interface() })
+
+	changes := []auditChange{}
+	n := auditView(after)
+	configFields(&n, func(name string, v reflect.Value) {
+		if !reflect.DeepEqual(old[name], v.Interface()) {
+			changes = append(changes, auditChange{name, old[name], v.Interface()})
+		}
+	})
+	return changes
+}
+
+// audit records a config change made through the API. Requests that
+// changed nothing are not logged.
+func (a *App) audit(remote, action, profile string, before, after Config) {
+	changes := diffConfig(before, after)
+	if len(changes) == 0 {
+		return
+	}
+	line, err := json.Marshal(auditEntry{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Remote:    remote,
+		Action:    action,
+		Profile:   profile,
+		Changes:   changes,
+	})
+	if err != nil {
+		return
+	}
+	a.auditLog.append(a.auditPath(), append(line, '\n'), auditMaxBytes, auditKeep)
+}
+
+// readAudit returns entries from the current and rotated audit files,
+// newest first, stopping at since or after limit entries
+func (a *App) readAudit(since time.Time, limit int) []auditEntry {
+	a.auditLog.mu.Lock()
+	defer a.auditLog.mu.Unlock()
+
+	path := a.auditPath()
+	files := []string{path}
+	for i := 1; i <= auditKeep; i++ {
+		files = append(files, path+"."+strconv.Itoa(i))
+	}
+
+	entries := []auditEntry{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+		for i := len(lines) - 1; i >= 0; i-- {
+			var e auditEntry
+			if json.Unmarshal(lines[i], &e) != nil {
+				continue
+			}
+			if t, err := time.Parse(time.RFC3339, e.Timestamp); err == nil && t.Before(since) {
+				return entries
+			}
+			entries = append(entries, e)
+			if len(entries) == limit {
+				return entries
+			}
+		}
+	}
+	return entries
+}
+
+// handleAudit returns the newest config changes. ?limit= caps how many
+// (default 50) and ?since= (RFC 3339) drops older ones.
+func (a *App) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	limit := defaultAuditLimit
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+	var since time.Time
+	if s := q.Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"limit":   limit,
+		"entries": a.readAudit(since, limit),
+	})
+}
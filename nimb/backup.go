@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// backupVersion is bumped when the backup format changes incompatibly
+const backupVersion = 1
+
+// maxBackupBytes bounds a restore upload
+const maxBackupBytes = 32 << 20
+
+// backupDoc is everything needed to move NIMB to another device. Model
+// aliases and rules are part of the settings.
+type backupDoc struct {
+	Version       int                        `json:"version"`
+	CreatedAt     string                     `json:"createdAt"`
+	Settings      json.RawMessage            `json:"settings"`
+	ActiveProfile string                     `json:"activeProfile,omitempty"`
+	Profiles      map[string]json.RawMessage `json:"profiles"`
+	// APIKeys and Stats are only included when asked for. Without the
+	// keys, the other secrets in Settings and Profiles are masked too.
+	APIKeys []string     `json:"apiKeys,omitempty"`
+	Stats   *statsExport `json:"stats,omitempty"`
+}
+
+func (a *App) backupsDir() string {
+	return filepath.Join(a.settingsDir, "backups")
+}
+
+// buildBackup collects the saved state. Values set from the environment
+// are left out, as they are from settings.json. Unless withKeys is set,
+// secrets are masked as /api/config shows them.
+func (a *App) buildBackup(withKeys, withStats bool) (backupDoc, error) {
+	a.mu.RLock()
+	cfg := a.config
+	active := a.activeProfile
+	var stats *statsExport
+	if withStats {
+		stats = &statsExport{
+			Version:    statsExportVersion,
+			ExportedAt: time.Now().Format(time.RFC3339),
+			Stats:      a.statsSnapshot(),
+			Timeseries: usageSeries{
+				Hourly: append([]usageBucket{}, a.series.Hourly...),
+				Daily:  append([]usageBucket{}, a.series.Daily...),
+			},
+		}
+	}
+	a.mu.RUnlock()
+
+	a.env.restore(&cfg)
+	keys := cfg.APIKeys
+	cfg.APIKeys = nil
+	if !withKeys {
+		cfg = redactedConfig(cfg)
+	}
+	settings, err := json.Marshal(cfg)
+	if err != nil {
+		return backupDoc{}, err
+	}
+
+	doc := backupDoc{
+		Version:       backupVersion,
+		CreatedAt:     time.Now().Format(time.RFC3339),
+		Settings:      settings,
+		ActiveProfile: active,
+		Profiles:      map[string]json.RawMessage{},
+		Stats:         stats,
+	}
+	if withKeys {
+		doc.APIKeys = keys
+	}
+	for _, p := range a.listProfiles() {
+		data, err := os.ReadFile(a.profilePath(p.Name))
+		if err != nil {
+			return backupDoc{}, err
+		}
+		if !withKeys {
+			var profile Config
+			if err := json.Unmarshal(data, &profile); err != nil {
+				return backupDoc{}, fmt.Errorf("profile %s: %w", p.Name, err)
+			}
+			if data, err = json.MarshalIndent(redactedConfig(profile), "", "  "); err != nil {
+				return backupDoc{}, err
+			}
+		}
+		doc.Profiles[p.Name] = data
+	}
+	return doc, nil
+}
+
+// checkConfig runs the checks a config save does, naming fields under prefix
+func checkConfig(prefix string, data []byte) (Config, []fieldError) {
+	cfg, err := patchConfig(defaultConfig(), data)
+	if err != nil {
+		return cfg, []fieldError{{prefix, "is not a valid config: " + err.Error()}}
+	}
+	var errs []fieldError
+	for _, e := range cfg.validate() {
+		errs = append(errs, fieldError{prefix + "." + e.Field, e.Error})
+	}
+	return cfg, errs
+}
+
+// validate checks the whole backup and returns every problem found, so a
+// restore either applies completely or not at all
+func (doc *backupDoc) validate() (Config, []fieldError) {
+	if doc.Version != backupVersion {
+		return Config{}, []fieldError{{"version", fmt.Sprintf("unsupported backup version %d", doc.Version)}}
+	}
+	if len(doc.Settings) == 0 {
+		return Config{}, []fieldError{{"settings", "is missing"}}
+	}
+	cfg, errs := checkConfig("settings", doc.Settings)
+	for name, data := range doc.Profiles {
+		if !profileName.MatchString(name) {
+			errs = append(errs, fieldError{"profiles." + name, "is not a valid profile name"})
+			continue
+		}
+		_, perrs := checkConfig("profiles."+name, data)
+		errs = append(errs, perrs...)
+	}
+	if doc.ActiveProfile != "" {
+		if _, ok := doc.Profiles[doc.ActiveProfile]; !ok {
+			errs = append(errs, fieldError{"activeProfile", "names a profile that is not in the backup"})
+		}
+	}
+	if doc.Stats != nil {
+		if err := doc.Stats.validate(); err != nil {
+			errs = append(errs, fieldError{"stats", err.Error()})
+		}
+	}
+	return cfg, errs
+}
+
+// writeBackupFile saves doc under the backups directory, readable by the
+// owner only as it may hold keys
+func (a *App) writeBackupFile(doc backupDoc, prefix string) (string, error) {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(a.backupsDir(), 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(a.backupsDir(), prefix+"-"+time.Now().Format("20060102-150405")+".json")
+	return path, os.WriteFile(path, data, 0600)
+}
+
+// stageProfiles writes the backup's profiles to a new directory next to
+// the live one
+func (a *App) stageProfiles(profiles map[string]json.RawMessage) (string, error) {
+	staged := a.profilesDir() + ".restore"
+	os.RemoveAll(staged)
+	if err := os.MkdirAll(staged, 0755); err != nil {
+		return "", err
+	}
+	for name, data := range profiles {
+		if err := os.WriteFile(filepath.Join(staged, name+".json"), data, 0644); err != nil {
+			os.RemoveAll(staged)
+			return "", err
+		}
+	}
+	return staged, nil
+}
+
+// swapProfiles replaces the profiles directory with the staged one
+func (a *App) swapProfiles(staged string) error {
+	live, old := a.profilesDir(), a.profilesDir()+".old"
+	os.RemoveAll(old)
+	if err := os.Rename(live, old); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(staged, live); err != nil {
+		os.Rename(old, live)
+		return err
+	}
+	os.RemoveAll(old)
+	return nil
+}
+
+// unmaskProfiles returns the profiles with secrets a backup left masked
+// filled in from stored
+func unmaskProfiles(profiles map[string]json.RawMessage, stored Config) (map[string]json.RawMessage, error) {
+	out := make(map[string]json.RawMessage, len(profiles))
+	for name, data := range profiles {
+		if bytes.Contains(data, []byte(maskedValue)) {
+			var profile Config
+			if err := json.Unmarshal(data, &profile); err != nil {
+				return nil, fmt.Errorf("profile %s: %w", name, err)
+			}
+			restoreMaskedSecrets(&profile, stored)
+			var err error
+			if data, err = json.MarshalIndent(profile, "", "  "); err != nil {
+				return nil, err
+			}
+		}
+		out[name] = data
+	}
+	return out, nil
+}
+
+// restore applies a validated backup. The current state is written to the
+// backups directory first, and put back if any step fails. Secrets the
+// backup leaves out or masked keep their current values.
+func (a *App) restore(doc backupDoc, cfg Config) (string, error) {
+	current, err := a.buildBackup(true, true)
+	if err != nil {
+		return "", err
+	}
+	saved, err := a.writeBackupFile(current, "pre-restore")
+	if err != nil {
+		return "", fmt.Errorf("could not back up the current state: %w", err)
+	}
+
+	a.mu.RLock()
+	stored := a.config
+	a.mu.RUnlock()
+	a.env.restore(&stored)
+	profiles, err := unmaskProfiles(doc.Profiles, stored)
+	if err != nil {
+		return saved, err
+	}
+	staged, err := a.stageProfiles(profiles)
+	if err != nil {
+		return saved, err
+	}
+
+	a.mu.Lock()
+	oldConfig, oldProfile := a.config, a.activeProfile
+	oldStats, oldSeries := a.stats, a.series
+	if doc.APIKeys == nil {
+		cfg.APIKeys = a.config.APIKeys
+	} else {
+		cfg.APIKeys = doc.APIKeys
+	}
+	restoreMaskedSecrets(&cfg, stored)
+	a.env.apply(&cfg)
+	a.config = cfg
+	a.activeProfile = doc.ActiveProfile
+	if doc.Stats != nil {
+		stats := newStats()
+		mergeStats(&stats, doc.Stats.Stats)
+		a.stats = stats
+		a.series = doc.Stats.Timeseries
+	}
+	a.mu.Unlock()
+
+	rollback := func() {
+		a.mu.Lock()
+		a.config, a.activeProfile = oldConfig, oldProfile
+		a.stats, a.series = oldStats, oldSeries
+		a.mu.Unlock()
+		a.saveSettings()
+		a.saveSeries()
+	}
+	if err := a.saveSettings(); err != nil {
+		os.RemoveAll(staged)
+		rollback()
+		return saved, err
+	}
+	if err := a.saveSeries(); err != nil {
+		os.RemoveAll(staged)
+		rollback()
+		return saved, err
+	}
+	if err := a.swapProfiles(staged); err != nil {
+		os.RemoveAll(staged)
+		rollback()
+		return saved, err
+	}
+
+	a.configChanged(cfg)
+	a.statsHub.publish()
+	logInfof("Restored backup from %v - previous state saved to %s", doc.CreatedAt, saved)
+	return saved, nil
+}
+
+// handleBackup downloads settings and profiles as one JSON document.
+// ?keys=true adds the API keys and other secrets unmasked, ?stats=true the
+// usage stats.
+func (a *App) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	withKeys := r.URL.Query().Get("keys") == "true"
+	if withKeys && a.vault.isLocked() {
+		writeLockedError(w)
+		return
+	}
+
+	doc, err := a.buildBackup(withKeys, r.URL.Query().Get("stats") == "true")
+	if err != nil {
+		writeAPIError(w, 500, "Could not read the data directory: "+err.Error(), "server_error")
+		return
+	}
+
+	name := "nimb-backup-" + time.Now().Format("20060102") + ".json"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(doc)
+}
+
+// handleRestore validates and applies a document from /api/backup
+func (a *App) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.vault.isLocked() {
+		writeLockedError(w)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBackupBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var doc backupDoc
+	if err := json.Unmarshal(body, &doc); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "not a backup file: " + err.Error()})
+		return
+	}
+	cfg, errs := doc.validate()
+	if len(errs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Invalid backup, nothing was changed",
+			"fields":  errs,
+		})
+		return
+	}
+
+	saved, err := a.restore(doc, cfg)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  false,
+			"error":    "Restore failed, nothing was changed: " + err.Error(),
+			"previous": saved,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"profiles": len(doc.Profiles),
+		"apiKeys":  doc.APIKeys != nil,
+		"stats":    doc.Stats != nil,
+		"previous": saved,
+	})
+}
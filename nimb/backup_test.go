@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		fields []string
+	}{
+		{"empty object", `{}`, nil},
+		{"valid fields", `{"contextSize":8192,"temperature":0.3}`, nil},
+		{"not JSON", `{"contextSize":`, []string{"settings"}},
+		{"wrong type", `{"contextSize":"big"}`, []string{"settings"}},
+		{"invalid values", `{"contextSize":-1,"temperature":9}`, []string{"settings.contextSize", "settings.temperature"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := checkConfig("settings", []byte(tt.data))
+			var fields []string
+			for _, e := range errs {
+				fields = append(fields, e.Field)
+			}
+			slices.Sort(fields)
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestBackupValidate(t *testing.T) {
+	valid := json.RawMessage(`{"contextSize":8192}`)
+	tests := []struct {
+		name   string
+		doc    backupDoc
+		fields []string
+	}{
+		{"valid", backupDoc{Version: backupVersion, Settings: valid, ActiveProfile: "work",
+			Profiles: map[string]json.RawMessage{"work": valid}}, nil},
+		{"wrong version", backupDoc{Version: 99, Settings: valid}, []string{"version"}},
+		{"no settings", backupDoc{Version: backupVersion}, []string{"settings"}},
+		{"bad profile name", backupDoc{Version: backupVersion, Settings: valid,
+			Profiles: map[string]json.RawMessage{"../x": valid}}, []string{"profiles.../x"}},
+		{"invalid profile", backupDoc{Version: backupVersion, Settings: valid,
+			Profiles: map[string]json.RawMessage{"work": json.RawMessage(`{"contextSize":-1}`)}}, []string{"profiles.work.contextSize"}},
+		{"missing active profile", backupDoc{Version: backupVersion, Settings: valid, ActiveProfile: "home"}, []string{"activeProfile"}},
+		{"every problem reported", backupDoc{Version: backupVersion, Settings: json.RawMessage(`{"temperature":9}`), ActiveProfile: "home",
+			Profiles: map[string]json.RawMessage{"work": json.RawMessage(`{"contextSize":-1}`)}},
+			[]string{"activeProfile", "profiles.work.contextSize", "settings.temperature"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := tt.doc.validate()
+			var fields []string
+			for _, e := range errs {
+				fields = append(fields, e.Field)
+			}
+			slices.Sort(fields)
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+// TestBackupRoundTrip restores a backup of a populated data directory
+// into a fresh one
+func TestBackupRoundTrip(t *testing.T) {
+	src := newTestApp(t, nil)
+	src.config.ContextSize = 8192
+	src.config.ModelAliases = map[string]string{"fast": "meta/llama-3.1-8b-instruct"}
+	if err := src.saveProfile("work"); err != nil {
+		t.Fatal(err)
+	}
+	src.activeProfile = "work"
+	src.stats.MessageCount = 7
+
+	if body := serve(src.handleBackup, "GET", "/api/backup", "").Body.String(); strings.Contains(body, "nvapi-test") {
+		t.Error("the backup holds API keys without ?keys=true")
+	}
+	backup := serve(src.handleBackup, "GET", "/api/backup?keys=true&stats=true", "").Body.String()
+
+	dst := newTestApp(t, nil)
+	dst.config.APIKeys = []string{"nvapi-other"}
+	rec := serve(dst.handleRestore, "POST", "/api/restore", backup)
+	if rec.Code != 200 {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if dst.config.ContextSize != 8192 || dst.config.ModelAliases["fast"] != "meta/llama-3.1-8b-instruct" {
+		t.Errorf("config not restored: contextSize %d, aliases %v", dst.config.ContextSize, dst.config.ModelAliases)
+	}
+	if !slices.Equal(dst.config.APIKeys, []string{"nvapi-test"}) {
+		t.Errorf("keys = %v", dst.config.APIKeys)
+	}
+	if dst.activeProfile != "work" || len(dst.listProfiles()) != 1 {
+		t.Errorf("active profile %q, profiles %v", dst.activeProfile, dst.listProfiles())
+	}
+	if dst.stats.MessageCount != 7 {
+		t.Errorf("MessageCount = %d, want 7", dst.stats.MessageCount)
+	}
+
+	// The state before the restore was kept
+	var resp struct {
+		Previous string `json:"previous"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	saved, err := os.ReadFile(resp.Previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(saved), "nvapi-other") {
+		t.Error("the pre-restore backup lacks the old keys")
+	}
+}
+
+// TestRestoreInvalid checks a backup that fails validation changes
+// nothing
+func TestRestoreInvalid(t *testing.T) {
+	a := newTestApp(t, nil)
+	before := a.config
+	body := `{"version":1,"settings":{"contextSize":8192},"profiles":{"work":{"temperature":9}}}`
+	rec := serve(a.handleRestore, "POST", "/api/restore", body)
+	if rec.Code != 422 {
+		t.Fatalf("status = %d, want 422", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "profiles.work.temperature") {
+		t.Errorf("body %s does not name the bad field", rec.Body)
+	}
+	if a.config.ContextSize != before.ContextSize || len(a.listProfiles()) != 0 {
+		t.Error("a rejected backup changed the state")
+	}
+	if _, err := os.Stat(a.backupsDir()); !os.IsNotExist(err) {
+		t.Errorf("a rejected backup wrote to the backups directory: %v", err)
+	}
+}
+
+// TestBackupSecrets checks a backup without ?keys=true masks every secret,
+// in the settings and the profiles, and restoring it keeps the current ones
+func TestBackupSecrets(t *testing.T) {
+	secrets := []string{"nimb-proxy-key-abcdefgh5678", "https://discord.com/api/webhooks/1/tok3n", "tunnel-token-abcdefgh", "ngrok-token-abcdefgh"}
+	withSecrets := func(a *App) {
+		a.config.ProxyAPIKey = secrets[0]
+		a.config.WebhookURL = secrets[1]
+		a.config.TunnelToken = secrets[2]
+		a.config.TunnelHostname = "nimb.example.com"
+		a.config.NgrokAuthtoken = secrets[3]
+	}
+	src := newTestApp(t, nil)
+	withSecrets(src)
+	if err := src.saveProfile("work"); err != nil {
+		t.Fatal(err)
+	}
+
+	masked := serve(src.handleBackup, "GET", "/api/backup", "").Body.String()
+	for _, secret := range secrets {
+		if strings.Contains(masked, secret) {
+			t.Errorf("the backup shows %s", secret)
+		}
+	}
+	full := serve(src.handleBackup, "GET", "/api/backup?keys=true", "").Body.String()
+	for _, secret := range secrets {
+		if !strings.Contains(full, secret) {
+			t.Errorf("the backup with keys lacks %s", secret)
+		}
+	}
+
+	dst := newTestApp(t, nil)
+	dst.config.ProxyAPIKey = "nimb-dst-proxy-key-12345678"
+	dst.config.WebhookURL = "https://hooks.slack.com/services/T0/B0/dst"
+	if rec := serve(dst.handleRestore, "POST", "/api/restore", masked); rec.Code != 200 {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if dst.config.ProxyAPIKey != "nimb-dst-proxy-key-12345678" || dst.config.WebhookURL != "https://hooks.slack.com/services/T0/B0/dst" {
+		t.Errorf("restored proxy key %q, webhook %q, want the current ones", dst.config.ProxyAPIKey, dst.config.WebhookURL)
+	}
+	if dst.config.TunnelToken != "" {
+		t.Errorf("restored tunnel token %q, want none", dst.config.TunnelToken)
+	}
+	profile, err := os.ReadFile(dst.profilePath("work"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(profile), maskedValue) || !strings.Contains(string(profile), "nimb-dst-proxy-key-12345678") {
+		t.Errorf("restored profile %s", profile)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultBenchPrompt asks for a reply long enough to measure a rate
+	defaultBenchPrompt     = "Write a short paragraph describing the ocean at dawn."
+	defaultBenchIterations = 3
+	maxBenchIterations     = 20
+	// benchMaxTokens keeps runs comparable and bounds their cost
+	benchMaxTokens = 256
+)
+
+// benchRun is the timing of one benchmark iteration
+type benchRun struct {
+	Iteration        int     `json:"iteration"`
+	Status           int     `json:"status"`
+	TTFBMs           int64   `json:"ttfbMs"`
+	TotalMs          int64   `json:"totalMs"`
+	CompletionTokens int     `json:"completionTokens"`
+	Estimated        bool    `json:"estimated,omitempty"`
+	TokensPerSec     float64 `json:"tokensPerSec"`
+	Error            string  `json:"error,omitempty"`
+}
+
+// benchSummary aggregates one measure over the successful runs
+type benchSummary struct {
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	Max    float64 `json:"max"`
+}
+
+func summarizeBench(values []float64) benchSummary {
+	if len(values) == 0 {
+		return benchSummary{}
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + median) / 2
+	}
+	return benchSummary{Min: sorted[0], Median: median, Max: sorted[len(sorted)-1]}
+}
+
+// benchOnce sends one benchmark request and measures it. Token usage is
+// recorded like any other request so it counts towards the budget.
+func (a *App) benchOnce(ctx context.Context, config Config, payload map[string]interface{}, stream bool) benchRun {
+	var run benchRun
+	body, _ := json.Marshal(payload)
+	model, _ := payload["model"].(string)
+
+	start := time.Now()
+	resp, err := a.doUpstream(ctx, "/chat/completions", body, &config)
+	if err != nil {
+		run.Error = scrubSecretsWith(err.Error(), config.APIKey)
+		return run
+	}
+	defer resp.Body.Close()
+	run.Status = resp.StatusCode
+	run.TTFBMs = time.Since(start).Milliseconds()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		run.Error = upstreamErrorMessage(data)
+		if run.Error == "" {
+			run.Error = resp.Status
+		}
+		run.TotalMs = time.Since(start).Milliseconds()
+		return run
+	}
+
+	var usage map[string]interface{}
+	var text strings.Builder
+	var firstData time.Time
+	if stream {
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64<<10), 1<<20)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			data = strings.TrimSpace(data)
+			if !ok || data == "[DONE]" {
+				continue
+			}
+			var chunk map[string]interface{}
+			if json.Unmarshal([]byte(data), &chunk) != nil {
+				continue
+			}
+			if u, ok := chunk["usage"].(map[string]interface{}); ok {
+				usage = u
+			}
+			choices, _ := chunk["choices"].([]interface{})
+			for _, c := range choices {
+				choice, _ := c.(map[string]interface{})
+				delta, _ := choice["delta"].(map[string]interface{})
+				content, _ := delta["content"].(string)
+				reasoning, _ := delta["reasoning_content"].(string)
+				if (content != "" || reasoning != "") && firstData.IsZero() {
+					firstData = time.Now()
+				}
+				text.WriteString(content)
+			}
+		}
+		err = scanner.Err()
+	} else {
+		var data []byte
+		data, err = io.ReadAll(resp.Body)
+		var result map[string]interface{}
+		if json.Unmarshal(data, &result) == nil {
+			usage, _ = result["usage"].(map[string]interface{})
+			text.WriteString(responseText(result))
+		}
+	}
+	end := time.Now()
+	run.TotalMs = end.Sub(start).Milliseconds()
+	if err != nil {
+		run.Error = bodyTimeout(ctx, err).Error()
+		return run
+	}
+
+	id := keyID(config.APIKey)
+	if usage != nil {
+		run.CompletionTokens = usageCompletionTokens(usage)
+		a.recordUsage(id, model, usage)
+	} else {
+		run.CompletionTokens = tokenCounter.Count(text.String())
+		run.Estimated = true
+		a.recordEstimatedUsage(id, model, estimatePromptTokens(payload), run.CompletionTokens)
+	}
+
+	// For streams the rate covers generation only, not the wait before it
+	generating := end.Sub(start)
+	if !firstData.IsZero() {
+		run.TTFBMs = firstData.Sub(start).Milliseconds()
+		generating = end.Sub(firstData)
+	}
+	run.TokensPerSec = tokensPerSecond(run.CompletionTokens, generating)
+	return run
+}
+
+// handleBenchmark runs a fixed prompt against a model several times in a
+// row and reports TTFB, total time and tokens per second. Runs are
+// sequential so they don't slow each other down, and stop when the client
+// goes away, a run fails or the daily budget is reached.
+func (a *App) handleBenchmark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Model      string `json:"model"`
+		Prompt     string `json:"prompt"`
+		Iterations int    `json:"iterations"`
+		Stream     bool   `json:"stream"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Iterations == 0 {
+		req.Iterations = defaultBenchIterations
+	}
+	if req.Iterations < 0 || req.Iterations > maxBenchIterations {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "iterations must be between 1 and " + strconv.Itoa(maxBenchIterations),
+		})
+		return
+	}
+	if req.Prompt == "" {
+		req.Prompt = defaultBenchPrompt
+	}
+
+	config, ok := a.proxyConfig(w, r)
+	if !ok {
+		return
+	}
+	if config.MockMode {
+		writeAPIError(w, 409, "Mock mode is on, so there is no upstream to benchmark", "invalid_request_error")
+		return
+	}
+	if exceeded, resetAt := a.budgetExceeded(); exceeded {
+		writeAPIError(w, 429, "Daily token budget reached, resets at "+resetAt.Format(time.RFC3339), "budget_exceeded")
+		return
+	}
+
+	model := req.Model
+	if target := config.ModelAliases[model]; target != "" {
+		model = target
+	}
+	if model == "" {
+		model = config.CurrentModel
+	}
+	useProvider(&config, a.router.pick(config, model))
+
+	payload := map[string]interface{}{
+		"model":      model,
+		"messages":   []interface{}{map[string]interface{}{"role": "user", "content": req.Prompt}},
+		"max_tokens": benchMaxTokens,
+		"stream":     req.Stream,
+	}
+	if req.Stream {
+		payload["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+
+	ctx := r.Context()
+	runs := []benchRun{}
+	stopped := ""
+	for i := 1; i <= req.Iterations; i++ {
+		if ctx.Err() != nil {
+			return
+		}
+		if i > 1 {
+			if exceeded, _ := a.budgetExceeded(); exceeded {
+				stopped = "budget_exceeded"
+				break
+			}
+		}
+		run := a.benchOnce(ctx, config, payload, req.Stream)
+		run.Iteration = i
+
+		a.mu.Lock()
+		a.stats.BenchmarkRuns++
+		a.mu.Unlock()
+		a.statsHub.publish()
+
+		runs = append(runs, run)
+		if run.Error != "" {
+			stopped = "run_failed"
+			break
+		}
+	}
+
+	var ttfb, total, tps []float64
+	for _, run := range runs {
+		if run.Error != "" {
+			continue
+		}
+		ttfb = append(ttfb, float64(run.TTFBMs))
+		total = append(total, float64(run.TotalMs))
+		if run.TokensPerSec > 0 {
+			tps = append(tps, run.TokensPerSec)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      stopped != "run_failed",
+		"model":        model,
+		"stream":       req.Stream,
+		"stopped":      stopped,
+		"ttfbMs":       summarizeBench(ttfb),
+		"totalMs":      summarizeBench(total),
+		"tokensPerSec": summarizeBench(tps),
+		"runs":         runs,
+	})
+}
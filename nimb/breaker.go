@@ -0,0 +1,134 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// Defaults used when the breaker settings are unset
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerWindow    = 60 * time.Second
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// circuitBreaker stops sending requests to an upstream that keeps failing.
+// After threshold consecutive failures within the window it opens and fails
+// requests fast; once the cool-down passes a single probe is let through,
+// which closes the breaker on success or reopens it on failure.
+type circuitBreaker struct {
+	mu           sync.Mutex
+	state        string
+	failures     int
+	firstFailure time.Time
+	nextProbe    time.Time
+	probing      bool
+}
+
+// breakerSettings returns the effective breaker settings for a config
+func breakerSettings(config Config) (threshold int, window, cooldown time.Duration) {
+	threshold = config.BreakerThreshold
+	if threshold <= 0 {
+		threshold = defaultBreakerThreshold
+	}
+	window = time.Duration(config.BreakerWindowSec) * time.Second
+	if window <= 0 {
+		window = defaultBreakerWindow
+	}
+	cooldown = time.Duration(config.BreakerCooldownSec) * time.Second
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return threshold, window, cooldown
+}
+
+// allow reports whether a request may go upstream, and if not, how long
+// until the next probe
+func (b *circuitBreaker) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if wait := time.Until(b.nextProbe); wait > 0 {
+			return false, wait
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true, 0
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, time.Second
+		}
+		b.probing = true
+		return true, 0
+	}
+	return true, 0
+}
+
+// record reports the outcome of an upstream call
+func (b *circuitBreaker) record(success bool, config Config) {
+	threshold, window, cooldown := breakerSettings(config)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.state = BreakerClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+
+	now := time.Now()
+	if b.state == BreakerHalfOpen {
+		b.state = BreakerOpen
+		b.probing = false
+		b.nextProbe = now.Add(cooldown)
+		return
+	}
+
+	if b.failures == 0 || now.Sub(b.firstFailure) > window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.failures >= threshold {
+		b.state = BreakerOpen
+		b.nextProbe = now.Add(cooldown)
+	}
+}
+
+// abort gives up a probe slot without recording an outcome, e.g. when the
+// client disconnected before the upstream answered
+func (b *circuitBreaker) abort() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// status describes the breaker for /api/health
+func (b *circuitBreaker) status() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state
+	if state == "" {
+		state = BreakerClosed
+	}
+	status := map[string]interface{}{
+		"state":    state,
+		"failures": b.failures,
+	}
+	if state != BreakerClosed {
+		status["nextProbe"] = b.nextProbe.Format(time.RFC3339)
+	}
+	return status
+}
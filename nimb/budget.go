@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// budgetState tracks tokens spent on one local calendar day. It is stored
+// separately from the stats so neither restarts nor a stats reset clear it.
+type budgetState struct {
+	Date string `json:"date"`
+	Used int    `json:"used"`
+}
+
+func (a *App) budgetPath() string {
+	return filepath.Join(a.settingsDir, "budget.json")
+}
+
+func (a *App) loadBudget() {
+	data, err := os.ReadFile(a.budgetPath())
+	if err != nil {
+		return
+	}
+	var saved budgetState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return
+	}
+	a.mu.Lock()
+	a.budget = saved
+	a.mu.Unlock()
+}
+
+func (a *App) saveBudget() error {
+	a.mu.RLock()
+	data, err := json.Marshal(a.budget)
+	a.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(a.budgetPath(), data, 0644)
+}
+
+// nextMidnight returns the start of the next local day after t
+func nextMidnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+}
+
+// rollBudgetLocked starts a new budget day when the date has changed.
+// Callers must hold a.mu.
+func (a *App) rollBudgetLocked() {
+	today := time.Now().Format("2006-01-02")
+	if a.budget.Date != today {
+		a.budget = budgetState{Date: today}
+	}
+}
+
+// budgetExceeded reports whether today's token budget is used up, and when it resets
+func (a *App) budgetExceeded() (bool, time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rollBudgetLocked()
+	limit := a.config.DailyTokenBudget
+	return limit > 0 && a.budget.Used >= limit, nextMidnight(time.Now())
+}
+
+// budgetStatusLocked fills the budget fields of a stats snapshot. Callers must hold a.mu.
+func (a *App) budgetStatusLocked(stats *Stats) {
+	used := a.budget.Used
+	if a.budget.Date != time.Now().Format("2006-01-02") {
+		used = 0
+	}
+	stats.BudgetUsed = used
+	stats.BudgetRemaining = -1
+	if limit := a.config.DailyTokenBudget; limit > 0 {
+		stats.BudgetRemaining = max(limit-used, 0)
+	}
+	stats.BudgetResetAt = nextMidnight(time.Now()).Format(time.RFC3339)
+}
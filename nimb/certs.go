@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	_ "embed"
+	"errors"
+	"os"
+)
+
+// bundledCAs is the Mozilla CA bundle, used when the device's system roots
+// aren't visible to Go (Termux/Android)
+//
+//go:embed certs/cacert.pem
+var bundledCAs []byte
+
+// TLS verification modes reported by /api/health
+const (
+	TLSModeSystem   = "system"   // system roots plus the bundled CAs
+	TLSModeBundled  = "bundled"  // bundled CAs only
+	TLSModeCustom   = "custom"   // CABundlePath or $SSL_CERT_FILE
+	TLSModeInsecure = "insecure" // certificates are not verified
+)
+
+// loadCABundle reads a PEM file into a new certificate pool
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("cannot read CA bundle: " + err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("CA bundle " + path + " contains no PEM certificates")
+	}
+	return pool, nil
+}
+
+// upstreamTLSConfig picks the root CAs for upstream connections and reports
+// the resulting verification mode. Verification is only skipped when
+// allowInsecure is set explicitly.
+func upstreamTLSConfig(caBundlePath string, allowInsecure bool) (*tls.Config, string) {
+	if allowInsecure {
+		return &tls.Config{InsecureSkipVerify: true}, TLSModeInsecure
+	}
+
+	if caBundlePath == "" {
+		caBundlePath = os.Getenv("SSL_CERT_FILE")
+	}
+	if caBundlePath != "" {
+		pool, err := loadCABundle(caBundlePath)
+		if err == nil {
+			return &tls.Config{RootCAs: pool}, TLSModeCustom
+		}
+		logWarnf("[NIMB] %v, using default roots", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	mode := TLSModeSystem
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+		mode = TLSModeBundled
+	}
+	pool.AppendCertsFromPEM(bundledCAs)
+	return &tls.Config{RootCAs: pool}, mode
+}
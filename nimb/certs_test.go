@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestUpstreamTLSConfig checks which roots are used for each setting and
+// that verification is only skipped when asked
+func TestUpstreamTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "ca.pem")
+	os.WriteFile(valid, bundledCAs, 0644)
+	invalid := filepath.Join(dir, "junk.pem")
+	os.WriteFile(invalid, []byte("not a certificate"), 0644)
+
+	tests := []struct {
+		name     string
+		path     string
+		env      string
+		insecure bool
+		modes    []string
+	}{
+		{"default", "", "", false, []string{TLSModeSystem, TLSModeBundled}},
+		{"custom bundle", valid, "", false, []string{TLSModeCustom}},
+		{"SSL_CERT_FILE", "", valid, false, []string{TLSModeCustom}},
+		{"unreadable bundle", filepath.Join(dir, "missing.pem"), "", false, []string{TLSModeSystem, TLSModeBundled}},
+		{"bundle without certificates", invalid, "", false, []string{TLSModeSystem, TLSModeBundled}},
+		{"insecure", valid, "", true, []string{TLSModeInsecure}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SSL_CERT_FILE", tt.env)
+			cfg, mode := upstreamTLSConfig(tt.path, tt.insecure)
+			if !slices.Contains(tt.modes, mode) {
+				t.Errorf("mode = %s, want one of %v", mode, tt.modes)
+			}
+			if cfg.InsecureSkipVerify != tt.insecure {
+				t.Errorf("InsecureSkipVerify = %v, want %v", cfg.InsecureSkipVerify, tt.insecure)
+			}
+			if !tt.insecure && cfg.RootCAs == nil {
+				t.Error("no root CAs")
+			}
+		})
+	}
+}
+
+// TestCABundleTrustsServer checks a server whose certificate is in the
+// custom bundle is trusted, and one that isn't is refused
+func TestCABundleTrustsServer(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	t.Setenv("SSL_CERT_FILE", "")
+
+	own := filepath.Join(t.TempDir(), "server.pem")
+	os.WriteFile(own, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644)
+	other := filepath.Join(t.TempDir(), "other.pem")
+	os.WriteFile(other, bundledCAs, 0644)
+
+	tests := []struct {
+		name   string
+		bundle string
+		ok     bool
+	}{
+		{"server's certificate", own, true},
+		{"other CAs", other, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _ := upstreamTLSConfig(tt.bundle, false)
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != tt.ok {
+				t.Errorf("err = %v, want success %v", err, tt.ok)
+			}
+		})
+	}
+}
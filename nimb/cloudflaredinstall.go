@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// cloudflaredReleaseURL describes the newest cloudflared release
+	cloudflaredReleaseURL = "https://api.github.com/repos/cloudflare/cloudflared/releases/latest"
+	// cloudflaredDownloadTimeout bounds the whole download on a slow link
+	cloudflaredDownloadTimeout = 10 * time.Minute
+	cloudflaredVersionTimeout  = 10 * time.Second
+)
+
+// Install states for installState.Status
+const (
+	InstallIdle        = "idle"
+	InstallDownloading = "downloading"
+	InstallDone        = "done"
+	InstallError       = "error"
+)
+
+// installState is the progress of a cloudflared download, polled through
+// GET /api/tunnel/install
+type installState struct {
+	mu       sync.Mutex
+	Status   string
+	Version  string
+	Received int64
+	// Total is -1 while the size is unknown
+	Total int64
+	Path  string
+	Error string
+}
+
+// snapshot copies the state for encoding
+func (s *installState) snapshot() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.Status
+	if status == "" {
+		status = InstallIdle
+	}
+	return map[string]interface{}{
+		"status":   status,
+		"version":  s.Version,
+		"received": s.Received,
+		"total":    s.Total,
+		"path":     s.Path,
+		"error":    s.Error,
+	}
+}
+
+// binDir is where NIMB keeps programs it downloaded
+func (a *App) binDir() string {
+	return filepath.Join(a.settingsDir, "bin")
+}
+
+// cloudflaredAsset is the release file for this OS and architecture
+func cloudflaredAsset() (string, error) {
+	switch runtime.GOOS + "/" + runtime.GOARCH {
+	case "linux/arm64", "android/arm64":
+		return "cloudflared-linux-arm64", nil
+	case "linux/arm", "android/arm":
+		return "cloudflared-linux-arm", nil
+	case "linux/amd64", "android/amd64":
+		return "cloudflared-linux-amd64", nil
+	case "windows/amd64":
+		return "cloudflared-windows-amd64.exe", nil
+	}
+	return "", fmt.Errorf("no cloudflared download for %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// cloudflaredRelease is the part of the GitHub release we use
+type cloudflaredRelease struct {
+	TagName string `json:"tag_name"`
+	Body    string `json:"body"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+		// Digest is "sha256:<hex>" on releases published since GitHub
+		// started recording it
+		Digest string `json:"digest"`
+	} `json:"assets"`
+}
+
+// checksum returns the SHA-256 published for asset: GitHub's digest, or
+// the "name: hex" list cloudflared puts in its release notes
+func (r cloudflaredRelease) checksum(asset string) string {
+	for _, f := range r.Assets {
+		if f.Name == asset && strings.HasPrefix(f.Digest, "sha256:") {
+			return strings.ToLower(strings.TrimPrefix(f.Digest, "sha256:"))
+		}
+	}
+	pattern := regexp.MustCompile(`(?m)^\s*` + regexp.QuoteMeta(asset) + `:\s*([0-9a-fA-F]{64})\s*$`)
+	if m := pattern.FindStringSubmatch(r.Body); m != nil {
+		return strings.ToLower(m[1])
+	}
+	return ""
+}
+
+// installCloudflared downloads the latest cloudflared into binDir,
+// replacing an earlier download once the new one checks out
+func (a *App) installCloudflared(ctx context.Context) error {
+	asset, err := cloudflaredAsset()
+	if err != nil {
+		return err
+	}
+	client := a.upstreamClient()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", cloudflaredReleaseURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach GitHub: %w", err)
+	}
+	var release cloudflaredRelease
+	err = json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&release)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GitHub answered %d for the latest cloudflared release", resp.StatusCode)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read the cloudflared release: %w", err)
+	}
+
+	downloadURL := ""
+	for _, f := range release.Assets {
+		if f.Name == asset {
+			downloadURL = f.URL
+		}
+	}
+	if downloadURL == "" {
+		return fmt.Errorf("release %s has no %s", release.TagName, asset)
+	}
+	want := release.checksum(asset)
+	if want == "" {
+		return fmt.Errorf("release %s publishes no checksum for %s", release.TagName, asset)
+	}
+
+	a.install.mu.Lock()
+	a.install.Version = release.TagName
+	a.install.mu.Unlock()
+
+	req, err = http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+	a.install.mu.Lock()
+	a.install.Total = resp.ContentLength
+	a.install.mu.Unlock()
+
+	dir := a.binDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "cloudflared-*.download")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash, progressWriter{&a.install}), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", asset, got, want)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	name := "cloudflared"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	path := filepath.Join(dir, name)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	a.install.mu.Lock()
+	a.install.Path = path
+	a.install.mu.Unlock()
+	logInfof("Installed cloudflared %s at %s", release.TagName, path)
+	return nil
+}
+
+// progressWriter counts downloaded bytes into an installState
+type progressWriter struct{ state *installState }
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	p.state.mu.Lock()
+	p.state.Received += int64(len(b))
+	p.state.mu.Unlock()
+	return len(b), nil
+}
+
+// handleTunnelInstall starts a cloudflared download on POST and reports its
+// progress on GET
+func (a *App) handleTunnelInstall(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.install.snapshot())
+		return
+	case "POST":
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	a.install.mu.Lock()
+	if a.install.Status == InstallDownloading {
+		a.install.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "A download is already running"})
+		return
+	}
+	a.install.Status = InstallDownloading
+	a.install.Version, a.install.Path, a.install.Error = "", "", ""
+	a.install.Received, a.install.Total = 0, -1
+	a.install.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cloudflaredDownloadTimeout)
+		defer cancel()
+		err := a.installCloudflared(ctx)
+
+		a.install.mu.Lock()
+		defer a.install.mu.Unlock()
+		if err != nil {
+			logErrorf("cloudflared install failed: %v", err)
+			a.install.Status = InstallError
+			a.install.Error = err.Error()
+			return
+		}
+		a.install.Status = InstallDone
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "status": InstallDownloading})
+}
+
+// handleTunnelBinary reports which cloudflared StartTunnel would run and
+// what version it is
+func (a *App) handleTunnelBinary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	path, err := findTunnelBinary(a.binDir(), "cloudflared", cloudflaredInstallHint)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"found": false, "error": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), cloudflaredVersionTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	result := map[string]interface{}{
+		"found":   true,
+		"path":    path,
+		"managed": filepath.Dir(path) == a.binDir(),
+		"version": strings.TrimSpace(string(out)),
+	}
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = ctx.Err()
+		}
+		result["error"] = "cloudflared --version failed: " + err.Error()
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,75 @@
+package main
+
+import "strings"
+
+// reasoningEffortModels accept reasoning_effort natively. For models with a
+// thinking toggle instead (see thinkingFamilies) the effort is mapped onto
+// the toggle; everywhere else it is dropped.
+var reasoningEffortModels = []string{"openai/gpt-oss"}
+
+// supportsReasoningEffort reports whether a model takes reasoning_effort as is
+func supportsReasoningEffort(model string) bool {
+	model = strings.ToLower(model)
+	for _, prefix := range reasoningEffortModels {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// translateCompat rewrites, in place, request fields from newer OpenAI SDKs
+// that NIM doesn't understand, and returns a description of each rewrite.
+// model is the upstream model the request will be sent to.
+func translateCompat(reqBody map[string]interface{}, model string) []string {
+	var rewrites []string
+
+	if mct, ok := reqBody["max_completion_tokens"]; ok {
+		if _, explicit := reqBody["max_tokens"]; !explicit {
+			reqBody["max_tokens"] = mct
+			rewrites = append(rewrites, "max_completion_tokens -> max_tokens")
+		} else {
+			rewrites = append(rewrites, "max_completion_tokens dropped (max_tokens set)")
+		}
+		delete(reqBody, "max_completion_tokens")
+	}
+
+	if messages, ok := reqBody["messages"].([]interface{}); ok {
+		developer := 0
+		for _, m := range messages {
+			if msg, ok := m.(map[string]interface{}); ok && msg["role"] == "developer" {
+				msg["role"] = "system"
+				developer++
+			}
+		}
+		if developer > 0 {
+			rewrites = append(rewrites, "developer role -> system")
+		}
+	}
+
+	if effort, ok := reqBody["reasoning_effort"].(string); ok && !supportsReasoningEffort(model) {
+		delete(reqBody, "reasoning_effort")
+		if key := thinkingKwarg(model); key != "" {
+			kwargs, _ := reqBody["chat_template_kwargs"].(map[string]interface{})
+			if kwargs == nil {
+				kwargs = map[string]interface{}{}
+				reqBody["chat_template_kwargs"] = kwargs
+			}
+			if _, explicit := kwargs[key]; !explicit {
+				kwargs[key] = effort != "none" && effort != "minimal"
+			}
+			rewrites = append(rewrites, "reasoning_effort -> chat_template_kwargs."+key)
+		} else {
+			rewrites = append(rewrites, "reasoning_effort dropped")
+		}
+	}
+
+	for _, field := range []string{"store", "metadata"} {
+		if _, ok := reqBody[field]; ok {
+			delete(reqBody, field)
+			rewrites = append(rewrites, field+" dropped")
+		}
+	}
+
+	return rewrites
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestTranslateCompat(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    string
+		in       string
+		want     string
+		rewrites []string
+	}{
+		{"nothing to do", "meta/llama-3.1-8b-instruct",
+			`{"max_tokens":100,"messages":[{"role":"system","content":"s"}]}`,
+			`{"max_tokens":100,"messages":[{"role":"system","content":"s"}]}`, nil},
+		{"max_completion_tokens", "meta/llama-3.1-8b-instruct",
+			`{"max_completion_tokens":256}`,
+			`{"max_tokens":256}`, []string{"max_completion_tokens -> max_tokens"}},
+		{"max_tokens already set", "meta/llama-3.1-8b-instruct",
+			`{"max_completion_tokens":256,"max_tokens":100}`,
+			`{"max_tokens":100}`, []string{"max_completion_tokens dropped (max_tokens set)"}},
+		{"developer role", "meta/llama-3.1-8b-instruct",
+			`{"messages":[{"role":"developer","content":"d"},{"role":"user","content":"u"},{"role":"developer","content":"e"}]}`,
+			`{"messages":[{"role":"system","content":"d"},{"role":"user","content":"u"},{"role":"system","content":"e"}]}`,
+			[]string{"developer role -> system"}},
+		{"reasoning_effort kept", "openai/gpt-oss-120b",
+			`{"reasoning_effort":"high"}`,
+			`{"reasoning_effort":"high"}`, nil},
+		{"reasoning_effort on deepseek", "deepseek-ai/deepseek-v3.1",
+			`{"reasoning_effort":"medium"}`,
+			`{"chat_template_kwargs":{"thinking":true}}`, []string{"reasoning_effort -> chat_template_kwargs.thinking"}},
+		{"reasoning_effort none on qwen", "qwen/qwen3-235b-a22b",
+			`{"reasoning_effort":"none"}`,
+			`{"chat_template_kwargs":{"enable_thinking":false}}`, []string{"reasoning_effort -> chat_template_kwargs.enable_thinking"}},
+		{"reasoning_effort minimal", "Qwen/Qwen3-32B",
+			`{"reasoning_effort":"minimal","chat_template_kwargs":{"other":1}}`,
+			`{"chat_template_kwargs":{"other":1,"enable_thinking":false}}`, []string{"reasoning_effort -> chat_template_kwargs.enable_thinking"}},
+		{"client kwarg wins", "deepseek-ai/deepseek-v3.1",
+			`{"reasoning_effort":"high","chat_template_kwargs":{"thinking":false}}`,
+			`{"chat_template_kwargs":{"thinking":false}}`, []string{"reasoning_effort -> chat_template_kwargs.thinking"}},
+		{"reasoning_effort dropped", "meta/llama-3.1-8b-instruct",
+			`{"reasoning_effort":"low"}`,
+			`{}`, []string{"reasoning_effort dropped"}},
+		{"store and metadata", "meta/llama-3.1-8b-instruct",
+			`{"store":true,"metadata":{"user":"u1"},"temperature":0.5}`,
+			`{"temperature":0.5}`, []string{"store dropped", "metadata dropped"}},
+		{"everything", "qwen/qwen3-235b-a22b",
+			`{"max_completion_tokens":64,"messages":[{"role":"developer","content":"d"}],"reasoning_effort":"low","store":false}`,
+			`{"max_tokens":64,"messages":[{"role":"system","content":"d"}],"chat_template_kwargs":{"enable_thinking":true}}`,
+			[]string{"max_completion_tokens -> max_tokens", "developer role -> system", "reasoning_effort -> chat_template_kwargs.enable_thinking", "store dropped"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reqBody, want map[string]interface{}
+			json.Unmarshal([]byte(tt.in), &reqBody)
+			json.Unmarshal([]byte(tt.want), &want)
+			rewrites := translateCompat(reqBody, tt.model)
+			if !reflect.DeepEqual(reqBody, want) {
+				got, _ := json.Marshal(reqBody)
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+			if !slices.Equal(rewrites, tt.rewrites) {
+				t.Errorf("rewrites = %q, want %q", rewrites, tt.rewrites)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+)
+
+// Legacy /v1/completions support.
+//
+// Models listed in Config.CompletionModels are forwarded to the upstream
+// /completions endpoint unchanged. Every other model gets the prompt wrapped
+// in a single user message and the chat response converted back into the
+// text_completion shape. The conversion cannot express a few legacy
+// features, so these return 400 instead of being silently ignored:
+//   - suffix (fill-in-the-middle needs a native completions model)
+//   - echo: true (the chat API never returns the prompt)
+//   - n or best_of above 1, and arrays of more than one prompt
+
+// completionPrompt extracts a single prompt string from a legacy request,
+// returning an error message and offending param when it can't be converted
+func completionPrompt(reqBody map[string]interface{}) (prompt, errMsg, param string) {
+	switch p := reqBody["prompt"].(type) {
+	case string:
+		prompt = p
+	case []interface{}:
+		if len(p) != 1 {
+			return "", "only a single prompt is supported", "prompt"
+		}
+		s, ok := p[0].(string)
+		if !ok {
+			return "", "token array prompts are not supported", "prompt"
+		}
+		prompt = s
+	default:
+		return "", "prompt must be a string", "prompt"
+	}
+
+	if suffix, ok := reqBody["suffix"].(string); ok && suffix != "" {
+		return "", "suffix is not supported for this model", "suffix"
+	}
+	if echo, ok := reqBody["echo"].(bool); ok && echo {
+		return "", "echo is not supported for this model", "echo"
+	}
+	if n, ok := reqBody["n"].(float64); ok && n > 1 {
+		return "", "n > 1 is not supported for this model", "n"
+	}
+	if bestOf, ok := reqBody["best_of"].(float64); ok && bestOf > 1 {
+		return "", "best_of > 1 is not supported for this model", "best_of"
+	}
+	return prompt, "", ""
+}
+
+// chatToCompletion converts a chat completion response into a text_completion
+func chatToCompletion(resp map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{
+		"id":      resp["id"],
+		"object":  "text_completion",
+		"created": resp["created"],
+		"model":   resp["model"],
+	}
+	if usage, ok := resp["usage"]; ok {
+		out["usage"] = usage
+	}
+
+	choices, _ := resp["choices"].([]interface{})
+	converted := make([]interface{}, 0, len(choices))
+	for _, c := range choices {
+		choice, _ := c.(map[string]interface{})
+		msg, _ := choice["message"].(map[string]interface{})
+		text, _ := msg["content"].(string)
+		converted = append(converted, map[string]interface{}{
+			"text":          text,
+			"index":         choice["index"],
+			"logprobs":      nil,
+			"finish_reason": choice["finish_reason"],
+		})
+	}
+	out["choices"] = converted
+	return out
+}
+
+// chatChunkToCompletion converts a streamed chat chunk into a text_completion chunk
+func chatChunkToCompletion(chunk map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{
+		"id":      chunk["id"],
+		"object":  "text_completion",
+		"created": chunk["created"],
+		"model":   chunk["model"],
+	}
+	if usage, ok := chunk["usage"]; ok {
+		out["usage"] = usage
+	}
+
+	choices, _ := chunk["choices"].([]interface{})
+	converted := make([]interface{}, 0, len(choices))
+	for _, c := range choices {
+		choice, _ := c.(map[string]interface{})
+		delta, _ := choice["delta"].(map[string]interface{})
+		text, _ := delta["content"].(string)
+		converted = append(converted, map[string]interface{}{
+			"text":          text,
+			"index":         choice["index"],
+			"logprobs":      nil,
+			"finish_reason": choice["finish_reason"],
+		})
+	}
+	out["choices"] = converted
+	return out
+}
+
+var completionAdapter = &chatAdapter{
+	response: chatToCompletion,
+	chunk:    chatChunkToCompletion,
+}
+
+func (a *App) handleCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, ok := a.proxyConfig(w, r)
+	if !ok {
+		return
+	}
+
+	reqBody, ok := a.readJSONBody(w, r, config.MaxRequestBytes)
+	if !ok {
+		return
+	}
+
+	requested, _ := reqBody["model"].(string)
+	model := resolveModel(requested, config)
+
+	if slices.Contains(config.CompletionModels, model) {
+		nimReq := buildUpstreamRequest(reqBody, config)
+		delete(nimReq, "messages")
+		for _, p := range []string{"prompt", "suffix", "echo", "best_of", "logprobs"} {
+			if v, ok := reqBody[p]; ok {
+				nimReq[p] = v
+			}
+		}
+
+		if config.LogRequests {
+			logInfof("[NIMB] completions %v -> %s", reqBody["model"], model)
+		}
+		a.forward(w, r, config, proxyRequest{
+			path:        "/completions",
+			payload:     nimReq,
+			clientModel: aliasedModel(reqBody, config),
+		})
+		return
+	}
+
+	prompt, errMsg, param := completionPrompt(reqBody)
+	if errMsg != "" {
+		a.writeInvalidRequest(w, &requestError{errMsg, param})
+		return
+	}
+
+	chatReq := map[string]interface{}{}
+	for k, v := range reqBody {
+		chatReq[k] = v
+	}
+	chatReq["messages"] = []interface{}{
+		map[string]interface{}{"role": "user", "content": prompt},
+	}
+
+	if verr := validateChatRequest(chatReq); verr != nil {
+		a.writeInvalidRequest(w, verr)
+		return
+	}
+
+	nimReq := buildUpstreamRequest(chatReq, config)
+
+	if config.LogRequests {
+		logInfof("[NIMB] completions %v -> %v (via chat)", reqBody["model"], nimReq["model"])
+	}
+
+	a.forward(w, r, config, proxyRequest{
+		path:        "/chat/completions",
+		payload:     nimReq,
+		clientModel: aliasedModel(reqBody, config),
+		adapter:     completionAdapter,
+	})
+}
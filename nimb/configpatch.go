@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// patchConfig applies a JSON config update onto current. Fields missing
+// from body keep their current value, so {"temperature":0.3} changes only
+// the temperature; a full config object replaces every field as before.
+// Maps and slices that are present are replaced rather than merged, and
+// never share storage with current.
+func patchConfig(current Config, body []byte) (Config, error) {
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(body, &present); err != nil {
+		return current, err
+	}
+
+	cfg := current
+	v := reflect.ValueOf(&cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if kind := field.Kind(); kind != reflect.Map && kind != reflect.Slice {
+			continue
+		}
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		for key := range present {
+			if strings.EqualFold(key, name) {
+				field.Set(reflect.Zero(field.Type()))
+				break
+			}
+		}
+	}
+
+	if err := json.Unmarshal(body, &cfg); err != nil {
+		return current, err
+	}
+	return cfg, nil
+}
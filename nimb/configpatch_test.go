@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestPatchConfig checks only the fields sent change, and that maps and
+// slices sent are replaced without sharing storage with the old config
+func TestPatchConfig(t *testing.T) {
+	current := defaultConfig()
+	current.ContextSize = 32768
+	current.CurrentModel = "meta/llama-3.1-8b-instruct"
+	current.ModelAliases = map[string]string{"fast": "meta/llama-3.1-8b-instruct", "big": "meta/llama-3.1-405b-instruct"}
+
+	tests := []struct {
+		name   string
+		body   string
+		change func(c *Config)
+	}{
+		{"empty object", `{}`, func(c *Config) {}},
+		{"one field", `{"temperature":0.3}`, func(c *Config) { c.Temperature = 0.3 }},
+		{"zero value", `{"contextSize":0}`, func(c *Config) { c.ContextSize = 0 }},
+		{"false", `{"streamingEnabled":false}`, func(c *Config) { c.StreamingEnabled = false }},
+		{"map replaced", `{"modelAliases":{"fast":"x/y"}}`, func(c *Config) { c.ModelAliases = map[string]string{"fast": "x/y"} }},
+		{"map cleared", `{"modelAliases":null}`, func(c *Config) { c.ModelAliases = nil }},
+		{"key case", `{"Temperature":0.5}`, func(c *Config) { c.Temperature = 0.5 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := current
+			tt.change(&want)
+			got, err := patchConfig(current, []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("patchConfig(%s) = %+v\nwant %+v", tt.body, got, want)
+			}
+		})
+	}
+
+	got, _ := patchConfig(current, []byte(`{"modelAliases":{"fast":"x/y"}}`))
+	got.ModelAliases["new"] = "a/b"
+	if _, ok := current.ModelAliases["new"]; ok {
+		t.Error("the patched map shares storage with the current config")
+	}
+	if _, err := patchConfig(current, []byte(`{"temperature":"hot"}`)); err == nil {
+		t.Error("a wrongly typed field was accepted")
+	}
+	if _, err := patchConfig(current, []byte(`[1]`)); err == nil {
+		t.Error("a non-object body was accepted")
+	}
+}
+
+// TestSaveConfigPartial checks posting one field leaves the rest of the
+// config alone, and posting back the whole config changes nothing
+func TestSaveConfigPartial(t *testing.T) {
+	a := newTestApp(t, nil)
+	a.config.ContextSize = 32768
+	a.config.CurrentModel = "meta/llama-3.1-8b-instruct"
+	a.config.ModelAliases = map[string]string{"fast": "meta/llama-3.1-8b-instruct"}
+	before := a.config
+
+	rec := serve(a.handleSaveConfig, "POST", "/api/config", `{"temperature":0.3}`)
+	if rec.Code != 200 {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	want := before
+	want.Temperature = 0.3
+	if !reflect.DeepEqual(a.config, want) {
+		t.Errorf("config = %+v\nwant %+v", a.config, want)
+	}
+
+	// The UI posts back what GET /api/config gave it, masked secrets and all
+	full := serve(a.handleConfig, "GET", "/api/config", "").Body.String()
+	if rec := serve(a.handleSaveConfig, "POST", "/api/config", full); rec.Code != 200 {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if !reflect.DeepEqual(a.config, want) {
+		t.Errorf("after posting the full config = %+v\nwant %+v", a.config, want)
+	}
+
+	if rec := serve(a.handleSaveConfig, "POST", "/api/config", `{"contextSize":-1}`); rec.Code != 422 {
+		t.Errorf("invalid value: status = %d, want 422", rec.Code)
+	}
+	if a.config.ContextSize != 32768 {
+		t.Errorf("ContextSize = %d after a rejected update, want 32768", a.config.ContextSize)
+	}
+}
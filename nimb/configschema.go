@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// fieldBounds is the allowed range of a numeric config field
+type fieldBounds struct {
+	min, max float64
+}
+
+// configBounds lists numeric fields with ranges of their own. Every other
+// numeric field is a count, size or duration and only has to be >= 0.
+var configBounds = map[string]fieldBounds{
+	"temperature": {0, 2},
+	"contextSize": {1000, 2_000_000},
+	"maxRetries":  {0, 10},
+}
+
+// requiredFields are string fields that must not be empty
+var requiredFields = []string{"currentModel"}
+
+// configEnums lists the values accepted by mode fields, for the schema
+var configEnums = map[string][]string{
+	"modelOverrideMode":  {ModelModeForce, ModelModeClient, ModelModeMap},
+	"queueMode":          {QueueModeWait, QueueModeReject},
+	"contextMode":        {ContextModePassthrough, ContextModeTrim, ContextModeReject},
+	"systemPromptMode":   {SystemPromptOff, SystemPromptPrepend, SystemPromptOverride},
+	"dnsMode":            {DNSModeUDP, DNSModeDoH, DNSModeSystem},
+	"authHeaderStyle":    {AuthStyleBearer, AuthStyleXAPIKey, AuthStyleNone},
+	"upstreamStreamMode": {StreamModeAuto, StreamModeAlways, StreamModeNever},
+	"keyRotation":        {KeyRotationSticky, KeyRotationRoundRobin},
+	"routingPolicy":      {RouteRoundRobin, RouteLatency},
+	"webhookFormat":      {WebhookFormatJSON, WebhookFormatDiscord, WebhookFormatSlack},
+	"tunnelExposure":     {TunnelExposureProxyOnly, TunnelExposureFull},
+	"tunnelProvider":     {TunnelProviderCloudflare, TunnelProviderNgrok, TunnelProviderSSH},
+	"logLevel":           {LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError},
+	"logFormat":          {LogFormatText, LogFormatJSON},
+}
+
+// fieldError names an invalid config field
+type fieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// configField is one entry of /api/config/schema
+type configField struct {
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	Min     *float64    `json:"min,omitempty"`
+	Max     *float64    `json:"max,omitempty"`
+	Enum    []string    `json:"enum,omitempty"`
+	Default interface{} `json:"default"`
+}
+
+// configFields calls fn with the JSON name and value of each config field
+func configFields(c *Config, fn func(name string, v reflect.Value)) {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fn(name, v.Field(i))
+	}
+}
+
+// boundsOf returns the range of a numeric field
+func boundsOf(name string) fieldBounds {
+	if b, ok := configBounds[name]; ok {
+		return b
+	}
+	return fieldBounds{0, math.Inf(1)}
+}
+
+// validate runs every check a config save does and returns each invalid
+// field. Values that pass are put in normal form: URLs are cleaned up and
+// mode fields left empty get their default.
+func (c *Config) validate() []fieldError {
+	var errs []fieldError
+	check := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fieldError{name, err.Error()})
+		}
+	}
+
+	defaults := defaultConfig()
+	fallback := map[string]reflect.Value{}
+	configFields(&defaults, func(name string, v reflect.Value) { fallback[name] = v })
+	configFields(c, func(name string, v reflect.Value) {
+		var n float64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int64:
+			n = float64(v.Int())
+		case reflect.Float64:
+			n = v.Float()
+		case reflect.String:
+			if slices.Contains(requiredFields, name) && strings.TrimSpace(v.String()) == "" {
+				errs = append(errs, fieldError{name, "must not be empty"})
+			}
+			values, ok := configEnums[name]
+			switch {
+			case !ok:
+			case v.String() == "":
+				v.Set(fallback[name])
+			case !slices.Contains(values, v.String()):
+				errs = append(errs, fieldError{name, "must be one of " + strings.Join(values, ", ")})
+			}
+			return
+		default:
+			return
+		}
+		b := boundsOf(name)
+		switch {
+		case math.IsInf(b.max, 1) && n < b.min:
+			errs = append(errs, fieldError{name, "must be at least " + formatBound(b.min)})
+		case n < b.min || n > b.max:
+			errs = append(errs, fieldError{name, "must be between " + formatBound(b.min) + " and " + formatBound(b.max)})
+		}
+	})
+
+	if baseURL, err := normalizeBaseURL(c.UpstreamBaseURL); err != nil {
+		check("upstreamBaseUrl", err)
+	} else {
+		c.UpstreamBaseURL = baseURL
+	}
+	if proxyURL, err := normalizeProxyURL(c.OutboundProxyURL); err != nil {
+		check("outboundProxyUrl", err)
+	} else {
+		c.OutboundProxyURL = proxyURL
+	}
+	if origins, err := normalizeOrigins(c.CORSOrigins); err != nil {
+		check("corsOrigins", err)
+	} else if c.CORSOrigins != nil {
+		c.CORSOrigins = origins
+	}
+	check("providers", validateProviders(c.Providers))
+	check("modelAliases", validateAliases(c.ModelAliases))
+	if c.ListenAddress != "" {
+		check("listenAddress", validateHost(c.ListenAddress))
+	}
+	errs = append(errs, validateWebhook(*c)...)
+	_, err := parseIPList("allowedIps", c.AllowedIPs)
+	check("allowedIps", err)
+	_, err = parseIPList("blockedIps", c.BlockedIPs)
+	check("blockedIps", err)
+	check("tunnelSshTarget", validateSSHTunnel(*c))
+	check("tunnelHostname", validateTunnel(*c))
+	check("tunnelTargetUrl", validateTunnelTarget(c.TunnelTargetURL))
+	check("tlsCertPath", validateServerTLS(*c))
+	for _, rule := range c.Rules {
+		check("rules", rule.validate())
+	}
+	if c.CABundlePath != "" {
+		_, err := loadCABundle(c.CABundlePath)
+		check("caBundlePath", err)
+	}
+	return errs
+}
+
+// formatBound prints a bound without an exponent
+func formatBound(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// resetInvalid puts the default value back into each invalid field
+func resetInvalid(c *Config, errs []fieldError) {
+	defaults := defaultConfig()
+	fallback := map[string]reflect.Value{}
+	configFields(&defaults, func(name string, v reflect.Value) { fallback[name] = v })
+	configFields(c, func(name string, v reflect.Value) {
+		for _, e := range errs {
+			if e.Field == name {
+				v.Set(fallback[name])
+			}
+		}
+	})
+}
+
+// writeConfigErrors answers a save that failed validation
+func writeConfigErrors(w http.ResponseWriter, errs []fieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   "Invalid config",
+		"fields":  errs,
+	})
+}
+
+// handleConfigSchema describes the config fields, their types, bounds and
+// defaults so forms can be built from it
+func (a *App) handleConfigSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defaults := defaultConfig()
+	fields := []configField{}
+	configFields(&defaults, func(name string, v reflect.Value) {
+		f := configField{Name: name, Default: v.Interface(), Enum: configEnums[name]}
+		switch v.Kind() {
+		case reflect.Bool:
+			f.Type = "boolean"
+		case reflect.Int, reflect.Int64, reflect.Float64:
+			f.Type = "integer"
+			if v.Kind() == reflect.Float64 {
+				f.Type = "number"
+			}
+			b := boundsOf(name)
+			f.Min = &b.min
+			if !math.IsInf(b.max, 1) {
+				f.Max = &b.max
+			}
+		case reflect.String:
+			f.Type = "string"
+		case reflect.Slice:
+			f.Type = "array"
+		default:
+			f.Type = "object"
+		}
+		fields = append(fields, f)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"fields":   fields,
+		"required": requiredFields,
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// normalizeOrigins checks the corsOrigins of a config being saved and
+// returns them in the form browsers send: lower case, no trailing slash
+func normalizeOrigins(origins []string) ([]string, error) {
+	out := make([]string, 0, len(origins))
+	for _, origin := range origins {
+		u, err := url.Parse(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return nil, fmt.Errorf("corsOrigins: %q is not an origin like https://example.com", origin)
+		}
+		out = append(out, strings.ToLower(u.Scheme+"://"+u.Host))
+	}
+	return out, nil
+}
+
+// sameOrigin reports whether origin is the address the request was sent to
+func sameOrigin(r *http.Request, origin string) bool {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return strings.EqualFold(origin, scheme+"://"+r.Host)
+}
+
+// originAllowed reports whether a page from origin may call this server:
+// the UI itself, the running tunnel, or an entry of Config.CORSOrigins
+func (a *App) originAllowed(r *http.Request, origin string) bool {
+	if sameOrigin(r, origin) {
+		return true
+	}
+	origin = strings.ToLower(origin)
+
+	_, tunnelURL := a.tunnelStatus()
+	if tunnelURL != "" && origin == strings.ToLower(tunnelURL) {
+		return true
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.Contains(a.config.CORSOrigins, origin)
+}
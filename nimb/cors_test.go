@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeOrigins(t *testing.T) {
+	tests := []struct {
+		in      []string
+		want    []string
+		wantErr bool
+	}{
+		{[]string{" https://Example.com/ ", "http://localhost:5173"}, []string{"https://example.com", "http://localhost:5173"}, false},
+		{[]string{}, []string{}, false},
+		{[]string{"example.com"}, nil, true},
+		{[]string{"ftp://example.com"}, nil, true},
+		{[]string{"https://example.com/app"}, nil, true},
+		{[]string{"https://example.com?x=1"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeOrigins(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizeOrigins(%q) err = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("normalizeOrigins(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestCORS checks preflight and simple requests from allowed and
+// disallowed origins
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		origin   string
+		allowAll bool
+		status   int
+		allow    string
+		vary     bool
+	}{
+		{"no origin", "GET", "", false, 200, "", true},
+		{"same origin", "GET", "http://example.test", false, 200, "http://example.test", true},
+		{"listed origin", "GET", "https://app.example.com", false, 200, "https://app.example.com", true},
+		{"listed origin other case", "GET", "https://APP.example.com", false, 200, "https://APP.example.com", true},
+		{"tunnel origin", "GET", "https://fox.trycloudflare.com", false, 200, "https://fox.trycloudflare.com", true},
+		{"other origin", "GET", "https://evil.example", false, 200, "", true},
+		{"preflight allowed", "OPTIONS", "https://app.example.com", false, 200, "https://app.example.com", true},
+		{"preflight refused", "OPTIONS", "https://evil.example", false, 403, "", true},
+		{"allow all", "GET", "https://evil.example", true, 200, "*", false},
+		{"allow all preflight", "OPTIONS", "https://evil.example", true, 200, "*", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, nil)
+			a.config.CORSOrigins = []string{"https://app.example.com"}
+			a.config.CORSAllowAll = tt.allowAll
+			a.tunnel.provider = &fakeTunnel{url: "https://fox.trycloudflare.com"}
+			reached := false
+			handler := a.corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true }))
+
+			r := httptest.NewRequest(tt.method, "http://example.test/api/config", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allow)
+			}
+			if got := w.Header().Get("Vary") == "Origin"; got != tt.vary {
+				t.Errorf("Vary: Origin = %v, want %v", got, tt.vary)
+			}
+			if (w.Header().Get("Access-Control-Allow-Methods") != "") != (tt.allow != "") {
+				t.Error("Access-Control-Allow-Methods sent without an allowed origin, or missing with one")
+			}
+			if reached != (tt.method != "OPTIONS") {
+				t.Errorf("handler reached = %v", reached)
+			}
+		})
+	}
+}
+
+// TestCORSAllowHeaders checks a preflight for each request header the
+// proxy reads is answered with that header allowed
+func TestCORSAllowHeaders(t *testing.T) {
+	for _, header := range []string{"Authorization", "X-Api-Key", "X-Request-Timeout", "X-NIMB-Async", "Idempotency-Key"} {
+		t.Run(header, func(t *testing.T) {
+			a := newTestApp(t, nil)
+			a.config.CORSOrigins = []string{"https://app.example.com"}
+			handler := a.corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			r := httptest.NewRequest("OPTIONS", "http://example.test/v1/chat/completions", nil)
+			r.Header.Set("Origin", "https://app.example.com")
+			r.Header.Set("Access-Control-Request-Method", "POST")
+			r.Header.Set("Access-Control-Request-Headers", strings.ToLower(header))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != 200 {
+				t.Fatalf("status = %d, want 200", w.Code)
+			}
+			allowed := strings.Split(w.Header().Get("Access-Control-Allow-Headers"), ", ")
+			if !slices.ContainsFunc(allowed, func(h string) bool { return strings.EqualFold(h, header) }) {
+				t.Errorf("Access-Control-Allow-Headers = %q, want %s in it", allowed, header)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// credentials are kept apart from settings.json so the keys can be written
+// with owner-only permissions. With encryption on only Sealed is stored.
+type credentials struct {
+	APIKeys []string   `json:"apiKeys,omitempty"`
+	Sealed  *sealedBox `json:"encrypted,omitempty"`
+}
+
+func (a *App) credentialsPath() string {
+	return filepath.Join(a.settingsDir, "credentials.json")
+}
+
+// maskKey shows enough of a key to recognise it, e.g. "nvapi-****abcd"
+func maskKey(key string) string {
+	prefix, _, found := strings.Cut(key, "-")
+	if !found || len(prefix) > 8 || len(key) < 12 {
+		return keyID(key)
+	}
+	return prefix + "-" + keyID(key)
+}
+
+// isMaskedKey reports whether a posted key is a masked one sent back
+// unchanged
+func isMaskedKey(key string) bool {
+	return strings.Contains(key, "****")
+}
+
+// loadCredentials reads the stored API keys. ok is false when there is no
+// credentials file yet. Encrypted keys are kept in the vault until unlocked.
+func (a *App) loadCredentials() (keys []string, ok bool, err error) {
+	data, err := os.ReadFile(a.credentialsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var creds credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, false, err
+	}
+	if creds.Sealed != nil {
+		a.vault.mu.Lock()
+		a.vault.sealed = creds.Sealed
+		a.vault.mu.Unlock()
+		logWarnf("Credentials are encrypted; waiting for the passphrase")
+	}
+	return creds.APIKeys, true, nil
+}
+
+// saveCredentials writes the API keys readable by the owner only, encrypted
+// when a passphrase is set. The mode is set again in case the file was
+// created looser by hand. While locked the stored keys are left as they are.
+func (a *App) saveCredentials(keys []string) error {
+	a.vault.mu.Lock()
+	defer a.vault.mu.Unlock()
+	if a.vault.lockedLocked() {
+		return nil
+	}
+	creds := credentials{APIKeys: keys}
+	if a.vault.key != nil {
+		box, err := seal(a.vault.key, a.vault.salt, a.vault.iterations, keys)
+		if err != nil {
+			return err
+		}
+		creds = credentials{Sealed: box}
+	}
+
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := a.credentialsPath()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// loadAPIKeys takes the keys from credentials.json. Keys still found in
+// settings.json, as written by older versions, are moved out of it.
+func (a *App) loadAPIKeys(inSettings bool) {
+	keys, ok, err := a.loadCredentials()
+	if err != nil {
+		logWarnf("Ignoring unreadable credentials: %v", err)
+		return
+	}
+	if ok {
+		a.mu.Lock()
+		a.config.APIKeys = keys
+		a.mu.Unlock()
+	}
+	if inSettings && !a.vault.isLocked() {
+		if err := a.saveSettings(); err != nil {
+			logErrorf("Could not move API keys to credentials.json: %v", err)
+			return
+		}
+		logInfof("Moved API keys from settings.json to %s", a.credentialsPath())
+	}
+}
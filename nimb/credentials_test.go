@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMaskKey(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"nvapi-abcdefghijklmnop1234", "nvapi-****1234"},
+		{"sk-abcdefgh5678", "sk-****5678"},
+		{"plainsecretkey9876", "****9876"},
+		{"verylongprefix-abcdefgh4321", "****4321"},
+		{"nvapi", "****"},
+	}
+	for _, tt := range tests {
+		if got := maskKey(tt.key); got != tt.want {
+			t.Errorf("maskKey(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+		if !isMaskedKey(maskKey(tt.key)) {
+			t.Errorf("isMaskedKey(maskKey(%q)) = false", tt.key)
+		}
+	}
+}
+
+// TestAPIKeyMigration checks a key stored in settings.json by an older
+// version moves to an owner-only credentials.json
+func TestAPIKeyMigration(t *testing.T) {
+	dir := t.TempDir()
+	const key = "nvapi-abcdefghijklmnop1234"
+	old := `{"settingsVersion":2,"proxyApiKey":"nimb-proxy","apiKey":"` + key + `","contextSize":8192}`
+	if err := os.WriteFile(dir+"/settings.json", []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewApp(options{DataDir: dir})
+	if !slices.Equal(a.config.APIKeys, []string{key}) || a.config.ContextSize != 8192 {
+		t.Fatalf("loaded keys %v, context size %d", a.config.APIKeys, a.config.ContextSize)
+	}
+	settings, err := os.ReadFile(a.settingsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(settings), key) {
+		t.Error("settings.json still holds the key")
+	}
+	info, err := os.Stat(a.credentialsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("credentials.json mode = %o, want 600", mode)
+	}
+
+	// A restart reads the key from its new home
+	if again := NewApp(options{DataDir: dir}); !slices.Equal(again.config.APIKeys, []string{key}) {
+		t.Errorf("after a restart keys = %v", again.config.APIKeys)
+	}
+}
+
+// TestConfigMasksAPIKey checks GET /api/config never shows the key and a
+// masked key posted back leaves it alone
+func TestConfigMasksAPIKey(t *testing.T) {
+	a := newTestApp(t, nil)
+	const key = "nvapi-abcdefghijklmnop1234"
+	a.config.APIKeys = []string{key}
+
+	body := serve(a.handleConfig, "GET", "/api/config", "").Body.String()
+	if strings.Contains(body, key) {
+		t.Fatal("/api/config shows the key")
+	}
+	var got struct {
+		APIKey     string `json:"apiKey"`
+		Configured bool   `json:"apiKeyConfigured"`
+	}
+	json.Unmarshal([]byte(body), &got)
+	if got.APIKey != "nvapi-****1234" || !got.Configured {
+		t.Errorf("apiKey = %q, apiKeyConfigured = %v", got.APIKey, got.Configured)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"masked key sent back", `{"apiKey":"nvapi-****1234"}`, []string{key}},
+		{"no key sent", `{"temperature":0.3}`, []string{key}},
+		{"new key", `{"apiKey":"nvapi-newnewnewnewnew5678"}`, []string{"nvapi-newnewnewnewnew5678", key}},
+	}
+	for _, tt := range tests {
+		if rec := serve(a.handleSaveConfig, "POST", "/api/config", tt.body); rec.Code != 200 {
+			t.Fatalf("%s: status = %d, body %s", tt.name, rec.Code, rec.Body)
+		}
+		if !slices.Equal(a.config.APIKeys, tt.want) {
+			t.Errorf("%s: keys = %v, want %v", tt.name, a.config.APIKeys, tt.want)
+		}
+	}
+}
+
+// TestVaultRoundTrip checks encrypted credentials stay locked after a
+// restart until the right passphrase is given
+func TestVaultRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	const key = "nvapi-abcdefghijklmnop1234"
+	a := NewApp(options{DataDir: dir})
+	a.config.APIKeys = []string{key}
+	// Few iterations keep the test fast; unlock reads them from the file
+	a.vault.salt = []byte("0123456789abcdef")
+	a.vault.iterations = 1000
+	a.vault.key = pbkdf2SHA256([]byte("correct horse"), a.vault.salt, a.vault.iterations, 32)
+	if err := a.saveSettings(); err != nil {
+		t.Fatal(err)
+	}
+	creds, _ := os.ReadFile(a.credentialsPath())
+	if strings.Contains(string(creds), key) {
+		t.Fatal("credentials.json holds the key in plain text")
+	}
+
+	b := NewApp(options{DataDir: dir})
+	if !b.vault.isLocked() || len(b.config.APIKeys) != 0 {
+		t.Fatalf("locked = %v, keys = %v after a restart", b.vault.isLocked(), b.config.APIKeys)
+	}
+	if _, err := b.unlock("wrong horse"); err != errWrongPassphrase {
+		t.Errorf("wrong passphrase: err = %v", err)
+	}
+	b.vault.retryAt = b.vault.retryAt.AddDate(-1, 0, 0)
+	if _, err := b.unlock("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if b.vault.isLocked() || !slices.Equal(b.config.APIKeys, []string{key}) {
+		t.Errorf("locked = %v, keys = %v after unlocking", b.vault.isLocked(), b.config.APIKeys)
+	}
+	if _, err := b.unlock("correct horse"); err != errNotLocked {
+		t.Errorf("second unlock: err = %v, want %v", err, errNotLocked)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// runtimeStatsTTL bounds how often /api/health reads MemStats, which
+	// stops the world briefly
+	runtimeStatsTTL = 5 * time.Second
+	// pprofAddr is where /debug/pprof is served when EnablePprof is on. It
+	// is a separate loopback listener so it is never reachable through the
+	// tunnel or the LAN.
+	pprofAddr = "127.0.0.1:6060"
+)
+
+// RuntimeStats describes the process itself, for spotting when NIMB is
+// the one using the memory
+type RuntimeStats struct {
+	HeapInUseBytes  uint64 `json:"heapInUseBytes"`
+	HeapAllocBytes  uint64 `json:"heapAllocBytes"`
+	TotalAllocBytes uint64 `json:"totalAllocBytes"`
+	SysBytes        uint64 `json:"sysBytes"`
+	NumGC           uint32 `json:"numGc"`
+	GCPauseTotalMs  int64  `json:"gcPauseTotalMs"`
+	LastGCPauseUs   int64  `json:"lastGcPauseUs"`
+	Goroutines      int    `json:"goroutines"`
+	UptimeSec       int    `json:"uptimeSec"`
+	GoVersion       string `json:"goVersion"`
+	SampledAt       string `json:"sampledAt"`
+}
+
+// runtimeCache holds the last MemStats reading
+type runtimeCache struct {
+	mu    sync.Mutex
+	at    time.Time
+	stats RuntimeStats
+}
+
+// runtimeStats returns runtime figures, reading MemStats at most once per
+// runtimeStatsTTL
+func (a *App) runtimeStats() RuntimeStats {
+	c := &a.runtime
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.at) >= runtimeStatsTTL {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		c.at = now
+		c.stats = RuntimeStats{
+			HeapInUseBytes:  m.HeapInuse,
+			HeapAllocBytes:  m.HeapAlloc,
+			TotalAllocBytes: m.TotalAlloc,
+			SysBytes:        m.Sys,
+			NumGC:           m.NumGC,
+			GCPauseTotalMs:  int64(m.PauseTotalNs / uint64(time.Millisecond)),
+			Goroutines:      runtime.NumGoroutine(),
+			GoVersion:       runtime.Version(),
+			SampledAt:       now.Format(time.RFC3339),
+		}
+		if m.NumGC > 0 {
+			c.stats.LastGCPauseUs = int64(m.PauseNs[(m.NumGC+255)%256] / uint64(time.Microsecond))
+		}
+	}
+
+	stats := c.stats
+	stats.UptimeSec = int(time.Since(a.startTime).Seconds())
+	return stats
+}
+
+// pprofServer is the loopback listener for /debug/pprof
+type pprofServer struct {
+	mu  sync.Mutex
+	srv *http.Server
+}
+
+// applyPprof starts or stops the pprof listener to match the config
+func (a *App) applyPprof(enabled bool) {
+	p := &a.pprof
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !enabled {
+		if p.srv != nil {
+			p.srv.Close()
+			p.srv = nil
+			logInfof("pprof stopped")
+		}
+		return
+	}
+	if p.srv != nil {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	srv := &http.Server{Addr: pprofAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	p.srv = srv
+	go func() {
+		logInfof("pprof listening on http://%s/debug/pprof/", pprofAddr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logErrorf("pprof: %v", err)
+			a.logError("pprof listener failed: "+err.Error(), 500)
+			p.mu.Lock()
+			if p.srv == srv {
+				p.srv = nil
+			}
+			p.mu.Unlock()
+		}
+	}()
+}
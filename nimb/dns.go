@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNS modes for upstream lookups
+const (
+	DNSModeUDP    = "udp"    // plain DNS against DNSServers in order
+	DNSModeDoH    = "doh"    // DNS-over-HTTPS against DoHURL
+	DNSModeSystem = "system" // the platform resolver
+)
+
+// defaultDNSServers are tried in order when DNSServers is empty
+var defaultDNSServers = []string{"8.8.8.8", "1.1.1.1"}
+
+// defaultDoHURL is used when DoHURL is empty
+const defaultDoHURL = "https://cloudflare-dns.com/dns-query"
+
+// dohBootstrap maps well-known DoH hosts to a fixed address, so DoH works on
+// networks where plain DNS can't even resolve the DoH server
+var dohBootstrap = map[string]string{
+	"cloudflare-dns.com": "1.1.1.1",
+	"dns.google":         "8.8.8.8",
+}
+
+const (
+	dnsCacheTTL    = 60 * time.Second
+	dnsTryTimeout  = 5 * time.Second
+	dnsDialTimeout = 10 * time.Second
+)
+
+// dnsEntry is a cached lookup result
+type dnsEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// dnsResolver resolves upstream hosts through a fallback chain of DNS
+// servers or DoH, caching results briefly so each request doesn't pay for a
+// lookup
+type dnsResolver struct {
+	mode    string
+	servers []string
+	dohURL  string
+	doh     *http.Client
+
+	mu           sync.Mutex
+	cache        map[string]dnsEntry
+	lastResolver string
+}
+
+// newDNSResolver builds a resolver for the given mode. servers may omit the
+// port; dohURL and servers fall back to the defaults when empty.
+func newDNSResolver(mode string, servers []string, dohURL string) *dnsResolver {
+	if len(servers) == 0 {
+		servers = defaultDNSServers
+	}
+	if dohURL == "" {
+		dohURL = defaultDoHURL
+	}
+
+	r := &dnsResolver{mode: mode, dohURL: dohURL, cache: map[string]dnsEntry{}}
+	for _, s := range servers {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, "53")
+		}
+		r.servers = append(r.servers, s)
+	}
+
+	if mode == DNSModeDoH {
+		dialer := &net.Dialer{Timeout: dnsDialTimeout}
+		tlsConfig, _ := upstreamTLSConfig("", false)
+		r.doh = &http.Client{
+			Timeout: dnsTryTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					host, port, _ := net.SplitHostPort(addr)
+					if ip, ok := dohBootstrap[host]; ok {
+						addr = net.JoinHostPort(ip, port)
+					}
+					return dialer.DialContext(ctx, network, addr)
+				},
+				TLSClientConfig: tlsConfig,
+			},
+		}
+	}
+	return r
+}
+
+// lastUsed returns the resolver that answered the most recent lookup
+func (r *dnsResolver) lastUsed() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastResolver
+}
+
+// lookup resolves host, serving from the cache when possible
+func (r *dnsResolver) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	r.mu.Lock()
+	entry, ok := r.cache[host]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.ips, nil
+	}
+
+	var ips []net.IP
+	var used string
+	var err error
+	if r.mode == DNSModeDoH {
+		ips, err = r.lookupDoH(ctx, host)
+		used = r.dohURL
+	} else {
+		ips, used, err = r.lookupUDP(ctx, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.cache[host] = dnsEntry{ips: ips, expires: time.Now().Add(dnsCacheTTL)}
+	r.lastResolver = used
+	r.mu.Unlock()
+	return ips, nil
+}
+
+// lookupUDP tries each DNS server in order, returning the first answer and
+// the server that gave it
+func (r *dnsResolver) lookupUDP(ctx context.Context, host string) ([]net.IP, string, error) {
+	var errs []string
+	for _, server := range r.servers {
+		resolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				d := net.Dialer{Timeout: dnsTryTimeout}
+				return d.DialContext(ctx, "udp", server)
+			},
+		}
+
+		tryCtx, cancel := context.WithTimeout(ctx, dnsTryTimeout)
+		ips, err := resolver.LookupIP(tryCtx, "ip", host)
+		cancel()
+		if err == nil && len(ips) > 0 {
+			return preferIPv4(ips), server, nil
+		}
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		errs = append(errs, server+": "+errString(err, "no addresses"))
+	}
+	return nil, "", errors.New("DNS lookup for " + host + " failed: " + strings.Join(errs, "; "))
+}
+
+// dohAnswer is the subset of the DNS JSON API response we read
+type dohAnswer struct {
+	Status int `json:"Status"`
+	Answer []struct {
+		Type int    `json:"type"`
+		Data string `json:"data"`
+	} `json:"Answer"`
+}
+
+// lookupDoH resolves A records through the DNS JSON API
+func (r *dnsResolver) lookupDoH(ctx context.Context, host string) ([]net.IP, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", r.dohURL+"?name="+url.QueryEscape(host)+"&type=A", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/dns-json")
+
+	resp, err := r.doh.Do(req)
+	if err != nil {
+		return nil, errors.New("DoH lookup for " + host + " failed: " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	var answer dohAnswer
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("DoH lookup for " + host + " failed: " + resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return nil, errors.New("DoH lookup for " + host + " failed: " + err.Error())
+	}
+
+	var ips []net.IP
+	for _, a := range answer.Answer {
+		if ip := net.ParseIP(a.Data); a.Type == 1 && ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("DoH lookup for " + host + " returned no addresses")
+	}
+	return ips, nil
+}
+
+// dialContext resolves the host through the resolver and dials each address
+// in turn
+func (r *dnsResolver) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		ips, err := r.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var lastErr error
+		for _, ip := range ips {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// preferIPv4 orders IPv4 addresses first, avoiding broken IPv6 routes on
+// Android
+func preferIPv4(ips []net.IP) []net.IP {
+	sorted := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			sorted = append(sorted, ip)
+		}
+	}
+	for _, ip := range ips {
+		if ip.To4() == nil {
+			sorted = append(sorted, ip)
+		}
+	}
+	return sorted
+}
+
+// errString returns err's message, or fallback when err is nil
+func errString(err error, fallback string) string {
+	if err == nil {
+		return fallback
+	}
+	return err.Error()
+}
+
+// dnsServerList parses the comma-separated server list kept in transportSettings
+func dnsServerList(joined string) []string {
+	if joined == "" {
+		return nil
+	}
+	return strings.Split(joined, ",")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// envAliases are short names for the overrides scripts set most often.
+// The full NIMB_<FIELD> name is accepted too and wins over the alias.
+var envAliases = map[string]string{
+	"currentModel": "NIMB_MODEL",
+	"apiKeys":      "NIMB_API_KEY",
+}
+
+// envName maps a config field to its variable, so temperature is
+// NIMB_TEMPERATURE and upstreamBaseUrl is NIMB_UPSTREAM_BASE_URL
+func envName(field string) string {
+	var b strings.Builder
+	b.WriteString("NIMB_")
+	runes := []rune(field)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) ||
+			(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// parseEnvValue parses s into a value of v's type. Lists are comma
+// separated; maps and structs are JSON.
+func parseEnvValue(v reflect.Value, s string) (reflect.Value, error) {
+	out := reflect.New(v.Type()).Elem()
+	switch v.Kind() {
+	case reflect.String:
+		out.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return out, fmt.Errorf("%q is not true or false", s)
+		}
+		out.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return out, fmt.Errorf("%q is not a whole number", s)
+		}
+		out.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return out, fmt.Errorf("%q is not a number", s)
+		}
+		out.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(s), "[") {
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					out = reflect.Append(out, reflect.ValueOf(item))
+				}
+			}
+			return out, nil
+		}
+		fallthrough
+	default:
+		if err := json.Unmarshal([]byte(s), out.Addr().Interface()); err != nil {
+			return out, fmt.Errorf("is not valid JSON: %v", err)
+		}
+	}
+	return out, nil
+}
+
+// envOverrides are the config fields set from the environment. They are
+// read once at startup and stay fixed, so they need no lock.
+type envOverrides struct {
+	// vars maps each overridden field to the variable that set it
+	vars map[string]string
+	// values are the overriding values
+	values map[string]reflect.Value
+	// file are the values the settings file had, which is what gets saved
+	file map[string]reflect.Value
+}
+
+// locked reports which variable, if any, fixes a field
+func (e envOverrides) locked(field string) (string, bool) {
+	name, ok := e.vars[field]
+	return name, ok
+}
+
+// apply puts the environment values back over c
+func (e envOverrides) apply(c *Config) {
+	configFields(c, func(name string, v reflect.Value) {
+		if value, ok := e.values[name]; ok {
+			v.Set(value)
+		}
+	})
+}
+
+// restore swaps the environment values in c for the saved ones, so they
+// are never written to settings.json
+func (e envOverrides) restore(c *Config) {
+	configFields(c, func(name string, v reflect.Value) {
+		if value, ok := e.file[name]; ok {
+			v.Set(value)
+		}
+	})
+}
+
+// applyEnvOverrides reads NIMB_<FIELD> variables over the loaded settings.
+// A value that does not parse or is out of range stops startup, like a bad
+// command-line option.
+func (a *App) applyEnvOverrides(getenv func(string) string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	env := envOverrides{
+		vars:   map[string]string{},
+		values: map[string]reflect.Value{},
+		file:   map[string]reflect.Value{},
+	}
+	cfg := a.config
+	var err error
+	configFields(&cfg, func(name string, v reflect.Value) {
+		if err != nil {
+			return
+		}
+		varName := envName(name)
+		s := getenv(varName)
+		if alias, ok := envAliases[name]; ok && s == "" {
+			varName, s = alias, getenv(alias)
+		}
+		if s == "" {
+			return
+		}
+		value, perr := parseEnvValue(v, s)
+		if perr != nil {
+			err = fmt.Errorf("%s %v", varName, perr)
+			return
+		}
+		if enum, ok := configEnums[name]; ok && value.String() != "" && !slices.Contains(enum, value.String()) {
+			err = fmt.Errorf("%s must be one of %s", varName, strings.Join(enum, ", "))
+			return
+		}
+		env.vars[name] = varName
+		env.file[name] = reflect.ValueOf(v.Interface())
+		env.values[name] = value
+		v.Set(value)
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range cfg.validate() {
+		if varName, ok := env.vars[e.Field]; ok {
+			return fmt.Errorf("%s %s", varName, e.Error)
+		}
+	}
+
+	for _, name := range env.fields() {
+		logInfof("Config: %s set from %s", name, env.vars[name])
+	}
+	a.config = cfg
+	a.env = env
+	logScrubber.useConfig(cfg)
+	logs.useConfig(cfg)
+	a.applyLogFile(cfg)
+	return nil
+}
+
+// fields lists the overridden fields in order
+func (e envOverrides) fields() []string {
+	fields := make([]string, 0, len(e.vars))
+	for name := range e.vars {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// writeLocked refuses a change to a field fixed by the environment
+func writeLocked(w http.ResponseWriter, field, varName string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   field + " is set by " + varName + " and cannot be changed here",
+	})
+}
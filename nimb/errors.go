@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// errorRepeatWindow is how long an error log entry absorbs identical
+	// errors, so a flapping upstream does not flood the log
+	errorRepeatWindow = time.Minute
+	// defaultErrorPage is how many entries /api/errors returns by default
+	defaultErrorPage = 50
+)
+
+// recentRepeat returns the index of an entry logged within
+// errorRepeatWindow that item repeats, or -1. The log is newest first.
+func recentRepeat(log []ErrorItem, item ErrorItem, now time.Time) int {
+	for i, e := range log {
+		t, err := time.Parse(time.RFC3339, e.Timestamp)
+		if err != nil || now.Sub(t) > errorRepeatWindow {
+			return -1
+		}
+		if e.Message == item.Message && e.Code == item.Code && e.Model == item.Model && e.Route == item.Route {
+			return i
+		}
+	}
+	return -1
+}
+
+// handleErrors lists the error log, newest first, with GET filters limit,
+// offset, minCode and since (RFC 3339). DELETE clears the log but keeps
+// the error counters.
+func (a *App) handleErrors(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "DELETE":
+		a.mu.Lock()
+		a.stats.ErrorLog = []ErrorItem{}
+		a.mu.Unlock()
+		a.statsHub.publish()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	limit, offset, minCode := defaultErrorPage, 0, 0
+	var since time.Time
+	var bad string
+	for name, dst := range map[string]*int{"limit": &limit, "offset": &offset, "minCode": &minCode} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				bad = name + " must be a non-negative integer"
+				break
+			}
+			*dst = n
+		}
+	}
+	if v := q.Get("since"); v != "" && bad == "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			bad = "since must be an RFC 3339 time"
+		}
+		since = t
+	}
+	if bad != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": bad})
+		return
+	}
+
+	a.mu.RLock()
+	matched := []ErrorItem{}
+	for _, e := range a.stats.ErrorLog {
+		if e.Code < minCode {
+			continue
+		}
+		if !since.IsZero() {
+			if t, err := time.Parse(time.RFC3339, e.Timestamp); err != nil || t.Before(since) {
+				continue
+			}
+		}
+		matched = append(matched, e)
+	}
+	errorCount := a.stats.ErrorCount
+	a.mu.RUnlock()
+
+	page := matched[min(offset, len(matched)):]
+	page = page[:min(limit, len(page))]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":      len(matched),
+		"limit":      limit,
+		"offset":     offset,
+		"errorCount": errorCount,
+		"errors":     page,
+	})
+}
@@ -44,6 +44,67 @@ function showConfirm(message) {
     });
 }
 
+// Admin login, shown until the server accepts our session
+function showLogin(setup) {
+    if (document.getElementById('loginPassword')) return;
+    const overlay = document.createElement('div');
+    overlay.className = 'setup-overlay';
+    overlay.innerHTML = `
+        <div class="setup-modal" style="max-width: 400px; padding: 32px;">
+            <h2 style="font-family: var(--font-display); margin-bottom: 16px;">${setup ? 'Set Admin Password' : 'Log In'}</h2>
+            <p style="color: var(--text-secondary); margin-bottom: 24px;">${setup
+                ? 'Choose a password for this dashboard. It can only be set from this device.'
+                : 'Enter the admin password.'}</p>
+            <input type="password" class="form-input" id="loginPassword" placeholder="Password" style="margin-bottom: 24px;">
+            <div style="display: flex; gap: 12px; justify-content: flex-end;">
+                <button class="btn btn-primary" id="loginOk">${setup ? 'Set Password' : 'Log In'}</button>
+            </div>
+        </div>
+    `;
+    document.body.appendChild(overlay);
+
+    const input = overlay.querySelector('#loginPassword');
+    const submit = async () => {
+        try {
+            const res = await fetch(setup ? '/api/setup' : '/api/login', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ password: input.value })
+            });
+            const result = await res.json();
+            if (result.success) {
+                location.reload();
+                return;
+            }
+            showToast(result.error?.message || result.error || 'Login failed', 'error');
+        } catch (e) {
+            showToast('Login failed', 'error');
+        }
+        input.value = '';
+    };
+    overlay.querySelector('#loginOk').onclick = submit;
+    input.onkeydown = (e) => { if (e.key === 'Enter') submit(); };
+    input.focus();
+}
+
+// checkAuth shows the login or setup form when there is no session
+async function checkAuth() {
+    try {
+        const res = await fetch('/api/session');
+        const status = await res.json();
+        if (status.authenticated) return true;
+        showLogin(!status.configured);
+    } catch (e) {
+        console.error('Failed to check login:', e);
+    }
+    return false;
+}
+
+async function logout() {
+    await fetch('/api/logout', { method: 'POST' });
+    location.reload();
+}
+
 // Copy to clipboard
 async function copyToClipboard(text) {
     try {
@@ -111,6 +172,11 @@ function formatUptime(s) {
 async function fetchData() {
     try {
         const res = await fetch('/api/health');
+        if (res.status === 401) {
+            // Session expired or was logged out elsewhere
+            showLogin(false);
+            return;
+        }
         const data = await res.json();
         updateUI(data);
         setOnlineStatus(true);
@@ -131,7 +197,37 @@ async function saveConfig(config) {
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify(config)
     });
-    return res.json();
+    const result = await res.json();
+    if (result.fields) {
+        showToast(result.fields.map(f => `${f.field} ${f.error}`).join(', '), 'error');
+    }
+    return result;
+}
+
+// Input limits come from the config schema rather than being hard-coded
+async function loadConfigSchema() {
+    try {
+        const res = await fetch('/api/config/schema');
+        const schema = await res.json();
+        const fields = Object.fromEntries(schema.fields.map(f => [f.name, f]));
+        const inputs = [
+            ['temperature', 'temperature'],
+            ['contextSize', 'contextSize'],
+            ['contextSizeSlider', 'contextSize'],
+            ['maxTokens', 'maxTokens'],
+            ['maxTokensSlider', 'maxTokens']
+        ];
+        for (const [id, name] of inputs) {
+            const el = document.getElementById(id);
+            const field = fields[name];
+            if (!el || !field) continue;
+            if (field.min !== undefined) el.min = field.min;
+            // Sliders keep their own range; only typed values may go higher
+            if (field.max !== undefined && el.type === 'number') el.max = field.max;
+        }
+    } catch (e) {
+        console.error('Failed to load config schema:', e);
+    }
 }
 
 async function setModel(model) {
@@ -152,6 +248,15 @@ async function setAPIKey(key) {
     return res.json();
 }
 
+async function testAPIKey(key) {
+    const res = await fetch('/api/test', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ key })
+    });
+    return res.json();
+}
+
 async function resetStats() {
     const res = await fetch('/api/stats/reset', { method: 'POST' });
     return res.json();
@@ -183,32 +288,11 @@ function setOnlineStatus(isOnline) {
 }
 
 function updateUI(data) {
-    // Stats
-    document.getElementById('totalReq').innerText = data.stats.messageCount;
-    document.getElementById('errCount').innerText = data.stats.errorCount;
-
-    const rate = data.stats.messageCount > 0
-        ? ((data.stats.errorCount / data.stats.messageCount) * 100).toFixed(2)
-        : '0.00';
-    const errorRateEl = document.getElementById('errorRate');
-    errorRateEl.innerText = rate + '%';
-    errorRateEl.className = 'stat-value ' + (parseFloat(rate) > 5 ? 'error' : '');
-
-    document.getElementById('tokenUsage').innerText = formatNum(data.stats.totalTokens);
-    document.getElementById('promptTok').innerText = data.stats.promptTokens.toLocaleString();
-    document.getElementById('compTok').innerText = data.stats.completionTokens.toLocaleString();
-    document.getElementById('totalTok').innerText = data.stats.totalTokens.toLocaleString();
+    updateStats(data.stats);
 
     document.getElementById('uptimeDisplay').innerText = formatUptime(data.uptime);
     document.getElementById('uptimeStat').innerText = Math.floor(data.uptime / 3600) + 'h';
 
-    document.getElementById('lastReq').innerText = data.stats.lastRequestTime
-        ? new Date(data.stats.lastRequestTime).toLocaleString()
-        : '-';
-    document.getElementById('sessionStart').innerText = data.stats.startTime
-        ? new Date(data.stats.startTime).toLocaleString()
-        : '-';
-
     // Model display only - skip if save in progress
     if (!settingsSaveInProgress && document.activeElement.id !== 'modelName') {
         document.getElementById('modelName').value = data.config.currentModel || '';
@@ -216,32 +300,151 @@ function updateUI(data) {
     document.getElementById('currentModelDisplay').innerText = data.model || '-';
 
     // Tunnel
-    updateTunnelUI(data.tunnel.status, data.tunnel.url);
+    updateTunnelUI(data.tunnel);
+
+    updateKeyStatus(data.lastKeyCheck);
+    if (data.proxyAuth) {
+        document.getElementById('proxyKeyStatus').innerText = data.proxyAuth.enabled
+            ? data.proxyAuth.key + (data.proxyAuth.localBypass ? ' (local bypass)' : '')
+            : 'Off - /v1 is open';
+    }
+
+    if (data.runtime) {
+        const mb = (data.runtime.heapInUseBytes / 1048576).toFixed(1);
+        document.getElementById('processMemory').innerText =
+            `${mb} MB heap · ${data.runtime.goroutines} goroutines`;
+    }
+}
+
+function updateStats(stats) {
+    document.getElementById('totalReq').innerText = stats.messageCount;
+    document.getElementById('errCount').innerText = stats.errorCount;
+
+    const rate = stats.messageCount > 0
+        ? ((stats.errorCount / stats.messageCount) * 100).toFixed(2)
+        : '0.00';
+    const errorRateEl = document.getElementById('errorRate');
+    errorRateEl.innerText = rate + '%';
+    errorRateEl.className = 'stat-value ' + (parseFloat(rate) > 5 ? 'error' : '');
+
+    document.getElementById('tokenUsage').innerText = formatNum(stats.totalTokens);
+    document.getElementById('promptTok').innerText = stats.promptTokens.toLocaleString();
+    document.getElementById('compTok').innerText = stats.completionTokens.toLocaleString();
+    document.getElementById('totalTok').innerText = stats.totalTokens.toLocaleString();
+
+    document.getElementById('lastReq').innerText = stats.lastRequestTime
+        ? new Date(stats.lastRequestTime).toLocaleString()
+        : '-';
+    document.getElementById('sessionStart').innerText = stats.startTime
+        ? new Date(stats.startTime).toLocaleString()
+        : '-';
 
     // Error Log
-    updateErrorLog(data.stats.errorLog || []);
+    updateErrorLog(stats.errorLog || []);
+}
+
+// Stats are pushed over SSE, so the health poll only needs to catch the
+// slower-moving tunnel and model state
+let healthTimer = setInterval(fetchData, 2000);
+
+function watchStats() {
+    if (!window.EventSource) return;
+    const source = new EventSource('/api/stats/stream');
+    source.addEventListener('stats', (e) => updateStats(JSON.parse(e.data)));
+    source.onopen = () => {
+        clearInterval(healthTimer);
+        healthTimer = setInterval(fetchData, 10000);
+    };
+    source.onerror = () => {
+        clearInterval(healthTimer);
+        healthTimer = setInterval(fetchData, 2000);
+    };
+}
+
+// Tunnel transitions arrive as they happen; each one refreshes the
+// health data, which carries the full tunnel state
+function watchTunnel() {
+    if (!window.EventSource) return;
+    const source = new EventSource('/api/tunnel/events');
+    for (const event of ['starting', 'url-acquired', 'running', 'degraded', 'stopped', 'error']) {
+        source.addEventListener(event, () => fetchData());
+    }
+}
+
+function formatAgo(timestamp) {
+    const s = Math.max(0, Math.floor((Date.now() - new Date(timestamp)) / 1000));
+    if (s < 60) return 'just now';
+    if (s < 3600) return `${Math.floor(s / 60)} min ago`;
+    if (s < 86400) return `${Math.floor(s / 3600)}h ago`;
+    return new Date(timestamp).toLocaleString();
+}
+
+function updateKeyStatus(check) {
+    const el = document.getElementById('keyStatus');
+    if (!check) {
+        el.innerText = 'Not checked';
+        el.style.color = 'var(--text-secondary)';
+        return;
+    }
+    el.innerText = check.success
+        ? `Key ${check.key} verified ${formatAgo(check.timestamp)}`
+        : `Key ${check.key} failed ${formatAgo(check.timestamp)}`;
+    el.style.color = check.success ? 'var(--success)' : 'var(--error)';
 }
 
-function updateTunnelUI(status, url) {
+function updateTunnelUI(tunnel) {
+    const { status, url, exposure, lastError, latencyMs, target, customTarget } = tunnel;
     const dot = document.getElementById('tunnelDot');
     const statusText = document.getElementById('tunnelStatus');
     const urlEl = document.getElementById('tunnelUrl');
     const startBtn = document.getElementById('startTunnelBtn');
     const stopBtn = document.getElementById('stopTunnelBtn');
+    const qrBtn = document.getElementById('tunnelQrBtn');
+    statusText.title = '';
 
-    if (status === 'running' && url) {
-        dot.style.background = 'var(--success)';
+    const up = status === 'running' || status === 'connected' || status === 'degraded';
+    if (!up) {
+        qrBtn.classList.add('hidden');
+        document.getElementById('tunnelQr').classList.add('hidden');
+    }
+
+    if (up && url) {
+        // 'running' until the public URL has answered once
+        const colors = { connected: 'var(--success)', running: 'var(--warning)', degraded: 'var(--error)' };
+        dot.style.background = colors[status];
         dot.style.animation = 'pulse 2s infinite';
-        statusText.textContent = 'Running';
-        urlEl.textContent = url + '/v1/chat/completions';
+        const states = {
+            connected: latencyMs ? `Connected (${latencyMs} ms)` : 'Connected',
+            running: 'Running (unverified)',
+            degraded: 'Degraded - URL not answering',
+        };
+        const state = states[status];
+        if (customTarget) {
+            // Another service is behind the tunnel, so NIMB's paths don't apply
+            statusText.textContent = state + ', forwarding to ' + target;
+            urlEl.textContent = url;
+        } else {
+            statusText.textContent = state + (exposure === 'full' ? ', UI exposed' : ', proxy only');
+            urlEl.textContent = url + '/v1/chat/completions';
+        }
+        statusText.title = 'Tunnel target: ' + target;
         urlEl.classList.remove('hidden');
         startBtn.classList.add('hidden');
         stopBtn.classList.remove('hidden');
+        qrBtn.classList.remove('hidden');
     } else if (status === 'starting') {
         dot.style.background = 'var(--warning)';
         dot.style.animation = 'pulse 1s infinite';
         statusText.textContent = 'Starting...';
         urlEl.classList.add('hidden');
+    } else if (status === 'error') {
+        dot.style.background = 'var(--error)';
+        dot.style.animation = 'none';
+        statusText.textContent = 'Failed to start';
+        statusText.title = lastError || '';
+        urlEl.classList.add('hidden');
+        startBtn.classList.remove('hidden');
+        stopBtn.classList.add('hidden');
     } else {
         dot.style.background = 'var(--text-muted)';
         dot.style.animation = 'none';
@@ -252,6 +455,12 @@ function updateTunnelUI(status, url) {
     }
 }
 
+function escapeHTML(text) {
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML;
+}
+
 function updateErrorLog(logs) {
     const container = document.getElementById('errorLog');
     document.getElementById('errLogCount').innerText = logs.length;
@@ -265,13 +474,24 @@ function updateErrorLog(logs) {
         <div class="log-item">
             <span class="log-time">${new Date(e.timestamp).toLocaleTimeString()}</span>
             <span class="log-code">${e.code}</span>
-            <span class="log-msg">${e.message}</span>
+            <span class="log-msg">${escapeHTML(e.model ? `[${e.model}] ${e.message}` : e.message)}${e.count > 1 ? ` <span class="log-count">×${e.count}</span>` : ''}</span>
         </div>
     `).join('');
 
     container.innerHTML = html;
 }
 
+async function clearErrorLog() {
+    try {
+        const res = await fetch('/api/errors', { method: 'DELETE' });
+        if (!res.ok) throw new Error(`HTTP ${res.status}`);
+        updateErrorLog([]);
+        showToast('Error log cleared', 'success');
+    } catch (e) {
+        showToast('Failed to clear error log', 'error');
+    }
+}
+
 // AUTO-SAVE SETTINGS
 
 document.getElementById('temperature').addEventListener('input', async (e) => {
@@ -301,7 +521,7 @@ document.getElementById('contextSizeSlider').addEventListener('input', async (e)
 });
 
 document.getElementById('contextSize').addEventListener('input', async (e) => {
-    const value = Math.min(128000, Math.max(0, parseInt(e.target.value) || 128000));
+    const value = Math.min(Number(e.target.max) || Infinity, Math.max(Number(e.target.min) || 0, parseInt(e.target.value) || 128000));
     document.getElementById('contextSizeSlider').value = value;
 
     try {
@@ -418,6 +638,128 @@ async function saveModel() {
     }
 }
 
+// PROFILES
+
+async function loadProfiles() {
+    try {
+        const res = await fetch('/api/profiles');
+        const data = await res.json();
+        const select = document.getElementById('profileSelect');
+        select.innerHTML = data.profiles.length
+            ? data.profiles.map(p => `<option value="${escapeHTML(p.name)}">${escapeHTML(p.name)} (${escapeHTML(p.model)})</option>`).join('')
+            : '<option value="">No profiles saved</option>';
+        if (data.active) select.value = data.active;
+    } catch (e) {
+        console.error('Failed to load profiles:', e);
+    }
+}
+
+async function saveProfile() {
+    const name = prompt('Profile name (letters, digits, - or _):');
+    if (!name) return;
+
+    try {
+        const res = await fetch('/api/profiles', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ name })
+        });
+        const result = await res.json();
+        if (result.success) {
+            showToast(`Saved profile ${name}`, 'success');
+            loadProfiles();
+        } else {
+            showToast(result.error || 'Failed to save profile', 'error');
+        }
+    } catch (e) {
+        showToast('Failed to save profile', 'error');
+    }
+}
+
+async function activateProfile() {
+    const name = document.getElementById('profileSelect').value;
+    if (!name) return;
+
+    try {
+        const res = await fetch(`/api/profiles/${encodeURIComponent(name)}/activate`, { method: 'POST' });
+        const result = await res.json();
+        if (result.success) {
+            showToast(`Profile ${name} active`, 'success');
+            loadInitialSettings();
+            fetchData();
+        } else {
+            showToast(result.error?.message || result.error || 'Failed to activate profile', 'error');
+        }
+    } catch (e) {
+        showToast('Failed to activate profile', 'error');
+    }
+}
+
+async function deleteProfile() {
+    const name = document.getElementById('profileSelect').value;
+    if (!name || !confirm(`Delete profile ${name}?`)) return;
+
+    try {
+        await fetch(`/api/profiles/${encodeURIComponent(name)}`, { method: 'DELETE' });
+        showToast(`Deleted profile ${name}`, 'success');
+        loadProfiles();
+    } catch (e) {
+        showToast('Failed to delete profile', 'error');
+    }
+}
+
+function downloadBackup() {
+    const withKeys = confirm('Include the API key in the backup?');
+    window.location.href = `/api/backup?stats=true&keys=${withKeys}`;
+}
+
+async function restoreBackup(file) {
+    if (!file || !confirm('Replace the current settings and profiles with this backup?')) return;
+
+    try {
+        const res = await fetch('/api/restore', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: await file.text()
+        });
+        const result = await res.json();
+        if (result.success) {
+            showToast('Backup restored', 'success');
+            loadInitialSettings();
+            loadProfiles();
+        } else {
+            const fields = (result.fields || []).map(f => `${f.field} ${f.error}`).join('; ');
+            showToast(`${result.error}${fields ? ': ' + fields : ''}`, 'error');
+        }
+    } catch (e) {
+        showToast('Failed to restore backup', 'error');
+    }
+    document.getElementById('restoreFile').value = '';
+}
+
+async function benchmarkModel() {
+    const model = document.getElementById('modelName').value.trim();
+    showToast(`Benchmarking ${model || 'current model'}...`, 'info');
+
+    try {
+        const res = await fetch('/api/benchmark', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ model, iterations: 3, stream: true })
+        });
+        const result = await res.json();
+        if (!res.ok || !result.success) {
+            const run = (result.runs || []).find(r => r.error);
+            const msg = result.error?.message || result.error || run?.error || `HTTP ${res.status}`;
+            showToast(`Benchmark failed: ${msg}`, 'error');
+            return;
+        }
+        showToast(`${result.tokensPerSec.median} tok/s, first token in ${Math.round(result.ttfbMs.median)} ms (median of ${result.runs.length})`, 'success');
+    } catch (e) {
+        showToast('Benchmark failed', 'error');
+    }
+}
+
 async function saveApiKey() {
     const key = document.getElementById('apiKey').value.trim();
     if (!key) {
@@ -438,26 +780,162 @@ async function saveApiKey() {
     }
 }
 
+async function testApiKey() {
+    // A key typed but not saved is tested as-is and never stored
+    const key = document.getElementById('apiKey').value.trim();
+    try {
+        const result = await testAPIKey(key);
+        if (result.success) {
+            showToast(`API key works (${result.latencyMs} ms)`, 'success');
+        } else {
+            showToast('API key test failed: ' + (result.error || 'unknown error'), 'error');
+        }
+        if (result.timestamp) updateKeyStatus(result);
+    } catch (e) {
+        showToast('Failed to test API key', 'error');
+    }
+}
+
+async function rotateProxyKey() {
+    if (!confirm('Generate a new proxy key? Clients using the old one will stop working.')) return;
+
+    try {
+        const res = await fetch('/api/proxykey/rotate', { method: 'POST' });
+        const result = await res.json();
+        if (result.success) {
+            // Shown only this once
+            prompt('New proxy key - copy it now, it will not be shown again:', result.key);
+            fetchData();
+        } else {
+            showToast('Failed to rotate proxy key: ' + (result.error || 'unknown error'), 'error');
+        }
+    } catch (e) {
+        showToast('Failed to rotate proxy key', 'error');
+    }
+}
+
+async function loadEncryptionStatus() {
+    try {
+        const res = await fetch('/api/encryption');
+        const status = await res.json();
+        document.getElementById('encryptionStatus').innerText =
+            status.locked ? 'Locked' : status.enabled ? 'On' : 'Off';
+    } catch (e) {
+        console.error('Failed to load encryption status:', e);
+    }
+}
+
+async function unlockCredentials() {
+    const passphrase = document.getElementById('passphrase').value;
+    try {
+        const res = await fetch('/api/unlock', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ passphrase })
+        });
+        const result = await res.json();
+        if (result.success) {
+            showToast('Credentials unlocked', 'success');
+            document.getElementById('passphrase').value = '';
+        } else {
+            showToast('Unlock failed: ' + (result.error?.message || 'unknown error'), 'error');
+        }
+    } catch (e) {
+        showToast('Unlock failed', 'error');
+    }
+    loadEncryptionStatus();
+}
+
+async function setEncryption(enabled) {
+    const passphrase = document.getElementById('passphrase').value;
+    try {
+        const res = await fetch('/api/encryption', {
+            method: enabled ? 'POST' : 'DELETE',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ passphrase })
+        });
+        const result = await res.json();
+        if (result.success) {
+            showToast(enabled ? 'API key encrypted' : 'API key decrypted', 'success');
+            document.getElementById('passphrase').value = '';
+        } else {
+            showToast('Failed: ' + (result.error?.message || result.error || 'unknown error'), 'error');
+        }
+    } catch (e) {
+        showToast('Failed to change encryption', 'error');
+    }
+    loadEncryptionStatus();
+}
+
 async function startTunnel() {
-    updateTunnelUI('starting', null);
+    updateTunnelUI({ status: 'starting' });
     try {
         const result = await startTunnelAPI();
         if (result.success) {
             showToast('Starting tunnel...', 'info');
         } else {
             showToast(result.error || 'Failed to start tunnel', 'error');
-            updateTunnelUI('stopped', null);
+            updateTunnelUI({ status: 'stopped' });
         }
     } catch (e) {
         showToast('Failed to start tunnel', 'error');
-        updateTunnelUI('stopped', null);
+        updateTunnelUI({ status: 'stopped' });
     }
 }
 
+function toggleTunnelQR() {
+    const img = document.getElementById('tunnelQr');
+    if (img.classList.toggle('hidden')) return;
+    // The server answers 304 until the tunnel URL changes
+    img.src = '/api/tunnel/qr?t=' + Date.now();
+}
+
+async function installCloudflared() {
+    const btn = document.getElementById('installCloudflaredBtn');
+    const bar = document.getElementById('installProgressBar');
+    const box = document.getElementById('installProgress');
+    try {
+        const res = await fetch('/api/tunnel/install', { method: 'POST' });
+        const result = await res.json();
+        if (!result.success) {
+            showToast(result.error || 'Install failed', 'error');
+            return;
+        }
+    } catch (e) {
+        showToast('Install failed', 'error');
+        return;
+    }
+
+    btn.disabled = true;
+    box.classList.remove('hidden');
+    bar.style.width = '0';
+    const poll = setInterval(async () => {
+        try {
+            const state = await (await fetch('/api/tunnel/install')).json();
+            if (state.total > 0) {
+                bar.style.width = Math.round((state.received / state.total) * 100) + '%';
+            }
+            if (state.status === 'downloading') return;
+            clearInterval(poll);
+            btn.disabled = false;
+            box.classList.add('hidden');
+            if (state.status === 'done') {
+                showToast('Installed cloudflared ' + state.version, 'success');
+            } else {
+                showToast(state.error || 'Install failed', 'error');
+            }
+        } catch (e) {
+            clearInterval(poll);
+            btn.disabled = false;
+            box.classList.add('hidden');
+        }
+    }, 500);
+}
+
 async function stopTunnel() {
     try {
-        await stopTunnelAPI();
-        showToast('Tunnel stopped', 'info');
+        const result = await stopTunnelAPI();
+        showToast(result.clean === false ? 'Tunnel stopped (had to be killed)' : 'Tunnel stopped', 'info');
         fetchData();
     } catch (e) {
         showToast('Failed to stop tunnel', 'error');
@@ -477,8 +955,30 @@ async function resetStatsBtn() {
     }
 }
 
+async function fetchTimeseries() {
+    try {
+        const res = await fetch('/api/stats/timeseries?granularity=hour');
+        const data = await res.json();
+        updateUsageChart(data.buckets || []);
+    } catch (e) {
+        console.error('fetchTimeseries error:', e);
+    }
+}
+
+function updateUsageChart(buckets) {
+    const chart = document.getElementById('usageChart');
+    const totals = buckets.map(b => b.promptTokens + b.completionTokens);
+    const peak = Math.max(1, ...totals);
+    chart.innerHTML = buckets.map((b, i) => {
+        const height = Math.round((totals[i] / peak) * 100);
+        const label = `${new Date(b.timestamp).toLocaleString()}: ${totals[i].toLocaleString()} tokens, ${b.messages} messages`;
+        return `<div class="usage-bar" style="height: ${height}%" title="${escapeHTML(label)}"></div>`;
+    }).join('');
+}
+
 function refreshData() {
     fetchData();
+    fetchTimeseries();
     showToast('Data refreshed', 'info');
 }
 
@@ -618,13 +1118,51 @@ async function loadInitialSettings() {
         document.getElementById('maxTokensSlider').value = config.maxTokens || 0;
         document.getElementById('temperature').value = config.temperature;
         document.getElementById('tempVal').innerText = config.temperature;
+        if (config.apiKeyConfigured) {
+            document.getElementById('apiKey').placeholder = config.apiKey;
+        }
+        lockEnvFields(config.readOnly || {});
     } catch (error) {
         console.error('Failed to load initial settings:', error);
     }
 }
 
-// Initialize
-loadInitialSettings();
-setInterval(fetchData, 2000);
-fetchData();
-initModelDropdown();
+// Inputs for each config field that can be fixed by a NIMB_ variable
+const ENV_FIELD_INPUTS = {
+    showReasoning: ['showReasoning'],
+    enableThinking: ['enableThinking'],
+    logRequests: ['logRequests'],
+    streamingEnabled: ['streamingEnabled'],
+    temperature: ['temperature'],
+    contextSize: ['contextSize', 'contextSizeSlider'],
+    maxTokens: ['maxTokens', 'maxTokensSlider'],
+    currentModel: ['modelName'],
+    apiKeys: ['apiKey']
+};
+
+function lockEnvFields(readOnly) {
+    Object.entries(readOnly).forEach(([field, envVar]) => {
+        (ENV_FIELD_INPUTS[field] || []).forEach(id => {
+            const input = document.getElementById(id);
+            if (!input) return;
+            input.disabled = true;
+            input.classList.add('env-locked');
+            input.title = `Set by ${envVar}`;
+        });
+    });
+}
+
+// Initialize once logged in
+checkAuth().then((ok) => {
+    if (!ok) return;
+    loadConfigSchema();
+    loadInitialSettings();
+    loadProfiles();
+    loadEncryptionStatus();
+    setInterval(fetchTimeseries, 60000);
+    fetchData();
+    watchStats();
+    watchTunnel();
+    fetchTimeseries();
+    initModelDropdown();
+});
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// guardTimeout bounds a guard check, which runs before every request sent
+// upstream
+const guardTimeout = 15 * time.Second
+
+// guardPrompt asks the guard model for a one-word verdict. Safety models
+// such as nemoguard answer in their own format, which still names the
+// verdict as "safe" or "unsafe".
+const guardPrompt = `You are a content safety classifier. Decide whether the user message ` +
+	`below is allowed: it must not seek help with violence, weapons, self-harm, ` +
+	`sexual content involving minors, malware, or other clearly harmful acts. ` +
+	`Reply with exactly one word: "safe" or "unsafe".`
+
+// GuardStats counts guard model checks
+type GuardStats struct {
+	Checks        int   `json:"checks"`
+	Blocked       int   `json:"blocked"`
+	Errors        int   `json:"errors"`
+	AvgLatencyMs  int64 `json:"avgLatencyMs"`
+	LastLatencyMs int64 `json:"lastLatencyMs"`
+}
+
+// lastUserMessage returns the text of the latest user message
+func lastUserMessage(messages []interface{}) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if msg, ok := messages[i].(map[string]interface{}); ok && msg["role"] == "user" {
+			return messageText(msg)
+		}
+	}
+	return ""
+}
+
+// guardText is what the guard model checks in an upstream payload: the
+// latest user message, or the prompt of a plain completion
+func guardText(payload map[string]interface{}) string {
+	if messages, ok := payload["messages"].([]interface{}); ok {
+		return lastUserMessage(messages)
+	}
+	switch prompt := payload["prompt"].(type) {
+	case string:
+		return prompt
+	case []interface{}:
+		var parts []string
+		for _, p := range prompt {
+			if s, ok := p.(string); ok {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// guardAllows runs the guard check on a payload about to go upstream. It
+// answers the client itself and returns false when the request is blocked,
+// or when the guard failed and GuardFailClosed is set. forward calls it,
+// so every endpoint is covered.
+func (a *App) guardAllows(w http.ResponseWriter, r *http.Request, config Config, payload map[string]interface{}) bool {
+	if config.GuardModel == "" || config.MockMode {
+		return true
+	}
+	text := guardText(payload)
+	if text == "" {
+		return true
+	}
+	blocked, err := a.guardCheck(r.Context(), config, text)
+	if err != nil {
+		a.logRequestError(r, "Guard check failed: "+err.Error(), 503)
+		if config.GuardFailClosed {
+			writeAPIError(w, 503, "Content guard unavailable, request not forwarded", "guard_unavailable")
+			return false
+		}
+	} else if blocked {
+		a.logRequestError(r, "Request blocked by guard model "+config.GuardModel, 400)
+		writeAPIError(w, 400, "Request blocked by content policy", "policy_violation")
+		return false
+	}
+	return true
+}
+
+// guardVerdict reports whether a guard model reply marks the message as
+// disallowed
+func guardVerdict(reply string) bool {
+	return strings.Contains(strings.ToLower(reply), "unsafe")
+}
+
+// guardCheck classifies text with the guard model and reports whether it
+// is blocked
+func (a *App) guardCheck(ctx context.Context, config Config, text string) (bool, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"model": config.GuardModel,
+		"messages": []interface{}{
+			map[string]interface{}{"role": "system", "content": guardPrompt},
+			map[string]interface{}{"role": "user", "content": text},
+		},
+		"max_tokens":  16,
+		"temperature": 0,
+		"stream":      false,
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, guardTimeout)
+	defer cancel()
+
+	start := time.Now()
+	blocked, err := a.classify(ctx, config, body)
+	latency := time.Since(start).Milliseconds()
+
+	a.mu.Lock()
+	g := &a.stats.Guard
+	g.Checks++
+	g.AvgLatencyMs += (latency - g.AvgLatencyMs) / int64(g.Checks)
+	g.LastLatencyMs = latency
+	if err != nil {
+		g.Errors++
+	} else if blocked {
+		g.Blocked++
+	}
+	a.mu.Unlock()
+	a.statsHub.publish()
+	return blocked, err
+}
+
+// classify sends a guard request and reads the verdict
+func (a *App) classify(ctx context.Context, config Config, body []byte) (bool, error) {
+	resp, err := a.doUpstream(ctx, "/chat/completions", body, &config)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != 200 {
+		msg := upstreamErrorMessage(data)
+		if msg == "" {
+			msg = resp.Status
+		}
+		return false, fmt.Errorf("guard model returned %d: %s", resp.StatusCode, msg)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return false, fmt.Errorf("guard model returned invalid JSON: %w", err)
+	}
+	reply := responseText(result)
+	if strings.TrimSpace(reply) == "" {
+		return false, fmt.Errorf("guard model returned no verdict")
+	}
+	return guardVerdict(reply), nil
+}
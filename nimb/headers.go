@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Ways of attaching the API key to upstream requests
+const (
+	AuthStyleBearer  = "bearer"    // Authorization: Bearer <key>
+	AuthStyleXAPIKey = "x-api-key" // x-api-key: <key>
+	AuthStyleNone    = "none"      // no key; UpstreamHeaders may carry it
+)
+
+// apiKeyPlaceholder is replaced by the API key in UpstreamHeaders values
+const apiKeyPlaceholder = "{{apikey}}"
+
+// maskedValue stands in for secrets in config responses. Saving it back
+// keeps the stored value.
+const maskedValue = "********"
+
+// setUpstreamHeaders attaches the API key in the configured style, then the
+// extra UpstreamHeaders, which may override anything set before them
+func setUpstreamHeaders(h http.Header, config Config) {
+	switch config.AuthHeaderStyle {
+	case AuthStyleXAPIKey:
+		h.Set("x-api-key", config.APIKey)
+	case AuthStyleNone:
+	default:
+		h.Set("Authorization", "Bearer "+config.APIKey)
+	}
+	h.Set("Content-Type", "application/json")
+
+	for name, value := range config.UpstreamHeaders {
+		h.Set(name, strings.ReplaceAll(value, apiKeyPlaceholder, config.APIKey))
+	}
+}
+
+// isSecretHeader reports whether a header value is likely a credential
+func isSecretHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"auth", "key", "token", "secret", "cookie"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedConfig returns a copy of config that is safe to send to clients:
+// the API keys are removed and secret-looking header values are masked unless
+// they only reference the key through the placeholder.
+func redactedConfig(config Config) Config {
+	config.APIKeys = nil
+	if config.UpstreamHeaders != nil {
+		headers := make(map[string]string, len(config.UpstreamHeaders))
+		for name, value := range config.UpstreamHeaders {
+			if isSecretHeader(name) && !strings.Contains(value, apiKeyPlaceholder) {
+				value = maskedValue
+			}
+			headers[name] = value
+		}
+		config.UpstreamHeaders = headers
+	}
+	if config.Providers != nil {
+		providers := slices.Clone(config.Providers)
+		for i := range providers {
+			if providers[i].APIKey != "" {
+				providers[i].APIKey = maskedValue
+			}
+		}
+		config.Providers = providers
+	}
+	if config.WebhookURL != "" {
+		config.WebhookURL = maskedValue
+	}
+	if config.ProxyAPIKey != "" {
+		config.ProxyAPIKey = maskedValue
+	}
+	if config.TunnelToken != "" {
+		config.TunnelToken = maskedValue
+	}
+	if config.NgrokAuthtoken != "" {
+		config.NgrokAuthtoken = maskedValue
+	}
+	return config
+}
+
+// restoreMaskedHeaders keeps the stored value of headers saved back masked
+func restoreMaskedHeaders(headers, stored map[string]string) {
+	for name, value := range headers {
+		if value == maskedValue {
+			headers[name] = stored[name]
+		}
+	}
+}
+
+// restoreMaskedProviderKeys puts back provider keys a client echoed from
+// redactedConfig, matching providers by name
+func restoreMaskedProviderKeys(providers, stored []Provider) {
+	for i, p := range providers {
+		if p.APIKey != maskedValue {
+			continue
+		}
+		providers[i].APIKey = ""
+		for _, s := range stored {
+			if s.Name == p.Name {
+				providers[i].APIKey = s.APIKey
+			}
+		}
+	}
+}
+
+// restoreMaskedSecrets puts back, from stored, every secret in cfg that is
+// still masked as redactedConfig left it
+func restoreMaskedSecrets(cfg *Config, stored Config) {
+	restoreMaskedHeaders(cfg.UpstreamHeaders, stored.UpstreamHeaders)
+	restoreMaskedProviderKeys(cfg.Providers, stored.Providers)
+	if cfg.WebhookURL == maskedValue {
+		cfg.WebhookURL = stored.WebhookURL
+	}
+	if cfg.ProxyAPIKey == maskedValue {
+		cfg.ProxyAPIKey = stored.ProxyAPIKey
+	}
+	if cfg.TunnelToken == maskedValue {
+		cfg.TunnelToken = stored.TunnelToken
+	}
+	if cfg.NgrokAuthtoken == maskedValue {
+		cfg.NgrokAuthtoken = stored.NgrokAuthtoken
+	}
+}
+
+// defaultForwardHeaders are the upstream response headers passed on to
+// clients when Config.ForwardHeaders is unset
+var defaultForwardHeaders = []string{"x-request-id", "x-ratelimit-*", "retry-after"}
+
+// unforwardableHeaders are never copied from the upstream: hop-by-hop
+// headers, and framing headers the proxy sets itself since it may rewrite
+// the body
+var unforwardableHeaders = []string{
+	"connection", "keep-alive", "proxy-authenticate", "proxy-authorization",
+	"proxy-connection", "te", "trailer", "transfer-encoding", "upgrade",
+	"content-length", "content-encoding", "content-type",
+}
+
+// headerAllowed matches a header name against an allowlist in which a
+// trailing "*" matches any suffix
+func headerAllowed(name string, allow []string) bool {
+	name = strings.ToLower(name)
+	if slices.Contains(unforwardableHeaders, name) {
+		return false
+	}
+	for _, pattern := range allow {
+		pattern = strings.ToLower(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// copyUpstreamHeaders copies the allowed upstream response headers to the
+// client response. The X-Request-Id NIMB gave the request is kept; an
+// upstream one that differs goes out as X-Upstream-Request-Id.
+func copyUpstreamHeaders(dst, src http.Header, allow []string) {
+	if allow == nil {
+		allow = defaultForwardHeaders
+	}
+	// Headers named in Connection are hop-by-hop too
+	var hop []string
+	for _, v := range src.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			hop = append(hop, http.CanonicalHeaderKey(strings.TrimSpace(name)))
+		}
+	}
+	for name, values := range src {
+		if !headerAllowed(name, allow) || slices.Contains(hop, name) {
+			continue
+		}
+		if own := dst.Get(name); name == "X-Request-Id" && own != "" {
+			if values[0] != own {
+				dst["X-Upstream-Request-Id"] = slices.Clone(values)
+			}
+			continue
+		}
+		dst[name] = slices.Clone(values)
+	}
+}
+
+// upstreamRequestID returns the upstream's id for a request, for quoting in
+// support tickets
+func upstreamRequestID(h http.Header) string {
+	if id := h.Get("X-Request-Id"); id != "" {
+		return id
+	}
+	return h.Get("Nvcf-Reqid")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestSetUpstreamHeaders checks each auth style and that extra headers,
+// with the key placeholder filled in, override what came before them
+func TestSetUpstreamHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		style string
+		extra map[string]string
+		want  map[string]string // "" means the header must be absent
+	}{
+		{"bearer", AuthStyleBearer, nil, map[string]string{"Authorization": "Bearer nvapi-test", "X-Api-Key": ""}},
+		{"default is bearer", "", nil, map[string]string{"Authorization": "Bearer nvapi-test"}},
+		{"x-api-key", AuthStyleXAPIKey, nil, map[string]string{"Authorization": "", "X-Api-Key": "nvapi-test"}},
+		{"none", AuthStyleNone, nil, map[string]string{"Authorization": "", "X-Api-Key": ""}},
+		{"extra header", AuthStyleBearer, map[string]string{"X-Org": "acme"}, map[string]string{"X-Org": "acme", "Authorization": "Bearer nvapi-test"}},
+		{"placeholder", AuthStyleNone, map[string]string{"Api-Token": "Key {{apikey}}"}, map[string]string{"Api-Token": "Key nvapi-test", "Authorization": ""}},
+		{"extra overrides auth", AuthStyleBearer, map[string]string{"Authorization": "Basic abc"}, map[string]string{"Authorization": "Basic abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.APIKey = "nvapi-test"
+			config.AuthHeaderStyle = tt.style
+			config.UpstreamHeaders = tt.extra
+			h := http.Header{}
+			setUpstreamHeaders(h, config)
+			for name, want := range tt.want {
+				if got := h.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestRedactedHeaders checks secret header values are masked in config
+// responses and kept when saved back masked
+func TestRedactedHeaders(t *testing.T) {
+	stored := map[string]string{
+		"Authorization": "Bearer secret",
+		"X-Api-Token":   "Token {{apikey}}",
+		"X-Org":         "acme",
+	}
+	tests := []struct {
+		name, masked string
+	}{
+		{"Authorization", maskedValue},
+		{"X-Api-Token", "Token {{apikey}}"},
+		{"X-Org", "acme"},
+	}
+	config := defaultConfig()
+	config.UpstreamHeaders = stored
+	redacted := redactedConfig(config).UpstreamHeaders
+	for _, tt := range tests {
+		if redacted[tt.name] != tt.masked {
+			t.Errorf("redacted %s = %q, want %q", tt.name, redacted[tt.name], tt.masked)
+		}
+	}
+	if stored["Authorization"] != "Bearer secret" {
+		t.Error("redacting changed the stored headers")
+	}
+
+	restoreMaskedHeaders(redacted, stored)
+	for _, tt := range tests {
+		if redacted[tt.name] != stored[tt.name] {
+			t.Errorf("restored %s = %q, want %q", tt.name, redacted[tt.name], stored[tt.name])
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultHistorySize = 50
+
+// historyEntry is a recent chat completion kept for listing and replay
+type historyEntry struct {
+	ID         string          `json:"id"`
+	Timestamp  string          `json:"timestamp"`
+	Model      string          `json:"model"`
+	Status     int             `json:"status"`
+	DurationMs int64           `json:"durationMs"`
+	ReplayOf   string          `json:"replayOf,omitempty"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// requestHistory is a ring buffer of recent chat completion requests. It
+// holds prompts, so it is only filled while LogMessageContent is on.
+type requestHistory struct {
+	mu      sync.Mutex
+	entries []historyEntry
+	nextID  int
+}
+
+// add stores an entry, dropping the oldest beyond size
+func (h *requestHistory) add(entry historyEntry, size int) {
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	entry.ID = strconv.Itoa(h.nextID)
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > size {
+		h.entries = append([]historyEntry(nil), h.entries[len(h.entries)-size:]...)
+	}
+}
+
+// list returns the entries, newest first
+func (h *requestHistory) list() []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	list := make([]historyEntry, 0, len(h.entries))
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		list = append(list, h.entries[i])
+	}
+	return list
+}
+
+// get returns the entry with the given id
+func (h *requestHistory) get(id string) (historyEntry, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, e := range h.entries {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return historyEntry{}, false
+}
+
+// clear drops every entry
+func (h *requestHistory) clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = nil
+}
+
+// recordHistory wraps w so the request is added to the history once it
+// completes; call the returned function when done. With LogMessageContent
+// off nothing is kept and w is returned unchanged.
+func (a *App) recordHistory(w http.ResponseWriter, config Config, payload map[string]interface{}, replayOf string) (http.ResponseWriter, func()) {
+	if !config.LogMessageContent {
+		return w, func() {}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return w, func() {}
+	}
+	rec := &statusRecorder{ResponseWriter: w}
+	start := time.Now()
+	model, _ := payload["model"].(string)
+
+	return rec, func() {
+		a.history.add(historyEntry{
+			Timestamp:  start.Format(time.RFC3339),
+			Model:      model,
+			Status:     rec.status,
+			DurationMs: time.Since(start).Milliseconds(),
+			ReplayOf:   replayOf,
+			Payload:    data,
+		}, config.HistorySize)
+	}
+}
+
+// handleRequests lists recent requests: the in-memory history by default,
+// or the request log file with ?source=log
+func (a *App) handleRequests(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("source") == "log" {
+		a.handleRequestLog(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.history.list())
+}
+
+// handleReplay re-sends a stored request, served at
+// POST /api/requests/{id}/replay. A JSON body may override "model" and
+// "stream". The replay goes through the normal proxy path and counts in
+// the stats like any other request.
+func (a *App) handleReplay(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/requests/"), "/replay")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entry, found := a.history.get(id)
+	if !found {
+		writeAPIError(w, 404, "No stored request with id "+id, "not_found")
+		return
+	}
+
+	var overrides struct {
+		Model  string `json:"model"`
+		Stream *bool  `json:"stream"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	config, ok := a.proxyConfig(w, r)
+	if !ok {
+		return
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(entry.Payload, &payload); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if overrides.Model != "" {
+		payload["model"] = overrides.Model
+	}
+	if overrides.Stream != nil {
+		payload["stream"] = *overrides.Stream
+		if !*overrides.Stream {
+			delete(payload, "stream_options")
+		}
+	}
+
+	w, done := a.recordHistory(w, config, payload, id)
+	defer done()
+	a.forward(w, r, config, proxyRequest{path: "/chat/completions", payload: payload})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Idempotency-Key support for /v1/chat/completions.
+//
+// The first request with a given key runs normally and its response is kept
+// for Config.IdempotencyWindowSec; a retry with the same key gets the stored
+// response, or waits for the original if it is still running. Streaming
+// requests are rejected with 400 when they carry a key, since a stream can't
+// be replayed. Upstream 5xx responses are not kept so a retry can try again.
+//
+// Keys are scoped to the proxy or virtual key the client presented, so
+// one client can't replay another's response, and tied to the request
+// body: reusing a key with a different body is refused with 422.
+
+// maxIdempotencyEntries bounds how many responses are held in memory
+const maxIdempotencyEntries = 256
+
+// defaultIdempotencyWindow applies when IdempotencyWindowSec is unset
+const defaultIdempotencyWindow = 10 * time.Minute
+
+// bufferedResponse is an http.ResponseWriter that captures a response in memory
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(code int)        { b.status = code }
+
+// writeTo copies the captured response to w
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
+
+type idempotencyEntry struct {
+	done    chan struct{} // closed once resp is set
+	resp    *bufferedResponse
+	created time.Time
+	// body is the hash of the request the key was first used with
+	body string
+}
+
+// idempotencyStore holds responses by Idempotency-Key
+type idempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+// begin returns the entry for key and whether the caller owns it (must run
+// the request and call finish). An entry made for a different body comes
+// back with owner false and mismatch true.
+func (s *idempotencyStore) begin(key, body string, window time.Duration) (e *idempotencyEntry, owner, mismatch bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.entries == nil {
+		s.entries = make(map[string]*idempotencyEntry)
+	}
+	if e, ok := s.entries[key]; ok && time.Since(e.created) < window {
+		return e, false, e.body != body
+	}
+
+	if len(s.entries) >= maxIdempotencyEntries {
+		s.evictLocked(window)
+	}
+	e = &idempotencyEntry{done: make(chan struct{}), created: time.Now(), body: body}
+	s.entries[key] = e
+	return e, true, false
+}
+
+// finish stores the response for an owned entry and wakes any waiters
+func (s *idempotencyStore) finish(key string, e *idempotencyEntry, resp *bufferedResponse) {
+	s.mu.Lock()
+	e.resp = resp
+	if resp.status >= 500 && s.entries[key] == e {
+		delete(s.entries, key)
+	}
+	s.mu.Unlock()
+	close(e.done)
+}
+
+// evictLocked drops expired entries, then the oldest finished ones, until
+// there is room for a new entry. Callers must hold s.mu.
+func (s *idempotencyStore) evictLocked(window time.Duration) {
+	for key, e := range s.entries {
+		if time.Since(e.created) >= window {
+			delete(s.entries, key)
+		}
+	}
+	for len(s.entries) >= maxIdempotencyEntries {
+		var oldestKey string
+		var oldest *idempotencyEntry
+		for key, e := range s.entries {
+			if e.resp != nil && (oldest == nil || e.created.Before(oldest.created)) {
+				oldestKey, oldest = key, e
+			}
+		}
+		if oldest == nil {
+			return // everything is in flight; allow a temporary overshoot
+		}
+		delete(s.entries, oldestKey)
+	}
+}
+
+// idempotencyHash hashes the parts of a scoped key or a request body
+func idempotencyHash(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotent runs handle at most once per Idempotency-Key, presented key
+// and request body within the configured window, replaying the stored
+// response for repeats
+func (a *App) idempotent(w http.ResponseWriter, r *http.Request, key string, reqBody map[string]interface{}, config Config, handle func(w http.ResponseWriter)) {
+	window := time.Duration(config.IdempotencyWindowSec) * time.Second
+	if window <= 0 {
+		window = defaultIdempotencyWindow
+	}
+
+	// Marshalling sorts map keys, so the hash ignores formatting
+	canonical, _ := json.Marshal(reqBody)
+	scoped := idempotencyHash(presentedProxyKey(r), key)
+	entry, owner, mismatch := a.idempotency.begin(scoped, idempotencyHash(string(canonical)), window)
+	if mismatch {
+		writeAPIError(w, 422, "Idempotency-Key was already used with a different request body", "invalid_request_error")
+		return
+	}
+	if !owner {
+		select {
+		case <-entry.done:
+			w.Header().Set("Idempotent-Replayed", "true")
+			entry.resp.writeTo(w)
+		case <-r.Context().Done():
+		}
+		return
+	}
+
+	resp := newBufferedResponse()
+	handle(resp)
+	a.idempotency.finish(scoped, entry, resp)
+	resp.writeTo(w)
+}
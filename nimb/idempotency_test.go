@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestIdempotencyReplay sends a request twice with the same
+// Idempotency-Key and checks when the second is replayed
+func TestIdempotencyReplay(t *testing.T) {
+	const first = `{"model":"m","stream":false,"messages":[{"role":"user","content":"Hi"}]}`
+	tests := []struct {
+		name     string
+		status   int // upstream status
+		second   string
+		proxyKey string
+		code     int
+		replayed bool
+		calls    int32
+	}{
+		{"same request", 200, first, "", 200, true, 1},
+		{"same request reformatted", 200, `{"stream":false, "messages":[{"content":"Hi","role":"user"}], "model":"m"}`, "", 200, true, 1},
+		{"different body", 200, `{"model":"m","stream":false,"messages":[{"role":"user","content":"Bye"}]}`, "", 422, false, 1},
+		{"another client", 200, first, "nimb-other-client-key", 200, false, 2},
+		{"upstream 5xx not kept", 503, first, "", 503, false, 2},
+		{"upstream 4xx kept", 400, first, "", 400, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			a := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+				n := calls.Add(1)
+				if tt.status != 200 {
+					http.Error(w, `{"error":{"message":"nope"}}`, tt.status)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"id":"chatcmpl-` + strconv.Itoa(int(n)) + `","choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]}`))
+			})
+			a.config.ModelOverrideMode = ModelModeClient
+			a.config.MaxRetries = 0
+
+			send := func(body, proxyKey string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+				r.Header.Set("Idempotency-Key", "order-42")
+				if proxyKey != "" {
+					r.Header.Set("X-Api-Key", proxyKey)
+				}
+				w := httptest.NewRecorder()
+				a.handleChatCompletions(w, r)
+				return w
+			}
+			one := send(first, "")
+			two := send(tt.second, tt.proxyKey)
+			if two.Code != tt.code {
+				t.Errorf("status = %d, want %d: %s", two.Code, tt.code, two.Body)
+			}
+			if replayed := two.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.replayed {
+				t.Errorf("replayed = %v, want %v", replayed, tt.replayed)
+			}
+			if tt.replayed && two.Body.String() != one.Body.String() {
+				t.Errorf("replayed body %q, want %q", two.Body, one.Body)
+			}
+			if calls.Load() != tt.calls {
+				t.Errorf("upstream called %d times, want %d", calls.Load(), tt.calls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyStreamRejected(t *testing.T) {
+	a := newTestApp(t, jsonUpstream)
+	r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"m","stream":true,"messages":[{"role":"user","content":"Hi"}]}`))
+	r.Header.Set("Idempotency-Key", "order-42")
+	w := httptest.NewRecorder()
+	a.handleChatCompletions(w, r)
+	if w.Code != 400 || !strings.Contains(w.Body.String(), `"param":"stream"`) {
+		t.Errorf("status %d, body %s", w.Code, w.Body)
+	}
+}
+
+// TestIdempotencyWaits checks a repeat that arrives while the first
+// request runs gets its response once it is done
+func TestIdempotencyWaits(t *testing.T) {
+	var s idempotencyStore
+	e, owner, _ := s.begin("k", "body", time.Minute)
+	if !owner {
+		t.Fatal("first begin does not own the entry")
+	}
+	again, owner, mismatch := s.begin("k", "body", time.Minute)
+	if owner || mismatch || again != e {
+		t.Fatalf("repeat: owner %v, mismatch %v", owner, mismatch)
+	}
+	select {
+	case <-again.done:
+		t.Fatal("done before the first request finished")
+	default:
+	}
+	resp := newBufferedResponse()
+	resp.Write([]byte("ok"))
+	s.finish("k", e, resp)
+	<-again.done
+	if again.resp.body.String() != "ok" {
+		t.Errorf("waiter got %q", again.resp.body.String())
+	}
+}
+
+// TestIdempotencyEviction checks expired entries go first, then the
+// oldest finished ones, and in-flight ones are never dropped
+func TestIdempotencyEviction(t *testing.T) {
+	fill := func(s *idempotencyStore, finished bool, age func(i int) time.Duration) {
+		for i := 0; i < maxIdempotencyEntries; i++ {
+			key := "k" + strconv.Itoa(i)
+			e, _, _ := s.begin(key, "body", time.Hour)
+			e.created = time.Now().Add(-age(i))
+			if finished {
+				s.finish(key, e, newBufferedResponse())
+			}
+		}
+	}
+	tests := []struct {
+		name     string
+		finished bool
+		age      func(i int) time.Duration
+		gone     []string
+		size     int
+	}{
+		{"oldest finished dropped", true, func(i int) time.Duration { return time.Duration(maxIdempotencyEntries-i) * time.Second },
+			[]string{"k0"}, maxIdempotencyEntries},
+		{"expired dropped", true, func(i int) time.Duration {
+			if i%2 == 0 {
+				return 2 * time.Hour
+			}
+			return time.Second
+		}, []string{"k0", "k2", "k4"}, maxIdempotencyEntries/2 + 1},
+		{"in flight kept", false, func(i int) time.Duration { return time.Second }, nil, maxIdempotencyEntries + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s idempotencyStore
+			fill(&s, tt.finished, tt.age)
+			if _, owner, _ := s.begin("new", "body", time.Hour); !owner {
+				t.Fatal("new key not owned")
+			}
+			if len(s.entries) != tt.size {
+				t.Errorf("%d entries, want %d", len(s.entries), tt.size)
+			}
+			for _, key := range tt.gone {
+				if _, ok := s.entries[key]; ok {
+					t.Errorf("%s was kept", key)
+				}
+			}
+			if _, ok := s.entries["new"]; !ok {
+				t.Error("the new entry is missing")
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultJobTTL is how long a finished job is kept when JobTTLSec is unset
+	defaultJobTTL = time.Hour
+	// defaultMaxPendingJobs applies when MaxPendingJobs is unset
+	defaultMaxPendingJobs = 10
+)
+
+// Job states
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// job is a chat completion run in the background, for clients whose
+// connection would be cut before a long completion finishes
+type job struct {
+	ID         string          `json:"id"`
+	Object     string          `json:"object"`
+	Status     string          `json:"status"`
+	Model      string          `json:"model"`
+	CreatedAt  string          `json:"createdAt"`
+	FinishedAt string          `json:"finishedAt,omitempty"`
+	HTTPStatus int             `json:"httpStatus,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+
+	finished time.Time
+	cancel   context.CancelFunc
+	// owner is the hash of the key the job was created with, see jobOwner
+	owner string
+}
+
+// jobStore holds background jobs until their results expire
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// jobLimits returns the TTL of finished jobs and the cap on running ones
+func jobLimits(config Config) (time.Duration, int) {
+	ttl := defaultJobTTL
+	if config.JobTTLSec > 0 {
+		ttl = time.Duration(config.JobTTLSec) * time.Second
+	}
+	maxPending := defaultMaxPendingJobs
+	if config.MaxPendingJobs > 0 {
+		maxPending = config.MaxPendingJobs
+	}
+	return ttl, maxPending
+}
+
+// evictLocked drops finished jobs older than ttl
+func (s *jobStore) evictLocked(ttl time.Duration) {
+	for id, j := range s.jobs {
+		if !j.finished.IsZero() && time.Since(j.finished) > ttl {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// snapshot returns a copy of the job that is safe to encode
+func (s *jobStore) snapshot(id string, ttl time.Duration) (job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evictLocked(ttl)
+	j, ok := s.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// newJobID returns a random id, so job results cannot be guessed through
+// a shared tunnel
+func newJobID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "job_" + hex.EncodeToString(b)
+}
+
+// jobOwner identifies the client behind a request by the proxy or
+// virtual key it presented, so /v1/jobs only shows a client its own jobs
+func jobOwner(r *http.Request) string {
+	sum := sha256.Sum256([]byte(presentedProxyKey(r)))
+	return hex.EncodeToString(sum[:])
+}
+
+// startJob runs pr in the background and answers with the new job's id
+func (a *App) startJob(w http.ResponseWriter, r *http.Request, config Config, pr proxyRequest) {
+	ttl, maxPending := jobLimits(config)
+
+	// A job's result is fetched whole, so the upstream is never asked to stream
+	pr.payload = maps.Clone(pr.payload)
+	pr.payload["stream"] = false
+	delete(pr.payload, "stream_options")
+
+	// The job outlives the request that created it, but keeps its values
+	// such as the request id so its logs and errors can be traced back
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	model, _ := pr.payload["model"].(string)
+	j := &job{
+		ID:        newJobID(),
+		Object:    "job",
+		Status:    JobRunning,
+		Model:     model,
+		CreatedAt: time.Now().Format(time.RFC3339),
+		cancel:    cancel,
+		owner:     jobOwner(r),
+	}
+
+	a.jobs.mu.Lock()
+	a.jobs.evictLocked(ttl)
+	running := 0
+	for _, other := range a.jobs.jobs {
+		if other.Status == JobRunning {
+			running++
+		}
+	}
+	if running >= maxPending {
+		a.jobs.mu.Unlock()
+		cancel()
+		w.Header().Set("Retry-After", "5")
+		writeAPIError(w, 429, "Too many pending jobs, try again once one finishes", "rate_limit_error")
+		return
+	}
+	if a.jobs.jobs == nil {
+		a.jobs.jobs = make(map[string]*job)
+	}
+	a.jobs.jobs[j.ID] = j
+	snapshot := *j
+	a.jobs.mu.Unlock()
+
+	jr := r.Clone(ctx)
+	go func() {
+		defer cancel()
+		resp := newBufferedResponse()
+		rw, done := a.recordHistory(resp, config, pr.payload, "")
+		a.forward(rw, jr, config, pr)
+		done()
+
+		a.jobs.mu.Lock()
+		defer a.jobs.mu.Unlock()
+		j.finished = time.Now()
+		j.FinishedAt = j.finished.Format(time.RFC3339)
+		j.HTTPStatus = resp.status
+		if json.Valid(resp.body.Bytes()) {
+			j.Result = json.RawMessage(resp.body.Bytes())
+		}
+		switch {
+		case ctx.Err() != nil:
+			j.Status = JobCanceled
+		case resp.status >= 200 && resp.status < 300:
+			j.Status = JobCompleted
+		default:
+			j.Status = JobFailed
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	location := "/api/jobs/"
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		location = "/v1/jobs/"
+	}
+	w.Header().Set("Location", location+j.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// handleJobs creates a job from a chat completion body (POST) or lists the
+// known jobs, newest first (GET)
+func (a *App) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		r.Header.Set("X-NIMB-Async", "true")
+		a.handleChatCompletions(w, r)
+	case "GET":
+		a.mu.RLock()
+		ttl, _ := jobLimits(a.config)
+		a.mu.RUnlock()
+
+		a.jobs.mu.Lock()
+		a.jobs.evictLocked(ttl)
+		list := make([]job, 0, len(a.jobs.jobs))
+		for _, j := range a.jobs.jobs {
+			entry := *j
+			entry.Result = nil
+			list = append(list, entry)
+		}
+		a.jobs.mu.Unlock()
+
+		sort.Slice(list, func(i, k int) bool { return list[i].CreatedAt > list[k].CreatedAt })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleJob polls any job for its status and result (GET) or cancels it
+// (DELETE), for the admin
+func (a *App) handleJob(w http.ResponseWriter, r *http.Request) {
+	a.serveJob(w, r, strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "")
+}
+
+// handleClientJob is /v1/jobs/{id}: the same for the client that started
+// the job, which may only reach the proxy endpoints, e.g. through the
+// tunnel. Other clients' jobs are not found.
+func (a *App) handleClientJob(w http.ResponseWriter, r *http.Request) {
+	a.serveJob(w, r, strings.TrimPrefix(r.URL.Path, "/v1/jobs/"), jobOwner(r))
+}
+
+// serveJob answers for job id, limited to the jobs of owner unless it is
+// empty
+func (a *App) serveJob(w http.ResponseWriter, r *http.Request, id, owner string) {
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	a.mu.RLock()
+	ttl, _ := jobLimits(a.config)
+	a.mu.RUnlock()
+
+	switch r.Method {
+	case "GET":
+		j, ok := a.jobs.snapshot(id, ttl)
+		if ok && owner != "" && j.owner != owner {
+			ok = false
+		}
+		if !ok {
+			writeAPIError(w, 404, "No job with id "+id, "not_found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if j.Status == JobRunning {
+			w.Header().Set("Retry-After", "2")
+		}
+		json.NewEncoder(w).Encode(j)
+	case "DELETE":
+		a.jobs.mu.Lock()
+		j, ok := a.jobs.jobs[id]
+		if ok && owner != "" && j.owner != owner {
+			ok = false
+		}
+		if ok {
+			// Cancelling a running job leaves it listed as canceled;
+			// deleting a finished one discards its result
+			if j.Status == JobRunning {
+				j.cancel()
+			} else {
+				delete(a.jobs.jobs, id)
+			}
+		}
+		a.jobs.mu.Unlock()
+
+		if !ok {
+			writeAPIError(w, 404, "No job with id "+id, "not_found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
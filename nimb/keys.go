@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Key rotation modes for Config.KeyRotation
+const (
+	KeyRotationSticky     = "sticky"      // use one key until it is rate limited or rejected
+	KeyRotationRoundRobin = "round-robin" // spread requests across all keys
+)
+
+const defaultKeyCooldown = 60 * time.Second
+
+// isKeyFailure reports whether an upstream status means the key itself,
+// rather than the request, is the problem
+func isKeyFailure(code int) bool {
+	return code == 401 || code == 429
+}
+
+// keyCooldown returns how long a failing key is skipped
+func keyCooldown(config Config) time.Duration {
+	if config.KeyCooldownSec <= 0 {
+		return defaultKeyCooldown
+	}
+	return time.Duration(config.KeyCooldownSec) * time.Second
+}
+
+// keyPool chooses among the stored API keys, skipping keys that recently
+// returned 401 or 429
+type keyPool struct {
+	mu       sync.Mutex
+	current  int
+	cooldown map[string]time.Time
+}
+
+// pick returns the key to use next, or "" and the time until one becomes
+// available when every key is cooling down
+func (p *keyPool) pick(keys []string, mode string) (string, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pickLocked(keys, mode)
+}
+
+func (p *keyPool) pickLocked(keys []string, mode string) (string, time.Duration) {
+	now := time.Now()
+	var wait time.Duration
+	for i := range keys {
+		idx := (p.current + i) % len(keys)
+		key := keys[idx]
+		if until, ok := p.cooldown[key]; ok {
+			if now.Before(until) {
+				if wait == 0 || until.Sub(now) < wait {
+					wait = until.Sub(now)
+				}
+				continue
+			}
+			delete(p.cooldown, key)
+		}
+
+		p.current = idx
+		if mode == KeyRotationRoundRobin {
+			p.current = idx + 1
+		}
+		return key, 0
+	}
+	return "", wait
+}
+
+// rotate puts a failing key on cool-down and returns the next usable key.
+// With a single key there is nothing to rotate to, so the key is left
+// alone and the upstream's response is passed through as before.
+func (p *keyPool) rotate(bad string, config Config) (string, bool) {
+	if len(config.APIKeys) < 2 {
+		return "", false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cooldown == nil {
+		p.cooldown = map[string]time.Time{}
+	}
+	p.cooldown[bad] = time.Now().Add(keyCooldown(config))
+	for i, key := range config.APIKeys {
+		if key == bad {
+			p.current = i + 1
+		}
+	}
+
+	next, _ := p.pickLocked(config.APIKeys, config.KeyRotation)
+	return next, next != ""
+}
+
+// status describes each key for /api/apikey without revealing it
+func (p *keyPool) status(keys []string) []map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	list := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		entry := map[string]interface{}{"id": keyID(key), "coolingDown": false}
+		if until, ok := p.cooldown[key]; ok && time.Now().Before(until) {
+			entry["coolingDown"] = true
+			entry["availableAt"] = until.Format(time.RFC3339)
+		}
+		list = append(list, entry)
+	}
+	return list
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// keyTestTimeout bounds a key check so the UI gets an answer quickly
+const keyTestTimeout = 15 * time.Second
+
+// KeyCheck is the outcome of the last API key test
+type KeyCheck struct {
+	Timestamp string `json:"timestamp"`
+	Key       string `json:"key"`
+	Success   bool   `json:"success"`
+	Status    int    `json:"status,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// testKey lists the upstream models with key, the cheapest call that
+// proves the key is accepted
+func (a *App) testKey(config Config, key string) KeyCheck {
+	config.APIKey = key
+	check := KeyCheck{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Key:       keyID(key),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), keyTestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", a.upstreamURL("/models"), nil)
+	if err != nil {
+		check.Error = scrubSecretsWith(err.Error(), config.APIKey)
+		return check
+	}
+	setUpstreamHeaders(req.Header, config)
+
+	client := a.upstreamClient()
+	start := time.Now()
+	resp, err := client.Do(req)
+	check.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = errors.New("upstream did not answer within " + keyTestTimeout.String())
+		} else {
+			err = describeConnError(err, client, req)
+		}
+		check.Error = scrubSecretsWith(err.Error(), config.APIKey)
+		return check
+	}
+	defer resp.Body.Close()
+
+	check.Status = resp.StatusCode
+	check.Success = resp.StatusCode == http.StatusOK
+	if !check.Success {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		msg := upstreamErrorMessage(data)
+		if msg == "" {
+			msg = resp.Status
+		}
+		check.Error = scrubSecretsWith(msg, config.APIKey)
+	}
+	return check
+}
+
+// handleTestKey checks an API key against the upstream: the one in the
+// body if given, otherwise a stored key. A key sent here is never saved.
+func (a *App) handleTestKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.mu.RLock()
+	config := a.config
+	a.mu.RUnlock()
+
+	key := req.Key
+	if key == "" {
+		key, _ = a.keys.pick(config.APIKeys, config.KeyRotation)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if key == "" && config.AuthHeaderStyle != AuthStyleNone {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "No API key to test",
+		})
+		return
+	}
+
+	check := a.testKey(config, key)
+
+	a.mu.Lock()
+	a.lastKeyCheck = &check
+	a.mu.Unlock()
+
+	json.NewEncoder(w).Encode(check)
+}
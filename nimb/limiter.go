@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Queue modes for requests beyond MaxConcurrentRequests
+const (
+	QueueModeWait   = "wait"   // wait up to QueueTimeoutMs for a free slot
+	QueueModeReject = "reject" // fail immediately with 429
+)
+
+// defaultMaxConcurrent applies when MaxConcurrentRequests is unset
+const defaultMaxConcurrent = 4
+
+// errQueueFull is returned when no upstream slot became free in time
+var errQueueFull = errors.New("too many concurrent requests")
+
+// limiter caps the number of in-flight upstream requests. The limit is
+// passed on every call so config changes take effect immediately.
+type limiter struct {
+	mu       sync.Mutex
+	inFlight int
+	waiters  []chan struct{}
+}
+
+// acquire takes a slot, waiting up to timeout for one to free up. A zero
+// timeout fails immediately when the limiter is full.
+func (l *limiter) acquire(ctx context.Context, limit int, timeout time.Duration) error {
+	l.mu.Lock()
+	if l.inFlight < limit {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	if timeout <= 0 {
+		l.mu.Unlock()
+		return errQueueFull
+	}
+	ch := make(chan struct{})
+	l.waiters = append(l.waiters, ch)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		err = errQueueFull
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, w := range l.waiters {
+		if w == ch {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return err
+		}
+	}
+	// The slot was handed over just as we gave up; give it back
+	l.releaseLocked(limit)
+	return err
+}
+
+// release frees a slot, handing it directly to the oldest waiter if any
+func (l *limiter) release(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked(limit)
+}
+
+func (l *limiter) releaseLocked(limit int) {
+	if len(l.waiters) > 0 && l.inFlight <= limit {
+		ch := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		close(ch)
+		return
+	}
+	l.inFlight--
+}
+
+// load returns the current in-flight and queued request counts
+func (l *limiter) load() (inFlight, queued int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight, len(l.waiters)
+}
+
+// concurrencyLimit returns the effective limit and queue wait for a config
+func concurrencyLimit(config Config) (int, time.Duration) {
+	limit := config.MaxConcurrentRequests
+	if limit <= 0 {
+		limit = defaultMaxConcurrent
+	}
+	if config.QueueMode == QueueModeReject {
+		return limit, 0
+	}
+	return limit, time.Duration(config.QueueTimeoutMs) * time.Millisecond
+}
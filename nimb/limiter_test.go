@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		limit int
+		wait  time.Duration
+	}{
+		{"defaults", Config{}, defaultMaxConcurrent, 0},
+		{"wait", Config{MaxConcurrentRequests: 2, QueueMode: QueueModeWait, QueueTimeoutMs: 1500}, 2, 1500 * time.Millisecond},
+		{"reject ignores the timeout", Config{MaxConcurrentRequests: 2, QueueMode: QueueModeReject, QueueTimeoutMs: 1500}, 2, 0},
+	}
+	for _, tt := range tests {
+		limit, wait := concurrencyLimit(tt.cfg)
+		if limit != tt.limit || wait != tt.wait {
+			t.Errorf("%s: concurrencyLimit = %d, %s, want %d, %s", tt.name, limit, wait, tt.limit, tt.wait)
+		}
+	}
+}
+
+// TestLimiterAcquire checks what a request gets when the limiter is full
+func TestLimiterAcquire(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		timeout time.Duration
+		want    error
+	}{
+		{"reject", context.Background(), 0, errQueueFull},
+		{"queue timeout", context.Background(), 20 * time.Millisecond, errQueueFull},
+		{"client gone", cancelled, time.Minute, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l limiter
+			if err := l.acquire(context.Background(), 1, 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := l.acquire(tt.ctx, 1, tt.timeout); err != tt.want {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+			if inFlight, queued := l.load(); inFlight != 1 || queued != 0 {
+				t.Errorf("load = %d, %d after giving up, want 1, 0", inFlight, queued)
+			}
+			l.release(1)
+			if inFlight, _ := l.load(); inFlight != 0 {
+				t.Errorf("inFlight = %d after the release, want 0", inFlight)
+			}
+		})
+	}
+}
+
+// TestLimiterHandoff checks a freed slot goes straight to the oldest
+// waiter, so it can't be taken by a newcomer in between
+func TestLimiterHandoff(t *testing.T) {
+	var l limiter
+	if err := l.acquire(context.Background(), 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	order := make(chan int, 2)
+	for i := 1; i <= 2; i++ {
+		go func() {
+			if err := l.acquire(context.Background(), 1, time.Minute); err == nil {
+				order <- i
+			}
+		}()
+		// Queue them in order
+		for _, queued := l.load(); queued < i; _, queued = l.load() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	l.release(1)
+	if got := <-order; got != 1 {
+		t.Errorf("waiter %d got the slot first, want 1", got)
+	}
+	if inFlight, queued := l.load(); inFlight != 1 || queued != 1 {
+		t.Errorf("load = %d, %d after the handoff, want 1, 1", inFlight, queued)
+	}
+	if err := l.acquire(context.Background(), 1, 0); err != errQueueFull {
+		t.Errorf("a newcomer got the slot ahead of the queue: err = %v", err)
+	}
+
+	l.release(1)
+	if got := <-order; got != 2 {
+		t.Errorf("waiter %d got the second slot, want 2", got)
+	}
+	l.release(1)
+	if inFlight, queued := l.load(); inFlight != 0 || queued != 0 {
+		t.Errorf("load = %d, %d at the end, want 0, 0", inFlight, queued)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"syscall"
+)
+
+// portFallbackTries is how many ports after the configured one
+// --port-fallback tries
+const portFallbackTries = 10
+
+// listen opens the server's port. If it is taken and PortFallback is set,
+// the next free port up to portFallbackTries along is used instead and
+// opts.Port updated to match.
+func listen(opts *options) (net.Listener, error) {
+	listener, err := net.Listen("tcp", opts.addr())
+	if err == nil || !errors.Is(err, syscall.EADDRINUSE) {
+		return listener, err
+	}
+	if !opts.PortFallback {
+		return nil, fmt.Errorf("port %d is already in use by another program; stop it, choose another port with --port or start with --port-fallback", opts.Port)
+	}
+	for port := opts.Port + 1; port <= opts.Port+portFallbackTries && port <= 65535; port++ {
+		listener, err = net.Listen("tcp", net.JoinHostPort(opts.Host, strconv.Itoa(port)))
+		if err == nil {
+			logWarnf("Port %d is in use, listening on %d instead", opts.Port, port)
+			opts.RequestedPort = opts.Port
+			opts.Port = port
+			return listener, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("ports %d-%d are all in use", opts.Port, min(opts.Port+portFallbackTries, 65535))
+}
+
+// lanIPs lists this device's network addresses other devices may reach
+// it on: IPv4 before IPv6 and private ranges, such as Wi-Fi, first
+func lanIPs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		logWarnf("Cannot list network addresses: %v", err)
+		return nil
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() && !ipNet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	rank := func(ip net.IP) int {
+		switch {
+		case ip.To4() != nil && ip.IsPrivate():
+			return 0
+		case ip.To4() != nil:
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(ips, func(i, j int) bool { return rank(ips[i]) < rank(ips[j]) })
+	return ips
+}
+
+// lanURLs are the addresses other devices on the network use, best
+// first, and empty when the server only listens on this device
+func (a *App) lanURLs() []string {
+	if !a.listen.exposed() {
+		return nil
+	}
+	var ips []net.IP
+	host := net.ParseIP(a.listen.Host)
+	if host != nil && !host.IsUnspecified() {
+		ips = []net.IP{host}
+	} else {
+		for _, ip := range lanIPs() {
+			// 0.0.0.0 only listens on IPv4
+			if host != nil && host.To4() != nil && ip.To4() == nil {
+				continue
+			}
+			ips = append(ips, ip)
+		}
+	}
+	scheme := "http://"
+	if a.listen.TLS {
+		scheme = "https://"
+	}
+	urls := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		urls = append(urls, scheme+net.JoinHostPort(ip.String(), strconv.Itoa(a.listen.Port)))
+	}
+	return urls
+}
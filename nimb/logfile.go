@@ -0,0 +1,209 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultLogFileMaxBytes = 5 << 20
+	defaultLogFileKeep     = 3
+)
+
+// logFile is the file the logger copies its lines to. Rotated files are
+// gzipped: nimb.log.1.gz (newest) to nimb.log.N.gz. All methods are called
+// with logs.mu held, which also serializes rotation against writes.
+type logFile struct {
+	path     string
+	maxBytes int64
+	keep     int
+	f        *os.File
+	size     int64
+}
+
+// openLogFile opens path for appending
+func openLogFile(path string, maxBytes int64, keep int) (*logFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &logFile{path: path, maxBytes: maxBytes, keep: keep, f: f, size: info.Size()}, nil
+}
+
+// write appends a line, rotating first if it would grow past maxBytes
+func (lf *logFile) write(line []byte) error {
+	if lf.size > 0 && lf.size+int64(len(line)) > lf.maxBytes {
+		if err := lf.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := lf.f.Write(line)
+	lf.size += int64(n)
+	return err
+}
+
+// rotate compresses the current file into nimb.log.1.gz, shifting the
+// older ones up and dropping the oldest, and starts an empty file
+func (lf *logFile) rotate() error {
+	lf.f.Close()
+	name := func(i int) string { return lf.path + "." + strconv.Itoa(i) + ".gz" }
+	os.Remove(name(lf.keep))
+	for i := lf.keep - 1; i >= 1; i-- {
+		os.Rename(name(i), name(i+1))
+	}
+	if err := gzipFile(lf.path, name(1)); err != nil {
+		// Keep logging into the old file rather than losing lines
+		f, openErr := os.OpenFile(lf.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if openErr == nil {
+			lf.f = f
+		}
+		return err
+	}
+	f, err := os.OpenFile(lf.path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	lf.f, lf.size = f, 0
+	return nil
+}
+
+// gzipFile writes a compressed copy of src to dst, through a temporary
+// file so a crash never leaves half an archive
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// logFilePath is where the log file goes: Config.LogFile, or nimb.log in
+// the data directory
+func (a *App) logFilePath(cfg Config) string {
+	if cfg.LogFile != "" {
+		return cfg.LogFile
+	}
+	return filepath.Join(a.settingsDir, "nimb.log")
+}
+
+// applyLogFile starts, moves or stops the log file to match cfg
+func (a *App) applyLogFile(cfg Config) {
+	if !cfg.LogToFile {
+		logs.useFile(nil)
+		return
+	}
+	maxBytes := cfg.LogFileMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultLogFileMaxBytes
+	}
+	keep := cfg.LogFileKeep
+	if keep <= 0 {
+		keep = defaultLogFileKeep
+	}
+	path := a.logFilePath(cfg)
+	if logs.sameFile(path, maxBytes, keep) {
+		return
+	}
+	lf, err := openLogFile(path, maxBytes, keep)
+	if err != nil {
+		logs.useFile(nil)
+		logErrorf("Cannot open the log file: %v", err)
+		return
+	}
+	logs.useFile(lf)
+}
+
+// useFile replaces the log file, closing the previous one
+func (l *logger) useFile(lf *logFile) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.f.Close()
+	}
+	l.file = lf
+}
+
+// sameFile reports whether the log file in use already has these settings
+func (l *logger) sameFile(path string, maxBytes int64, keep int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil || l.file.path != path {
+		return false
+	}
+	l.file.maxBytes, l.file.keep = maxBytes, keep
+	return true
+}
+
+// fileStatus describes the log file for /api/health, nil when off
+func (l *logger) fileStatus() map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"path":     l.file.path,
+		"size":     l.file.size,
+		"maxBytes": l.file.maxBytes,
+	}
+}
+
+// handleLogDownload streams the current log file
+func (a *App) handleLogDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	logs.mu.Lock()
+	var path string
+	if logs.file != nil {
+		path = logs.file.path
+	}
+	logs.mu.Unlock()
+	if path == "" {
+		writeAPIError(w, 409, "Logging to a file is off; set logToFile to turn it on", "invalid_request_error")
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		writeAPIError(w, 500, "Cannot open the log file: "+err.Error(), "api_error")
+		return
+	}
+	defer f.Close()
+
+	name := "nimb-" + time.Now().Format("20060102-150405") + ".log"
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	io.Copy(w, f)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log levels for Config.LogLevel
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+// Log formats for Config.LogFormat
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json" // one JSON object per line, for log collectors
+)
+
+const (
+	// maxLogEntries is how many lines /api/logs can return
+	maxLogEntries      = 1000
+	defaultLogLimit    = 100
+	logStreamBacklog   = 50
+	logTimestampFormat = "2006/01/02 15:04:05"
+)
+
+// logLevels orders the levels; a line is kept when its rank is at least
+// the configured one
+var logLevels = map[string]int{LogLevelDebug: 0, LogLevelInfo: 1, LogLevelWarn: 2, LogLevelError: 3}
+
+// logEntry is one line of NIMB's log
+type logEntry struct {
+	Seq   int64  `json:"seq"`
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+}
+
+// logger writes leveled lines to the terminal and keeps the latest in
+// memory for /api/logs, secrets masked in both
+type logger struct {
+	mu      sync.Mutex
+	out     io.Writer
+	level   int
+	json    bool
+	entries []logEntry
+	seq     int64
+	// changes signals followers of /api/logs/stream
+	changes statsHub
+	// file gets a copy of every line when Config.LogToFile is on
+	file *logFile
+}
+
+// logs is NIMB's logger. Lines written through the standard log package,
+// such as net/http's, arrive as warnings.
+var logs = &logger{out: os.Stderr, level: logLevels[LogLevelInfo]}
+
+func init() {
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{})
+}
+
+// useConfig applies Config.LogLevel and Config.LogFormat; unknown values
+// mean info and text
+func (l *logger) useConfig(cfg Config) {
+	level, ok := logLevels[cfg.LogLevel]
+	if !ok {
+		level = logLevels[LogLevelInfo]
+	}
+	l.mu.Lock()
+	l.level = level
+	l.json = cfg.LogFormat == LogFormatJSON
+	l.mu.Unlock()
+}
+
+// enabled reports whether lines at level are kept
+func (l *logger) enabled(level string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return logLevels[level] >= l.level
+}
+
+// write logs msg at level with secrets masked
+func (l *logger) write(level, msg string) {
+	l.emit(level, scrubSecrets(msg), true)
+}
+
+// emit prints a line and, if keep is set, adds it to the kept lines
+func (l *logger) emit(level, msg string, keep bool) {
+	now := time.Now()
+	msg = strings.TrimRight(msg, "\n")
+	l.mu.Lock()
+	if logLevels[level] < l.level {
+		l.mu.Unlock()
+		return
+	}
+	entry := logEntry{Time: now.Format(time.RFC3339), Level: level, Msg: msg}
+	if keep {
+		l.seq++
+		entry.Seq = l.seq
+		l.entries = append(l.entries, entry)
+		if over := len(l.entries) - maxLogEntries; over > 0 {
+			l.entries = append(l.entries[:0:0], l.entries[over:]...)
+		}
+	}
+	var line []byte
+	if l.json {
+		line, _ = json.Marshal(entry)
+		line = append(line, '\n')
+	} else {
+		line = []byte(fmt.Sprintf("%s %-5s %s\n", now.Format(logTimestampFormat), strings.ToUpper(level), msg))
+	}
+	l.out.Write(line)
+	if l.file != nil && keep {
+		if err := l.file.write(line); err != nil {
+			// Said on the terminal only; logging it would come back here
+			fmt.Fprintln(l.out, "Log file error, logging to the terminal only:", err)
+			l.file.f.Close()
+			l.file = nil
+		}
+	}
+	l.mu.Unlock()
+	if keep {
+		l.changes.publish()
+	}
+}
+
+// query returns the kept lines at or above level, newer than seq and
+// since, at most limit of the newest
+func (l *logger) query(level string, seq int64, since time.Time, limit int) []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	min := logLevels[level]
+	entries := []logEntry{}
+	for _, e := range l.entries {
+		if e.Seq <= seq || logLevels[e.Level] < min {
+			continue
+		}
+		if !since.IsZero() {
+			if t, err := time.Parse(time.RFC3339, e.Time); err == nil && t.Before(since) {
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries
+}
+
+func logDebugf(format string, args ...interface{}) {
+	logs.write(LogLevelDebug, fmt.Sprintf(format, args...))
+}
+
+func logInfof(format string, args ...interface{}) {
+	logs.write(LogLevelInfo, fmt.Sprintf(format, args...))
+}
+
+func logWarnf(format string, args ...interface{}) {
+	logs.write(LogLevelWarn, fmt.Sprintf(format, args...))
+}
+
+func logErrorf(format string, args ...interface{}) {
+	logs.write(LogLevelError, fmt.Sprintf(format, args...))
+}
+
+// logUnscrubbedf is for the rare line that shows a secret on purpose, such
+// as a newly generated proxy key. It goes to the terminal only, never to
+// /api/logs.
+func logUnscrubbedf(format string, args ...interface{}) {
+	logs.emit(LogLevelInfo, fmt.Sprintf(format, args...), false)
+}
+
+// logFatalf logs an error and exits
+func logFatalf(format string, args ...interface{}) {
+	logErrorf(format, args...)
+	os.Exit(1)
+}
+
+// stdLogWriter sends the standard logger's output to logs
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	logs.write(LogLevelWarn, string(p))
+	return len(p), nil
+}
+
+// logQueryLevel reads ?level=, defaulting to debug so every kept line
+// matches
+func logQueryLevel(r *http.Request) (string, bool) {
+	level := r.URL.Query().Get("level")
+	if level == "" {
+		return LogLevelDebug, true
+	}
+	_, ok := logLevels[level]
+	return level, ok
+}
+
+// handleLogs returns the newest log lines. ?level= keeps that level and
+// above, ?since= (RFC 3339) drops older lines and ?limit= caps how many
+// (default 100).
+func (a *App) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	level, ok := logQueryLevel(r)
+	var since time.Time
+	if s := r.URL.Query().Get("since"); ok && s != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, s); err != nil {
+			ok = false
+		}
+	}
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "level must be debug, info, warn or error and since an RFC 3339 time"})
+		return
+	}
+	limit := defaultLogLimit
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logs.query(level, 0, since, limit))
+}
+
+// handleLogStream sends the last ?limit= lines (default 50) as
+// server-sent events, then follows new ones; ?level= filters as for
+// /api/logs
+func (a *App) handleLogStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	level, ok := logQueryLevel(r)
+	if !ok {
+		http.Error(w, "level must be debug, info, warn or error", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", 500)
+		return
+	}
+
+	limit := logStreamBacklog
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n >= 0 {
+		limit = n
+	}
+
+	changes := logs.changes.subscribe()
+	defer logs.changes.unsubscribe(changes)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	logs.mu.Lock()
+	last := logs.seq
+	logs.mu.Unlock()
+	send := func(entries []logEntry) bool {
+		for _, e := range entries {
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if _, err := w.Write([]byte("event: log\ndata: " + string(data) + "\n\n")); err != nil {
+				return false
+			}
+			last = max(last, e.Seq)
+		}
+		flusher.Flush()
+		return true
+	}
+	if limit > 0 && !send(logs.query(level, 0, time.Time{}, limit)) {
+		return
+	}
+
+	heartbeat := time.NewTicker(statsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.draining:
+			return
+		case <-changes:
+			if !send(logs.query(level, last, time.Time{}, 0)) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -1,74 +1,252 @@
 package main
 
 import (
+	"crypto/tls"
 	"embed"
-	"io/fs"
-	"log"
+	"errors"
+	"flag"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 )
 
 //go:embed all:frontend
 var assets embed.FS
 
 func main() {
-	app := NewApp()
+	opts, err := parseOptions(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		logFatalf("Invalid option: %v", err)
+	}
+
+	app := NewApp(opts)
+	if err := app.applyEnvOverrides(os.Getenv); err != nil {
+		logFatalf("Invalid environment: %v", err)
+	}
+	if err := opts.resolveHost(app.config); err != nil {
+		logFatalf("Invalid option: %v", err)
+	}
+	opts.TLS = app.config.TLSEnabled
+	listener, err := listen(&opts)
+	if err != nil {
+		logFatalf("Server error: %v", err)
+	}
+	app.listen = opts
+	app.applyPprof(app.config.EnablePprof)
 
 	mux := http.NewServeMux()
 
 	// Serve static frontend files
-	frontendFS, _ := fs.Sub(assets, "frontend")
-	fileServer := http.FileServer(http.FS(frontendFS))
-	mux.Handle("/", fileServer)
+	frontendFS, dev := frontendSource(opts.FrontendDir)
+	frontend, err := newStaticHandler(frontendFS, dev)
+	if err != nil {
+		logFatalf("Failed to load frontend: %v", err)
+	}
+	mux.Handle("/", frontend)
+
+	// Ollama-compatible endpoints, registered ahead of the admin API
+	// which shares the /api/ prefix
+	mux.HandleFunc("/api/chat", app.rateLimited(app.requireProxyKey(app.handleOllamaChat)))
+	mux.HandleFunc("/api/tags", app.requireProxyKey(app.handleOllamaTags))
+
+	// Admin login; every other /api/ route needs it, see requireAdmin
+	mux.HandleFunc("/api/session", app.handleSession)
+	mux.HandleFunc("/api/setup", app.handleSetup)
+	mux.HandleFunc("/api/login", app.handleLogin)
+	mux.HandleFunc("/api/logout", app.handleLogout)
 
 	// API endpoints
 	mux.HandleFunc("/api/health", app.handleHealth)
+	mux.HandleFunc("/api/version", app.handleVersion)
 	mux.HandleFunc("/api/config", app.handleConfig)
 	mux.HandleFunc("/api/config/save", app.handleSaveConfig)
+	mux.HandleFunc("/api/config/schema", app.handleConfigSchema)
+	mux.HandleFunc("/api/profiles", app.handleProfiles)
+	mux.HandleFunc("/api/profiles/", app.handleProfile)
 	mux.HandleFunc("/api/model", app.handleSetModel)
-	mux.HandleFunc("/api/apikey", app.handleSetAPIKey)
+	mux.HandleFunc("/api/apikey", app.handleAPIKeys)
+	mux.HandleFunc("/api/proxykey/rotate", app.handleRotateProxyKey)
+	mux.HandleFunc("/api/keys", app.handleVirtualKeys)
+	mux.HandleFunc("/api/keys/", app.handleVirtualKey)
+	mux.HandleFunc("/api/unlock", app.handleUnlock)
+	mux.HandleFunc("/api/encryption", app.handleEncryption)
+	mux.HandleFunc("/api/test", app.handleTestKey)
+	mux.HandleFunc("/api/benchmark", app.handleBenchmark)
+	mux.HandleFunc("/api/jobs", app.handleJobs)
+	mux.HandleFunc("/api/jobs/", app.handleJob)
+	mux.HandleFunc("/api/aliases", app.handleAliases)
+	mux.HandleFunc("/api/rules", app.handleRules)
+	mux.HandleFunc("/api/models/refresh", app.handleRefreshModels)
 	mux.HandleFunc("/api/stats", app.handleStats)
 	mux.HandleFunc("/api/stats/reset", app.handleResetStats)
+	mux.HandleFunc("/api/stats/timeseries", app.handleTimeseries)
+	mux.HandleFunc("/api/stats/stream", app.handleStatsStream)
+	mux.HandleFunc("/api/stats/export", app.handleStatsExport)
+	mux.HandleFunc("/api/stats/export.csv", app.handleStatsExportCSV)
+	mux.HandleFunc("/api/stats/import", app.handleStatsImport)
+	mux.HandleFunc("/api/backup", app.handleBackup)
+	mux.HandleFunc("/api/restore", app.handleRestore)
+	mux.HandleFunc("/api/errors", app.handleErrors)
+	mux.HandleFunc("/api/audit", app.handleAudit)
+	mux.HandleFunc("/api/tokens/estimate", app.handleEstimateTokens)
+	mux.HandleFunc("/api/requests", app.handleRequests)
+	mux.HandleFunc("/api/requests/", app.handleReplay)
 	mux.HandleFunc("/api/tunnel/start", app.handleStartTunnel)
 	mux.HandleFunc("/api/tunnel/stop", app.handleStopTunnel)
 	mux.HandleFunc("/api/tunnel/status", app.handleTunnelStatus)
+	mux.HandleFunc("/api/tunnel/logs", app.handleTunnelLogs)
+	mux.HandleFunc("/api/tunnel/logs/stream", app.handleTunnelLogStream)
+	mux.HandleFunc("/api/tunnel/events", app.handleTunnelEvents)
+	mux.HandleFunc("/api/logs", app.handleLogs)
+	mux.HandleFunc("/api/logs/stream", app.handleLogStream)
+	mux.HandleFunc("/api/logs/download", app.handleLogDownload)
+	mux.HandleFunc("/api/tunnel/install", app.handleTunnelInstall)
+	mux.HandleFunc("/api/tunnel/binary", app.handleTunnelBinary)
+	mux.HandleFunc("/api/tunnel/qr", app.handleTunnelQR)
+	mux.HandleFunc("/api/lan/qr", app.handleLANQR)
+	mux.HandleFunc("/api/tls/cert", app.handleTLSCert)
 
 	// Proxy endpoints (OpenAI compatible)
 	mux.HandleFunc("/health", app.handleHealthJSON)
-	mux.HandleFunc("/v1/models", app.handleModels)
-	mux.HandleFunc("/v1/chat/completions", app.handleChatCompletions)
+	mux.HandleFunc("/metrics", app.handleMetrics)
+	mux.HandleFunc("/v1/models", app.requireProxyKey(app.handleModels))
+	mux.HandleFunc("/v1/chat/completions", app.rateLimited(app.requireProxyKey(app.handleChatCompletions)))
+	mux.HandleFunc("/v1/completions", app.rateLimited(app.requireProxyKey(app.handleCompletions)))
+	mux.HandleFunc("/v1/jobs/", app.requireProxyKey(app.handleClientJob))
+
+	// SIGHUP reloads settings.json after it was edited by hand
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			app.reloadSettings("SIGHUP")
+		}
+	}()
+	go app.watchSettings()
+	go app.watchUsage()
+	go app.watchUpdates()
+
+	logInfof("===========================================")
+	logInfof("  NIMB Mobile - Termux Edition")
+	logInfof("===========================================")
+	logInfof("  UI:  %s", opts.localURL())
+	logInfof("  API: %s/v1/chat/completions", opts.localURL())
+	for _, url := range app.lanURLs() {
+		logInfof("  LAN: %s", url)
+	}
+	logInfof("  Listening on %s, data in %s", opts.addr(), opts.DataDir)
+	logInfof("===========================================")
+	if opts.exposed() {
+		logWarnf("!!! WARNING: listening on %s (--insecure-lan).", opts.Host)
+		logWarnf("!!! Anyone on this network can use the admin API and your API keys.")
+		logWarnf("===========================================")
+	}
+
+	app.handler = app.accessLog(app.recoverPanics(app.filterIPs(app.corsMiddleware(app.restrictTunnel(app.requireAdmin(app.countRequests(mux)))))))
+	server := &http.Server{
+		Addr:    opts.addr(),
+		Handler: app.handler,
+		// Bound how long a slow client may take to send its request. No
+		// WriteTimeout: streamed completions can legitimately run for minutes.
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+
+	var certFile, keyFile string
+	if opts.TLS {
+		certFile, keyFile, err = app.serverCert()
+		if err != nil {
+			logFatalf("TLS error: %v", err)
+		}
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	// The tunnel points at our own port, so it waits until that is open
+	if app.config.TunnelAutoStart {
+		go func() {
+			if result := app.StartTunnel(); result["success"] != true {
+				logErrorf("Could not start the tunnel: %v", result["error"])
+			}
+		}()
+	}
 
-	// Graceful shutdown
+	servers := []*http.Server{server}
+	if port := app.config.HTTPRedirectPort; opts.TLS && port > 0 {
+		redirectAddr := net.JoinHostPort(opts.Host, strconv.Itoa(port))
+		logInfof("Redirecting http://%s to HTTPS", redirectAddr)
+		redirect := &http.Server{Addr: redirectAddr, Handler: redirectToHTTPS(opts.Port), ReadHeaderTimeout: 10 * time.Second}
+		servers = append(servers, redirect)
+		go func() {
+			if err := redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logErrorf("HTTP redirect listener error: %v", err)
+			}
+		}()
+	}
+
+	// Graceful shutdown: drain requests in flight, then exit. A second
+	// signal exits at once.
+	stopped := make(chan struct{})
 	go func() {
-		sigChan := make(chan os.Signal, 1)
+		sigChan := make(chan os.Signal, 2)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
-		log.Println("Shutting down...")
-		app.StopTunnel()
-		os.Exit(0)
+		go func() {
+			<-sigChan
+			logWarnf("Second signal, exiting now")
+			os.Exit(1)
+		}()
+		app.shutdown(servers...)
+		close(stopped)
 	}()
 
-	log.Println("===========================================")
-	log.Println("  NIMB Mobile - Termux Edition")
-	log.Println("===========================================")
-	log.Println("  UI:  http://localhost:3000")
-	log.Println("  API: http://localhost:3000/v1/chat/completions")
-	log.Println("===========================================")
-
-	if err := http.ListenAndServe(":3000", corsMiddleware(mux)); err != nil {
-		log.Fatal("Server error:", err)
+	if opts.TLS {
+		err = server.ServeTLS(listener, certFile, keyFile)
+	} else {
+		err = server.Serve(listener)
 	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		logFatalf("Server error: %v", err)
+	}
+	<-stopped
 }
 
-func corsMiddleware(next http.Handler) http.Handler {
+// corsMiddleware lets allowed origins call the API from a browser, see
+// originAllowed. Other origins get no CORS headers, so their pages can't
+// read the responses. Config.CORSAllowAll restores the old wildcard.
+func (a *App) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		a.mu.RLock()
+		allowAll := a.config.CORSAllowAll
+		a.mu.RUnlock()
+
+		origin := r.Header.Get("Origin")
+		allowed := allowAll || (origin != "" && a.originAllowed(r, origin))
+		if !allowAll {
+			w.Header().Add("Vary", "Origin")
+		}
+		if allowed {
+			if allowAll {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Api-Key, X-Request-Timeout, X-NIMB-Async, Idempotency-Key")
+		}
 
 		if r.Method == "OPTIONS" {
+			if origin != "" && !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
 			w.WriteHeader(http.StatusOK)
 			return
 		}
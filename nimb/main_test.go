@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logs.out = io.Discard
+	os.Exit(m.Run())
+}
+
+// newTestApp returns an App keeping its files in a temporary directory,
+// with a stored API key and upstream requests going to upstream
+func newTestApp(t *testing.T, upstream http.HandlerFunc) *App {
+	t.Helper()
+	a := NewApp(options{DataDir: t.TempDir()})
+	if upstream != nil {
+		srv := httptest.NewServer(upstream)
+		t.Cleanup(srv.Close)
+		a.config.UpstreamBaseURL = srv.URL
+	}
+	a.config.APIKeys = []string{"nvapi-test"}
+	return a
+}
+
+// serve sends one request with a JSON body to handler
+func serve(handler http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// fakeTunnel is a TunnelProvider that is running at url from the start
+type fakeTunnel struct{ url string }
+
+func (f *fakeTunnel) Start(events tunnelEvents) error { return nil }
+func (f *fakeTunnel) Stop() bool                      { return true }
+func (f *fakeTunnel) Status() string                  { return "running" }
+func (f *fakeTunnel) URL() string                     { return f.url }
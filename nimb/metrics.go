@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+const (
+	latencyWindow = 200 // samples kept for the percentiles
+	recentLatency = 20  // samples listed individually in /api/stats
+)
+
+// LatencySample is the timing of one completed request
+type LatencySample struct {
+	Timestamp    string  `json:"timestamp"`
+	Model        string  `json:"model"`
+	Stream       bool    `json:"stream"`
+	TTFBMs       int64   `json:"ttfbMs"`
+	DurationMs   int64   `json:"durationMs"`
+	TokensPerSec float64 `json:"tokensPerSec"`
+}
+
+// LatencySummary aggregates one latency measure
+type LatencySummary struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	Max float64 `json:"max"`
+}
+
+// LatencyStats is the speed section of /api/stats
+type LatencyStats struct {
+	TTFBMs       LatencySummary  `json:"ttfbMs"`
+	DurationMs   LatencySummary  `json:"durationMs"`
+	TokensPerSec LatencySummary  `json:"tokensPerSec"`
+	Recent       []LatencySample `json:"recent"`
+}
+
+// tokensPerSecond computes a generation rate, or 0 when it can't be measured
+func tokensPerSecond(tokens int, elapsed time.Duration) float64 {
+	if tokens <= 0 || elapsed <= 0 {
+		return 0
+	}
+	return math.Round(float64(tokens)/elapsed.Seconds()*10) / 10
+}
+
+// summarize returns the p50, p95 and max of values
+func summarize(values []float64) LatencySummary {
+	if len(values) == 0 {
+		return LatencySummary{}
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	at := func(p float64) float64 {
+		return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+	}
+	return LatencySummary{P50: at(0.5), P95: at(0.95), Max: sorted[len(sorted)-1]}
+}
+
+// latencyStats aggregates the samples kept in stats
+func latencyStats(samples []LatencySample) LatencyStats {
+	var ttfb, duration, tps []float64
+	for _, s := range samples {
+		ttfb = append(ttfb, float64(s.TTFBMs))
+		duration = append(duration, float64(s.DurationMs))
+		if s.TokensPerSec > 0 {
+			tps = append(tps, s.TokensPerSec)
+		}
+	}
+
+	recent := slices.Clone(samples[max(0, len(samples)-recentLatency):])
+	slices.Reverse(recent)
+	return LatencyStats{
+		TTFBMs:       summarize(ttfb),
+		DurationMs:   summarize(duration),
+		TokensPerSec: summarize(tps),
+		Recent:       recent,
+	}
+}
+
+// recordLatency adds a request's timing to the stats
+func (a *App) recordLatency(sample LatencySample) {
+	sample.Timestamp = time.Now().Format(time.RFC3339)
+
+	a.mu.Lock()
+	a.stats.latencySamples = append(a.stats.latencySamples, sample)
+	if len(a.stats.latencySamples) > latencyWindow {
+		a.stats.latencySamples = slices.Clone(a.stats.latencySamples[len(a.stats.latencySamples)-latencyWindow:])
+	}
+	a.mu.Unlock()
+	a.statsHub.publish()
+}
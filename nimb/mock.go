@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// mockModel is the model name reported by mock completions
+const mockModel = "nimb-mock"
+
+// mockModels is the fixed list served by /v1/models in mock mode
+var mockModels = append([]string{mockModel}, builtinModels...)
+
+// mockReply returns the canned reply for a payload, echoing the last user
+// message (or the prompt of a legacy completion)
+func mockReply(payload map[string]interface{}) string {
+	var last string
+	if messages, ok := payload["messages"].([]interface{}); ok {
+		for _, m := range messages {
+			if msg, ok := m.(map[string]interface{}); ok && msg["role"] == "user" {
+				last = messageText(msg)
+			}
+		}
+	} else if prompt, ok := payload["prompt"].(string); ok {
+		last = prompt
+	}
+	if strings.TrimSpace(last) == "" {
+		return "This is a mock response from NIMB."
+	}
+	return "This is a mock response from NIMB. You said: " + last
+}
+
+// mockResponse builds a fake upstream response for pr without any network
+// call. Streams are sent a word at a time, delay apart, to simulate
+// generation speed.
+func mockResponse(ctx context.Context, pr proxyRequest, stream bool, delay time.Duration) *http.Response {
+	reply := mockReply(pr.payload)
+	prompt := estimatePromptTokens(pr.payload)
+	completion := tokenCounter.Count(reply)
+	usage := map[string]interface{}{
+		"prompt_tokens":     prompt,
+		"completion_tokens": completion,
+		"total_tokens":      prompt + completion,
+	}
+	model, _ := pr.payload["model"].(string)
+	if model == "" {
+		model = mockModel
+	}
+	legacy := pr.path == "/completions"
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{},
+	}
+
+	if !stream {
+		var choice map[string]interface{}
+		object := "chat.completion"
+		if legacy {
+			object = "text_completion"
+			choice = map[string]interface{}{"index": 0, "text": reply, "finish_reason": "stop"}
+		} else {
+			choice = map[string]interface{}{
+				"index":         0,
+				"message":       map[string]interface{}{"role": "assistant", "content": reply},
+				"finish_reason": "stop",
+			}
+		}
+		data, _ := json.Marshal(map[string]interface{}{
+			"id":      "mock-completion",
+			"object":  object,
+			"created": time.Now().Unix(),
+			"model":   model,
+			"choices": []interface{}{choice},
+			"usage":   usage,
+		})
+		resp.Header.Set("Content-Type", "application/json")
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		return resp
+	}
+
+	created := time.Now().Unix()
+	chunk := func(choice map[string]interface{}) []byte {
+		object := "chat.completion.chunk"
+		if legacy {
+			object = "text_completion"
+		}
+		data, _ := json.Marshal(map[string]interface{}{
+			"id":      "mock-completion",
+			"object":  object,
+			"created": created,
+			"model":   model,
+			"choices": []interface{}{choice},
+		})
+		return []byte("data: " + string(data) + "\n\n")
+	}
+
+	var events [][]byte
+	for _, word := range strings.SplitAfter(reply, " ") {
+		if legacy {
+			events = append(events, chunk(map[string]interface{}{"index": 0, "text": word, "finish_reason": nil}))
+		} else {
+			events = append(events, chunk(map[string]interface{}{
+				"index":         0,
+				"delta":         map[string]interface{}{"content": word},
+				"finish_reason": nil,
+			}))
+		}
+	}
+	final := map[string]interface{}{"index": 0, "finish_reason": "stop"}
+	if legacy {
+		final["text"] = ""
+	} else {
+		final["delta"] = map[string]interface{}{}
+	}
+	events = append(events, chunk(final))
+	if opts, ok := pr.payload["stream_options"].(map[string]interface{}); ok && opts["include_usage"] == true {
+		data, _ := json.Marshal(map[string]interface{}{
+			"id":      "mock-completion",
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": []interface{}{},
+			"usage":   usage,
+		})
+		events = append(events, []byte("data: "+string(data)+"\n\n"))
+	}
+	events = append(events, []byte("data: [DONE]\n\n"))
+
+	body, writer := io.Pipe()
+	go func() {
+		for _, event := range events {
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					writer.CloseWithError(ctx.Err())
+					return
+				}
+			}
+			// Fails once the reader is closed, i.e. the client went away
+			if _, err := writer.Write(event); err != nil {
+				return
+			}
+		}
+		writer.Close()
+	}()
+
+	resp.Header.Set("Content-Type", "text/event-stream")
+	resp.Body = body
+	return resp
+}
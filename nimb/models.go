@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// modelCacheTTL is how long an upstream model list is served before refetching
+const modelCacheTTL = 10 * time.Minute
+
+// builtinModels is served when the upstream list is unavailable
+var builtinModels = []string{
+	"deepseek-ai/deepseek-v3.2",
+	"deepseek-ai/deepseek-r1",
+	"meta/llama-3.3-70b-instruct",
+	"qwen/qwen3-235b-a22b",
+	"moonshotai/kimi-k2-instruct",
+}
+
+// modelCache holds the last model list fetched from the upstream
+type modelCache struct {
+	entries   []map[string]interface{}
+	fetchedAt time.Time
+	baseURL   string
+	mu        sync.Mutex
+
+	// fetchMu serializes refreshes so concurrent requests share one upstream call
+	fetchMu sync.Mutex
+}
+
+// cachedModels returns the cached upstream entries if they are still fresh
+func (a *App) cachedModels(baseURL string) []map[string]interface{} {
+	a.models.mu.Lock()
+	defer a.models.mu.Unlock()
+
+	if a.models.entries == nil || a.models.baseURL != baseURL || time.Since(a.models.fetchedAt) > modelCacheTTL {
+		return nil
+	}
+	return a.models.entries
+}
+
+// upstreamModels returns the upstream model list, fetching it when the cache
+// is stale or force is set
+func (a *App) upstreamModels(force bool) ([]map[string]interface{}, error) {
+	baseURL := a.upstreamBaseURL()
+	if !force {
+		if entries := a.cachedModels(baseURL); entries != nil {
+			return entries, nil
+		}
+	}
+
+	requested := time.Now()
+	a.models.fetchMu.Lock()
+	defer a.models.fetchMu.Unlock()
+
+	// Another request may have refreshed the cache while we waited
+	a.models.mu.Lock()
+	if a.models.entries != nil && a.models.baseURL == baseURL && a.models.fetchedAt.After(requested) {
+		entries := a.models.entries
+		a.models.mu.Unlock()
+		return entries, nil
+	}
+	a.models.mu.Unlock()
+
+	entries, err := a.fetchModels(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	a.models.mu.Lock()
+	a.models.entries = entries
+	a.models.fetchedAt = time.Now()
+	a.models.baseURL = baseURL
+	a.models.mu.Unlock()
+	return entries, nil
+}
+
+// fetchModels calls GET {upstream}/models with the configured key
+func (a *App) fetchModels(baseURL string) ([]map[string]interface{}, error) {
+	a.mu.RLock()
+	config := a.config
+	a.mu.RUnlock()
+
+	config.APIKey, _ = a.keys.pick(config.APIKeys, config.KeyRotation)
+	if config.APIKey == "" && config.AuthHeaderStyle != AuthStyleNone {
+		return nil, errors.New("API key not configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	setUpstreamHeaders(req.Header, config)
+
+	client := a.upstreamClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, describeConnError(err, client, req)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream returned %d", resp.StatusCode)
+	}
+
+	var list struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	if list.Data == nil {
+		list.Data = []map[string]interface{}{}
+	}
+	return list.Data, nil
+}
+
+func (a *App) handleModels(w http.ResponseWriter, r *http.Request) {
+	if a.vault.isLocked() {
+		writeLockedError(w)
+		return
+	}
+
+	a.mu.RLock()
+	config := a.config
+	a.mu.RUnlock()
+
+	var aliases []string
+	for alias := range config.ModelAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if config.MockMode {
+		w.Header().Set("x-nimb-mock", "true")
+		json.NewEncoder(w).Encode(modelList(mockModels))
+		return
+	}
+
+	// Only the client mode can reach arbitrary upstream models
+	if config.ModelOverrideMode != ModelModeClient {
+		json.NewEncoder(w).Encode(modelList(append([]string{config.CurrentModel}, aliases...)))
+		return
+	}
+
+	entries, err := a.upstreamModels(false)
+	if err != nil {
+		if config.LogRequests {
+			logWarnf("[NIMB] Model list unavailable, using built-in list: %v", err)
+		}
+		ids := append([]string{config.CurrentModel}, aliases...)
+		for _, m := range builtinModels {
+			if m != config.CurrentModel {
+				ids = append(ids, m)
+			}
+		}
+		json.NewEncoder(w).Encode(modelList(ids))
+		return
+	}
+
+	list := modelList(aliases)
+	list["data"] = append(list["data"].([]map[string]interface{}), entries...)
+	json.NewEncoder(w).Encode(list)
+}
+
+// availableModelIDs lists the model names clients can use, as served by
+// /v1/models: aliases first, then the upstream list (or a built-in fallback)
+func (a *App) availableModelIDs(config Config) []string {
+	var aliases []string
+	for alias := range config.ModelAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	if config.MockMode {
+		return mockModels
+	}
+	if config.ModelOverrideMode != ModelModeClient {
+		return append([]string{config.CurrentModel}, aliases...)
+	}
+
+	entries, err := a.upstreamModels(false)
+	if err != nil {
+		ids := append([]string{config.CurrentModel}, aliases...)
+		for _, m := range builtinModels {
+			if m != config.CurrentModel {
+				ids = append(ids, m)
+			}
+		}
+		return ids
+	}
+
+	ids := aliases
+	for _, e := range entries {
+		if id, ok := e["id"].(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (a *App) handleRefreshModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries, err := a.upstreamModels(true)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"count":   len(entries),
+	})
+}
+
+// modelList formats model ids as an OpenAI-style /v1/models response
+func modelList(ids []string) map[string]interface{} {
+	data := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		data = append(data, map[string]interface{}{
+			"id":       id,
+			"object":   "model",
+			"owned_by": "nimb",
+		})
+	}
+	return map[string]interface{}{
+		"object": "list",
+		"data":   data,
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tunnelGracePeriod is how long cloudflared lets requests finish after
+// SIGTERM; it has to fit in tunnelStopTimeout
+const tunnelGracePeriod = "3s"
+
+// tunnelSpec is how cloudflared is run
+type tunnelSpec struct {
+	args []string
+	env  []string
+	// hostname is the public name of a named tunnel, empty for a quick
+	// tunnel whose URL is only known once cloudflared prints it
+	hostname string
+}
+
+// tunnelIDFromCredentials reads the tunnel id from a credentials file
+// written by `cloudflared tunnel create`
+func tunnelIDFromCredentials(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read tunnel credentials: %w", err)
+	}
+	var creds struct {
+		TunnelID string `json:"TunnelID"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil || creds.TunnelID == "" {
+		return "", errors.New("tunnel credentials file has no TunnelID")
+	}
+	return creds.TunnelID, nil
+}
+
+// validateTunnel checks the named tunnel settings of a config being saved
+func validateTunnel(cfg Config) error {
+	if cfg.TunnelToken == "" && cfg.TunnelCredentialsFile == "" {
+		return nil
+	}
+	if cfg.TunnelToken != "" && cfg.TunnelCredentialsFile != "" {
+		return errors.New("set either tunnelToken or tunnelCredentialsFile, not both")
+	}
+	if cfg.TunnelHostname == "" || strings.ContainsAny(cfg.TunnelHostname, ":/ ") {
+		return errors.New("tunnelHostname must be the tunnel's public host name, like nimb.example.com")
+	}
+	if cfg.TunnelCredentialsFile != "" {
+		if _, err := tunnelIDFromCredentials(cfg.TunnelCredentialsFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tunnelSpec builds the cloudflared command line: a named tunnel when a
+// token or credentials file is set, a quick tunnel otherwise
+func (a *App) tunnelSpec() (tunnelSpec, error) {
+	a.mu.RLock()
+	token, credsFile, hostname := a.config.TunnelToken, a.config.TunnelCredentialsFile, a.config.TunnelHostname
+	a.mu.RUnlock()
+	target, _ := a.tunnelTarget()
+	// cloudflared can't verify NIMB's self-signed certificate, nor most
+	// local services'
+	insecure := strings.HasPrefix(target, "https://")
+
+	switch {
+	case token != "":
+		// Passed in the environment so it doesn't show in ps. Where
+		// traffic goes is set up with the tunnel in the Cloudflare dashboard.
+		return tunnelSpec{
+			args:     []string{"tunnel", "--no-autoupdate", "--grace-period", tunnelGracePeriod, "run"},
+			env:      append(os.Environ(), "TUNNEL_TOKEN="+token),
+			hostname: hostname,
+		}, nil
+	case credsFile != "":
+		id, err := tunnelIDFromCredentials(credsFile)
+		if err != nil {
+			return tunnelSpec{}, err
+		}
+		args := []string{"tunnel", "--no-autoupdate", "--grace-period", tunnelGracePeriod, "--cred-file", credsFile, "--url", target}
+		if insecure {
+			args = append(args, "--no-tls-verify")
+		}
+		return tunnelSpec{args: append(args, "run", id), hostname: hostname}, nil
+	}
+
+	args := []string{"tunnel", "--grace-period", tunnelGracePeriod, "--url", target}
+	if insecure {
+		args = append(args, "--no-tls-verify")
+	}
+	return tunnelSpec{args: args}, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// ngrokAPIURL is the agent's local API, which lists its tunnels
+	ngrokAPIURL = "http://127.0.0.1:4040/api/tunnels"
+	// ngrokURLWait is how long the agent gets to open its tunnel
+	ngrokURLWait = 30 * time.Second
+)
+
+// ngrokTunnel runs the ngrok agent against the tunnel upstream and reads
+// the public URL from the agent's local API
+type ngrokTunnel struct {
+	tunnelProcess
+	authtoken string
+	// upstream is the URL traffic is forwarded to
+	upstream func() (string, error)
+}
+
+func (t *ngrokTunnel) Start(events tunnelEvents) error {
+	path, err := findTunnelBinary("", "ngrok", "Download it from https://ngrok.com/download and put it in /usr/local/bin")
+	if err != nil {
+		return err
+	}
+	upstream, err := t.upstream()
+	if err != nil {
+		return err
+	}
+	env := os.Environ()
+	if t.authtoken != "" {
+		// Kept out of the command line so it doesn't show in ps
+		env = append(env, "NGROK_AUTHTOKEN="+t.authtoken)
+	}
+	args := []string{"http", upstream, "--log", "stdout"}
+	if err := t.run("ngrok", path, args, env, events, nil); err != nil {
+		return err
+	}
+	go t.waitForURL()
+	return nil
+}
+
+// waitForURL polls the agent's API until it lists a public URL
+func (t *ngrokTunnel) waitForURL() {
+	client := &http.Client{Timeout: 2 * time.Second}
+	deadline := time.Now().Add(ngrokURLWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		if t.Status() != "starting" {
+			return
+		}
+		if url, err := ngrokPublicURL(client); err == nil {
+			t.setURL(url)
+			return
+		}
+	}
+	logWarnf("ngrok did not report a public URL within %s", ngrokURLWait)
+}
+
+// ngrokPublicURL asks the agent's API for its tunnel, preferring https
+func ngrokPublicURL(client *http.Client) (string, error) {
+	resp, err := client.Get(ngrokAPIURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Tunnels []struct {
+			PublicURL string `json:"public_url"`
+		} `json:"tunnels"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	url := ""
+	for _, tunnel := range body.Tunnels {
+		if strings.HasPrefix(tunnel.PublicURL, "https://") {
+			return tunnel.PublicURL, nil
+		}
+		if url == "" {
+			url = tunnel.PublicURL
+		}
+	}
+	if url == "" {
+		return "", errors.New("ngrok has no tunnels yet")
+	}
+	return url, nil
+}
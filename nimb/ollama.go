@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Ollama-compatible facade: /api/tags and /api/chat translate to the
+// upstream chat completions API so Ollama-only clients can use NIMB.
+
+// ollamaOptions maps Ollama's options to their OpenAI request fields
+var ollamaOptions = map[string]string{
+	"temperature": "temperature",
+	"num_predict": "max_tokens",
+	"top_p":       "top_p",
+	"top_k":       "top_k",
+	"seed":        "seed",
+	"stop":        "stop",
+}
+
+// ollamaToChat converts an Ollama /api/chat request into a chat completion request
+func ollamaToChat(reqBody map[string]interface{}) map[string]interface{} {
+	chatReq := map[string]interface{}{
+		"model":    reqBody["model"],
+		"messages": reqBody["messages"],
+		"stream":   true,
+	}
+	if stream, ok := reqBody["stream"].(bool); ok {
+		chatReq["stream"] = stream
+	}
+	if tools, ok := reqBody["tools"]; ok {
+		chatReq["tools"] = tools
+	}
+
+	if options, ok := reqBody["options"].(map[string]interface{}); ok {
+		for from, to := range ollamaOptions {
+			if v, ok := options[from]; ok {
+				chatReq[to] = v
+			}
+		}
+	}
+	return chatReq
+}
+
+// newOllamaAdapter builds a stateful adapter converting chat completion
+// output into Ollama's /api/chat responses for the given client model name
+func newOllamaAdapter(model string) *chatAdapter {
+	var doneReason string
+	var promptTokens, completionTokens float64
+
+	message := func(content string) map[string]interface{} {
+		return map[string]interface{}{
+			"model":      model,
+			"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+			"message": map[string]interface{}{
+				"role":    "assistant",
+				"content": content,
+			},
+		}
+	}
+	readUsage := func(obj map[string]interface{}) {
+		if usage, ok := obj["usage"].(map[string]interface{}); ok {
+			promptTokens, _ = usage["prompt_tokens"].(float64)
+			completionTokens, _ = usage["completion_tokens"].(float64)
+		}
+	}
+
+	return &chatAdapter{
+		ndjson: true,
+		response: func(resp map[string]interface{}) map[string]interface{} {
+			readUsage(resp)
+			var content, reason string
+			if choices, _ := resp["choices"].([]interface{}); len(choices) > 0 {
+				choice, _ := choices[0].(map[string]interface{})
+				msg, _ := choice["message"].(map[string]interface{})
+				content, _ = msg["content"].(string)
+				reason, _ = choice["finish_reason"].(string)
+			}
+			out := message(content)
+			out["done"] = true
+			out["done_reason"] = reason
+			out["prompt_eval_count"] = int(promptTokens)
+			out["eval_count"] = int(completionTokens)
+			return out
+		},
+		chunk: func(chunk map[string]interface{}) map[string]interface{} {
+			readUsage(chunk)
+			choices, _ := chunk["choices"].([]interface{})
+			if len(choices) == 0 {
+				return nil
+			}
+			choice, _ := choices[0].(map[string]interface{})
+			if reason, ok := choice["finish_reason"].(string); ok {
+				doneReason = reason
+			}
+			delta, _ := choice["delta"].(map[string]interface{})
+			content, _ := delta["content"].(string)
+			if content == "" {
+				return nil
+			}
+			out := message(content)
+			out["done"] = false
+			return out
+		},
+		finish: func() map[string]interface{} {
+			out := message("")
+			out["done"] = true
+			if doneReason == "" {
+				doneReason = "stop"
+			}
+			out["done_reason"] = doneReason
+			out["prompt_eval_count"] = int(promptTokens)
+			out["eval_count"] = int(completionTokens)
+			return out
+		},
+	}
+}
+
+func (a *App) handleOllamaChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, ok := a.proxyConfig(w, r)
+	if !ok {
+		return
+	}
+
+	reqBody, ok := a.readJSONBody(w, r, config.MaxRequestBytes)
+	if !ok {
+		return
+	}
+
+	chatReq := ollamaToChat(reqBody)
+	if verr := validateChatRequest(chatReq); verr != nil {
+		a.writeInvalidRequest(w, verr)
+		return
+	}
+	nimReq := buildUpstreamRequest(chatReq, config)
+
+	if config.LogRequests {
+		logInfof("[NIMB] ollama %v -> %v", reqBody["model"], nimReq["model"])
+	}
+
+	clientModel, _ := reqBody["model"].(string)
+	if clientModel == "" {
+		clientModel, _ = nimReq["model"].(string)
+	}
+
+	a.forward(w, r, config, proxyRequest{
+		path:    "/chat/completions",
+		payload: nimReq,
+		adapter: newOllamaAdapter(clientModel),
+	})
+}
+
+func (a *App) handleOllamaTags(w http.ResponseWriter, r *http.Request) {
+	a.mu.RLock()
+	config := a.config
+	a.mu.RUnlock()
+
+	ids := a.availableModelIDs(config)
+	models := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		models = append(models, map[string]interface{}{
+			"name":        id,
+			"model":       id,
+			"modified_at": a.startTime.UTC().Format(time.RFC3339),
+			"size":        0,
+			"digest":      "",
+			"details": map[string]interface{}{
+				"format": "nim",
+				"family": "",
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"models": models})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPort = 3000
+	// defaultHost keeps the admin API to this device. Listening on other
+	// interfaces takes --host 0.0.0.0 together with --insecure-lan.
+	defaultHost = "127.0.0.1"
+)
+
+// options are the command-line settings, which fall back to NIMB_PORT,
+// NIMB_HOST, NIMB_DATA_DIR, NIMB_PORT_FALLBACK and NIMB_FRONTEND_DIR and
+// then to the defaults. An unset Host
+// falls back to Config.ListenAddress before the default; see resolveHost.
+// Passphrase and AdminPassword only come from NIMB_PASSPHRASE and
+// NIMB_ADMIN_PASSWORD so they never show in ps.
+type options struct {
+	Host string
+	Port int
+	// PortFallback moves to a free port after Port when it is taken; see
+	// listen. RequestedPort is then the port that was taken.
+	PortFallback  bool
+	RequestedPort int
+	DataDir       string
+	InsecureLAN   bool
+	Passphrase    string
+	AdminPassword string
+	// FrontendDir serves the UI from disk instead of the embedded copy,
+	// for working on it without rebuilding
+	FrontendDir string
+	// TLS is set from Config.TLSEnabled at startup
+	TLS bool
+}
+
+// addr is the listen address
+func (o options) addr() string {
+	return net.JoinHostPort(o.Host, strconv.Itoa(o.Port))
+}
+
+// localURL is how processes on this device, such as cloudflared, reach
+// the server
+func (o options) localURL() string {
+	host := o.Host
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	scheme := "http://"
+	if o.TLS {
+		scheme = "https://"
+	}
+	return scheme + net.JoinHostPort(host, strconv.Itoa(o.Port))
+}
+
+// isLoopbackHost reports whether host only accepts connections from this
+// device
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// exposed reports whether the server is reachable beyond loopback
+func (o options) exposed() bool {
+	return !isLoopbackHost(o.Host)
+}
+
+// validateHost checks a listen host from a flag, NIMB_HOST or the config
+func validateHost(host string) error {
+	if host == "" || strings.ContainsAny(host, " /") {
+		return fmt.Errorf("host %q is not a valid address", host)
+	}
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return fmt.Errorf("host %q should not include a port; use --port", host)
+	}
+	return nil
+}
+
+// resolveHost settles the listen host once the config is loaded, and
+// refuses to expose the admin API to the network unless asked to
+func (o *options) resolveHost(config Config) error {
+	if o.Host == "" {
+		o.Host = config.ListenAddress
+	}
+	if o.Host == "" {
+		o.Host = defaultHost
+	}
+	if err := validateHost(o.Host); err != nil {
+		return err
+	}
+	if o.exposed() && !o.InsecureLAN {
+		return fmt.Errorf("listening on %s would expose the admin API and API keys to the network; "+
+			"pass --insecure-lan to allow it, or --host 127.0.0.1", o.Host)
+	}
+	return nil
+}
+
+// defaultDataDir is where settings live unless --data-dir says otherwise
+func defaultDataDir() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".nimb")
+}
+
+// parseOptions reads options from args and the environment. Values are
+// checked here so a typo fails at startup with a clear message.
+func parseOptions(args []string, getenv func(string) string) (options, error) {
+	opts := options{Port: defaultPort, DataDir: defaultDataDir()}
+	if v := getenv("NIMB_HOST"); v != "" {
+		opts.Host = v
+	}
+	if v := getenv("NIMB_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("NIMB_PORT %q is not a number", v)
+		}
+		opts.Port = port
+	}
+	if v := getenv("NIMB_DATA_DIR"); v != "" {
+		opts.DataDir = v
+	}
+	if v := getenv("NIMB_PORT_FALLBACK"); v != "" {
+		fallback, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("NIMB_PORT_FALLBACK %q is not true or false", v)
+		}
+		opts.PortFallback = fallback
+	}
+	opts.FrontendDir = getenv("NIMB_FRONTEND_DIR")
+	opts.Passphrase = getenv("NIMB_PASSPHRASE")
+	opts.AdminPassword = getenv("NIMB_ADMIN_PASSWORD")
+
+	fs := flag.NewFlagSet("nimb-mobile", flag.ContinueOnError)
+	fs.StringVar(&opts.Host, "host", opts.Host, "address to listen on (env NIMB_HOST, default "+defaultHost+")")
+	fs.IntVar(&opts.Port, "port", opts.Port, "port to listen on (env NIMB_PORT)")
+	fs.BoolVar(&opts.PortFallback, "port-fallback", opts.PortFallback, "use the next free port if the port is taken (env NIMB_PORT_FALLBACK)")
+	fs.StringVar(&opts.DataDir, "data-dir", opts.DataDir, "directory for settings and stats (env NIMB_DATA_DIR)")
+	fs.StringVar(&opts.FrontendDir, "frontend-dir", opts.FrontendDir, "serve the UI from this directory, for development (env NIMB_FRONTEND_DIR)")
+	fs.BoolVar(&opts.InsecureLAN, "insecure-lan", false, "allow listening on non-loopback addresses")
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if fs.NArg() > 0 {
+		return opts, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if opts.Port < 1 || opts.Port > 65535 {
+		return opts, fmt.Errorf("port %d is out of range 1-65535", opts.Port)
+	}
+	if opts.Host != "" {
+		if err := validateHost(opts.Host); err != nil {
+			return opts, err
+		}
+	}
+	if opts.DataDir == "" {
+		return opts, errors.New("data directory must not be empty")
+	}
+	if err := os.MkdirAll(opts.DataDir, 0755); err != nil {
+		return opts, fmt.Errorf("cannot create data directory: %w", err)
+	}
+	return opts, nil
+}
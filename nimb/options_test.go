@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// TestParseOptions checks flags win over the environment, which wins over
+// the defaults, and that bad values fail at startup
+func TestParseOptions(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		host    string
+		port    int
+		lan     bool
+		wantErr string
+	}{
+		{"defaults", nil, nil, "", defaultPort, false, ""},
+		{"environment", nil, map[string]string{"NIMB_HOST": "0.0.0.0", "NIMB_PORT": "8080"}, "0.0.0.0", 8080, false, ""},
+		{"flags over environment", []string{"--host", "::1", "--port", "9000"}, map[string]string{"NIMB_HOST": "0.0.0.0", "NIMB_PORT": "8080"}, "::1", 9000, false, ""},
+		{"insecure lan", []string{"--host", "0.0.0.0", "--insecure-lan"}, nil, "0.0.0.0", defaultPort, true, ""},
+		{"port not a number", nil, map[string]string{"NIMB_PORT": "eighty"}, "", 0, false, "not a number"},
+		{"port out of range", []string{"--port", "70000"}, nil, "", 0, false, "out of range"},
+		{"host with port", []string{"--host", "localhost:3000"}, nil, "", 0, false, "should not include a port"},
+		{"host with path", []string{"--host", "example.com/x"}, nil, "", 0, false, "not a valid address"},
+		{"stray argument", []string{"serve"}, nil, "", 0, false, "unexpected argument"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"NIMB_DATA_DIR": dir}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			opts, err := parseOptions(tt.args, func(k string) string { return env[k] })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.Host != tt.host || opts.Port != tt.port || opts.InsecureLAN != tt.lan {
+				t.Errorf("host, port, lan = %q, %d, %v, want %q, %d, %v", opts.Host, opts.Port, opts.InsecureLAN, tt.host, tt.port, tt.lan)
+			}
+			if opts.DataDir != dir {
+				t.Errorf("DataDir = %q, want %q", opts.DataDir, dir)
+			}
+		})
+	}
+}
+
+// TestResolveHost checks the host falls back to the config and then to
+// loopback, and that exposing the server takes --insecure-lan
+func TestResolveHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		config  string
+		lan     bool
+		want    string
+		exposed bool
+		wantErr bool
+	}{
+		{"default", "", "", false, defaultHost, false, false},
+		{"localhost", "localhost", "", false, "localhost", false, false},
+		{"IPv6 loopback", "::1", "", false, "::1", false, false},
+		{"from config", "", "127.0.0.2", false, "127.0.0.2", false, false},
+		{"flag over config", "127.0.0.1", "0.0.0.0", false, "127.0.0.1", false, false},
+		{"all interfaces refused", "0.0.0.0", "", false, "", true, true},
+		{"config all interfaces refused", "", "0.0.0.0", false, "", true, true},
+		{"all interfaces allowed", "0.0.0.0", "", true, "0.0.0.0", true, false},
+		{"LAN address allowed", "192.168.1.20", "", true, "192.168.1.20", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := options{Host: tt.host, InsecureLAN: tt.lan}
+			err := opts.resolveHost(Config{ListenAddress: tt.config})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if opts.exposed() != tt.exposed {
+				t.Errorf("exposed = %v, want %v", opts.exposed(), tt.exposed)
+			}
+			if !tt.wantErr && opts.Host != tt.want {
+				t.Errorf("Host = %q, want %q", opts.Host, tt.want)
+			}
+		})
+	}
+}
+
+// TestListenDefaultLoopback checks the default host really only listens on
+// this device
+func TestListenDefaultLoopback(t *testing.T) {
+	opts := options{}
+	if err := opts.resolveHost(Config{}); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := listen(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	addr := listener.Addr().(*net.TCPAddr)
+	if !addr.IP.IsLoopback() {
+		t.Errorf("listening on %s, want a loopback address", addr)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// profileName restricts profile names to what is safe as a file name
+var profileName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ProfileInfo is one entry of /api/profiles
+type ProfileInfo struct {
+	Name     string `json:"name"`
+	Model    string `json:"model"`
+	Modified string `json:"modified"`
+}
+
+func (a *App) profilesDir() string {
+	return filepath.Join(a.settingsDir, "profiles")
+}
+
+func (a *App) profilePath(name string) string {
+	return filepath.Join(a.profilesDir(), name+".json")
+}
+
+// listProfiles returns the saved profiles sorted by name
+func (a *App) listProfiles() []ProfileInfo {
+	entries, _ := os.ReadDir(a.profilesDir())
+	profiles := []ProfileInfo{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !profileName.MatchString(name) {
+			continue
+		}
+		info := ProfileInfo{Name: name}
+		if fi, err := e.Info(); err == nil {
+			info.Modified = fi.ModTime().Format(time.RFC3339)
+		}
+		if data, err := os.ReadFile(a.profilePath(name)); err == nil {
+			var cfg Config
+			if json.Unmarshal(data, &cfg) == nil {
+				info.Model = cfg.CurrentModel
+			}
+		}
+		profiles = append(profiles, info)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
+
+// saveProfile writes the live config under name. API keys stay in the
+// settings file and are shared by every profile.
+func (a *App) saveProfile(name string) error {
+	a.mu.RLock()
+	cfg := a.config
+	a.mu.RUnlock()
+	a.env.restore(&cfg)
+	cfg.APIKeys = nil
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(a.profilesDir(), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(a.profilePath(name), data, 0644)
+}
+
+// activateProfile makes a saved profile the live config, keeping the
+// current API keys and any fields set from the environment
+func (a *App) activateProfile(name, remote string) ([]fieldError, error) {
+	data, err := os.ReadFile(a.profilePath(name))
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := patchConfig(defaultConfig(), data)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	cfg.APIKeys = a.config.APIKeys
+	a.env.apply(&cfg)
+	if errs := cfg.validate(); len(errs) > 0 {
+		a.mu.Unlock()
+		return errs, nil
+	}
+	old := a.config
+	a.config = cfg
+	a.activeProfile = name
+	a.mu.Unlock()
+
+	a.configChanged(cfg)
+	a.audit(remote, AuditProfileActivate, name, old, cfg)
+	return nil, a.saveSettings()
+}
+
+// handleProfiles lists profiles on GET and saves the live config as a
+// profile on POST {name}
+func (a *App) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		a.mu.RLock()
+		active := a.activeProfile
+		a.mu.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"profiles": a.listProfiles(),
+			"active":   active,
+		})
+	case "POST":
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !profileName.MatchString(req.Name) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "name must be 1-64 letters, digits, - or _",
+			})
+			return
+		}
+
+		if err := a.saveProfile(req.Name); err != nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		a.mu.Lock()
+		a.activeProfile = req.Name
+		a.mu.Unlock()
+		a.saveSettings()
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "name": req.Name})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleProfile serves POST /api/profiles/{name}/activate and
+// DELETE /api/profiles/{name}
+func (a *App) handleProfile(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/profiles/")
+	name, action, _ := strings.Cut(rest, "/")
+	if !profileName.MatchString(name) || (action != "" && action != "activate") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case action == "activate" && r.Method == "POST":
+		errs, err := a.activateProfile(name, a.clientIP(r))
+		if len(errs) > 0 {
+			writeConfigErrors(w, errs)
+			return
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			writeAPIError(w, 404, "No profile named "+name, "not_found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "active": name})
+	case action == "" && r.Method == "DELETE":
+		err := os.Remove(a.profilePath(name))
+		if errors.Is(err, fs.ErrNotExist) {
+			writeAPIError(w, 404, "No profile named "+name, "not_found")
+			return
+		}
+		a.mu.Lock()
+		cleared := a.activeProfile == name
+		if cleared {
+			a.activeProfile = ""
+		}
+		a.mu.Unlock()
+		if cleared {
+			a.saveSettings()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": err == nil})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
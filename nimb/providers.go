@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Routing policies for spreading requests over providers
+const (
+	RouteRoundRobin = "round-robin"
+	RouteLatency    = "latency"
+)
+
+// defaultProviderName names the provider built from the main upstream
+// settings (UpstreamBaseURL and the API key pool)
+const defaultProviderName = "default"
+
+const (
+	// providerWindow is how many recent outcomes are kept per provider
+	providerWindow = 20
+	// providerEjectAfter consecutive failures take a provider out of rotation
+	providerEjectAfter = 3
+	// providerEjectFor is how long an ejected provider is skipped
+	providerEjectFor = time.Minute
+)
+
+// Provider is an extra OpenAI-compatible upstream requests can be routed to.
+// An empty Models list means the provider serves any model; entries may use
+// wildcards such as "llama-*".
+type Provider struct {
+	Name            string   `json:"name"`
+	BaseURL         string   `json:"baseUrl"`
+	APIKey          string   `json:"apiKey"`
+	AuthHeaderStyle string   `json:"authHeaderStyle"`
+	Models          []string `json:"models"`
+}
+
+// serves reports whether the provider accepts model
+func (p Provider) serves(model string) bool {
+	if len(p.Models) == 0 {
+		return true
+	}
+	for _, pattern := range p.Models {
+		if ok, _ := path.Match(pattern, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validateProviders normalizes provider base URLs and checks names are
+// set and unique
+func validateProviders(providers []Provider) error {
+	seen := map[string]bool{defaultProviderName: true}
+	for i := range providers {
+		p := &providers[i]
+		p.Name = strings.TrimSpace(p.Name)
+		if p.Name == "" {
+			return fmt.Errorf("provider %d needs a name", i+1)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("provider name %q is used twice", p.Name)
+		}
+		seen[p.Name] = true
+		if strings.TrimSpace(p.BaseURL) == "" {
+			return fmt.Errorf("provider %q needs a base URL", p.Name)
+		}
+		baseURL, err := normalizeBaseURL(p.BaseURL)
+		if err != nil {
+			return fmt.Errorf("provider %q: %v", p.Name, err)
+		}
+		p.BaseURL = baseURL
+		switch p.AuthHeaderStyle {
+		case "":
+			p.AuthHeaderStyle = AuthStyleBearer
+		case AuthStyleBearer, AuthStyleXAPIKey, AuthStyleNone:
+		default:
+			return fmt.Errorf("provider %q: authHeaderStyle must be bearer, x-api-key or none", p.Name)
+		}
+	}
+	return nil
+}
+
+// providerOutcome is one request's result, for health tracking
+type providerOutcome struct {
+	ok      bool
+	latency time.Duration
+}
+
+// providerHealth tracks one provider's recent requests
+type providerHealth struct {
+	outcomes     []providerOutcome
+	failures     int // consecutive
+	ejectedUntil time.Time
+	requests     int
+}
+
+// errorRate returns the share of recent requests that failed
+func (h *providerHealth) errorRate() float64 {
+	if len(h.outcomes) == 0 {
+		return 0
+	}
+	failed := 0
+	for _, o := range h.outcomes {
+		if !o.ok {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(h.outcomes))
+}
+
+// medianLatency returns the median latency of recent successful requests,
+// or 0 when there are none
+func (h *providerHealth) medianLatency() time.Duration {
+	var latencies []time.Duration
+	for _, o := range h.outcomes {
+		if o.ok {
+			latencies = append(latencies, o.latency)
+		}
+	}
+	if len(latencies) == 0 {
+		return 0
+	}
+	slices.Sort(latencies)
+	return latencies[len(latencies)/2]
+}
+
+// providerRouter picks a provider per request and tracks their health
+type providerRouter struct {
+	mu     sync.Mutex
+	next   int
+	health map[string]*providerHealth
+}
+
+// healthLocked returns the health record of a provider, creating it
+func (rt *providerRouter) healthLocked(name string) *providerHealth {
+	if rt.health == nil {
+		rt.health = make(map[string]*providerHealth)
+	}
+	h := rt.health[name]
+	if h == nil {
+		h = &providerHealth{}
+		rt.health[name] = h
+	}
+	return h
+}
+
+// providerList returns every provider of a config, the main upstream first
+func providerList(config Config) []Provider {
+	list := []Provider{{
+		Name:            defaultProviderName,
+		BaseURL:         configBaseURL(config),
+		AuthHeaderStyle: config.AuthHeaderStyle,
+	}}
+	return append(list, config.Providers...)
+}
+
+// pick chooses the provider for a request to model: a pinned provider if
+// one is set and healthy, otherwise one chosen by the routing policy among
+// the healthy providers serving the model. When every candidate is ejected
+// they are all tried anyway rather than failing outright.
+func (rt *providerRouter) pick(config Config, model string) Provider {
+	providers := providerList(config)
+	if len(providers) == 1 {
+		return providers[0]
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	now := time.Now()
+	healthy := func(p Provider) bool {
+		return now.After(rt.healthLocked(p.Name).ejectedUntil)
+	}
+
+	if name, ok := config.ProviderPins[model]; ok {
+		for _, p := range providers {
+			if p.Name == name && healthy(p) {
+				return p
+			}
+		}
+	}
+
+	var candidates, serving []Provider
+	for _, p := range providers {
+		if p.serves(model) {
+			serving = append(serving, p)
+			if healthy(p) {
+				candidates = append(candidates, p)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = serving
+	}
+	if len(candidates) == 0 {
+		return providers[0]
+	}
+
+	if config.RoutingPolicy == RouteLatency {
+		// Providers without samples go first, so every one gets measured
+		best := candidates[0]
+		bestLatency := rt.healthLocked(best.Name).medianLatency()
+		for _, p := range candidates[1:] {
+			latency := rt.healthLocked(p.Name).medianLatency()
+			if bestLatency != 0 && (latency == 0 || latency < bestLatency) {
+				best, bestLatency = p, latency
+			}
+		}
+		return best
+	}
+
+	p := candidates[rt.next%len(candidates)]
+	rt.next++
+	return p
+}
+
+// record notes the outcome of a request to a provider, ejecting it after
+// too many failures in a row
+func (rt *providerRouter) record(name string, ok bool, latency time.Duration) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	h := rt.healthLocked(name)
+	h.requests++
+	h.outcomes = append(h.outcomes, providerOutcome{ok, latency})
+	if len(h.outcomes) > providerWindow {
+		h.outcomes = h.outcomes[len(h.outcomes)-providerWindow:]
+	}
+	if ok {
+		h.failures = 0
+		return
+	}
+	h.failures++
+	if h.failures >= providerEjectAfter {
+		h.ejectedUntil = time.Now().Add(providerEjectFor)
+		h.failures = 0
+	}
+}
+
+// status reports each provider's health for /api/health
+func (rt *providerRouter) status(config Config) []map[string]interface{} {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	var list []map[string]interface{}
+	for _, p := range providerList(config) {
+		h := rt.healthLocked(p.Name)
+		entry := map[string]interface{}{
+			"name":            p.Name,
+			"baseUrl":         p.BaseURL,
+			"requests":        h.requests,
+			"errorRate":       h.errorRate(),
+			"medianLatencyMs": h.medianLatency().Milliseconds(),
+			"ejected":         time.Now().Before(h.ejectedUntil),
+		}
+		if time.Now().Before(h.ejectedUntil) {
+			entry["ejectedUntil"] = h.ejectedUntil.Format(time.RFC3339)
+		}
+		list = append(list, entry)
+	}
+	return list
+}
+
+// useProvider points a request's config at provider p. The main upstream
+// keeps its key pool; other providers use their single key.
+func useProvider(config *Config, p Provider) {
+	if p.Name == defaultProviderName {
+		return
+	}
+	config.UpstreamBaseURL = p.BaseURL
+	config.APIKey = p.APIKey
+	config.APIKeys = nil
+	config.AuthHeaderStyle = p.AuthHeaderStyle
+}
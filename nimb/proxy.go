@@ -0,0 +1,806 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chatAdapter reshapes chat completion output for endpoints that speak a
+// different API. Any function may be nil to leave that shape unchanged.
+type chatAdapter struct {
+	response func(resp map[string]interface{}) map[string]interface{}
+	chunk    func(chunk map[string]interface{}) map[string]interface{}
+
+	// ndjson streams converted chunks as newline-delimited JSON, ending
+	// with the object returned by finish
+	ndjson bool
+	finish func() map[string]interface{}
+}
+
+// proxyRequest describes one upstream call made on behalf of a client
+type proxyRequest struct {
+	path        string                 // upstream API path, e.g. "/chat/completions"
+	payload     map[string]interface{} // body sent upstream
+	clientModel string                 // model echoed back when an alias was used
+	adapter     *chatAdapter
+}
+
+// writeAPIError writes an OpenAI-style error object
+func writeAPIError(w http.ResponseWriter, code int, message, errType string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errType,
+			"code":    code,
+		},
+	})
+}
+
+// keyID identifies an API key in stats and logs by its last four characters
+func keyID(key string) string {
+	if len(key) < 8 {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
+
+// clientKey returns the bearer token a client sent, if any
+func clientKey(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// writeInvalidRequest records and writes a 400 for a request that failed validation
+func (a *App) writeInvalidRequest(w http.ResponseWriter, e *requestError) {
+	a.logError(e.Param+": "+e.Message, 400)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": e.Message,
+			"type":    "invalid_request_error",
+			"param":   e.Param,
+			"code":    "invalid_value",
+		},
+	})
+}
+
+// proxyConfig returns a snapshot of the config for a proxied request, writing
+// an error and returning false when no API key is available or the
+// credentials are still locked. With
+// AllowClientKeys on, a client's own bearer token replaces the stored keys.
+func (a *App) proxyConfig(w http.ResponseWriter, r *http.Request) (Config, bool) {
+	if a.vault.isLocked() {
+		writeLockedError(w)
+		return Config{}, false
+	}
+
+	a.mu.RLock()
+	config := a.config
+	a.mu.RUnlock()
+
+	// The proxy and virtual keys only get a request in; they are never
+	// sent upstream
+	if key := clientKey(r); config.AllowClientKeys && key != config.ProxyAPIKey && !a.isVirtualKey(key) {
+		config.APIKey = key
+	}
+	if config.APIKey == "" && len(config.APIKeys) > 0 {
+		key, wait := a.keys.pick(config.APIKeys, config.KeyRotation)
+		if key == "" {
+			secs := int(wait.Seconds()) + 1
+			msg := fmt.Sprintf("All API keys are rate limited or rejected, retry in %ds", secs)
+			a.logRequestError(r, msg, 429)
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			writeAPIError(w, 429, msg, "rate_limit_error")
+			return config, false
+		}
+		config.APIKey = key
+	}
+
+	// Mock mode answers locally, so it works before a key is set up
+	if config.APIKey == "" && config.AuthHeaderStyle != AuthStyleNone && !config.MockMode {
+		a.logRequestError(r, "API key not configured", 500)
+		writeAPIError(w, 500, "API key not configured", "configuration_error")
+		return config, false
+	}
+	return config, true
+}
+
+// defaultMaxRequestBytes caps proxied request bodies when the config leaves it unset
+const defaultMaxRequestBytes = 2 << 20
+
+// readJSONBody decodes a JSON object request body of at most limit bytes,
+// logging failures
+func (a *App) readJSONBody(w http.ResponseWriter, r *http.Request, limit int64) (map[string]interface{}, bool) {
+	if limit <= 0 {
+		limit = defaultMaxRequestBytes
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			msg := fmt.Sprintf("request body exceeds the %d byte limit", limit)
+			a.logRequestError(r, msg, 413)
+			writeAPIError(w, 413, msg, "invalid_request_error")
+			return nil, false
+		}
+		a.logRequestError(r, err.Error(), 400)
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+
+	var reqBody map[string]interface{}
+	if err := json.Unmarshal(body, &reqBody); err != nil {
+		a.logRequestError(r, err.Error(), 400)
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+
+	// Keep structured params as raw JSON so they are forwarded untouched
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(body, &raw) == nil {
+		for _, p := range structuredParams {
+			if v, ok := raw[p]; ok {
+				reqBody[p] = v
+			}
+		}
+	}
+	return reqBody, true
+}
+
+// aliasedModel returns the client's model name when it was translated
+// through an alias, so responses can carry the name the client asked for
+func aliasedModel(reqBody map[string]interface{}, config Config) string {
+	if requested, ok := reqBody["model"].(string); ok && config.ModelAliases[requested] != "" {
+		return requested
+	}
+	return ""
+}
+
+func (a *App) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, ok := a.proxyConfig(w, r)
+	if !ok {
+		return
+	}
+
+	reqBody, ok := a.readJSONBody(w, r, config.MaxRequestBytes)
+	if !ok {
+		return
+	}
+
+	requested, _ := reqBody["model"].(string)
+	if rewrites := translateCompat(reqBody, resolveModel(requested, config)); len(rewrites) > 0 && config.LogRequests {
+		logDebugf("[NIMB] Compat rewrites: %s", strings.Join(rewrites, ", "))
+	}
+
+	if verr := validateChatRequest(reqBody); verr != nil {
+		a.writeInvalidRequest(w, verr)
+		return
+	}
+
+	nimReq := buildUpstreamRequest(reqBody, config)
+
+	imageMessages, verr := prepareImages(nimReq, config)
+	if verr != nil {
+		a.writeInvalidRequest(w, verr)
+		return
+	}
+	if imageMessages > 0 {
+		a.mu.Lock()
+		a.stats.ImageMessages += imageMessages
+		a.mu.Unlock()
+		a.statsHub.publish()
+	}
+
+	trimmed, verr := applyContextLimit(nimReq, config)
+	if verr != nil {
+		a.writeInvalidRequest(w, verr)
+		return
+	}
+	if trimmed > 0 {
+		w.Header().Set("x-nimb-trimmed-messages", strconv.Itoa(trimmed))
+		a.mu.Lock()
+		a.stats.TrimCount++
+		a.mu.Unlock()
+		a.statsHub.publish()
+		if config.LogRequests {
+			logInfof("[NIMB] Trimmed %d messages to fit the context window", trimmed)
+		}
+	}
+
+	if config.LogRequests {
+		logInfof("[NIMB] %v -> %v (key %s)", reqBody["model"], nimReq["model"], keyID(config.APIKey))
+	}
+
+	pr := proxyRequest{
+		path:        "/chat/completions",
+		payload:     nimReq,
+		clientModel: aliasedModel(reqBody, config),
+	}
+
+	if strings.EqualFold(r.Header.Get("X-NIMB-Async"), "true") {
+		a.startJob(w, r, config, pr)
+		return
+	}
+
+	w, done := a.recordHistory(w, config, nimReq, "")
+	defer done()
+
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		if stream, _ := nimReq["stream"].(bool); stream {
+			a.writeInvalidRequest(w, &requestError{"Idempotency-Key is not supported for streaming requests", "stream"})
+			return
+		}
+		a.idempotent(w, r, key, reqBody, config, func(w http.ResponseWriter) {
+			a.forward(w, r, config, pr)
+		})
+		return
+	}
+
+	a.forward(w, r, config, pr)
+}
+
+// forward sends a prepared payload upstream and relays the response to the
+// client, updating stats along the way
+func (a *App) forward(w http.ResponseWriter, r *http.Request, config Config, pr proxyRequest) {
+	var applied []string
+	pr.payload, applied = applyRules(pr.payload, config.Rules, pr.clientModel, r)
+	if len(applied) > 0 && config.LogRequests {
+		logDebugf("[NIMB] Rules applied: %s", strings.Join(applied, ", "))
+	}
+
+	trace := &requestTrace{start: time.Now()}
+	if config.LogRequests {
+		rec := &statusRecorder{ResponseWriter: w}
+		w = rec
+		defer a.logRequest(config, pr, rec, trace)
+	}
+
+	// Only new requests are refused; streams already running may finish
+	if exceeded, resetAt := a.budgetExceeded(); exceeded && !config.MockMode {
+		msg := "Daily token budget reached, resets at " + resetAt.Format(time.RFC3339)
+		a.notify(EventBudgetReached, msg)
+		a.logErrorItem(ErrorItem{Message: msg, Code: 429, Model: pr.clientModel, Route: r.URL.Path,
+			ClientRequestID: requestIDFrom(r.Context())})
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(resetAt).Seconds())+1))
+		writeAPIError(w, 429, msg, "budget_exceeded")
+		return
+	}
+
+	if !a.guardAllows(w, r, config, pr.payload) {
+		return
+	}
+
+	limit, queueWait := concurrencyLimit(config)
+	if err := a.limiter.acquire(r.Context(), limit, queueWait); err != nil {
+		a.logErrorItem(ErrorItem{Message: "Concurrency limit reached: " + err.Error(), Code: 429, Model: pr.clientModel, Route: r.URL.Path,
+			ClientRequestID: requestIDFrom(r.Context())})
+		w.Header().Set("Retry-After", "1")
+		writeAPIError(w, 429, "Too many concurrent requests, try again shortly", "rate_limit_error")
+		return
+	}
+	defer a.limiter.release(limit)
+
+	if config.MockMode {
+		// Mock responses neither reach the upstream nor count against it
+	} else if ok, wait := a.breaker.allow(); !ok {
+		secs := int(wait.Seconds()) + 1
+		msg := fmt.Sprintf("upstream temporarily unavailable, retrying in %ds", secs)
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		writeAPIError(w, 503, msg, "upstream_unavailable")
+		return
+	}
+
+	isStream, _ := pr.payload["stream"].(bool)
+	upstreamStream := upstreamStreams(config.UpstreamStreamMode, pr.path, isStream)
+	if config.MockMode {
+		upstreamStream = isStream
+	}
+	if upstreamStream != isStream {
+		pr.payload = maps.Clone(pr.payload)
+		pr.payload["stream"] = upstreamStream
+		if upstreamStream {
+			pr.payload["stream_options"] = map[string]interface{}{"include_usage": true}
+		} else {
+			delete(pr.payload, "stream_options")
+		}
+	}
+
+	ctx, cancel := withTimeouts(r.Context(), requestTimeouts(r, config, upstreamStream))
+	defer cancel()
+
+	start := time.Now()
+	var resp *http.Response
+	var fallbackModel string
+	var err error
+	if config.MockMode {
+		resp = mockResponse(ctx, pr, isStream, time.Duration(config.MockDelayMs)*time.Millisecond)
+		w.Header().Set("x-nimb-mock", "true")
+	} else {
+		model, _ := pr.payload["model"].(string)
+		provider := a.router.pick(config, model)
+		useProvider(&config, provider)
+		if len(config.Providers) > 0 {
+			w.Header().Set("x-nimb-provider", provider.Name)
+		}
+		resp, fallbackModel, err = a.doUpstreamWithFallback(ctx, &config, pr)
+		if r.Context().Err() == nil {
+			a.router.record(provider.Name, err == nil && resp.StatusCode < 500, time.Since(start))
+		}
+	}
+	ttfb := time.Since(start)
+	// A client hanging up says nothing about the upstream's health
+	switch {
+	case config.MockMode:
+	case r.Context().Err() == nil:
+		a.breaker.record(err == nil && resp.StatusCode < 500, config)
+	default:
+		a.breaker.abort()
+	}
+	if err != nil {
+		a.writeUpstreamError(w, r, err, pr)
+		return
+	}
+	defer resp.Body.Close()
+
+	copyUpstreamHeaders(w.Header(), resp.Header, config.ForwardHeaders)
+	if fallbackModel != "" {
+		w.Header().Set("x-nimb-fallback-model", fallbackModel)
+	}
+
+	trace.model = servedModel(pr, fallbackModel)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		a.recordUpstreamError(resp, trace.model, r.URL.Path)
+	}
+
+	id := keyID(config.APIKey)
+
+	// Mock responses are kept out of the usage and cost stats
+	costed := !config.MockMode
+	if costed {
+		a.mu.Lock()
+		a.stats.MessageCount++
+		a.stats.LastRequestTime = time.Now().Format(time.RFC3339)
+		a.keyUsage(id).Requests++
+		a.modelUsage(trace.model).Requests++
+		a.recordSeriesLocked(func(b *usageBucket) { b.Messages++ })
+		a.mu.Unlock()
+		a.statsHub.publish()
+	}
+
+	// Convert between streaming and non-streaming when the upstream was
+	// asked for something other than what the client wants
+	if upstreamStream != isStream && resp.StatusCode == http.StatusOK {
+		var converted []byte
+		if upstreamStream {
+			aggregated, err := aggregateStream(resp.Body)
+			if err != nil {
+				a.writeUpstreamError(w, r, bodyTimeout(ctx, err), pr)
+				return
+			}
+			converted, _ = json.Marshal(aggregated)
+		} else {
+			var completion map[string]interface{}
+			data, err := io.ReadAll(resp.Body)
+			if err == nil {
+				err = json.Unmarshal(data, &completion)
+			}
+			if err != nil {
+				a.writeUpstreamError(w, r, bodyTimeout(ctx, err), pr)
+				return
+			}
+			converted = synthesizeStream(completion)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(converted))
+	}
+
+	// A failed stream request gets the upstream's error as plain JSON, as
+	// nothing has been streamed yet
+	if isStream && resp.StatusCode == http.StatusOK {
+		if pr.adapter != nil && pr.adapter.ndjson {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		} else {
+			w.Header().Set("Content-Type", "text/event-stream")
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("Trailer", "X-Nimb-Ttfb-Ms, X-Nimb-Duration-Ms")
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", 500)
+			return
+		}
+
+		relay := streamRelay{
+			clientModel:    pr.clientModel,
+			stripReasoning: !config.ShowReasoning,
+			keepalive:      time.Duration(config.StreamKeepaliveSec) * time.Second,
+		}
+		if pr.adapter != nil {
+			relay.convert = pr.adapter.chunk
+			relay.ndjson = pr.adapter.ndjson
+			relay.finish = pr.adapter.finish
+		}
+		if err := relay.relay(w, flusher, resp.Body); err != nil && r.Context().Err() == nil {
+			msg := "Stream aborted: " + err.Error()
+			a.logErrorItem(ErrorItem{Message: msg, Code: 502, Model: trace.model, Route: r.URL.Path,
+				RequestID: upstreamRequestID(resp.Header), ClientRequestID: requestIDFrom(r.Context())})
+			relay.abort(w, flusher, msg)
+		}
+
+		completion := usageCompletionTokens(relay.usage)
+		trace.usage = relay.usage
+		switch {
+		case !costed:
+		case relay.usage != nil:
+			a.recordUsage(id, trace.model, relay.usage)
+			a.chargeVirtualKey(r, usageTotalTokens(relay.usage))
+		default:
+			completion = tokenCounter.Count(relay.completion.String())
+			prompt := estimatePromptTokens(pr.payload)
+			a.recordEstimatedUsage(id, trace.model, prompt, completion)
+			a.chargeVirtualKey(r, prompt+completion)
+		}
+		if relay.reasoningChars > 0 {
+			a.recordReasoningTokens(reasoningTokens(relay.usage, relay.reasoningChars))
+		}
+
+		// For streams the first byte that matters is the first generated chunk
+		if !relay.firstData.IsZero() {
+			ttfb = relay.firstData.Sub(start)
+		}
+		duration := time.Since(start)
+		w.Header().Set("X-Nimb-Ttfb-Ms", strconv.FormatInt(ttfb.Milliseconds(), 10))
+		w.Header().Set("X-Nimb-Duration-Ms", strconv.FormatInt(duration.Milliseconds(), 10))
+		if costed {
+			a.recordLatency(LatencySample{
+				Model:        servedModel(pr, fallbackModel),
+				Stream:       true,
+				TTFBMs:       ttfb.Milliseconds(),
+				DurationMs:   duration.Milliseconds(),
+				TokensPerSec: tokensPerSecond(completion, relay.lastData.Sub(relay.firstData)),
+			})
+		}
+	} else {
+		respBody, err := io.ReadAll(resp.Body)
+		if err = bodyTimeout(ctx, err); err != nil {
+			a.writeUpstreamError(w, r, err, pr)
+			return
+		}
+
+		var nimResp map[string]interface{}
+		json.Unmarshal(respBody, &nimResp)
+
+		completion := 0
+		usage, hasUsage := nimResp["usage"].(map[string]interface{})
+		switch {
+		case !costed:
+		case hasUsage:
+			trace.usage = usage
+			a.recordUsage(id, trace.model, usage)
+			a.chargeVirtualKey(r, usageTotalTokens(usage))
+			completion = usageCompletionTokens(usage)
+		case resp.StatusCode == http.StatusOK && nimResp != nil:
+			completion = tokenCounter.Count(responseText(nimResp))
+			prompt := estimatePromptTokens(pr.payload)
+			a.recordEstimatedUsage(id, trace.model, prompt, completion)
+			a.chargeVirtualKey(r, prompt+completion)
+		}
+
+		rewrite := false
+		if !config.ShowReasoning && nimResp != nil {
+			if removed := stripReasoningResponse(nimResp); removed > 0 {
+				usage, _ := nimResp["usage"].(map[string]interface{})
+				a.recordReasoningTokens(reasoningTokens(usage, removed))
+				rewrite = true
+			}
+		}
+		if pr.clientModel != "" && nimResp != nil {
+			nimResp["model"] = pr.clientModel
+			rewrite = true
+		}
+		if pr.adapter != nil && pr.adapter.response != nil && nimResp != nil && resp.StatusCode == http.StatusOK {
+			nimResp = pr.adapter.response(nimResp)
+			rewrite = true
+		}
+		if rewrite {
+			if rewritten, err := json.Marshal(nimResp); err == nil {
+				respBody = rewritten
+			}
+		}
+
+		if nimResp == nil && resp.StatusCode != http.StatusOK {
+			msg := upstreamErrorMessage(respBody)
+			if msg == "" {
+				msg = resp.Status
+			}
+			writeAPIError(w, resp.StatusCode, msg, "upstream_error")
+			return
+		}
+
+		duration := time.Since(start)
+		if resp.StatusCode == http.StatusOK && costed {
+			a.recordLatency(LatencySample{
+				Model:        servedModel(pr, fallbackModel),
+				TTFBMs:       ttfb.Milliseconds(),
+				DurationMs:   duration.Milliseconds(),
+				TokensPerSec: tokensPerSecond(completion, duration),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Nimb-Ttfb-Ms", strconv.FormatInt(ttfb.Milliseconds(), 10))
+		w.Header().Set("X-Nimb-Duration-Ms", strconv.FormatInt(duration.Milliseconds(), 10))
+		w.WriteHeader(resp.StatusCode)
+		w.Write(respBody)
+	}
+
+	if config.LogRequests {
+		logDebugf("[NIMB] Done")
+	}
+}
+
+// servedModel returns the upstream model that answered a request
+func servedModel(pr proxyRequest, fallbackModel string) string {
+	if fallbackModel != "" {
+		return fallbackModel
+	}
+	model, _ := pr.payload["model"].(string)
+	return model
+}
+
+// usageCompletionTokens reads completion_tokens from a usage object
+func usageCompletionTokens(usage map[string]interface{}) int {
+	ct, _ := usage["completion_tokens"].(float64)
+	return int(ct)
+}
+
+// writeUpstreamError reports a failed upstream call, as a 504 naming the
+// phase when it timed out
+func (a *App) writeUpstreamError(w http.ResponseWriter, r *http.Request, err error, pr proxyRequest) {
+	a.noteUpstreamError()
+	model, _ := pr.payload["model"].(string)
+	var te *timeoutError
+	if errors.As(err, &te) {
+		a.logErrorItem(ErrorItem{Message: te.Error(), Code: 504, Model: model, Route: r.URL.Path,
+			ClientRequestID: requestIDFrom(r.Context())})
+		writeAPIError(w, 504, te.Error(), "timeout_error")
+		return
+	}
+	a.logErrorItem(ErrorItem{Message: err.Error(), Code: 500, Model: model, Route: r.URL.Path,
+		ClientRequestID: requestIDFrom(r.Context())})
+	writeAPIError(w, 500, err.Error(), "api_error")
+}
+
+// keyUsage returns the usage entry for a key id, creating it if needed.
+// Callers must hold a.mu.
+func (a *App) keyUsage(id string) *KeyUsage {
+	return usageEntry(a.stats.KeyUsage, id)
+}
+
+// modelUsage returns the usage entry for a model. Callers must hold a.mu.
+func (a *App) modelUsage(model string) *KeyUsage {
+	return usageEntry(a.stats.ModelUsage, model)
+}
+
+// usageEntry returns m[name], creating it if needed
+func usageEntry(m map[string]*KeyUsage, name string) *KeyUsage {
+	u := m[name]
+	if u == nil {
+		u = &KeyUsage{}
+		m[name] = u
+	}
+	return u
+}
+
+// cloneUsage deep-copies a usage map for a stats snapshot
+func cloneUsage(m map[string]*KeyUsage) map[string]*KeyUsage {
+	copied := make(map[string]*KeyUsage, len(m))
+	for name, u := range m {
+		entry := *u
+		copied[name] = &entry
+	}
+	return copied
+}
+
+// doUpstreamWithFallback sends the request to its model and, if that model
+// fails or is unavailable, to each of Config.FallbackModels in turn. It
+// returns the name of the fallback model that answered, or "" when the
+// original model did. Nothing has been written to the client at this point,
+// so streaming requests can fall back too. config.APIKey is updated if the
+// key is rotated along the way.
+func (a *App) doUpstreamWithFallback(ctx context.Context, config *Config, pr proxyRequest) (*http.Response, string, error) {
+	primary, _ := pr.payload["model"].(string)
+
+	models := []string{primary}
+	for _, m := range config.FallbackModels {
+		if m != "" && !slices.Contains(models, m) {
+			models = append(models, m)
+		}
+	}
+
+	payload := pr.payload
+	for i, model := range models {
+		if i > 0 {
+			payload = maps.Clone(pr.payload)
+			payload["model"] = model
+		}
+		body, _ := json.Marshal(payload)
+
+		resp, err := a.doUpstream(ctx, pr.path, body, config)
+		if err != nil || i == len(models)-1 || !shouldFallback(resp) {
+			if err == nil && i > 0 {
+				return resp, model, nil
+			}
+			return resp, "", err
+		}
+		resp.Body.Close()
+
+		logWarnf("[NIMB] %s returned %d, falling back to %s", model, resp.StatusCode, models[i+1])
+		a.mu.Lock()
+		a.stats.FallbackCount++
+		a.mu.Unlock()
+		a.statsHub.publish()
+	}
+	return nil, "", errors.New("no models to try")
+}
+
+// shouldFallback reports whether an upstream response means the model
+// itself is failing or unavailable. For 4xx responses the body is inspected
+// and then restored so it can still be relayed.
+func shouldFallback(resp *http.Response) bool {
+	switch {
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusNotFound:
+		return true
+	case resp.StatusCode == http.StatusBadRequest, resp.StatusCode == http.StatusUnprocessableEntity:
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+
+		msg := strings.ToLower(string(data))
+		return strings.Contains(msg, "model") &&
+			(strings.Contains(msg, "not found") || strings.Contains(msg, "unavailable") || strings.Contains(msg, "does not exist"))
+	}
+	return false
+}
+
+// maxErrorMessage caps upstream error messages kept in the error log
+const maxErrorMessage = 500
+
+// recordUpstreamError logs a non-2xx upstream response with its error
+// message. The body is read and restored so it can still be relayed.
+func (a *App) recordUpstreamError(resp *http.Response, model, route string) {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	msg := upstreamErrorMessage(data)
+	if msg == "" {
+		msg = resp.Status
+	}
+	if r := []rune(msg); len(r) > maxErrorMessage {
+		msg = string(r[:maxErrorMessage]) + "…"
+	}
+
+	a.noteUpstreamError()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		a.notify(EventKeyInvalid, fmt.Sprintf("Upstream rejected the API key with %d: %s", resp.StatusCode, msg))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stats.UpstreamErrors[strconv.Itoa(resp.StatusCode)]++
+	a.logErrorLocked(ErrorItem{
+		Message:   "Upstream: " + msg,
+		Code:      resp.StatusCode,
+		Model:     model,
+		Route:     route,
+		RequestID: upstreamRequestID(resp.Header),
+		// The upstream request carries the client request's context
+		ClientRequestID: requestIDFrom(resp.Request.Context()),
+	})
+}
+
+// upstreamErrorMessage extracts the message from an upstream error body,
+// which may be OpenAI-style, NIM/FastAPI-style or plain text
+func upstreamErrorMessage(data []byte) string {
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return strings.TrimSpace(string(data))
+	}
+
+	if e, ok := body["error"].(map[string]interface{}); ok {
+		if msg, ok := e["message"].(string); ok {
+			return msg
+		}
+	}
+	for _, field := range []string{"error", "detail", "message", "title"} {
+		if msg, ok := body[field].(string); ok {
+			return msg
+		}
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// recordUsage adds an OpenAI-style usage object to the token stats:
+// overall, for the key that made the request and for the model that served it
+func (a *App) recordUsage(id, model string, usage map[string]interface{}) {
+	a.mu.Lock()
+	a.addUsageLocked(id, model, usage)
+	a.mu.Unlock()
+	a.statsHub.publish()
+}
+
+// recordEstimatedUsage records estimated tokens for a response that came
+// back without usage. They count towards the budget but are kept apart from
+// the exact token stats.
+func (a *App) recordEstimatedUsage(id, model string, prompt, completion int) {
+	a.mu.Lock()
+	a.stats.EstimatedPromptTokens += prompt
+	a.stats.EstimatedCompletionTokens += completion
+	a.keyUsage(id).EstimatedTokens += prompt + completion
+	a.modelUsage(model).EstimatedTokens += prompt + completion
+	a.rollBudgetLocked()
+	a.budget.Used += prompt + completion
+	a.recordSeriesLocked(func(b *usageBucket) {
+		b.PromptTokens += prompt
+		b.CompletionTokens += completion
+	})
+	a.mu.Unlock()
+	a.statsHub.publish()
+}
+
+// addUsageLocked does the work of recordUsage. Callers must hold a.mu.
+func (a *App) addUsageLocked(id, model string, usage map[string]interface{}) {
+	ku, mu := a.keyUsage(id), a.modelUsage(model)
+	pt, _ := usage["prompt_tokens"].(float64)
+	ct, _ := usage["completion_tokens"].(float64)
+	a.stats.PromptTokens += int(pt)
+	ku.PromptTokens += int(pt)
+	mu.PromptTokens += int(pt)
+	a.stats.CompletionTokens += int(ct)
+	ku.CompletionTokens += int(ct)
+	mu.CompletionTokens += int(ct)
+	a.recordSeriesLocked(func(b *usageBucket) {
+		b.PromptTokens += int(pt)
+		b.CompletionTokens += int(ct)
+	})
+	if tt, ok := usage["total_tokens"].(float64); ok {
+		a.stats.TotalTokens += int(tt)
+		ku.TotalTokens += int(tt)
+		mu.TotalTokens += int(tt)
+
+		a.rollBudgetLocked()
+		a.budget.Used += int(tt)
+	}
+}
+
+// recordReasoningTokens counts reasoning tokens that were stripped from a response
+func (a *App) recordReasoningTokens(n int) {
+	a.mu.Lock()
+	a.stats.ReasoningTokens += n
+	a.mu.Unlock()
+	a.statsHub.publish()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// jsonUpstream answers every request with a fixed non-streamed completion
+func jsonUpstream(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`)
+}
+
+// chatBody is a non-streamed chat request whose message is n bytes long
+func chatBody(n int) string {
+	return `{"model":"m","stream":false,"messages":[{"role":"user","content":"` + strings.Repeat("a", n) + `"}]}`
+}
+
+// TestRequestBodyLimit checks oversized bodies get a 413 in the OpenAI
+// error format and an error log entry
+func TestRequestBodyLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int64
+		size  int
+		code  int
+	}{
+		{"under the limit", 1024, 100, 200},
+		{"over the limit", 1024, 2000, 413},
+		{"default limit", 0, 100 << 10, 200},
+		{"over the default limit", 0, 3 << 20, 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, jsonUpstream)
+			a.config.MaxRequestBytes = tt.limit
+			w := serve(a.handleChatCompletions, "POST", "/v1/chat/completions", chatBody(tt.size))
+			if w.Code != tt.code {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+			if tt.code != 413 {
+				return
+			}
+			if !strings.Contains(w.Body.String(), `"type":"invalid_request_error"`) {
+				t.Errorf("body %s is not an OpenAI-style error", w.Body)
+			}
+			a.mu.RLock()
+			defer a.mu.RUnlock()
+			if len(a.stats.ErrorLog) != 1 || a.stats.ErrorLog[0].Code != 413 {
+				t.Errorf("error log = %+v, want one 413 entry", a.stats.ErrorLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// newProxyKey returns a random key for clients of the /v1 endpoints
+func newProxyKey() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "nimb-" + hex.EncodeToString(b)
+}
+
+// issueProxyKey gives a config a new proxy key and shows it once in the
+// log, so the first client can be set up from the terminal. Every other
+// log line masks it.
+func issueProxyKey(cfg *Config) {
+	cfg.ProxyAPIKey = newProxyKey()
+	logUnscrubbedf("Generated a proxy API key for /v1 clients: %s", cfg.ProxyAPIKey)
+}
+
+// presentedProxyKey returns the key a client sent in X-Api-Key or as a
+// bearer token
+func presentedProxyKey(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	return clientKey(r)
+}
+
+// chargedRoute reports whether a request to path counts against a virtual
+// key's request quota. Listing models and polling jobs don't reach the
+// upstream, so they are free.
+func chargedRoute(path string) bool {
+	return path != "/v1/models" && path != "/api/tags" && !strings.HasPrefix(path, "/v1/jobs/")
+}
+
+// requireProxyKey wraps a /v1 or Ollama handler so it only serves
+// clients that present Config.ProxyAPIKey or a virtual key within its
+// quotas. An empty proxy key leaves the endpoint open, and
+// ProxyKeyLocalBypass lets clients on this device in without one.
+// Rejections are counted, not logged, like filterIPs refusals.
+func (a *App) requireProxyKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.mu.RLock()
+		key, bypass := a.config.ProxyAPIKey, a.config.ProxyKeyLocalBypass
+		a.mu.RUnlock()
+
+		if found, quotaErr, resetAt := a.admitVirtualKey(presentedProxyKey(r), chargedRoute(r.URL.Path)); found {
+			if quotaErr != "" {
+				writeQuotaExceeded(w, quotaErr, resetAt)
+				return
+			}
+			next(w, r)
+			return
+		}
+		if key == "" || (bypass && isLoopback(a.clientIP(r))) {
+			next(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(presentedProxyKey(r)), []byte(key)) != 1 {
+			a.mu.Lock()
+			a.stats.RejectedKeys++
+			a.mu.Unlock()
+			writeAPIError(w, 401, "Invalid or missing proxy API key. Send it as Authorization: Bearer <key> or X-Api-Key.", "authentication_error")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleRotateProxyKey replaces the proxy key and returns the new one. It
+// is only ever shown in this response.
+func (a *App) handleRotateProxyKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if varName, ok := a.env.locked("proxyApiKey"); ok {
+		writeLocked(w, "proxyApiKey", varName)
+		return
+	}
+
+	key := newProxyKey()
+	a.mu.Lock()
+	old := a.config
+	a.config.ProxyAPIKey = key
+	cfg := a.config
+	a.mu.Unlock()
+	a.audit(a.clientIP(r), AuditProxyKey, "", old, cfg)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := a.saveSettings(); err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "key": key})
+}
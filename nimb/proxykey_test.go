@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestRequireProxyKey checks both ways of sending the key, and that
+// rejections are counted rather than filling the error log
+func TestRequireProxyKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		status   int
+		rejected int
+	}{
+		{"bearer", "Authorization", "Bearer nimb-proxy-key", 200, 0},
+		{"x-api-key", "X-Api-Key", "nimb-proxy-key", 200, 0},
+		{"wrong key", "Authorization", "Bearer nimb-other-key", 401, 1},
+		{"no key", "", "", 401, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, nil)
+			a.config.ProxyAPIKey = "nimb-proxy-key"
+			h := a.requireProxyKey(func(w http.ResponseWriter, r *http.Request) {})
+			var headers []string
+			if tt.header != "" {
+				headers = []string{tt.header, tt.value}
+			}
+			if rec := get(h, "/v1/models", headers...); rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if a.stats.RejectedKeys != tt.rejected {
+				t.Errorf("rejectedKeys = %d, want %d", a.stats.RejectedKeys, tt.rejected)
+			}
+			if len(a.stats.ErrorLog) != 0 {
+				t.Errorf("error log = %+v, want it empty", a.stats.ErrorLog)
+			}
+		})
+	}
+}
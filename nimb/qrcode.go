@@ -0,0 +1,440 @@
+package main
+
+import (
+	"errors"
+)
+
+// A small QR code encoder: byte mode, error correction level M, versions
+// 1-10. That holds up to 213 bytes, plenty for a URL.
+
+// qrVersion describes the codeword layout of one version at level M
+type qrVersion struct {
+	ecPerBlock int
+	// blocks of group 1 and 2 and their data codewords; group 2 blocks
+	// hold one more than group 1
+	blocks1, data1 int
+	blocks2        int
+	// align lists the alignment pattern centres
+	align []int
+}
+
+var qrVersions = []qrVersion{
+	1:  {10, 1, 16, 0, nil},
+	2:  {16, 1, 28, 0, []int{6, 18}},
+	3:  {26, 1, 44, 0, []int{6, 22}},
+	4:  {18, 2, 32, 0, []int{6, 26}},
+	5:  {24, 2, 43, 0, []int{6, 30}},
+	6:  {16, 4, 27, 0, []int{6, 34}},
+	7:  {18, 4, 31, 0, []int{6, 22, 38}},
+	8:  {22, 2, 38, 2, []int{6, 24, 42}},
+	9:  {22, 3, 36, 2, []int{6, 26, 46}},
+	10: {26, 4, 43, 1, []int{6, 28, 50}},
+}
+
+func (v qrVersion) dataCodewords() int {
+	return v.blocks1*v.data1 + v.blocks2*(v.data1+1)
+}
+
+// qrCode is an encoded symbol; modules[y][x] is true for dark
+type qrCode struct {
+	size    int
+	modules [][]bool
+}
+
+// encodeQR encodes text, picking the smallest version it fits
+func encodeQR(text string) (*qrCode, error) {
+	data := []byte(text)
+	version := 0
+	for v := 1; v < len(qrVersions); v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*qrVersions[v].dataCodewords() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errors.New("text is too long for a QR code")
+	}
+	info := qrVersions[version]
+
+	// Mode indicator, length, data, then terminator and padding
+	var bits qrBits
+	bits.add(0x4, 4)
+	if version >= 10 {
+		bits.add(len(data), 16)
+	} else {
+		bits.add(len(data), 8)
+	}
+	for _, b := range data {
+		bits.add(int(b), 8)
+	}
+	capacity := 8 * info.dataCodewords()
+	bits.add(0, min(4, capacity-len(bits)))
+	bits.add(0, (8-len(bits)%8)%8)
+	codewords := bits.bytes()
+	for pad := byte(0xEC); len(codewords) < info.dataCodewords(); pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+
+	q := newQRCode(version)
+	q.drawCodewords(qrInterleave(codewords, info))
+	q.applyBestMask()
+	return &q.qrCode, nil
+}
+
+// qrBits is a bit buffer, most significant bit first
+type qrBits []bool
+
+func (b *qrBits) add(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, (value>>i)&1 == 1)
+	}
+}
+
+func (b qrBits) bytes() []byte {
+	out := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			out[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return out
+}
+
+// qrInterleave splits data into blocks, adds error correction to each and
+// interleaves them the way the symbol stores them
+func qrInterleave(data []byte, info qrVersion) []byte {
+	divisor := rsDivisor(info.ecPerBlock)
+	var blocks, ecc [][]byte
+	for i := 0; i < info.blocks1+info.blocks2; i++ {
+		n := info.data1
+		if i >= info.blocks1 {
+			n++
+		}
+		blocks = append(blocks, data[:n])
+		ecc = append(ecc, rsRemainder(data[:n], divisor))
+		data = data[n:]
+	}
+
+	var out []byte
+	for i := 0; i <= info.data1; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < info.ecPerBlock; i++ {
+		for _, block := range ecc {
+			out = append(out, block[i])
+		}
+	}
+	return out
+}
+
+// gfMul multiplies in GF(256) with the QR polynomial 0x11D
+func gfMul(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		hi := z & 0x80
+		z <<= 1
+		if hi != 0 {
+			z ^= 0x1D
+		}
+		if (y>>i)&1 == 1 {
+			z ^= x
+		}
+	}
+	return z
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of a degree,
+// highest coefficient (always 1) left out
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords for data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMul(d, factor)
+		}
+	}
+	return result
+}
+
+// qrBuilder is a symbol under construction: function marks the finder,
+// timing, alignment and format modules that data must skip
+type qrBuilder struct {
+	qrCode
+	version  int
+	function [][]bool
+}
+
+func newQRCode(version int) *qrBuilder {
+	size := 17 + 4*version
+	q := &qrBuilder{qrCode: qrCode{size: size}, version: version}
+	q.modules = make([][]bool, size)
+	q.function = make([][]bool, size)
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	q.finder(3, 3)
+	q.finder(size-4, 3)
+	q.finder(3, size-4)
+	align := qrVersions[version].align
+	for i, x := range align {
+		for j, y := range align {
+			// Skip the three corners taken by finder patterns
+			last := len(align) - 1
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			q.alignment(x, y)
+		}
+	}
+	// Reserve the format areas; drawFormat fills them in
+	q.drawFormat(0)
+	q.drawVersion()
+	return q
+}
+
+// set places a function module
+func (q *qrBuilder) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+// finder draws a finder pattern and its separator around centre x, y
+func (q *qrBuilder) finder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || y < 0 || x >= q.size || y >= q.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			q.set(x, y, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// alignment draws a 5x5 alignment pattern around centre x, y
+func (q *qrBuilder) alignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			q.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// drawFormat writes both copies of the format information for mask at
+// level M, plus the dark module
+func (q *qrBuilder) drawFormat(mask int) {
+	data := mask // level M's indicator is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+// drawVersion writes the version information of versions 7 and up
+func (q *qrBuilder) drawVersion() {
+	if q.version < 7 {
+		return
+	}
+	rem := q.version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := q.version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 == 1
+		a, b := q.size-11+i%3, i/3
+		q.set(a, b, dark)
+		q.set(b, a, dark)
+	}
+}
+
+// drawCodewords fills the data area in the zigzag order of the spec
+func (q *qrBuilder) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.function[y][x] && i < len(data)*8 {
+					q.modules[y][x] = (data[i>>3]>>(7-i&7))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// qrMasks are the eight data masks, true where a module is flipped
+var qrMasks = [8]func(x, y int) bool{
+	func(x, y int) bool { return (x+y)%2 == 0 },
+	func(x, y int) bool { return y%2 == 0 },
+	func(x, y int) bool { return x%3 == 0 },
+	func(x, y int) bool { return (x+y)%3 == 0 },
+	func(x, y int) bool { return (x/3+y/2)%2 == 0 },
+	func(x, y int) bool { return x*y%2+x*y%3 == 0 },
+	func(x, y int) bool { return (x*y%2+x*y%3)%2 == 0 },
+	func(x, y int) bool { return ((x+y)%2+x*y%3)%2 == 0 },
+}
+
+// applyMask flips the data modules of mask; applying it twice undoes it
+func (q *qrBuilder) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if !q.function[y][x] && qrMasks[mask](x, y) {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// applyBestMask tries every mask and keeps the one with the lowest penalty
+func (q *qrBuilder) applyBestMask() {
+	best, bestScore := 0, -1
+	for mask := range qrMasks {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if score := q.penalty(); bestScore < 0 || score < bestScore {
+			best, bestScore = mask, score
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+}
+
+// penalty scores the symbol by the four rules of the spec: long runs,
+// 2x2 blocks, finder-like patterns and an uneven dark ratio
+func (q *qrBuilder) penalty() int {
+	score := 0
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+	finderLike := []bool{true, false, true, true, true, false, true}
+
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < q.size; y++ {
+			run := 1
+			for x := 1; x <= q.size; x++ {
+				if x < q.size && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+			for x := 0; x+7 <= q.size; x++ {
+				match := true
+				for k, dark := range finderLike {
+					if at(x+k, y, vertical) != dark {
+						match = false
+						break
+					}
+				}
+				if match && (q.light(x-4, x, y, vertical, at) || q.light(x+7, x+11, y, vertical, at)) {
+					score += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < q.size && y+1 < q.size {
+				c := q.modules[y][x]
+				if q.modules[y][x+1] == c && q.modules[y+1][x] == c && q.modules[y+1][x+1] == c {
+					score += 3
+				}
+			}
+		}
+	}
+	percent := dark * 100 / (q.size * q.size)
+	score += abs(percent-50) / 5 * 10
+	return score
+}
+
+// light reports whether modules from..to of a line are light, counting
+// the quiet zone outside the symbol as light
+func (q *qrBuilder) light(from, to, y int, vertical bool, at func(x, y int, vertical bool) bool) bool {
+	for x := from; x < to; x++ {
+		if x >= 0 && x < q.size && at(x, y, vertical) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientIP returns the address a request came from. Forwarding headers
+// are only believed from the tunnel that sets them: ngrok's
+// X-Forwarded-For on the tunnel backend, and CF-Connecting-IP on loopback
+// while cloudflared is the running tunnel. Anything else could be sent by
+// the visitor to pass as local.
+func (a *App) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if onTunnelBackend(r) {
+		// ngrok appends the address it saw; ssh doesn't say, and the
+		// unspecified address keeps such clients from passing as local
+		if a.runningTunnelProvider() == TunnelProviderNgrok {
+			forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+			if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+		return "0.0.0.0"
+	}
+	if cf := r.Header.Get("CF-Connecting-IP"); cf != "" && isLoopback(host) && a.runningTunnelProvider() == TunnelProviderCloudflare {
+		if net.ParseIP(cf) != nil {
+			return cf
+		}
+		return "0.0.0.0"
+	}
+	return host
+}
+
+// isLoopback reports whether an address string is a loopback IP
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// bucket is a token bucket for one client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per client IP
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// take removes a token from the client's bucket. It returns whether the
+// request is allowed, the tokens left, and how long until the bucket is full
+// again (or, when denied, until the next token).
+func (rl *rateLimiter) take(ip string, perMinute, burst int) (bool, int, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rate := float64(perMinute) / 60 // tokens per second
+
+	if rl.buckets == nil {
+		rl.buckets = make(map[string]*bucket)
+	}
+	b := rl.buckets[ip]
+	if b == nil {
+		if len(rl.buckets) >= 1000 {
+			rl.evict(now, rate, float64(burst))
+		}
+		b = &bucket{tokens: float64(burst), last: now}
+		rl.buckets[ip] = b
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		return false, 0, wait
+	}
+	b.tokens--
+	full := time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second))
+	return true, int(b.tokens), full
+}
+
+// evict drops buckets that have refilled completely, since they carry no state
+func (rl *rateLimiter) evict(now time.Time, rate, burst float64) {
+	for ip, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(rl.buckets, ip)
+		}
+	}
+}
+
+// rateLimited wraps a proxy handler with per-client rate limiting. Limits
+// come from the live config so changes apply without a restart.
+func (a *App) rateLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.mu.RLock()
+		perMinute := a.config.RateLimitRPM
+		burst := a.config.RateLimitBurst
+		exemptLocal := a.config.RateLimitExemptLocal
+		a.mu.RUnlock()
+
+		ip := a.clientIP(r)
+		if perMinute <= 0 || (exemptLocal && isLoopback(ip)) {
+			next(w, r)
+			return
+		}
+		if burst <= 0 {
+			burst = perMinute
+		}
+
+		allowed, remaining, reset := a.rateLimiter.take(ip, perMinute, burst)
+		resetSecs := strconv.Itoa(int(math.Ceil(reset.Seconds())))
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(perMinute))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", resetSecs)
+
+		if !allowed {
+			w.Header().Set("Retry-After", resetSecs)
+			writeAPIError(w, 429, "Rate limit exceeded, retry in "+resetSecs+"s", "rate_limit_error")
+			return
+		}
+		next(w, r)
+	}
+}
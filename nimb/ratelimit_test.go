@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestTake checks the bucket empties after the burst and refills at the
+// configured rate
+func TestTake(t *testing.T) {
+	tests := []struct {
+		name      string
+		perMinute int
+		burst     int
+		takes     int           // taken before the elapsed time
+		elapsed   time.Duration // since the last take
+		allowed   bool
+		remaining int
+	}{
+		{"fresh bucket", 60, 3, 0, 0, true, 2},
+		{"burst used up", 60, 3, 3, 0, false, 0},
+		{"one token back", 60, 3, 3, time.Second, true, 0},
+		{"partly refilled", 60, 3, 3, 2500 * time.Millisecond, true, 1},
+		{"refill capped at the burst", 60, 3, 3, time.Hour, true, 2},
+		{"slow rate", 6, 2, 2, 5 * time.Second, false, 0},
+		{"slow rate refilled", 6, 2, 2, 10 * time.Second, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rl rateLimiter
+			for i := 0; i < tt.takes; i++ {
+				rl.take("192.0.2.1", tt.perMinute, tt.burst)
+			}
+			if b := rl.buckets["192.0.2.1"]; b != nil {
+				b.last = b.last.Add(-tt.elapsed)
+			}
+			allowed, remaining, _ := rl.take("192.0.2.1", tt.perMinute, tt.burst)
+			if allowed != tt.allowed || remaining != tt.remaining {
+				t.Errorf("take = %v, %d, want %v, %d", allowed, remaining, tt.allowed, tt.remaining)
+			}
+			if allowed, _, _ := rl.take("192.0.2.2", tt.perMinute, tt.burst); !allowed {
+				t.Error("another client shares the bucket")
+			}
+		})
+	}
+}
+
+// TestTakeWait checks the wait reported when denied and when allowed
+func TestTakeWait(t *testing.T) {
+	var rl rateLimiter
+	if _, _, full := rl.take("192.0.2.1", 60, 2); full != time.Second {
+		t.Errorf("after one take, full again in %s, want 1s", full)
+	}
+	rl.take("192.0.2.1", 60, 2)
+	rl.buckets["192.0.2.1"].last = rl.buckets["192.0.2.1"].last.Add(-250 * time.Millisecond)
+	allowed, _, wait := rl.take("192.0.2.1", 60, 2)
+	if allowed || wait < 700*time.Millisecond || wait > 750*time.Millisecond {
+		t.Errorf("take = %v, wait %s, want denied with about 750ms", allowed, wait)
+	}
+}
+
+// TestTakeEvicts checks full buckets are dropped once many clients are
+// tracked, and busy ones kept
+func TestTakeEvicts(t *testing.T) {
+	var rl rateLimiter
+	rl.take("busy", 60, 5)
+	rl.take("busy", 60, 5)
+	for i := 0; len(rl.buckets) < 1000; i++ {
+		rl.buckets["idle"+strconv.Itoa(i)] = &bucket{tokens: 5, last: time.Now()}
+	}
+	rl.take("newcomer", 60, 5)
+	if len(rl.buckets) != 2 || rl.buckets["busy"] == nil || rl.buckets["newcomer"] == nil {
+		t.Errorf("%d buckets left after eviction, want busy and newcomer", len(rl.buckets))
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	a := newTestApp(t, nil)
+	a.config.RateLimitRPM = 60
+	a.config.RateLimitBurst = 1
+	handler := a.rateLimited(func(w http.ResponseWriter, r *http.Request) {})
+
+	send := func(remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	if w := send("192.0.2.1:1000"); w.Code != 200 || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("first request: %d, remaining %q", w.Code, w.Header().Get("X-RateLimit-Remaining"))
+	}
+	w := send("192.0.2.1:1001")
+	if w.Code != 429 || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("second request: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	a.config.RateLimitExemptLocal = true
+	for i := 0; i < 3; i++ {
+		if w := send("127.0.0.1:1000"); w.Code != 200 {
+			t.Errorf("local request %d: status %d", i, w.Code)
+		}
+	}
+}
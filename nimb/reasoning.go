@@ -0,0 +1,121 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// thinkBlockRe matches inline reasoning emitted inside message content
+var thinkBlockRe = regexp.MustCompile(`(?s)<think>.*?</think>\s*`)
+
+// stripReasoningMessage removes reasoning_content and inline <think> blocks
+// from a non-streaming choice message. It returns the number of characters removed.
+func stripReasoningMessage(msg map[string]interface{}) int {
+	removed := 0
+	if rc, ok := msg["reasoning_content"].(string); ok {
+		removed += len(rc)
+	}
+	delete(msg, "reasoning_content")
+
+	if content, ok := msg["content"].(string); ok && strings.Contains(content, "<think>") {
+		stripped := thinkBlockRe.ReplaceAllString(content, "")
+		removed += len(content) - len(stripped)
+		msg["content"] = stripped
+	}
+	return removed
+}
+
+// stripReasoningResponse applies stripReasoningMessage to every choice of a
+// chat completion response
+func stripReasoningResponse(resp map[string]interface{}) int {
+	removed := 0
+	choices, _ := resp["choices"].([]interface{})
+	for _, c := range choices {
+		choice, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if msg, ok := choice["message"].(map[string]interface{}); ok {
+			removed += stripReasoningMessage(msg)
+		}
+	}
+	return removed
+}
+
+// thinkFilter removes <think>…</think> spans from streamed content. Tags may
+// be split across deltas, so a possible partial tag is held back until the
+// next delta arrives.
+type thinkFilter struct {
+	inThink bool
+	pending string
+	removed int
+}
+
+// filter returns the visible part of the next content delta
+func (f *thinkFilter) filter(s string) string {
+	s = f.pending + s
+	f.pending = ""
+
+	var out strings.Builder
+	for len(s) > 0 {
+		tag := "<think>"
+		if f.inThink {
+			tag = "</think>"
+		}
+
+		if i := strings.Index(s, tag); i >= 0 {
+			if f.inThink {
+				f.removed += i
+			} else {
+				out.WriteString(s[:i])
+			}
+			s = s[i+len(tag):]
+			f.inThink = !f.inThink
+			continue
+		}
+
+		keep := partialTagSuffix(s, tag)
+		body := s[:len(s)-keep]
+		if f.inThink {
+			f.removed += len(body)
+		} else {
+			out.WriteString(body)
+		}
+		f.pending = s[len(s)-keep:]
+		break
+	}
+	return out.String()
+}
+
+// flush returns any held-back text once the stream for this choice ends
+func (f *thinkFilter) flush() string {
+	rest := f.pending
+	f.pending = ""
+	if f.inThink {
+		f.removed += len(rest)
+		return ""
+	}
+	return rest
+}
+
+// partialTagSuffix returns the length of the longest suffix of s that is a
+// proper prefix of tag
+func partialTagSuffix(s, tag string) int {
+	for k := min(len(tag)-1, len(s)); k > 0; k-- {
+		if strings.HasSuffix(s, tag[:k]) {
+			return k
+		}
+	}
+	return 0
+}
+
+// reasoningTokens returns the upstream-reported reasoning token count from a
+// usage object, or an estimate from the number of stripped characters
+func reasoningTokens(usage map[string]interface{}, removedChars int) int {
+	if details, ok := usage["completion_tokens_details"].(map[string]interface{}); ok {
+		if rt, ok := details["reasoning_tokens"].(float64); ok && rt > 0 {
+			return int(rt)
+		}
+	}
+	return (removedChars + 3) / 4
+}
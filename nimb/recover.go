@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// recoverPanics keeps a panicking handler from taking the request down
+// with it. The stack is logged, the failure goes in the error log and the
+// client gets a 500 in the OpenAI error format, or an error event if a
+// stream was already under way.
+func (a *App) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		aw, ok := w.(*accessWriter)
+		if !ok {
+			aw = &accessWriter{ResponseWriter: w}
+		}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			id := requestIDFrom(r.Context())
+			logErrorf("panic serving %s %s id=%s: %v\n%s", r.Method, r.URL.Path, id, v, debug.Stack())
+			a.logRequestError(r, fmt.Sprintf("Internal error: %v", v), http.StatusInternalServerError)
+
+			if aw.status == 0 {
+				writeAPIError(aw, http.StatusInternalServerError, "Internal server error", "api_error")
+				return
+			}
+			if !writePanicEvent(aw) {
+				// Headers and part of a plain body are out; dropping the
+				// connection is the only way to tell the client it's short
+				panic(http.ErrAbortHandler)
+			}
+		}()
+		next.ServeHTTP(aw, r)
+	})
+}
+
+// writePanicEvent ends a stream that was cut short by a panic with an
+// error event, as streamRelay.abort does for upstream failures. It
+// reports false for responses that aren't streams.
+func writePanicEvent(w http.ResponseWriter) bool {
+	var event string
+	switch ct := w.Header().Get("Content-Type"); {
+	case strings.HasPrefix(ct, "text/event-stream"):
+		data, _ := json.Marshal(map[string]interface{}{
+			"error": map[string]interface{}{"message": "Internal server error", "type": "api_error", "code": 500},
+		})
+		event = "data: " + string(data) + "\n\ndata: [DONE]\n\n"
+	case strings.HasPrefix(ct, "application/x-ndjson"):
+		data, _ := json.Marshal(map[string]interface{}{"error": "Internal server error"})
+		event = string(data) + "\n"
+	default:
+		return false
+	}
+	w.Write([]byte(event))
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return true
+}
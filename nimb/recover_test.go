@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRecoverPanics checks what the client gets when a handler panics
+// before and after it started answering
+func TestRecoverPanics(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		body    string
+		abort   bool
+		logged  bool
+	}{
+		{"before answering", func(w http.ResponseWriter, r *http.Request) { panic("boom") },
+			500, `{"error":{"code":500,"message":"Internal server error","type":"api_error"}}` + "\n", false, true},
+		{"mid stream", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: {}\n\n")
+			panic("boom")
+		}, 200, "data: {}\n\n" + `data: {"error":{"code":500,"message":"Internal server error","type":"api_error"}}` + "\n\ndata: [DONE]\n\n", false, true},
+		{"mid NDJSON stream", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			io.WriteString(w, "{}\n")
+			panic("boom")
+		}, 200, "{}\n" + `{"error":"Internal server error"}` + "\n", false, true},
+		{"mid plain body", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"partial":`)
+			panic("boom")
+		}, 200, `{"partial":`, true, true},
+		{"abort", func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) },
+			200, "", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, nil)
+			w := httptest.NewRecorder()
+			aborted := func() (aborted bool) {
+				defer func() {
+					if v := recover(); v != nil {
+						if v != http.ErrAbortHandler {
+							t.Fatalf("panic %v got through", v)
+						}
+						aborted = true
+					}
+				}()
+				a.recoverPanics(tt.handler).ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", nil))
+				return false
+			}()
+			if aborted != tt.abort {
+				t.Errorf("aborted = %v, want %v", aborted, tt.abort)
+			}
+			if w.Code != tt.status || w.Body.String() != tt.body {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body, tt.status, tt.body)
+			}
+			if logged := len(a.stats.ErrorLog) > 0; logged != tt.logged {
+				t.Errorf("error logged = %v, want %v", logged, tt.logged)
+			} else if logged && !strings.Contains(a.stats.ErrorLog[0].Message, "boom") {
+				t.Errorf("error log entry %q", a.stats.ErrorLog[0].Message)
+			}
+		})
+	}
+}
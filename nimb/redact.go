@@ -0,0 +1,94 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// minSecretLen keeps short values such as "none" from being masked
+// everywhere they happen to appear
+const minSecretLen = 8
+
+var (
+	// tokenPattern matches API keys by their well-known prefixes
+	tokenPattern = regexp.MustCompile(`\b(?:nvapi|sk|nimb)-[A-Za-z0-9_-]{16,}`)
+	// bearerPattern matches the credential of an Authorization header
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer\s+)([A-Za-z0-9._~+/=-]{8,})`)
+	// queryKeyPattern matches keys passed as URL parameters
+	queryKeyPattern = regexp.MustCompile(`(?i)([?&](?:api_?key|key|token|access_token)=)[^&\s"']+`)
+)
+
+// scrubber masks secrets in text bound for the terminal, the error log and
+// the request log
+type scrubber struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// logScrubber holds the secrets of the current config. It is shared with
+// the logger, see logger.write.
+var logScrubber = &scrubber{}
+
+// useConfig replaces the known secrets with those in cfg. It only takes the
+// scrubber's own lock, so callers may hold a.mu.
+func (s *scrubber) useConfig(cfg Config) {
+	var secrets []string
+	add := func(v string) {
+		if len(v) >= minSecretLen && v != maskedValue && !slices.Contains(secrets, v) {
+			secrets = append(secrets, v)
+		}
+	}
+	for _, key := range cfg.APIKeys {
+		add(key)
+	}
+	add(cfg.ProxyAPIKey)
+	add(cfg.TunnelToken)
+	add(cfg.NgrokAuthtoken)
+	for _, p := range cfg.Providers {
+		add(p.APIKey)
+	}
+	for _, v := range cfg.UpstreamHeaders {
+		add(v)
+	}
+	// Longest first, so a key that contains another is masked whole
+	slices.SortFunc(secrets, func(a, b string) int { return len(b) - len(a) })
+
+	s.mu.Lock()
+	s.secrets = secrets
+	s.mu.Unlock()
+}
+
+// scrub masks configured secrets and anything that looks like a token
+func (s *scrubber) scrub(text string) string {
+	s.mu.RLock()
+	for _, secret := range s.secrets {
+		if strings.Contains(text, secret) {
+			text = strings.ReplaceAll(text, secret, maskKey(secret))
+		}
+	}
+	s.mu.RUnlock()
+
+	text = tokenPattern.ReplaceAllStringFunc(text, maskKey)
+	text = bearerPattern.ReplaceAllStringFunc(text, func(m string) string {
+		parts := bearerPattern.FindStringSubmatch(m)
+		return parts[1] + keyID(parts[2])
+	})
+	return queryKeyPattern.ReplaceAllString(text, "${1}****")
+}
+
+// scrubSecrets masks secrets in text with the current config's secrets
+func scrubSecrets(text string) string {
+	return logScrubber.scrub(text)
+}
+
+// scrubSecretsWith is scrubSecrets for text that may also hold key, the
+// one a request was sent with, which can be a client's own or one being
+// tested and so unknown to the config
+func scrubSecretsWith(text, key string) string {
+	if len(key) >= minSecretLen && key != maskedValue {
+		text = strings.ReplaceAll(text, key, maskKey(key))
+	}
+	return scrubSecrets(text)
+}
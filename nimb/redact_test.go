@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestScrub feeds text with keys in headers, JSON and URLs
+func TestScrub(t *testing.T) {
+	s := &scrubber{}
+	s.useConfig(Config{
+		APIKeys:         []string{"custom-upstream-secret-1234"},
+		ProxyAPIKey:     "proxykey-abcdefgh5678",
+		UpstreamHeaders: map[string]string{"X-Org": "org-secret-9999"},
+		TunnelToken:     "short",
+	})
+	tests := []struct {
+		name, in, want string
+	}{
+		{"configured key", "key custom-upstream-secret-1234 rejected", "key custom-****1234 rejected"},
+		{"proxy key in JSON", `{"key":"proxykey-abcdefgh5678"}`, `{"key":"proxykey-****5678"}`},
+		{"header value", "X-Org: org-secret-9999", "X-Org: org-****9999"},
+		{"nvapi token", "sent nvapi-AbCdEfGhIjKlMnOpQrSt1234", "sent nvapi-****1234"},
+		{"sk token", `"api_key": "sk-0123456789abcdefWXYZ"`, `"api_key": "sk-****WXYZ"`},
+		{"bearer header", "Authorization: Bearer abc.def.ghi_7890", "Authorization: Bearer ****7890"},
+		{"URL parameter", "GET https://host/v1/models?api_key=hunter22&x=1", "GET https://host/v1/models?api_key=****&x=1"},
+		{"token parameter", `url "https://host/?token=abcdef"`, `url "https://host/?token=****"`},
+		{"short secret left alone", "status short", "status short"},
+		{"nothing secret", "upstream answered 502", "upstream answered 502"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.scrub(tt.in); got != tt.want {
+				t.Errorf("scrub(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLogsScrubbed checks the logger and the error log mask the
+// configured keys
+func TestLogsScrubbed(t *testing.T) {
+	const key = "custom-upstream-secret-1234"
+	a := newTestApp(t, nil)
+	a.config.APIKeys = []string{key}
+	logScrubber.useConfig(a.config)
+	t.Cleanup(func() { logScrubber.useConfig(Config{}) })
+
+	logWarnf("Upstream rejected %s", key)
+	logs.mu.Lock()
+	last := logs.entries[len(logs.entries)-1].Msg
+	logs.mu.Unlock()
+	if strings.Contains(last, key) || !strings.Contains(last, "****1234") {
+		t.Errorf("log line %q", last)
+	}
+
+	a.logError(`upstream said: {"error":"bad key `+key+`"}`, 401)
+	if msg := a.stats.ErrorLog[0].Message; strings.Contains(msg, key) {
+		t.Errorf("error log entry %q shows the key", msg)
+	}
+}
+
+func TestScrubSecretsWith(t *testing.T) {
+	tests := []struct {
+		text, key, want string
+	}{
+		{"key client-own-key-5678 rejected", "client-own-key-5678", "key client-****5678 rejected"},
+		{"key short rejected", "short", "key short rejected"},
+		{"sent nvapi-AbCdEfGhIjKlMnOpQrSt1234", "", "sent nvapi-****1234"},
+		{"GET /models?key=hunter22", "", "GET /models?key=****"},
+	}
+	for _, tt := range tests {
+		if got := scrubSecretsWith(tt.text, tt.key); got != tt.want {
+			t.Errorf("scrubSecretsWith(%q, %q) = %q, want %q", tt.text, tt.key, got, tt.want)
+		}
+	}
+}
+
+// TestRequestLogScrubbed checks request log entries go through the same
+// scrubber as everything else
+func TestRequestLogScrubbed(t *testing.T) {
+	const upstreamKey = "nvapi-upstream-key-0000-1234"
+	a := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(401)
+		io.WriteString(w, `{"error":{"message":"key `+upstreamKey+` is not valid"}}`)
+	})
+	a.config.APIKeys = []string{upstreamKey}
+	a.config.ProxyAPIKey = "nimb-proxy-key-abcdefgh5678"
+	a.config.LogRequests = true
+	a.config.LogMessageContent = true
+	a.config.MaxRetries = 0
+	logScrubber.useConfig(a.config)
+	t.Cleanup(func() { logScrubber.useConfig(Config{}) })
+
+	serve(a.handleChatCompletions, "POST", "/v1/chat/completions",
+		`{"model":"m","stream":false,"messages":[{"role":"user","content":"my key is nimb-proxy-key-abcdefgh5678, see https://x.test/?key=hunter22"}]}`)
+
+	data, err := os.ReadFile(a.requestLogPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	for _, secret := range []string{upstreamKey, "nimb-proxy-key-abcdefgh5678", "hunter22"} {
+		if strings.Contains(log, secret) {
+			t.Errorf("request log shows %s: %s", secret, log)
+		}
+	}
+	for _, masked := range []string{"nvapi-****1234", "nimb-****5678", "?key=****"} {
+		if !strings.Contains(log, masked) {
+			t.Errorf("request log lacks %s: %s", masked, log)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// settingsWatchInterval is how often settings.json is checked for edits
+// when Config.WatchSettings is on
+const settingsWatchInterval = 3 * time.Second
+
+// reloadSettings re-reads settings.json after it was edited outside NIMB.
+// The file goes through the same defaults, migration and validation as at
+// startup; a file that no longer parses leaves the current config alone.
+func (a *App) reloadSettings(reason string) {
+	file, err := a.readSettings()
+	if err != nil {
+		logErrorf("Settings reload (%s) failed, keeping the current settings: %v", reason, err)
+		return
+	}
+
+	cfg := file.Config
+	a.mu.Lock()
+	// Keys live in credentials.json, so a file without them keeps the
+	// ones in memory
+	fileKeys := len(cfg.APIKeys) > 0
+	if !fileKeys {
+		cfg.APIKeys = a.config.APIKeys
+	}
+	a.env.apply(&cfg)
+	a.config = cfg
+	a.activeProfile = file.Profile
+	a.settingsModTime = file.ModTime
+	a.mu.Unlock()
+
+	a.configChanged(cfg)
+	if fileKeys && !a.vault.isLocked() {
+		a.saveSettings()
+	}
+	logInfof("Reloaded settings (%s)", reason)
+}
+
+// watchSettings reloads settings.json when its modification time changes
+func (a *App) watchSettings() {
+	ticker := time.NewTicker(settingsWatchInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		a.mu.RLock()
+		watch, last := a.config.WatchSettings, a.settingsModTime
+		a.mu.RUnlock()
+		if !watch {
+			continue
+		}
+		info, err := os.Stat(a.settingsPath())
+		if err != nil || info.ModTime().Equal(last) {
+			continue
+		}
+		a.reloadSettings("file changed")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// passthroughParams are forwarded to the upstream verbatim when the client sends them
+var passthroughParams = []string{"top_p", "top_k", "frequency_penalty", "presence_penalty", "repetition_penalty", "min_p", "seed", "stop", "n", "context_length", "context_window", "truncate", "reasoning_effort"}
+
+// structuredParams carry NIM extensions and constrained-generation settings.
+// They are kept as raw JSON from the client request so schemas reach the
+// upstream with their key order intact.
+var structuredParams = []string{"nvext", "response_format", "guided_json", "guided_regex", "guided_choice", "logit_bias"}
+
+// toolParams carry function-calling definitions; they can be disabled for
+// models that reject unknown fields
+var toolParams = []string{"tools", "tool_choice", "functions", "function_call", "parallel_tool_calls"}
+
+// reservedParams are set by NIMB itself and can't be overridden through
+// Config.PassthroughPrefix
+var reservedParams = map[string]bool{"model": true, "messages": true, "stream": true, "tools": true}
+
+// thinkingFamily maps a model family to the chat_template_kwargs switch that
+// turns its reasoning mode on
+type thinkingFamily struct {
+	Prefix string // matched against the lowercased upstream model name
+	Kwarg  string
+}
+
+// thinkingFamilies lists models that support a thinking toggle. Add new
+// families here; the first matching prefix wins.
+var thinkingFamilies = []thinkingFamily{
+	{Prefix: "deepseek-ai/deepseek-v3", Kwarg: "thinking"},
+	{Prefix: "qwen/qwen3", Kwarg: "enable_thinking"},
+}
+
+// thinkingKwarg returns the chat_template_kwargs key for a model, or "" when
+// the model has no known thinking toggle
+func thinkingKwarg(model string) string {
+	model = strings.ToLower(model)
+	for _, f := range thinkingFamilies {
+		if strings.HasPrefix(model, f.Prefix) {
+			return f.Kwarg
+		}
+	}
+	return ""
+}
+
+// applyThinking adds the model's thinking switch to chat_template_kwargs when
+// EnableThinking is on. Client-supplied kwargs are kept and an explicit value
+// for the switch wins over the config default.
+func applyThinking(nimReq, reqBody map[string]interface{}, config Config) {
+	kwargs := map[string]interface{}{}
+	if client, ok := reqBody["chat_template_kwargs"].(map[string]interface{}); ok {
+		for k, v := range client {
+			kwargs[k] = v
+		}
+	}
+
+	if config.EnableThinking {
+		model, _ := nimReq["model"].(string)
+		if key := thinkingKwarg(model); key != "" {
+			if _, explicit := kwargs[key]; !explicit {
+				kwargs[key] = true
+			}
+		}
+	}
+
+	if len(kwargs) > 0 {
+		nimReq["chat_template_kwargs"] = kwargs
+	}
+}
+
+// System prompt injection modes
+const (
+	SystemPromptOff      = "off"      // leave messages alone
+	SystemPromptPrepend  = "prepend"  // add SystemPrompt when the client sent no system message
+	SystemPromptOverride = "override" // replace any client system messages with SystemPrompt
+)
+
+// applySystemPrompt injects Config.SystemPrompt into a message list
+func applySystemPrompt(messages []interface{}, config Config) []interface{} {
+	if config.SystemPrompt == "" || (config.SystemPromptMode != SystemPromptPrepend && config.SystemPromptMode != SystemPromptOverride) {
+		return messages
+	}
+
+	system := map[string]interface{}{"role": "system", "content": config.SystemPrompt}
+	out := make([]interface{}, 0, len(messages)+1)
+	out = append(out, system)
+
+	for _, m := range messages {
+		msg, _ := m.(map[string]interface{})
+		if msg["role"] == "system" {
+			if config.SystemPromptMode == SystemPromptPrepend {
+				return messages
+			}
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// Model override modes. ModelAliases are consulted first in every mode;
+// the mode decides what happens to models without an alias.
+const (
+	ModelModeForce  = "force"  // use CurrentModel
+	ModelModeClient = "client" // use the client's model, falling back to CurrentModel
+	ModelModeMap    = "map"    // same as force; names alias-driven setups explicitly
+)
+
+// resolveModel picks the upstream model for a request according to the
+// alias table and the configured override mode
+func resolveModel(requested string, config Config) string {
+	if target, ok := config.ModelAliases[requested]; ok && target != "" {
+		return target
+	}
+	if config.ModelOverrideMode == ModelModeClient && requested != "" {
+		return requested
+	}
+	return config.CurrentModel
+}
+
+// validateAliases checks that every alias and its target look like model
+// names and that no alias points at itself
+func validateAliases(aliases map[string]string) error {
+	for alias, target := range aliases {
+		switch {
+		case alias == "" || strings.ContainsAny(alias, " \t\r\n"):
+			return fmt.Errorf("alias %q is not a valid model name", alias)
+		case target == "" || strings.ContainsAny(target, " \t\r\n"):
+			return fmt.Errorf("alias %q needs a model name as its target, not %q", alias, target)
+		case alias == target:
+			return fmt.Errorf("alias %q points at itself", alias)
+		}
+	}
+	return nil
+}
+
+// buildUpstreamRequest merges a client chat request with the config defaults
+// into the payload sent upstream. Client values win; a field the client sent
+// explicitly (even 0) is kept, while max_tokens is omitted entirely unless
+// the client or config supplies a positive value.
+func buildUpstreamRequest(reqBody map[string]interface{}, config Config) map[string]interface{} {
+	requested, _ := reqBody["model"].(string)
+
+	nimReq := map[string]interface{}{
+		"model":    resolveModel(requested, config),
+		"messages": reqBody["messages"],
+	}
+	if messages, ok := reqBody["messages"].([]interface{}); ok {
+		nimReq["messages"] = applySystemPrompt(messages, config)
+	}
+
+	if temp, ok := reqBody["temperature"].(float64); ok {
+		nimReq["temperature"] = temp
+	} else {
+		nimReq["temperature"] = config.Temperature
+	}
+
+	if maxTok, ok := reqBody["max_tokens"].(float64); ok && maxTok > 0 {
+		nimReq["max_tokens"] = int(maxTok)
+	} else if config.MaxTokens > 0 {
+		nimReq["max_tokens"] = config.MaxTokens
+	}
+
+	if stream, ok := reqBody["stream"].(bool); ok {
+		nimReq["stream"] = stream
+	} else {
+		nimReq["stream"] = config.StreamingEnabled
+	}
+
+	// Ask for a final usage chunk so streamed requests still update token stats
+	if nimReq["stream"] == true {
+		if opts, ok := reqBody["stream_options"]; ok {
+			nimReq["stream_options"] = opts
+		} else {
+			nimReq["stream_options"] = map[string]interface{}{"include_usage": true}
+		}
+	}
+
+	for _, p := range passthroughParams {
+		if v, ok := reqBody[p]; ok {
+			nimReq[p] = v
+		}
+	}
+	for _, p := range structuredParams {
+		if v, ok := reqBody[p]; ok {
+			nimReq[p] = v
+		}
+	}
+
+	// Escape hatch: "x_foo" is forwarded as "foo" for parameters we don't know
+	if prefix := config.PassthroughPrefix; prefix != "" {
+		for k, v := range reqBody {
+			if name, ok := strings.CutPrefix(k, prefix); ok && name != "" && !reservedParams[name] {
+				nimReq[name] = v
+			}
+		}
+	}
+
+	// After the passthrough, so "x_tool_choice" can't get around it either
+	for _, p := range toolParams {
+		if config.DisableToolForwarding {
+			delete(nimReq, p)
+		} else if v, ok := reqBody[p]; ok {
+			nimReq[p] = v
+		}
+	}
+
+	applyThinking(nimReq, reqBody, config)
+
+	return nimReq
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// decodeBody parses a JSON request body for the tests
+func decodeBody(t *testing.T, body string) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		t.Fatalf("bad test body %s: %v", body, err)
+	}
+	return m
+}
+
+// TestBuildUpstreamRequestDefaults checks how client values and config
+// defaults for temperature and max_tokens are merged
+func TestBuildUpstreamRequestDefaults(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		temperature   float64
+		maxTokens     int
+		wantTemp      float64
+		wantMaxTokens interface{} // nil when max_tokens must be left out
+	}{
+		{"client values", `{"temperature":0.3,"max_tokens":200}`, 0.9, 1000, 0.3, 200},
+		{"config defaults", `{}`, 0.9, 1000, 0.9, 1000},
+		{"zero config", `{}`, 0, 0, 0, nil},
+		{"explicit zero from client", `{"temperature":0,"max_tokens":0}`, 0.9, 1000, 0, 1000},
+		{"explicit zero with zero config", `{"temperature":0,"max_tokens":0}`, 0.9, 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.Temperature = tt.temperature
+			config.MaxTokens = tt.maxTokens
+			got := buildUpstreamRequest(decodeBody(t, tt.body), config)
+
+			if got["temperature"] != tt.wantTemp {
+				t.Errorf("temperature = %v, want %v", got["temperature"], tt.wantTemp)
+			}
+			maxTokens, ok := got["max_tokens"]
+			switch {
+			case tt.wantMaxTokens == nil && ok:
+				t.Errorf("max_tokens = %v, want it left out", maxTokens)
+			case tt.wantMaxTokens != nil && maxTokens != tt.wantMaxTokens:
+				t.Errorf("max_tokens = %v, want %v", maxTokens, tt.wantMaxTokens)
+			}
+		})
+	}
+}
+
+// TestToolForwarding round-trips a tools array through a fake upstream,
+// streamed and not, and checks DisableToolForwarding leaves tools out
+func TestToolForwarding(t *testing.T) {
+	const tools = `[{"type":"function","function":{"name":"get_weather","parameters":{"type":"object","properties":{"city":{"type":"string"}}}}}]`
+	const toolCall = `{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\\"city\\":\\"Oslo\\"}"}}`
+	tests := []struct {
+		name    string
+		stream  bool
+		disable bool
+	}{
+		{"non-streaming", false, false},
+		{"streaming", true, false},
+		{"disabled", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent map[string]interface{}
+			a := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				json.Unmarshal(body, &sent)
+				if tt.stream {
+					w.Header().Set("Content-Type", "text/event-stream")
+					io.WriteString(w, `data: {"choices":[{"index":0,"delta":{"tool_calls":[`+toolCall+`]}}]}`+"\n\n")
+					io.WriteString(w, `data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`+"\n\ndata: [DONE]\n\n")
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, `{"choices":[{"index":0,"message":{"role":"assistant","tool_calls":[`+toolCall+`]},"finish_reason":"tool_calls"}]}`)
+			})
+			a.config.DisableToolForwarding = tt.disable
+
+			body := `{"model":"m","stream":` + strconv.FormatBool(tt.stream) +
+				`,"messages":[{"role":"user","content":"Weather in Oslo?"}],"tools":` + tools + `,"tool_choice":"auto"}`
+			w := serve(a.handleChatCompletions, "POST", "/v1/chat/completions", body)
+			if w.Code != 200 {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+
+			if tt.disable {
+				if _, ok := sent["tools"]; ok {
+					t.Error("tools were forwarded with tool forwarding off")
+				}
+				if _, ok := sent["tool_choice"]; ok {
+					t.Error("tool_choice was forwarded with tool forwarding off")
+				}
+				return
+			}
+			var want interface{}
+			json.Unmarshal([]byte(tools), &want)
+			if !reflect.DeepEqual(sent["tools"], want) {
+				t.Errorf("upstream got tools %v, want %v", sent["tools"], want)
+			}
+			if sent["tool_choice"] != "auto" {
+				t.Errorf("upstream got tool_choice %v, want auto", sent["tool_choice"])
+			}
+			if !strings.Contains(w.Body.String(), toolCall) {
+				t.Errorf("client did not get the tool call unchanged: %s", w.Body)
+			}
+		})
+	}
+}
+
+// TestThinkingKwargs checks the exact chat_template_kwargs sent upstream
+// for each model family and setting
+func TestThinkingKwargs(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    string
+		enabled  bool
+		body     string
+		expected string
+	}{
+		{"deepseek on", "deepseek-ai/deepseek-v3.2", true, `{}`, `{"thinking":true}`},
+		{"deepseek off", "deepseek-ai/deepseek-v3.2", false, `{}`, `null`},
+		{"qwen on", "qwen/qwen3-235b-a22b", true, `{}`, `{"enable_thinking":true}`},
+		{"family matched case-insensitively", "Qwen/Qwen3-32B", true, `{}`, `{"enable_thinking":true}`},
+		{"unknown model on", "meta/llama-3.1-8b-instruct", true, `{}`, `null`},
+		{"client false wins", "deepseek-ai/deepseek-v3.2", true, `{"chat_template_kwargs":{"thinking":false}}`, `{"thinking":false}`},
+		{"client true with config off", "deepseek-ai/deepseek-v3.2", false, `{"chat_template_kwargs":{"thinking":true}}`, `{"thinking":true}`},
+		{"other client kwargs kept", "qwen/qwen3-235b-a22b", true, `{"chat_template_kwargs":{"foo":1}}`, `{"enable_thinking":true,"foo":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.ModelOverrideMode = ModelModeClient
+			config.EnableThinking = tt.enabled
+			body := decodeBody(t, tt.body)
+			body["model"] = tt.model
+
+			got, _ := json.Marshal(buildUpstreamRequest(body, config)["chat_template_kwargs"])
+			if string(got) != tt.expected {
+				t.Errorf("chat_template_kwargs = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestApplySystemPrompt checks the roles and contents of the messages sent
+// upstream in each system prompt mode
+func TestApplySystemPrompt(t *testing.T) {
+	const (
+		user   = `{"role":"user","content":"Hi"}`
+		system = `{"role":"system","content":"Client prompt"}`
+	)
+	tests := []struct {
+		name     string
+		mode     string
+		prompt   string
+		messages string
+		want     string // role:content pairs
+	}{
+		{"off", SystemPromptOff, "Be brief", `[` + user + `]`, "user:Hi"},
+		{"empty prompt", SystemPromptPrepend, "", `[` + user + `]`, "user:Hi"},
+		{"prepend", SystemPromptPrepend, "Be brief", `[` + user + `]`, "system:Be brief user:Hi"},
+		{"prepend keeps the client's", SystemPromptPrepend, "Be brief", `[` + system + `,` + user + `]`, "system:Client prompt user:Hi"},
+		{"override", SystemPromptOverride, "Be brief", `[` + user + `]`, "system:Be brief user:Hi"},
+		{"override replaces the client's", SystemPromptOverride, "Be brief", `[` + system + `,` + user + `,` + system + `]`, "system:Be brief user:Hi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.SystemPromptMode = tt.mode
+			config.SystemPrompt = tt.prompt
+			var messages []interface{}
+			json.Unmarshal([]byte(tt.messages), &messages)
+
+			var got []string
+			for _, m := range applySystemPrompt(messages, config) {
+				msg := m.(map[string]interface{})
+				got = append(got, msg["role"].(string)+":"+msg["content"].(string))
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("messages = %q, want %q", strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
+
+// TestStructuredParams checks NIM extensions and guided decoding schemas
+// reach the upstream byte for byte, key order included
+func TestStructuredParams(t *testing.T) {
+	tests := []struct {
+		param, value string
+	}{
+		{"nvext", `{"guided_json":{"type":"object"},"priority":1}`},
+		{"guided_json", `{"type":"object","required":["z","a"],"properties":{"z":{"type":"string"},"a":{"type":"number"}}}`},
+		{"guided_regex", `"[0-9]{3}"`},
+		{"guided_choice", `["yes","no"]`},
+		{"response_format", `{"type":"json_schema","json_schema":{"name":"x","schema":{"b":1,"a":2}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			var sent string
+			a := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				sent = string(body)
+				jsonUpstream(w, r)
+			})
+			body := `{"model":"m","stream":false,"messages":[{"role":"user","content":"Hi"}],"` + tt.param + `":` + tt.value + `}`
+			if w := serve(a.handleChatCompletions, "POST", "/v1/chat/completions", body); w.Code != 200 {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			if !strings.Contains(sent, `"`+tt.param+`":`+tt.value) {
+				t.Errorf("upstream body %s does not carry %s as sent", sent, tt.param)
+			}
+		})
+	}
+}
+
+// TestPassthroughPrefix checks prefixed fields are forwarded under their
+// own name, except the ones NIMB sets and disabled tool fields
+func TestPassthroughPrefix(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		disableTool bool
+		field       string
+		want        interface{} // nil when the field must not be sent
+	}{
+		{"unknown param", `{"x_min_tokens":5}`, false, "min_tokens", 5.0},
+		{"bare prefix ignored", `{"x_":5}`, false, "", nil},
+		{"model kept", `{"model":"m","x_model":"other"}`, false, "model", "m"},
+		{"stream kept", `{"stream":false,"x_stream":true}`, false, "stream", false},
+		{"messages kept", `{"x_messages":"Hi"}`, false, "messages", nil},
+		{"tools kept", `{"x_tools":[1]}`, false, "tools", nil},
+		{"tool_choice forwarded", `{"x_tool_choice":"auto"}`, false, "tool_choice", "auto"},
+		{"tool_choice with tools off", `{"x_tool_choice":"auto"}`, true, "tool_choice", nil},
+		{"parallel_tool_calls with tools off", `{"parallel_tool_calls":true,"x_parallel_tool_calls":true}`, true, "parallel_tool_calls", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.ModelOverrideMode = ModelModeClient
+			config.PassthroughPrefix = "x_"
+			config.DisableToolForwarding = tt.disableTool
+			got := buildUpstreamRequest(decodeBody(t, tt.body), config)
+			if tt.field == "" {
+				if _, ok := got[""]; ok {
+					t.Error("empty field name forwarded")
+				}
+				return
+			}
+			if got[tt.field] != tt.want {
+				t.Errorf("%s = %v, want %v", tt.field, got[tt.field], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// countingWriter remembers the status a handler wrote. It passes Flush
+// through so streamed responses still reach the client chunk by chunk.
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (c *countingWriter) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *countingWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (c *countingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// statusClass returns "2xx", "4xx" and so on for a status code
+func statusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// countRequests wraps mux so every response is counted by status class and
+// by the route pattern that served it. Patterns rather than paths keep the
+// route set small: /api/jobs/{id} all count under /api/jobs/. Counters do
+// not publish to stats streams, which would otherwise wake on their own
+// polling.
+func (a *App) countRequests(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// NIMB checking its own tunnel is not traffic
+		if isTunnelProbe(r) {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		cw := &countingWriter{ResponseWriter: w}
+		mux.ServeHTTP(cw, r)
+
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		status := cw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		a.mu.Lock()
+		a.stats.StatusClasses[statusClass(status)]++
+		a.stats.Routes[route]++
+		a.mu.Unlock()
+	})
+}
+
+// handleMetrics serves the counters in the Prometheus text format
+func (a *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	a.mu.RLock()
+	stats := a.statsSnapshot()
+	a.mu.RUnlock()
+
+	var b strings.Builder
+	counter := func(name, help string, value int) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	labelled := func(name, help, label string, values map[string]int) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, key := range slices.Sorted(maps.Keys(values)) {
+			fmt.Fprintf(&b, "%s{%s=%q} %d\n", name, label, key, values[key])
+		}
+	}
+
+	counter("nimb_messages_total", "Chat requests proxied upstream.", stats.MessageCount)
+	counter("nimb_errors_total", "Errors recorded in the error log.", stats.ErrorCount)
+	counter("nimb_blocked_requests_total", "Requests refused by the IP allow and block lists.", stats.BlockedRequests)
+	counter("nimb_rejected_keys_total", "Requests refused for a missing or wrong proxy key.", stats.RejectedKeys)
+	counter("nimb_prompt_tokens_total", "Prompt tokens reported by the upstream.", stats.PromptTokens)
+	counter("nimb_completion_tokens_total", "Completion tokens reported by the upstream.", stats.CompletionTokens)
+	labelled("nimb_http_responses_total", "HTTP responses by status class.", "class", stats.StatusClasses)
+	labelled("nimb_http_route_requests_total", "HTTP requests by route.", "route", stats.Routes)
+	labelled("nimb_upstream_errors_total", "Upstream error responses by status code.", "code", stats.UpstreamErrors)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCountRequests checks responses are counted by status class and by
+// route pattern, and the tunnel's own probes aren't counted
+func TestCountRequests(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) { http.Error(w, "no", 503) })
+	mux.HandleFunc("/api/jobs/", func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) })
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		path  string
+		probe bool
+		class string
+		route string
+	}{
+		{"/ok", false, "2xx", "/ok"},
+		{"/fail", false, "5xx", "/fail"},
+		{"/api/jobs/job_1", false, "4xx", "/api/jobs/"},
+		{"/api/jobs/job_2", false, "4xx", "/api/jobs/"},
+		{"/empty", false, "2xx", "/empty"},
+		{"/ok", true, "", ""},
+	}
+	a := newTestApp(t, nil)
+	handler := a.countRequests(mux)
+	want := map[string]int{}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.probe {
+			r.Header.Set(tunnelProbeHeader, tunnelProbeToken)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		if !tt.probe {
+			want["class "+tt.class]++
+			want["route "+tt.route]++
+		}
+	}
+
+	got := map[string]int{}
+	for class, n := range a.stats.StatusClasses {
+		got["class "+class] = n
+	}
+	for route, n := range a.stats.Routes {
+		got["route "+route] = n
+	}
+	for key, n := range want {
+		if got[key] != n {
+			t.Errorf("%s = %d, want %d", key, got[key], n)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("counters = %v, want %v", got, want)
+	}
+
+	metrics := serve(a.handleMetrics, "GET", "/metrics", "").Body.String()
+	for _, line := range []string{`nimb_http_responses_total{class="4xx"} 2`, `nimb_http_route_requests_total{route="/api/jobs/"} 2`} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("metrics lack %s", line)
+		}
+	}
+}
+
+// TestCountRequestsFlush checks a streaming handler's Flush still reaches
+// the underlying writer through the counting wrapper
+func TestCountRequestsFlush(t *testing.T) {
+	tests := []struct {
+		name  string
+		flush func(w http.ResponseWriter) error
+	}{
+		{"http.Flusher", func(w http.ResponseWriter) error {
+			f, ok := w.(http.Flusher)
+			if !ok {
+				return errors.New("writer is not an http.Flusher")
+			}
+			f.Flush()
+			return nil
+		}},
+		{"http.ResponseController", func(w http.ResponseWriter) error {
+			return http.NewResponseController(w).Flush()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var flushErr error
+			mux := http.NewServeMux()
+			mux.HandleFunc("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				io.WriteString(w, "data: {}\n\n")
+				flushErr = tt.flush(w)
+			})
+			a := newTestApp(t, nil)
+			w := httptest.NewRecorder()
+			a.countRequests(mux).ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", nil))
+			if flushErr != nil {
+				t.Fatal(flushErr)
+			}
+			if !w.Flushed {
+				t.Error("Flush did not reach the recorder")
+			}
+			if a.stats.StatusClasses["2xx"] != 1 {
+				t.Errorf("2xx = %d, want 1", a.stats.StatusClasses["2xx"])
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRequestLogMaxBytes = 5 << 20
+	defaultRequestLogKeep     = 3
+	defaultRequestLogLimit    = 50
+)
+
+// requestLogEntry is one line of ~/.nimb/requests.jsonl
+type requestLogEntry struct {
+	Timestamp        string          `json:"timestamp"`
+	Path             string          `json:"path"`
+	Model            string          `json:"model"`
+	Stream           bool            `json:"stream"`
+	Messages         int             `json:"messages"`
+	PromptChars      int             `json:"promptChars"`
+	Status           int             `json:"status"`
+	DurationMs       int64           `json:"durationMs"`
+	PromptTokens     int             `json:"promptTokens"`
+	CompletionTokens int             `json:"completionTokens"`
+	TotalTokens      int             `json:"totalTokens"`
+	Error            string          `json:"error,omitempty"`
+	Content          json.RawMessage `json:"content,omitempty"`
+}
+
+// requestTrace collects what the request log needs while forward runs
+type requestTrace struct {
+	start time.Time
+	model string
+	usage map[string]interface{}
+}
+
+// statusRecorder remembers the status written to a client and the start of
+// an error body
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	errBody bytes.Buffer
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	if s.status >= 400 && s.errBody.Len() < maxErrorMessage {
+		s.errBody.Write(b[:min(len(b), maxErrorMessage-s.errBody.Len())])
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// requestLogger appends entries to the JSONL request log, rotating it when
+// it grows past the configured size
+type requestLogger struct {
+	mu sync.Mutex
+}
+
+// requestLogPath returns the path of the current request log
+func (a *App) requestLogPath() string {
+	return filepath.Join(a.settingsDir, "requests.jsonl")
+}
+
+// logRequest writes a completed request to the request log
+func (a *App) logRequest(config Config, pr proxyRequest, rec *statusRecorder, trace *requestTrace) {
+	messages, _ := pr.payload["messages"].([]interface{})
+	promptChars := 0
+	for _, m := range messages {
+		if msg, ok := m.(map[string]interface{}); ok {
+			promptChars += len(messageText(msg))
+		}
+	}
+	if prompt, ok := pr.payload["prompt"].(string); ok {
+		promptChars += len(prompt)
+	}
+	stream, _ := pr.payload["stream"].(bool)
+
+	entry := requestLogEntry{
+		Timestamp:   trace.start.Format(time.RFC3339),
+		Path:        pr.path,
+		Model:       trace.model,
+		Stream:      stream,
+		Messages:    len(messages),
+		PromptChars: promptChars,
+		Status:      rec.status,
+		DurationMs:  time.Since(trace.start).Milliseconds(),
+	}
+	if entry.Model == "" {
+		entry.Model, _ = pr.payload["model"].(string)
+	}
+	if pt, ok := trace.usage["prompt_tokens"].(float64); ok {
+		entry.PromptTokens = int(pt)
+	}
+	if ct, ok := trace.usage["completion_tokens"].(float64); ok {
+		entry.CompletionTokens = int(ct)
+	}
+	if tt, ok := trace.usage["total_tokens"].(float64); ok {
+		entry.TotalTokens = int(tt)
+	}
+	if rec.errBody.Len() > 0 {
+		msg := upstreamErrorMessage(rec.errBody.Bytes())
+		if r := []rune(msg); len(r) > maxErrorMessage {
+			msg = string(r[:maxErrorMessage])
+		}
+		entry.Error = scrubSecretsWith(msg, config.APIKey)
+	}
+	if config.LogMessageContent {
+		if content, err := json.Marshal(pr.payload["messages"]); err == nil {
+			entry.Content = json.RawMessage(scrubSecretsWith(string(content), config.APIKey))
+		}
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	maxBytes := config.RequestLogMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultRequestLogMaxBytes
+	}
+	keep := config.RequestLogKeep
+	if keep <= 0 {
+		keep = defaultRequestLogKeep
+	}
+	a.requestLog.append(a.requestLogPath(), append(line, '\n'), maxBytes, keep)
+}
+
+// append writes a line to the log, rotating first if it would grow past
+// maxBytes. Rotated files are named requests.jsonl.1 (newest) to
+// requests.jsonl.N, keeping keep of them.
+func (l *requestLogger) append(path string, line []byte, maxBytes int64, keep int) {
+	line = []byte(scrubSecrets(string(line)))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(line)) > maxBytes {
+		os.Remove(path + "." + strconv.Itoa(keep))
+		for i := keep - 1; i >= 1; i-- {
+			os.Rename(path+"."+strconv.Itoa(i), path+"."+strconv.Itoa(i+1))
+		}
+		os.Rename(path, path+".1")
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(line)
+}
+
+// recent returns up to limit of the newest entries in the current log file,
+// newest first
+func (l *requestLogger) recent(path string, limit int) []json.RawMessage {
+	l.mu.Lock()
+	data, err := os.ReadFile(path)
+	l.mu.Unlock()
+	if err != nil {
+		return []json.RawMessage{}
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	entries := []json.RawMessage{}
+	for i := len(lines) - 1; i >= 0 && len(entries) < limit; i-- {
+		if json.Valid(lines[i]) {
+			entries = append(entries, json.RawMessage(lines[i]))
+		}
+	}
+	return entries
+}
+
+// handleRequestLog returns the newest request log entries; ?limit= caps
+// how many (default 50)
+func (a *App) handleRequestLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultRequestLogLimit
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.requestLog.recent(a.requestLogPath(), limit))
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Rule actions
+const (
+	RuleSet    = "set"
+	RuleDelete = "delete"
+	RuleRename = "rename"
+	RuleCap    = "cap"
+)
+
+// Rule rewrites a field of matching requests before they are forwarded.
+// Empty match fields match everything; set ones must all match. Model and
+// Path accept shell-style wildcards such as "deepseek-ai/*".
+type Rule struct {
+	Name        string `json:"name,omitempty"`
+	Model       string `json:"model,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Header      string `json:"header,omitempty"`
+	HeaderValue string `json:"headerValue,omitempty"`
+
+	Action string      `json:"action"`
+	Field  string      `json:"field"`
+	Value  interface{} `json:"value,omitempty"`
+	To     string      `json:"to,omitempty"`
+	Max    *float64    `json:"max,omitempty"`
+}
+
+// validate checks that a rule can be applied
+func (rule Rule) validate() error {
+	if strings.TrimSpace(rule.Field) == "" {
+		return fmt.Errorf("rule %q: field is required", rule.Name)
+	}
+	for _, pattern := range []string{rule.Model, rule.Path} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("rule %q: invalid pattern %q", rule.Name, pattern)
+		}
+	}
+	switch rule.Action {
+	case RuleSet:
+		if rule.Value == nil {
+			return fmt.Errorf("rule %q: set needs a value", rule.Name)
+		}
+	case RuleDelete:
+	case RuleRename:
+		if strings.TrimSpace(rule.To) == "" {
+			return fmt.Errorf("rule %q: rename needs a target field", rule.Name)
+		}
+	case RuleCap:
+		if rule.Max == nil {
+			return fmt.Errorf("rule %q: cap needs a max", rule.Name)
+		}
+	default:
+		return fmt.Errorf("rule %q: unknown action %q", rule.Name, rule.Action)
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to a request for model, or
+// for the alias the client asked for
+func (rule Rule) matches(model, alias string, r *http.Request) bool {
+	if rule.Model != "" {
+		ok, _ := path.Match(rule.Model, model)
+		if !ok && alias != "" {
+			ok, _ = path.Match(rule.Model, alias)
+		}
+		if !ok {
+			return false
+		}
+	}
+	if rule.Path != "" {
+		if ok, _ := path.Match(rule.Path, r.URL.Path); !ok {
+			return false
+		}
+	}
+	if rule.Header != "" {
+		values := r.Header.Values(rule.Header)
+		if len(values) == 0 {
+			return false
+		}
+		if rule.HeaderValue != "" && !strings.EqualFold(strings.Join(values, ","), rule.HeaderValue) {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldParent walks a dotted field such as "chat_template_kwargs.thinking"
+// and returns the object holding its last part, creating objects on the
+// way when create is set
+func fieldParent(payload map[string]interface{}, field string, create bool) (map[string]interface{}, string) {
+	parts := strings.Split(field, ".")
+	obj := payload
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := obj[part].(map[string]interface{})
+		if !ok {
+			if !create {
+				return nil, ""
+			}
+			next = map[string]interface{}{}
+		} else {
+			next = maps.Clone(next)
+		}
+		obj[part] = next
+		obj = next
+	}
+	return obj, parts[len(parts)-1]
+}
+
+// apply runs the rule's action on payload in place and reports whether it
+// changed anything
+func (rule Rule) apply(payload map[string]interface{}) bool {
+	switch rule.Action {
+	case RuleSet:
+		obj, key := fieldParent(payload, rule.Field, true)
+		obj[key] = rule.Value
+		return true
+	case RuleDelete:
+		obj, key := fieldParent(payload, rule.Field, false)
+		if _, ok := obj[key]; !ok {
+			return false
+		}
+		delete(obj, key)
+		return true
+	case RuleRename:
+		obj, key := fieldParent(payload, rule.Field, false)
+		value, ok := obj[key]
+		if !ok {
+			return false
+		}
+		delete(obj, key)
+		dst, dstKey := fieldParent(payload, rule.To, true)
+		dst[dstKey] = value
+		return true
+	case RuleCap:
+		obj, key := fieldParent(payload, rule.Field, false)
+		if n, ok := obj[key].(float64); ok && n > *rule.Max {
+			obj[key] = *rule.Max
+			return true
+		}
+	}
+	return false
+}
+
+// applyRules runs the configured rules in order on a copy of payload. Each
+// rule sees the result of the ones before it, so a later rule wins when two
+// touch the same field, and a rule that renames the model changes what
+// later model matches see.
+func applyRules(payload map[string]interface{}, rules []Rule, alias string, r *http.Request) (map[string]interface{}, []string) {
+	if len(rules) == 0 {
+		return payload, nil
+	}
+	payload = maps.Clone(payload)
+
+	var applied []string
+	for i, rule := range rules {
+		model, _ := payload["model"].(string)
+		if !rule.matches(model, alias, r) {
+			continue
+		}
+		if rule.apply(payload) {
+			name := rule.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			applied = append(applied, name)
+		}
+	}
+	return payload, applied
+}
+
+// handleRules returns the request rules (GET) or replaces them (POST)
+func (a *App) handleRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		a.mu.RLock()
+		rules := a.config.Rules
+		a.mu.RUnlock()
+		if rules == nil {
+			rules = []Rule{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if varName, ok := a.env.locked("rules"); ok {
+		writeLocked(w, "rules", varName)
+		return
+	}
+
+	var rules []Rule
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	a.mu.Lock()
+	a.config.Rules = rules
+	a.mu.Unlock()
+
+	success := a.saveSettings() == nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": success, "count": len(rules)})
+}
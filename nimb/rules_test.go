@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRuleValidate checks rules that can't be applied are refused
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule string
+		ok   bool
+	}{
+		{`{"action":"set","field":"top_p","value":0.9}`, true},
+		{`{"action":"set","field":"top_p"}`, false},
+		{`{"action":"delete","field":"seed"}`, true},
+		{`{"action":"delete","field":" "}`, false},
+		{`{"action":"rename","field":"max_completion_tokens","to":"max_tokens"}`, true},
+		{`{"action":"rename","field":"max_completion_tokens"}`, false},
+		{`{"action":"cap","field":"max_tokens","max":1024}`, true},
+		{`{"action":"cap","field":"max_tokens"}`, false},
+		{`{"action":"drop","field":"seed"}`, false},
+		{`{"action":"delete","field":"seed","model":"[deepseek"}`, false},
+	}
+	for _, tt := range tests {
+		var rule Rule
+		if err := json.Unmarshal([]byte(tt.rule), &rule); err != nil {
+			t.Fatal(err)
+		}
+		if err := rule.validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want valid %v", tt.rule, err, tt.ok)
+		}
+	}
+}
+
+// TestApplyRules checks matching, each action, rule order and that the
+// client's payload is left alone
+func TestApplyRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		payload string
+		alias   string
+		path    string
+		header  string
+		want    string
+		applied string
+	}{
+		{
+			name:    "set nested field",
+			rules:   `[{"name":"think","action":"set","field":"chat_template_kwargs.thinking","value":false}]`,
+			payload: `{"model":"deepseek-ai/deepseek-v3.2","chat_template_kwargs":{"x":1}}`,
+			want:    `{"chat_template_kwargs":{"thinking":false,"x":1},"model":"deepseek-ai/deepseek-v3.2"}`,
+			applied: "think",
+		},
+		{
+			name:    "model wildcard doesn't match",
+			rules:   `[{"model":"qwen/*","action":"delete","field":"seed"}]`,
+			payload: `{"model":"deepseek-ai/deepseek-v3.2","seed":1}`,
+			want:    `{"model":"deepseek-ai/deepseek-v3.2","seed":1}`,
+		},
+		{
+			name:    "alias matches",
+			rules:   `[{"model":"gpt-*","action":"delete","field":"seed"}]`,
+			payload: `{"model":"deepseek-ai/deepseek-v3.2","seed":1}`,
+			alias:   "gpt-4o",
+			want:    `{"model":"deepseek-ai/deepseek-v3.2"}`,
+			applied: "#1",
+		},
+		{
+			name:    "path and header",
+			rules:   `[{"path":"/v1/completions","action":"delete","field":"seed"},{"header":"X-Client","headerValue":"tavern","action":"cap","field":"max_tokens","max":100}]`,
+			payload: `{"model":"m","seed":1,"max_tokens":500}`,
+			path:    "/v1/chat/completions",
+			header:  "tavern",
+			want:    `{"max_tokens":100,"model":"m","seed":1}`,
+			applied: "#2",
+		},
+		{
+			name:    "rename then a later rule wins",
+			rules:   `[{"action":"rename","field":"max_completion_tokens","to":"max_tokens"},{"action":"cap","field":"max_tokens","max":64}]`,
+			payload: `{"model":"m","max_completion_tokens":500}`,
+			want:    `{"max_tokens":64,"model":"m"}`,
+			applied: "#1 #2",
+		},
+		{
+			name:    "nothing to change",
+			rules:   `[{"action":"delete","field":"seed"},{"action":"cap","field":"max_tokens","max":64}]`,
+			payload: `{"model":"m","max_tokens":10}`,
+			want:    `{"max_tokens":10,"model":"m"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules []Rule
+			if err := json.Unmarshal([]byte(tt.rules), &rules); err != nil {
+				t.Fatal(err)
+			}
+			payload := decodeBody(t, tt.payload)
+			path := tt.path
+			if path == "" {
+				path = "/v1/chat/completions"
+			}
+			r := httptest.NewRequest("POST", path, nil)
+			if tt.header != "" {
+				r.Header.Set("X-Client", tt.header)
+			}
+
+			got, applied := applyRules(payload, rules, tt.alias, r)
+			if data, _ := json.Marshal(got); string(data) != tt.want {
+				t.Errorf("payload = %s, want %s", data, tt.want)
+			}
+			if strings.Join(applied, " ") != tt.applied {
+				t.Errorf("applied = %v, want %q", applied, tt.applied)
+			}
+			if data, _ := json.Marshal(payload); string(data) != string(mustJSON(t, tt.payload)) {
+				t.Errorf("client payload changed to %s", data)
+			}
+		})
+	}
+}
+
+// mustJSON re-encodes a JSON document with sorted keys
+func mustJSON(t *testing.T, doc string) []byte {
+	t.Helper()
+	data, err := json.Marshal(decodeBody(t, doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
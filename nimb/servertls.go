@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	// Apple devices reject server certificates valid for longer than 825 days
+	serverCertValidity = 825 * 24 * time.Hour
+	defaultTLSHostname = "nimb.local"
+)
+
+func (a *App) tlsDir() string {
+	return filepath.Join(a.settingsDir, "tls")
+}
+
+// validateServerTLS checks the TLS settings of a config being saved
+func validateServerTLS(cfg Config) error {
+	if (cfg.TLSCertPath == "") != (cfg.TLSKeyPath == "") {
+		return errors.New("tlsCertPath and tlsKeyPath must be set together")
+	}
+	if cfg.TLSCertPath != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.TLSCertPath, cfg.TLSKeyPath); err != nil {
+			return fmt.Errorf("cannot load TLS certificate: %w", err)
+		}
+	}
+	if cfg.HTTPRedirectPort < 0 || cfg.HTTPRedirectPort > 65535 {
+		return errors.New("httpRedirectPort is out of range 0-65535")
+	}
+	return nil
+}
+
+// serverCert returns the certificate and key files to serve with. Without
+// TLSCertPath a self-signed pair is generated into ~/.nimb/tls on first use.
+func (a *App) serverCert() (certFile, keyFile string, err error) {
+	a.mu.RLock()
+	certFile, keyFile, hostname := a.config.TLSCertPath, a.config.TLSKeyPath, a.config.TLSHostname
+	a.mu.RUnlock()
+	if certFile != "" {
+		return certFile, keyFile, nil
+	}
+
+	certFile = filepath.Join(a.tlsDir(), "cert.pem")
+	keyFile = filepath.Join(a.tlsDir(), "key.pem")
+	if _, err := os.Stat(certFile); err == nil {
+		return certFile, keyFile, nil
+	}
+	if hostname == "" {
+		hostname = defaultTLSHostname
+	}
+	if err := os.MkdirAll(a.tlsDir(), 0700); err != nil {
+		return "", "", err
+	}
+	if err := generateServerCert(certFile, keyFile, hostname, a.listen.Host); err != nil {
+		return "", "", fmt.Errorf("cannot generate a TLS certificate: %w", err)
+	}
+	logInfof("Generated a self-signed TLS certificate in %s", a.tlsDir())
+	return certFile, keyFile, nil
+}
+
+// certIPs lists the addresses the certificate should cover: loopback, the
+// listen address and whatever LAN addresses the device reports. Android
+// may refuse to list interfaces, in which case only the others are used.
+func certIPs(listenHost string) []net.IP {
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	if ip := net.ParseIP(listenHost); ip != nil && !ip.IsUnspecified() && !ip.IsLoopback() {
+		ips = append(ips, ip)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		logWarnf("Cannot list network addresses for the TLS certificate: %v", err)
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips
+}
+
+// generateServerCert writes a self-signed certificate and its key. It is
+// its own CA so it can be installed and trusted on other devices.
+func generateServerCert(certFile, keyFile, hostname, listenHost string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	notBefore := time.Now().Add(-time.Hour)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname, Organization: []string{"NIMB Mobile"}},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(serverCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{hostname, "localhost"},
+		IPAddresses:           certIPs(listenHost),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same host on the TLS port
+func redirectToHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		target := "https://" + net.JoinHostPort(host, strconv.Itoa(port)) + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+// handleTLSCert downloads the server certificate so other devices can be
+// told to trust it
+func (a *App) handleTLSCert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !a.listen.TLS {
+		http.Error(w, "TLS is not enabled", http.StatusNotFound)
+		return
+	}
+	certFile, _, err := a.serverCert()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Header().Set("Content-Disposition", `attachment; filename="nimb.crt"`)
+	w.Write(data)
+}
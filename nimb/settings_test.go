@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// TestReadSettings checks fields missing from an older settings file keep
+// their defaults, and the migrations for its version run
+func TestReadSettings(t *testing.T) {
+	defaults := defaultConfig()
+	tests := []struct {
+		name     string
+		file     string
+		version  int
+		check    func(c Config) bool
+		proxyKey bool
+	}{
+		{"unversioned file missing fields", `{"temperature":0.3,"contextSize":8192}`, 0,
+			func(c Config) bool {
+				return c.Temperature == 0.3 && c.ContextSize == 8192 &&
+					c.StreamingEnabled == defaults.StreamingEnabled && c.UpstreamBaseURL == defaults.UpstreamBaseURL
+			}, true},
+		{"zero values kept", `{"settingsVersion":2,"streamingEnabled":false,"maxTokens":0}`, 2,
+			func(c Config) bool { return !c.StreamingEnabled && c.ContextSize == defaults.ContextSize }, false},
+		{"invalid value reset", `{"settingsVersion":2,"contextSize":-5,"temperature":0.2}`, 2,
+			func(c Config) bool { return c.ContextSize == defaults.ContextSize && c.Temperature == 0.2 }, false},
+		{"version 1 gets a proxy key", `{"settingsVersion":1,"proxyApiKey":""}`, 1,
+			func(c Config) bool { return c.ContextSize == defaults.ContextSize }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, nil)
+			if err := os.WriteFile(a.settingsPath(), []byte(tt.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			file, err := a.readSettings()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file.Version != tt.version {
+				t.Errorf("Version = %d, want %d", file.Version, tt.version)
+			}
+			if !tt.check(file.Config) {
+				t.Errorf("config = %+v", file.Config)
+			}
+			if got := file.Config.ProxyAPIKey != ""; got != tt.proxyKey {
+				t.Errorf("proxy key issued = %v, want %v", got, tt.proxyKey)
+			}
+		})
+	}
+}
+
+// TestLoadSettingsRewritesOldFile checks a migrated file is saved with
+// the current version so its migrations run once
+func TestLoadSettingsRewritesOldFile(t *testing.T) {
+	a := newTestApp(t, nil)
+	if err := os.WriteFile(a.settingsPath(), []byte(`{"contextSize":8192}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a.loadSettings()
+	key := a.config.ProxyAPIKey
+	if key == "" {
+		t.Fatal("no proxy key issued for an unversioned file")
+	}
+
+	data, err := os.ReadFile(a.settingsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		Version  int    `json:"settingsVersion"`
+		ProxyKey string `json:"proxyApiKey"`
+		Context  int    `json:"contextSize"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != settingsVersion || saved.ProxyKey != key || saved.Context != 8192 {
+		t.Errorf("saved %+v, want version %d with the issued key", saved, settingsVersion)
+	}
+
+	a.loadSettings()
+	if a.config.ProxyAPIKey != key {
+		t.Error("loading the migrated file issued another proxy key")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// defaultShutdownGrace is how long in-flight requests get to finish once
+// NIMB is told to stop
+const defaultShutdownGrace = 15 * time.Second
+
+// shutdownGrace returns Config.ShutdownGraceSec, or the default
+func (a *App) shutdownGrace() time.Duration {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.config.ShutdownGraceSec > 0 {
+		return time.Duration(a.config.ShutdownGraceSec) * time.Second
+	}
+	return defaultShutdownGrace
+}
+
+// shutdown stops NIMB in order: the servers stop accepting connections and
+// wait up to the grace period for requests in flight, streamed completions
+// included; then the tunnel is stopped and usage is written to disk.
+// Event streams never finish on their own, so they are told to end first.
+func (a *App) shutdown(servers ...*http.Server) {
+	grace := a.shutdownGrace()
+	logInfof("Shutting down, giving requests in flight up to %s to finish...", grace)
+	close(a.draining)
+
+	a.tunnelListener.Lock()
+	if a.tunnelListener.server != nil {
+		servers = append(servers, a.tunnelListener.server)
+	}
+	a.tunnelListener.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	done := make(chan struct{}, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			if err := server.Shutdown(ctx); errors.Is(err, context.DeadlineExceeded) {
+				logWarnf("Grace period over, closing the remaining connections")
+				server.Close()
+			}
+			done <- struct{}{}
+		}(server)
+	}
+	for range servers {
+		<-done
+	}
+
+	a.StopTunnel()
+	if err := a.saveSeries(); err != nil {
+		logErrorf("Could not save usage history: %v", err)
+	}
+	if err := a.saveBudget(); err != nil {
+		logErrorf("Could not save the budget: %v", err)
+	}
+	a.flushVirtualKeys()
+	logInfof("Shut down")
+}
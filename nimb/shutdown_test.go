@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowStream starts a server for a streamed completion whose upstream
+// sends one event, then waits for release before finishing. It returns
+// the server, its address and the client's response once the first event
+// is in.
+func slowStream(t *testing.T, release chan struct{}) (*App, *http.Server, string, *bufio.Reader) {
+	t.Helper()
+	a := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		io.WriteString(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"lo\"},\"finish_reason\":\"stop\"}]}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	})
+	a.config.ModelOverrideMode = ModelModeClient
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(a.handleChatCompletions)}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	resp, err := http.Post("http://"+listener.Addr().String()+"/v1/chat/completions", "application/json",
+		strings.NewReader(`{"model":"m","stream":true,"messages":[{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	body := bufio.NewReader(resp.Body)
+	if line, err := body.ReadString('\n'); err != nil || !strings.Contains(line, "Hel") {
+		t.Fatalf("first line %q, err %v", line, err)
+	}
+	return a, server, listener.Addr().String(), body
+}
+
+// TestShutdownDrainsStream starts a slow streamed completion, shuts down
+// and checks the stream still completes before shutdown returns
+func TestShutdownDrainsStream(t *testing.T) {
+	release := make(chan struct{})
+	a, server, addr, body := slowStream(t, release)
+
+	done := make(chan struct{})
+	go func() {
+		a.shutdown(server)
+		close(done)
+	}()
+
+	// New connections are refused while the stream drains
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("still accepting connections while shutting down")
+		}
+	}
+	select {
+	case <-done:
+		t.Fatal("shutdown returned with a stream in flight")
+	default:
+	}
+
+	close(release)
+	rest, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("stream cut off: %v", err)
+	}
+	if !strings.Contains(string(rest), `"lo"`) || !strings.HasSuffix(string(rest), "data: [DONE]\n\n") {
+		t.Errorf("rest of the stream = %q", rest)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return after the stream finished")
+	}
+	if _, err := os.Stat(a.seriesPath()); err != nil {
+		t.Errorf("usage history not saved: %v", err)
+	}
+}
+
+// TestShutdownGrace checks a stream still going when the grace period
+// ends is closed rather than waited for
+func TestShutdownGrace(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	a, server, _, body := slowStream(t, release)
+	a.config.ShutdownGraceSec = 1
+
+	start := time.Now()
+	a.shutdown(server)
+	if took := time.Since(start); took > 3*time.Second {
+		t.Errorf("shutdown took %s with a 1s grace period", took)
+	}
+	if _, err := io.ReadAll(body); err == nil {
+		t.Error("the unfinished stream ended cleanly")
+	}
+}
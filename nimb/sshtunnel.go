@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sshTunnel forwards a port on a server of your own back to NIMB (or the
+// tunnel target) with ssh -R. The server needs GatewayPorts enabled for the port to be public.
+type sshTunnel struct {
+	tunnelProcess
+	user, host, port string
+	remotePort       int
+	keyPath          string
+	// upstream is the URL traffic is forwarded to
+	upstream func() (string, error)
+}
+
+// parseSSHTarget splits user@host or user@host:port
+func parseSSHTarget(target string) (user, host, port string, err error) {
+	user, hostPort, ok := strings.Cut(target, "@")
+	if !ok || user == "" || hostPort == "" || strings.ContainsAny(target, " /") {
+		return "", "", "", errors.New("tunnelSshTarget must look like user@host or user@host:port")
+	}
+	host = hostPort
+	if h, p, err := net.SplitHostPort(hostPort); err == nil {
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+			return "", "", "", fmt.Errorf("tunnelSshTarget port %q is not valid", p)
+		}
+		host, port = h, p
+	}
+	return user, host, port, nil
+}
+
+// validateSSHTunnel checks the ssh settings of a config being saved
+func validateSSHTunnel(cfg Config) error {
+	if cfg.TunnelProvider != TunnelProviderSSH {
+		return nil
+	}
+	if _, _, _, err := parseSSHTarget(cfg.TunnelSSHTarget); err != nil {
+		return err
+	}
+	if cfg.TunnelSSHRemotePort < 1 || cfg.TunnelSSHRemotePort > 65535 {
+		return errors.New("tunnelSshRemotePort must be between 1 and 65535")
+	}
+	if cfg.TunnelSSHKeyPath != "" {
+		if _, err := os.Stat(cfg.TunnelSSHKeyPath); err != nil {
+			return fmt.Errorf("cannot read tunnelSshKeyPath: %w", err)
+		}
+	}
+	return nil
+}
+
+func newSSHTunnel(cfg Config, upstream func() (string, error)) (*sshTunnel, error) {
+	if err := validateSSHTunnel(cfg); err != nil {
+		return nil, err
+	}
+	user, host, port, _ := parseSSHTarget(cfg.TunnelSSHTarget)
+	return &sshTunnel{
+		user: user, host: host, port: port,
+		remotePort: cfg.TunnelSSHRemotePort,
+		keyPath:    cfg.TunnelSSHKeyPath,
+		upstream:   upstream,
+	}, nil
+}
+
+func (t *sshTunnel) Start(events tunnelEvents) error {
+	path, err := findTunnelBinary("", "ssh", "Install with: pkg install openssh")
+	if err != nil {
+		return err
+	}
+	upstream, err := t.upstream()
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return err
+	}
+	args := []string{
+		"-N",
+		// Fail instead of running without the forward, e.g. when the
+		// remote port is taken
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "ServerAliveInterval=30",
+		"-o", "ServerAliveCountMax=3",
+		// Nobody is there to type a password
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-R", "0.0.0.0:" + strconv.Itoa(t.remotePort) + ":" + targetAddr(u),
+	}
+	if t.keyPath != "" {
+		args = append(args, "-i", t.keyPath)
+	}
+	if t.port != "" {
+		args = append(args, "-p", t.port)
+	}
+	args = append(args, t.user+"@"+t.host)
+	if err := t.run("ssh", path, args, nil, events, nil); err != nil {
+		return err
+	}
+	// ssh prints nothing once the forward is up; the probe tells whether
+	// the address answers
+	// The forward carries whatever the upstream speaks, TLS included
+	t.setURL(u.Scheme + "://" + net.JoinHostPort(t.host, strconv.Itoa(t.remotePort)))
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// staticFile is an embedded frontend file, read once at startup along
+// with its gzipped copy
+type staticFile struct {
+	body        []byte
+	gzipped     []byte // nil when gzip doesn't make it smaller
+	contentType string
+	hash        string
+}
+
+// staticHandler serves the frontend. Paths that aren't files get
+// index.html so deep links into the UI load; only paths under /assets/ or
+// with an extension, and API paths, get a real 404.
+//
+// The embedded frontend is loaded once. A development directory (see
+// frontendSource) is re-read on every request and never cached, so edits
+// show up on reload.
+type staticHandler struct {
+	files   map[string]*staticFile
+	modTime time.Time
+	dev     fs.FS
+}
+
+// newStaticHandler serves fsys, re-reading it for every request when dev
+// is set
+func newStaticHandler(fsys fs.FS, dev bool) (*staticHandler, error) {
+	if dev {
+		return &staticHandler{dev: fsys}, nil
+	}
+	files, err := loadStaticFiles(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &staticHandler{files: files, modTime: staticModTime()}, nil
+}
+
+// frontendSource picks where the UI is served from: dir when it is set
+// and exists, the embedded copy otherwise
+func frontendSource(dir string) (fsys fs.FS, dev bool) {
+	if dir != "" {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			logWarnf("==== DEV FRONTEND: serving the UI from %s with caching off ====", dir)
+			return os.DirFS(dir), true
+		}
+		logWarnf("Frontend directory %s not found, serving the built-in UI", dir)
+	}
+	sub, _ := fs.Sub(assets, "frontend")
+	return sub, false
+}
+
+// loadStaticFiles reads every file in fsys. References to the other files
+// in index.html are rewritten to absolute, versioned URLs so they work
+// from any depth and can be cached for good.
+func loadStaticFiles(fsys fs.FS) (map[string]*staticFile, error) {
+	files := map[string]*staticFile{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		files["/"+name] = &staticFile{body: body}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		f.hash = contentHash(f.body)
+	}
+	if index := files["/index.html"]; index != nil {
+		for name, f := range files {
+			if name == "/index.html" {
+				continue
+			}
+			ref := strings.TrimPrefix(name, "/")
+			versioned := name + "?v=" + f.hash
+			index.body = bytes.ReplaceAll(index.body, []byte(`href="`+ref+`"`), []byte(`href="`+versioned+`"`))
+			index.body = bytes.ReplaceAll(index.body, []byte(`src="`+ref+`"`), []byte(`src="`+versioned+`"`))
+		}
+		index.hash = contentHash(index.body)
+	}
+	for name, f := range files {
+		f.contentType = mime.TypeByExtension(path.Ext(name))
+		if f.contentType == "" {
+			f.contentType = http.DetectContentType(f.body)
+		}
+		if compressible(f.contentType) {
+			f.gzipped = gzipBytes(f.body)
+		}
+	}
+	return files, nil
+}
+
+// staticModTime is used as Last-Modified for every file. Embedded files
+// carry no times of their own, so it is when the binary was built, or
+// failing that when NIMB started.
+func staticModTime() time.Time {
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			return info.ModTime()
+		}
+	}
+	return time.Now()
+}
+
+// contentHash is a short hash of a file's contents, used in its ETag and
+// versioned URL
+func contentHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:8])
+}
+
+// compressible reports whether files of a content type are worth gzipping
+func compressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") ||
+		strings.Contains(contentType, "javascript") ||
+		strings.Contains(contentType, "json") ||
+		strings.Contains(contentType, "svg")
+}
+
+// gzipBytes compresses body, returning nil if that doesn't make it smaller
+func gzipBytes(body []byte) []byte {
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	zw.Write(body)
+	zw.Close()
+	if buf.Len() >= len(body) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// acceptsGzip reports whether the client takes gzip responses
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		if coding = strings.TrimSpace(coding); coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	files := h.files
+	if h.dev != nil {
+		var err error
+		if files, err = loadStaticFiles(h.dev); err != nil {
+			http.Error(w, "Failed to read frontend: "+err.Error(), 500)
+			return
+		}
+	}
+	name := path.Clean("/" + r.URL.Path)
+	if name == "/" {
+		name = "/index.html"
+	}
+	f := files[name]
+	if f == nil {
+		if strings.HasPrefix(name, "/api/") || strings.HasPrefix(name, "/v1/") ||
+			strings.HasPrefix(name, "/assets/") || path.Ext(name) != "" {
+			http.NotFound(w, r)
+			return
+		}
+		name = "/index.html"
+		f = files[name]
+		if f == nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	switch {
+	case h.dev != nil:
+		w.Header().Set("Cache-Control", "no-store")
+	case name == "/index.html":
+		w.Header().Set("Cache-Control", "no-cache")
+	case r.URL.Query().Get("v") == f.hash:
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	default:
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("Content-Type", f.contentType)
+
+	body, etag := f.body, `"`+f.hash+`"`
+	if f.gzipped != nil {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r) {
+			body, etag = f.gzipped, `"`+f.hash+`-gzip"`
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, name, h.modTime, bytes.NewReader(body))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// testFrontend is a small UI with an index linking its stylesheet and
+// script
+var testFrontend = fstest.MapFS{
+	"index.html":    {Data: []byte(`<html><link href="style.css"><script src="app.js"></script>` + strings.Repeat(" ", 200) + `</html>`)},
+	"style.css":     {Data: []byte("body { color: red; }" + strings.Repeat(" ", 200))},
+	"app.js":        {Data: []byte("console.log('hi');" + strings.Repeat(" ", 200))},
+	"assets/ok.png": {Data: []byte("\x89PNG\r\n\x1a\n")},
+}
+
+// get requests path from h with optional headers given as name, value
+func get(h http.Handler, path string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// TestStaticFallback checks UI routes get index.html and only asset-like
+// and API paths get a real 404
+func TestStaticFallback(t *testing.T) {
+	h, err := newStaticHandler(testFrontend, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		status int
+		index  bool
+	}{
+		{"/", 200, true},
+		{"/settings", 200, true},
+		{"/settings/tunnel", 200, true},
+		{"/index.html", 200, true},
+		{"/style.css", 200, false},
+		{"/assets/ok.png", 200, false},
+		{"/assets/missing", 404, false},
+		{"/missing.js", 404, false},
+		{"/api/nothing", 404, false},
+		{"/v1/nothing", 404, false},
+		{"/../index.html", 200, true},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.path, w.Code, tt.status)
+		}
+		if index := strings.HasPrefix(w.Body.String(), "<html>"); index != tt.index {
+			t.Errorf("%s: got index.html = %v, want %v", tt.path, index, tt.index)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+	if w.Code != 405 {
+		t.Errorf("POST: status = %d, want 405", w.Code)
+	}
+}
+
+// TestStaticCaching checks the cache headers, versioned references and
+// conditional GETs
+func TestStaticCaching(t *testing.T) {
+	h, err := newStaticHandler(testFrontend, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := get(h, "/")
+	css := h.files["/style.css"]
+	versioned := "/style.css?v=" + css.hash
+	if !strings.Contains(index.Body.String(), `href="`+versioned+`"`) {
+		t.Fatalf("index.html does not reference %s: %s", versioned, index.Body)
+	}
+
+	tests := []struct {
+		path  string
+		cache string
+	}{
+		{"/", "no-cache"},
+		{"/settings", "no-cache"},
+		{versioned, "public, max-age=31536000, immutable"},
+		{"/style.css", "no-cache"},
+		{"/style.css?v=stale", "no-cache"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path)
+		if got := w.Header().Get("Cache-Control"); got != tt.cache {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.path, got, tt.cache)
+		}
+		etag := w.Header().Get("ETag")
+		if etag == "" || w.Header().Get("Last-Modified") == "" {
+			t.Errorf("%s: ETag %q, Last-Modified %q", tt.path, etag, w.Header().Get("Last-Modified"))
+		}
+		if again := get(h, tt.path, "If-None-Match", etag); again.Code != 304 || again.Body.Len() != 0 {
+			t.Errorf("%s: If-None-Match: status %d, %d bytes", tt.path, again.Code, again.Body.Len())
+		}
+		modified := w.Header().Get("Last-Modified")
+		if again := get(h, tt.path, "If-Modified-Since", modified); again.Code != 304 {
+			t.Errorf("%s: If-Modified-Since: status %d", tt.path, again.Code)
+		}
+	}
+	if w := get(h, "/style.css", "If-None-Match", `"other"`); w.Code != 200 {
+		t.Errorf("stale ETag: status = %d, want 200", w.Code)
+	}
+}
+
+func TestStaticGzip(t *testing.T) {
+	h, err := newStaticHandler(testFrontend, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		accept string
+		gzip   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.8", true},
+		{"*", true},
+		{"gzip;q=0", false},
+		{"br", false},
+	}
+	for _, tt := range tests {
+		w := get(h, "/app.js", "Accept-Encoding", tt.accept)
+		gzipped := w.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != tt.gzip {
+			t.Errorf("Accept-Encoding %q: gzipped = %v, want %v", tt.accept, gzipped, tt.gzip)
+			continue
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Vary = %q", tt.accept, w.Header().Get("Vary"))
+		}
+		body := w.Body.Bytes()
+		if gzipped {
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ = io.ReadAll(zr)
+		}
+		if !bytes.Equal(body, testFrontend["app.js"].Data) {
+			t.Errorf("Accept-Encoding %q: body differs from app.js", tt.accept)
+		}
+	}
+	if w := get(h, "/assets/ok.png", "Accept-Encoding", "gzip"); w.Header().Get("Content-Encoding") != "" {
+		t.Error("an image was gzipped")
+	}
+}
+
+// TestFrontendSource checks a development directory takes precedence over
+// the embedded UI when it exists, and is read on every request
+func TestFrontendSource(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>v1</html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		dir  string
+		dev  bool
+	}{
+		{"unset", "", false},
+		{"existing directory", dir, true},
+		{"missing directory", filepath.Join(dir, "missing"), false},
+		{"a file", filepath.Join(dir, "index.html"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys, dev := frontendSource(tt.dir)
+			if dev != tt.dev {
+				t.Fatalf("dev = %v, want %v", dev, tt.dev)
+			}
+			h, err := newStaticHandler(fsys, dev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := get(h, "/settings")
+			if fromDir := w.Body.String() == "<html>v1</html>"; w.Code != 200 || fromDir != tt.dev {
+				t.Errorf("status %d, served from the directory = %v", w.Code, fromDir)
+			}
+			want := "no-cache"
+			if dev {
+				want = "no-store"
+			}
+			if got := w.Header().Get("Cache-Control"); got != want {
+				t.Errorf("Cache-Control = %q, want %q", got, want)
+			}
+		})
+	}
+
+	fsys, dev := frontendSource(dir)
+	h, _ := newStaticHandler(fsys, dev)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>v2</html>"), 0644)
+	if body := get(h, "/").Body.String(); body != "<html>v2</html>" {
+		t.Errorf("after an edit got %q", body)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// statsExportVersion is bumped when the export format changes incompatibly
+const statsExportVersion = 1
+
+// maxErrorLog is how many error log entries are kept
+const maxErrorLog = 200
+
+// statsExport is the document served by /api/stats/export and accepted by
+// /api/stats/import
+type statsExport struct {
+	Version    int         `json:"version"`
+	ExportedAt string      `json:"exportedAt"`
+	Stats      Stats       `json:"stats"`
+	Timeseries usageSeries `json:"timeseries"`
+}
+
+// handleStatsExport returns lifetime stats, the per-key and per-model
+// breakdowns, the usage time series and the error log as one download
+func (a *App) handleStatsExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	a.mu.RLock()
+	doc := statsExport{
+		Version:    statsExportVersion,
+		ExportedAt: time.Now().Format(time.RFC3339),
+		Stats:      a.statsSnapshot(),
+		Timeseries: usageSeries{
+			Hourly: append([]usageBucket{}, a.series.Hourly...),
+			Daily:  append([]usageBucket{}, a.series.Daily...),
+		},
+	}
+	a.mu.RUnlock()
+
+	name := "nimb-stats-" + time.Now().Format("20060102") + ".json"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(doc)
+}
+
+// handleStatsExportCSV writes the request log, oldest first, as CSV
+func (a *App) handleStatsExportCSV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	a.mu.RLock()
+	logging, keep := a.config.LogRequests, a.config.RequestLogKeep
+	a.mu.RUnlock()
+	if !logging {
+		writeAPIError(w, 409, "Request logging is off, so there is no per-request history to export", "invalid_request_error")
+		return
+	}
+	if keep <= 0 {
+		keep = defaultRequestLogKeep
+	}
+
+	// Rotated files hold older entries, the highest number the oldest
+	path := a.requestLogPath()
+	files := []string{}
+	for i := keep; i >= 1; i-- {
+		files = append(files, path+"."+strconv.Itoa(i))
+	}
+	files = append(files, path)
+
+	name := "nimb-requests-" + time.Now().Format("20060102") + ".csv"
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"timestamp", "path", "model", "stream", "messages", "status",
+		"durationMs", "promptTokens", "completionTokens", "totalTokens", "error"})
+
+	a.requestLog.mu.Lock()
+	defer a.requestLog.mu.Unlock()
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64<<10), 16<<20)
+		for scanner.Scan() {
+			var e requestLogEntry
+			if json.Unmarshal(scanner.Bytes(), &e) != nil {
+				continue
+			}
+			out.Write([]string{e.Timestamp, e.Path, e.Model, strconv.FormatBool(e.Stream),
+				strconv.Itoa(e.Messages), strconv.Itoa(e.Status), strconv.FormatInt(e.DurationMs, 10),
+				strconv.Itoa(e.PromptTokens), strconv.Itoa(e.CompletionTokens), strconv.Itoa(e.TotalTokens), e.Error})
+		}
+		f.Close()
+	}
+	out.Flush()
+}
+
+// validate checks that an import document is one this version can merge
+func (doc *statsExport) validate() error {
+	if doc.Version != statsExportVersion {
+		return fmt.Errorf("unsupported export version %d", doc.Version)
+	}
+	s := doc.Stats
+	for name, n := range map[string]int{
+		"messageCount": s.MessageCount, "promptTokens": s.PromptTokens,
+		"completionTokens": s.CompletionTokens, "totalTokens": s.TotalTokens,
+		"errorCount": s.ErrorCount, "retryCount": s.RetryCount,
+	} {
+		if n < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if s.StartTime != "" {
+		if _, err := time.Parse(time.RFC3339, s.StartTime); err != nil {
+			return errors.New("startTime is not an RFC 3339 time")
+		}
+	}
+	for _, b := range append(doc.Timeseries.Hourly, doc.Timeseries.Daily...) {
+		if b.Start.IsZero() {
+			return errors.New("time series bucket without a timestamp")
+		}
+	}
+	return nil
+}
+
+// mergeStats adds the counters of src into dst. Times take the earliest
+// start and latest request, and error logs are interleaved by time.
+func mergeStats(dst *Stats, src Stats) {
+	dst.MessageCount += src.MessageCount
+	dst.PromptTokens += src.PromptTokens
+	dst.CompletionTokens += src.CompletionTokens
+	dst.TotalTokens += src.TotalTokens
+	dst.ReasoningTokens += src.ReasoningTokens
+	dst.EstimatedPromptTokens += src.EstimatedPromptTokens
+	dst.EstimatedCompletionTokens += src.EstimatedCompletionTokens
+	dst.ErrorCount += src.ErrorCount
+	dst.RetryCount += src.RetryCount
+	dst.FallbackCount += src.FallbackCount
+	dst.TrimCount += src.TrimCount
+	dst.ImageMessages += src.ImageMessages
+	dst.BenchmarkRuns += src.BenchmarkRuns
+	dst.BlockedRequests += src.BlockedRequests
+	dst.RejectedKeys += src.RejectedKeys
+
+	if src.StartTime != "" && (dst.StartTime == "" || src.StartTime < dst.StartTime) {
+		dst.StartTime = src.StartTime
+	}
+	if src.LastRequestTime > dst.LastRequestTime {
+		dst.LastRequestTime = src.LastRequestTime
+	}
+
+	for _, m := range []struct{ dst, src map[string]int }{
+		{dst.UpstreamErrors, src.UpstreamErrors},
+		{dst.StatusClasses, src.StatusClasses},
+		{dst.Routes, src.Routes},
+	} {
+		for name, n := range m.src {
+			m.dst[name] += n
+		}
+	}
+	for _, m := range []struct{ dst, src map[string]*KeyUsage }{
+		{dst.KeyUsage, src.KeyUsage},
+		{dst.ModelUsage, src.ModelUsage},
+	} {
+		for name, u := range m.src {
+			d := usageEntry(m.dst, name)
+			d.Requests += u.Requests
+			d.PromptTokens += u.PromptTokens
+			d.CompletionTokens += u.CompletionTokens
+			d.TotalTokens += u.TotalTokens
+			d.EstimatedTokens += u.EstimatedTokens
+		}
+	}
+
+	g, sg := &dst.Guard, src.Guard
+	if g.Checks+sg.Checks > 0 {
+		g.AvgLatencyMs = (g.AvgLatencyMs*int64(g.Checks) + sg.AvgLatencyMs*int64(sg.Checks)) / int64(g.Checks+sg.Checks)
+	}
+	g.Checks += sg.Checks
+	g.Blocked += sg.Blocked
+	g.Errors += sg.Errors
+
+	errorLog := append(append([]ErrorItem{}, dst.ErrorLog...), src.ErrorLog...)
+	sort.SliceStable(errorLog, func(i, j int) bool { return errorLog[i].Timestamp > errorLog[j].Timestamp })
+	if len(errorLog) > maxErrorLog {
+		errorLog = errorLog[:maxErrorLog]
+	}
+	dst.ErrorLog = errorLog
+}
+
+// mergeBuckets unions two bucket lists. A bucket present in both keeps the
+// larger of each count, so importing the same export twice changes nothing.
+func mergeBuckets(dst, src []usageBucket) []usageBucket {
+	byStart := make(map[int64]int, len(dst))
+	merged := append([]usageBucket{}, dst...)
+	for i, b := range merged {
+		byStart[b.Start.Unix()] = i
+	}
+	for _, b := range src {
+		i, ok := byStart[b.Start.Unix()]
+		if !ok {
+			byStart[b.Start.Unix()] = len(merged)
+			merged = append(merged, b)
+			continue
+		}
+		m := &merged[i]
+		m.Messages = max(m.Messages, b.Messages)
+		m.PromptTokens = max(m.PromptTokens, b.PromptTokens)
+		m.CompletionTokens = max(m.CompletionTokens, b.CompletionTokens)
+		m.Errors = max(m.Errors, b.Errors)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Start.Before(merged[j].Start) })
+	return merged
+}
+
+// handleStatsImport merges an exported document into the current stats.
+// With ?dryRun=true the merged result is returned without being applied.
+func (a *App) handleStatsImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var doc statsExport
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := doc.validate(); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+
+	a.mu.Lock()
+	merged := a.statsSnapshot()
+	merged.latencySamples = a.stats.latencySamples
+	mergeStats(&merged, doc.Stats)
+	series := usageSeries{
+		Hourly: mergeBuckets(a.series.Hourly, doc.Timeseries.Hourly),
+		Daily:  mergeBuckets(a.series.Daily, doc.Timeseries.Daily),
+	}
+	if !dryRun {
+		a.stats = merged
+		a.series = series
+	}
+	a.mu.Unlock()
+
+	if !dryRun {
+		a.statsHub.publish()
+		a.saveSeries()
+	}
+
+	merged.latencySamples = nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"dryRun":  dryRun,
+		"stats":   merged,
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+// TestStatsImportValidation checks documents this version can't merge are
+// refused without changing anything
+func TestStatsImportValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		code int
+	}{
+		{"valid", `{"version":1,"stats":{"messageCount":2}}`, 200},
+		{"not JSON", `{`, 400},
+		{"wrong version", `{"version":2,"stats":{}}`, 400},
+		{"negative counter", `{"version":1,"stats":{"promptTokens":-5}}`, 400},
+		{"bad start time", `{"version":1,"stats":{"startTime":"yesterday"}}`, 400},
+		{"bucket without timestamp", `{"version":1,"stats":{},"timeseries":{"hourly":[{"messages":1}]}}`, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, nil)
+			w := serve(a.handleStatsImport, "POST", "/api/stats/import", tt.doc)
+			if w.Code != tt.code {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+			if tt.code != 200 && a.stats.MessageCount != 0 {
+				t.Error("a refused import changed the stats")
+			}
+		})
+	}
+}
+
+// TestStatsExportImport moves stats from one install to another: a dry
+// run changes nothing, an import adds the counters, and importing the same
+// time series again leaves it as it was
+func TestStatsExportImport(t *testing.T) {
+	src := newTestApp(t, nil)
+	src.mu.Lock()
+	src.stats.MessageCount = 3
+	src.mu.Unlock()
+	src.recordUsage("k", "m", map[string]interface{}{"prompt_tokens": 10.0, "completion_tokens": 5.0, "total_tokens": 15.0})
+	export := serve(src.handleStatsExport, "GET", "/api/stats/export", "").Body.String()
+
+	dst := newTestApp(t, nil)
+	dst.recordUsage("k", "m", map[string]interface{}{"prompt_tokens": 1.0, "completion_tokens": 1.0, "total_tokens": 2.0})
+
+	steps := []struct {
+		path         string
+		messages     int
+		prompt       int
+		bucketPrompt int
+	}{
+		{"/api/stats/import?dryRun=true", 0, 1, 1},
+		{"/api/stats/import", 3, 11, 10},
+		{"/api/stats/import", 6, 21, 10},
+	}
+	for _, step := range steps {
+		if w := serve(dst.handleStatsImport, "POST", step.path, export); w.Code != 200 {
+			t.Fatalf("%s: status %d: %s", step.path, w.Code, w.Body)
+		}
+		dst.mu.RLock()
+		stats, hourly := dst.stats, dst.series.Hourly
+		dst.mu.RUnlock()
+		if stats.MessageCount != step.messages || stats.PromptTokens != step.prompt {
+			t.Errorf("%s: messages/prompt tokens = %d/%d, want %d/%d", step.path, stats.MessageCount, stats.PromptTokens, step.messages, step.prompt)
+		}
+		if stats.KeyUsage["k"] == nil || stats.KeyUsage["k"].PromptTokens != step.prompt {
+			t.Errorf("%s: key usage = %+v, want %d prompt tokens", step.path, stats.KeyUsage["k"], step.prompt)
+		}
+		// Buckets for the same hour keep the larger count rather than adding up
+		if len(hourly) != 1 || hourly[0].PromptTokens != step.bucketPrompt {
+			t.Errorf("%s: hourly = %+v, want one bucket with %d prompt tokens", step.path, hourly, step.bucketPrompt)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// statsEventInterval coalesces stats changes into at most one event
+	statsEventInterval = time.Second
+	// statsHeartbeat keeps idle stats streams open through tunnels
+	statsHeartbeat = 30 * time.Second
+)
+
+// statsHub tells subscribed stats streams that the stats changed. Each
+// subscriber channel holds at most one pending signal, so publishing never
+// blocks and bursts of changes collapse into one.
+type statsHub struct {
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+// subscribe registers a new listener
+func (h *statsHub) subscribe() chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs == nil {
+		h.subs = make(map[chan struct{}]struct{})
+	}
+	ch := make(chan struct{}, 1)
+	h.subs[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe removes a listener registered with subscribe
+func (h *statsHub) unsubscribe(ch chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, ch)
+}
+
+// publish signals every listener that the stats changed
+func (h *statsHub) publish() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// handleStatsStream pushes the /api/stats JSON as server-sent events: once
+// on connect and then whenever the stats change, at most once a second
+func (a *App) handleStatsStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", 500)
+		return
+	}
+
+	changes := a.statsHub.subscribe()
+	defer a.statsHub.unsubscribe(changes)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	send := func() bool {
+		a.mu.RLock()
+		data, err := json.Marshal(a.statsSnapshot())
+		a.mu.RUnlock()
+		if err != nil {
+			return true
+		}
+		if _, err := w.Write([]byte("event: stats\ndata: " + string(data) + "\n\n")); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+	if !send() {
+		return
+	}
+	lastSent := time.Now()
+
+	heartbeat := time.NewTicker(statsHeartbeat)
+	defer heartbeat.Stop()
+
+	// due fires when a change arrived too soon after the last event
+	var due <-chan time.Time
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.draining:
+			return
+		case <-changes:
+			if due != nil {
+				continue
+			}
+			if wait := statsEventInterval - time.Since(lastSent); wait > 0 {
+				due = time.After(wait)
+				continue
+			}
+		case <-due:
+			due = nil
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+			continue
+		}
+
+		if !send() {
+			return
+		}
+		lastSent = time.Now()
+	}
+}
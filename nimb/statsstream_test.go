@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStatsHub checks publishing never blocks, bursts collapse into one
+// signal and unsubscribed listeners hear nothing
+func TestStatsHub(t *testing.T) {
+	tests := []struct {
+		name        string
+		publishes   int
+		unsubscribe bool
+		signals     int
+	}{
+		{"no change", 0, false, 0},
+		{"one change", 1, false, 1},
+		{"burst", 5, false, 1},
+		{"unsubscribed", 3, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hub statsHub
+			ch := hub.subscribe()
+			if tt.unsubscribe {
+				hub.unsubscribe(ch)
+			}
+			for i := 0; i < tt.publishes; i++ {
+				hub.publish()
+			}
+			signals := 0
+			for done := false; !done; {
+				select {
+				case <-ch:
+					signals++
+				default:
+					done = true
+				}
+			}
+			if signals != tt.signals {
+				t.Errorf("%d signals, want %d", signals, tt.signals)
+			}
+		})
+	}
+}
+
+// TestStatsStream checks the stream sends the stats on connect and again
+// after they change
+func TestStatsStream(t *testing.T) {
+	a := newTestApp(t, nil)
+	srv := httptest.NewServer(http.HandlerFunc(a.handleStatsStream))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %s", ct)
+	}
+
+	events := make(chan Stats)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				var s Stats
+				json.Unmarshal([]byte(data), &s)
+				events <- s
+			}
+		}
+		close(events)
+	}()
+
+	next := func() Stats {
+		t.Helper()
+		select {
+		case s, ok := <-events:
+			if !ok {
+				t.Fatal("stream ended")
+			}
+			return s
+		case <-time.After(3 * time.Second):
+			t.Fatal("no event")
+		}
+		return Stats{}
+	}
+	if s := next(); s.PromptTokens != 0 {
+		t.Errorf("first event has %d prompt tokens, want 0", s.PromptTokens)
+	}
+	a.recordUsage("k", "m", map[string]interface{}{"prompt_tokens": 7.0, "completion_tokens": 2.0})
+	if s := next(); s.PromptTokens != 7 {
+		t.Errorf("event after a change has %d prompt tokens, want 7", s.PromptTokens)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// streamRelay forwards an upstream SSE body to the client line by line,
+// inspecting data lines on the way through. It remembers the last usage
+// object seen and can rewrite the model name of each chunk.
+type streamRelay struct {
+	// clientModel, when set, replaces the "model" field of every chunk so
+	// clients see the name they asked for rather than an alias target
+	clientModel string
+
+	// stripReasoning drops reasoning_content deltas and inline <think> spans
+	stripReasoning bool
+	thinkFilters   map[int]*thinkFilter
+
+	// convert, when set, reshapes each chunk into another API's format.
+	// Returning nil drops the chunk.
+	convert func(chunk map[string]interface{}) map[string]interface{}
+
+	// ndjson writes converted chunks as newline-delimited JSON instead of
+	// SSE events; finish supplies the closing object at the end of the stream
+	ndjson   bool
+	finish   func() map[string]interface{}
+	finished bool
+
+	// keepalive is the idle interval after which a comment is sent to keep
+	// tunnels and carriers from dropping the connection; 0 disables it
+	keepalive time.Duration
+
+	// done and sawFinish tell a complete stream from one cut off midway
+	done      bool
+	sawFinish bool
+
+	usage          map[string]interface{}
+	reasoningChars int
+
+	// firstData and lastData time the generated chunks, for latency stats
+	firstData time.Time
+	lastData  time.Time
+
+	// completion accumulates the generated text, for estimating tokens
+	// when the upstream sends no usage
+	completion strings.Builder
+}
+
+// maxPendingEvent bounds how much of an event is held back waiting for its
+// terminating blank line, for upstreams that never send one
+const maxPendingEvent = 64 << 10
+
+// relay copies body to w until the upstream ends. Lines are read whole, so
+// neither "data:" lines nor multi-byte characters are ever split, and each
+// blank-line delimited event is written and flushed in one go. While the
+// upstream is silent, keep-alive comments are sent between events.
+func (s *streamRelay) relay(w io.Writer, flusher http.Flusher, body io.Reader) error {
+	lines := make(chan streamLine)
+	stop := make(chan struct{})
+	defer close(stop)
+	go readLines(body, lines, stop)
+
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	if s.keepalive > 0 && !s.ndjson {
+		ticker = time.NewTicker(s.keepalive)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var event bytes.Buffer
+	for {
+		var next streamLine
+		select {
+		case <-tick:
+			// Only between events, so a comment never lands inside one
+			if event.Len() == 0 {
+				if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+					return err
+				}
+				flusher.Flush()
+			}
+			continue
+		case next = <-lines:
+		}
+
+		line, err := next.line, next.err
+		if len(line) > 0 {
+			if ticker != nil {
+				ticker.Reset(s.keepalive)
+			}
+			event.Write(s.processLine(line))
+			if len(bytes.TrimSpace(line)) == 0 || event.Len() > maxPendingEvent {
+				if werr := s.emit(w, flusher, &event); werr != nil {
+					return werr
+				}
+			}
+		}
+		if err == io.EOF {
+			if !s.done && !s.sawFinish {
+				s.emit(w, flusher, &event)
+				return errIncompleteStream
+			}
+			event.Write(s.finishLine())
+			if !s.done && !s.ndjson {
+				event.WriteString("data: [DONE]\n\n")
+			}
+			return s.emit(w, flusher, &event)
+		}
+		if err != nil {
+			s.emit(w, flusher, &event)
+			return err
+		}
+	}
+}
+
+// streamLine is one line read from the upstream, or the error that ended it
+type streamLine struct {
+	line []byte
+	err  error
+}
+
+// readLines feeds body to lines until it ends or stop is closed
+func readLines(body io.Reader, lines chan<- streamLine, stop <-chan struct{}) {
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		select {
+		case lines <- streamLine{line, err}:
+		case <-stop:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// errIncompleteStream means the upstream closed the stream before finishing
+var errIncompleteStream = errors.New("upstream closed the stream before it finished")
+
+// abort ends a stream that broke off with an OpenAI-style error event and
+// [DONE], so clients stop waiting instead of hanging until their own timeout
+func (s *streamRelay) abort(w io.Writer, flusher http.Flusher, msg string) {
+	var event bytes.Buffer
+	if s.ndjson {
+		data, _ := json.Marshal(map[string]interface{}{"error": msg})
+		event.Write(append(data, '\n'))
+	} else {
+		data, _ := json.Marshal(map[string]interface{}{
+			"error": map[string]interface{}{"message": msg, "type": "upstream_error", "code": 502},
+		})
+		event.WriteString("data: " + string(data) + "\n\n")
+		event.WriteString("data: [DONE]\n\n")
+	}
+	s.emit(w, flusher, &event)
+}
+
+// emit writes a buffered event to the client and flushes it
+func (s *streamRelay) emit(w io.Writer, flusher http.Flusher, event *bytes.Buffer) error {
+	if event.Len() == 0 {
+		return nil
+	}
+	_, err := w.Write(event.Bytes())
+	event.Reset()
+	flusher.Flush()
+	return err
+}
+
+// processLine inspects a single SSE line and returns the bytes to forward
+func (s *streamRelay) processLine(line []byte) []byte {
+	trimmed := bytes.TrimSpace(line)
+	if !bytes.HasPrefix(trimmed, []byte("data:")) {
+		if s.ndjson {
+			return nil
+		}
+		return line
+	}
+	data := bytes.TrimSpace(trimmed[len("data:"):])
+	if bytes.Equal(data, []byte("[DONE]")) {
+		s.done = true
+	}
+	if bytes.Equal(data, []byte("[DONE]")) && s.ndjson {
+		return s.finishLine()
+	}
+	if len(data) == 0 || bytes.Equal(data, []byte("[DONE]")) {
+		return line
+	}
+
+	var chunk map[string]interface{}
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		if s.ndjson {
+			return nil
+		}
+		return line
+	}
+	if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+		s.usage = usage
+	}
+	if s.usage == nil {
+		s.completion.WriteString(responseText(chunk))
+	}
+	if choices, ok := chunk["choices"].([]interface{}); ok {
+		if len(choices) > 0 {
+			if s.firstData.IsZero() {
+				s.firstData = time.Now()
+			}
+			s.lastData = time.Now()
+		}
+		for _, c := range choices {
+			if choice, ok := c.(map[string]interface{}); ok {
+				if reason, _ := choice["finish_reason"].(string); reason != "" {
+					s.sawFinish = true
+				}
+			}
+		}
+	}
+
+	modified := false
+	if s.clientModel != "" {
+		chunk["model"] = s.clientModel
+		modified = true
+	}
+	if s.stripReasoning && s.filterReasoning(chunk) {
+		modified = true
+	}
+	if s.convert != nil {
+		chunk = s.convert(chunk)
+		if chunk == nil {
+			return nil
+		}
+		modified = true
+	}
+	if !modified {
+		return line
+	}
+
+	rewritten, err := json.Marshal(chunk)
+	if err != nil {
+		return line
+	}
+	if s.ndjson {
+		return append(rewritten, '\n')
+	}
+	return append(append([]byte("data: "), rewritten...), '\n')
+}
+
+// finishLine returns the closing NDJSON object, at most once per stream
+func (s *streamRelay) finishLine() []byte {
+	if !s.ndjson || s.finish == nil || s.finished {
+		return nil
+	}
+	s.finished = true
+	data, err := json.Marshal(s.finish())
+	if err != nil {
+		return nil
+	}
+	return append(data, '\n')
+}
+
+// filterReasoning removes reasoning from the deltas of a chunk in place and
+// reports whether anything changed. The chunk itself is always kept so the
+// event framing stays intact.
+func (s *streamRelay) filterReasoning(chunk map[string]interface{}) bool {
+	changed := false
+	choices, _ := chunk["choices"].([]interface{})
+	for _, c := range choices {
+		choice, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		delta, ok := choice["delta"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if rc, ok := delta["reasoning_content"]; ok {
+			if text, ok := rc.(string); ok {
+				s.reasoningChars += len(text)
+			}
+			delete(delta, "reasoning_content")
+			changed = true
+		}
+
+		index, _ := choice["index"].(float64)
+		if s.thinkFilters == nil {
+			s.thinkFilters = make(map[int]*thinkFilter)
+		}
+		f := s.thinkFilters[int(index)]
+		if f == nil {
+			f = &thinkFilter{}
+			s.thinkFilters[int(index)] = f
+		}
+
+		content, hasContent := delta["content"].(string)
+		if !hasContent && f.pending == "" {
+			continue
+		}
+		before := f.removed
+		visible := f.filter(content)
+		if reason, _ := choice["finish_reason"].(string); reason != "" {
+			visible += f.flush()
+		}
+		s.reasoningChars += f.removed - before
+		if visible != content {
+			delta["content"] = visible
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseUpstream answers every request with events as a stream and records
+// the body it was sent
+func sseUpstream(events []string, sent *map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, sent)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, e := range events {
+			io.WriteString(w, "data: "+e+"\n\n")
+		}
+	}
+}
+
+// TestStreamUsage checks a streamed completion counts the usage of its last
+// chunk, or an estimate when the upstream sends none, and reaches the
+// client unchanged
+func TestStreamUsage(t *testing.T) {
+	tests := []struct {
+		name               string
+		events             []string
+		prompt, completion int
+		estimated          bool
+	}{
+		{
+			name: "usage in the last event",
+			events: []string{
+				`{"choices":[{"index":0,"delta":{"content":"Hello"}}]}`,
+				`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+				`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":3,"total_tokens":15}}`,
+				`[DONE]`,
+			},
+			prompt: 12, completion: 3,
+		},
+		{
+			name: "no usage",
+			events: []string{
+				`{"choices":[{"index":0,"delta":{"content":"Hello there, how are you?"}}]}`,
+				`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+				`[DONE]`,
+			},
+			estimated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent map[string]interface{}
+			a := newTestApp(t, sseUpstream(tt.events, &sent))
+			a.config.ModelOverrideMode = ModelModeClient
+
+			w := serve(a.handleChatCompletions, "POST", "/v1/chat/completions",
+				`{"model":"m","stream":true,"messages":[{"role":"user","content":"Hi"}]}`)
+			if w.Code != 200 {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			for _, e := range tt.events {
+				if !strings.Contains(w.Body.String(), "data: "+e+"\n\n") {
+					t.Errorf("client did not get %s unchanged", e)
+				}
+			}
+			opts, _ := sent["stream_options"].(map[string]interface{})
+			if opts["include_usage"] != true {
+				t.Errorf("stream_options = %v, want include_usage", sent["stream_options"])
+			}
+
+			a.mu.RLock()
+			defer a.mu.RUnlock()
+			if a.stats.PromptTokens != tt.prompt || a.stats.CompletionTokens != tt.completion {
+				t.Errorf("tokens = %d/%d, want %d/%d", a.stats.PromptTokens, a.stats.CompletionTokens, tt.prompt, tt.completion)
+			}
+			if estimated := a.stats.EstimatedCompletionTokens > 0; estimated != tt.estimated {
+				t.Errorf("estimated completion tokens = %d, want estimate %v", a.stats.EstimatedCompletionTokens, tt.estimated)
+			}
+		})
+	}
+}
+
+// eventRecorder keeps each write to the client separately
+type eventRecorder struct {
+	writes []string
+}
+
+func (e *eventRecorder) Write(p []byte) (int, error) {
+	e.writes = append(e.writes, string(p))
+	return len(p), nil
+}
+
+func (e *eventRecorder) Flush() {}
+
+// chunkedReader returns at most n bytes per read, like the old fixed-size
+// copy loop did
+type chunkedReader struct {
+	r io.Reader
+	n int
+}
+
+func (c chunkedReader) Read(p []byte) (int, error) {
+	return c.r.Read(p[:min(len(p), c.n)])
+}
+
+// TestRelayWholeEvents checks events straddling a 4096-byte read boundary,
+// with a multi-byte character split across it, reach the client whole and
+// one write per event
+func TestRelayWholeEvents(t *testing.T) {
+	event := func(content string) string {
+		data, _ := json.Marshal(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{"content": content}}},
+		})
+		return "data: " + string(data) + "\n\n"
+	}
+	// start is where the content begins within an event
+	start := strings.Index(event("@"), "@")
+	tests := []struct {
+		name   string
+		events []string
+	}{
+		// "é" is two bytes; the first event puts its first byte at 4095
+		{"rune across the boundary", []string{event(strings.Repeat("a", 4095-start) + "é" + "b"), event("ü"), "data: [DONE]\n\n"}},
+		{"event across the boundary", []string{event(strings.Repeat("x", 3000)), event(strings.Repeat("y", 3000)), "data: [DONE]\n\n"}},
+		{"multi-line event", []string{"event: message\n" + event("ok"), "data: [DONE]\n\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Join(tt.events, "")
+			var rec eventRecorder
+			s := &streamRelay{}
+			if err := s.relay(&rec, &rec, chunkedReader{bytes.NewReader([]byte(body)), 4096}); err != nil {
+				t.Fatal(err)
+			}
+			if len(rec.writes) != len(tt.events) {
+				t.Fatalf("%d writes, want %d", len(rec.writes), len(tt.events))
+			}
+			for i, want := range tt.events {
+				if rec.writes[i] != want {
+					t.Errorf("write %d = %.80q..., want %.80q...", i, rec.writes[i], want)
+				}
+			}
+		})
+	}
+}
+
+// TestStreamTermination checks a stream cut off midway ends with an error
+// event and [DONE], and a failed stream request gets a plain JSON error
+func TestStreamTermination(t *testing.T) {
+	tests := []struct {
+		name        string
+		upstream    http.HandlerFunc
+		code        int
+		contentType string
+		tail        string
+		logged      int // code of the error log entry
+	}{
+		{
+			name: "mid-stream EOF",
+			upstream: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				io.WriteString(w, `data: {"choices":[{"index":0,"delta":{"content":"Hel"}}]}`+"\n\n")
+			},
+			code:        200,
+			contentType: "text/event-stream",
+			tail:        "\"type\":\"upstream_error\"}}\n\ndata: [DONE]\n\n",
+			logged:      502,
+		},
+		{
+			name: "upstream 429 before any bytes",
+			upstream: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(429)
+				io.WriteString(w, `{"error":{"message":"Too many requests"}}`)
+			},
+			code:        429,
+			contentType: "application/json",
+			logged:      429,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, tt.upstream)
+			a.config.MaxRetries = 0
+			w := serve(a.handleChatCompletions, "POST", "/v1/chat/completions",
+				`{"model":"m","stream":true,"messages":[{"role":"user","content":"Hi"}]}`)
+			if w.Code != tt.code {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.contentType) {
+				t.Errorf("Content-Type = %s, want %s", ct, tt.contentType)
+			}
+			if !strings.HasSuffix(w.Body.String(), tt.tail) {
+				t.Errorf("body ends %q, want %q", w.Body.String(), tt.tail)
+			}
+			a.mu.RLock()
+			defer a.mu.RUnlock()
+			if len(a.stats.ErrorLog) == 0 || a.stats.ErrorLog[0].Code != tt.logged {
+				t.Errorf("error log = %+v, want a %d entry", a.stats.ErrorLog, tt.logged)
+			}
+		})
+	}
+}
+
+// TestRelayKeepalive checks keep-alive comments are sent while the upstream
+// is silent, never inside an event, and not at all with the interval at 0
+func TestRelayKeepalive(t *testing.T) {
+	const event = `data: {"choices":[{"index":0,"delta":{"content":"Hi"}}]}`
+	tests := []struct {
+		name      string
+		keepalive time.Duration
+	}{
+		{"on", 10 * time.Millisecond},
+		{"off", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr, pw := io.Pipe()
+			go func() {
+				// Silent before the first event, then in the middle of one
+				time.Sleep(60 * time.Millisecond)
+				io.WriteString(pw, event+"\n")
+				time.Sleep(60 * time.Millisecond)
+				io.WriteString(pw, "\n")
+				time.Sleep(60 * time.Millisecond)
+				io.WriteString(pw, "data: [DONE]\n\n")
+				pw.Close()
+			}()
+
+			var rec eventRecorder
+			s := &streamRelay{keepalive: tt.keepalive}
+			if err := s.relay(&rec, &rec, pr); err != nil {
+				t.Fatal(err)
+			}
+			var events []string
+			comments := 0
+			for _, w := range rec.writes {
+				if w == ": keepalive\n\n" {
+					comments++
+					continue
+				}
+				events = append(events, w)
+			}
+			want := []string{event + "\n\n", "data: [DONE]\n\n"}
+			if strings.Join(events, "|") != strings.Join(want, "|") {
+				t.Errorf("events = %q, want %q", events, want)
+			}
+			if (comments > 0) != (tt.keepalive > 0) {
+				t.Errorf("%d keep-alive comments with interval %s", comments, tt.keepalive)
+			}
+		})
+	}
+}
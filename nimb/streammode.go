@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Upstream stream modes decide whether the upstream is asked to stream,
+// independently of what the client asked for
+const (
+	StreamModeAuto   = "auto"   // stream upstream exactly when the client does
+	StreamModeAlways = "always" // always stream upstream, aggregating for non-streaming clients
+	StreamModeNever  = "never"  // never stream upstream, synthesizing chunks for streaming clients
+)
+
+// upstreamStreams reports whether the upstream should be asked to stream.
+// Only chat completions are converted; other paths follow the client.
+func upstreamStreams(mode, path string, clientStream bool) bool {
+	if path != "/chat/completions" {
+		return clientStream
+	}
+	switch mode {
+	case StreamModeAlways:
+		return true
+	case StreamModeNever:
+		return false
+	}
+	return clientStream
+}
+
+// aggregatedChoice collects the deltas of one choice
+type aggregatedChoice struct {
+	role         string
+	content      strings.Builder
+	reasoning    strings.Builder
+	toolCalls    map[int]map[string]interface{}
+	toolArgs     map[int]*strings.Builder
+	finishReason interface{}
+}
+
+// aggregateStream folds an SSE chat completion stream into the single
+// chat.completion object a non-streaming request would have returned,
+// merging content, reasoning_content and tool call deltas per choice
+func aggregateStream(body io.Reader) (map[string]interface{}, error) {
+	result := map[string]interface{}{"object": "chat.completion"}
+	choices := map[int]*aggregatedChoice{}
+	finished := false
+
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+		data = bytes.TrimSpace(data)
+		if ok && bytes.Equal(data, []byte("[DONE]")) {
+			finished = true
+		} else if ok && len(data) > 0 {
+			var chunk map[string]interface{}
+			if json.Unmarshal(data, &chunk) == nil {
+				if e, ok := chunk["error"]; ok {
+					msg, _ := json.Marshal(e)
+					return nil, errors.New("upstream stream error: " + string(msg))
+				}
+				if mergeChunk(result, choices, chunk) {
+					finished = true
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !finished {
+		return nil, errIncompleteStream
+	}
+
+	indexes := make([]int, 0, len(choices))
+	for i := range choices {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	list := make([]interface{}, 0, len(indexes))
+	for _, i := range indexes {
+		c := choices[i]
+		role := c.role
+		if role == "" {
+			role = "assistant"
+		}
+		message := map[string]interface{}{"role": role, "content": c.content.String()}
+		if c.reasoning.Len() > 0 {
+			message["reasoning_content"] = c.reasoning.String()
+		}
+		if len(c.toolCalls) > 0 {
+			callIndexes := make([]int, 0, len(c.toolCalls))
+			for ti := range c.toolCalls {
+				callIndexes = append(callIndexes, ti)
+			}
+			sort.Ints(callIndexes)
+			calls := make([]interface{}, 0, len(callIndexes))
+			for _, ti := range callIndexes {
+				call := c.toolCalls[ti]
+				fn, _ := call["function"].(map[string]interface{})
+				fn["arguments"] = c.toolArgs[ti].String()
+				calls = append(calls, call)
+			}
+			message["tool_calls"] = calls
+		}
+		list = append(list, map[string]interface{}{
+			"index":         i,
+			"message":       message,
+			"finish_reason": c.finishReason,
+		})
+	}
+	result["choices"] = list
+	return result, nil
+}
+
+// mergeChunk adds one stream chunk to the aggregate and reports whether it
+// finished a choice
+func mergeChunk(result map[string]interface{}, choices map[int]*aggregatedChoice, chunk map[string]interface{}) bool {
+	for _, field := range []string{"id", "created", "model", "system_fingerprint"} {
+		if v, ok := chunk[field]; ok {
+			if _, seen := result[field]; !seen {
+				result[field] = v
+			}
+		}
+	}
+	if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+		result["usage"] = usage
+	}
+
+	finished := false
+	list, _ := chunk["choices"].([]interface{})
+	for _, item := range list {
+		choice, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		index, _ := choice["index"].(float64)
+		c := choices[int(index)]
+		if c == nil {
+			c = &aggregatedChoice{toolCalls: map[int]map[string]interface{}{}, toolArgs: map[int]*strings.Builder{}}
+			choices[int(index)] = c
+		}
+
+		if reason, ok := choice["finish_reason"].(string); ok && reason != "" {
+			c.finishReason = reason
+			finished = true
+		}
+
+		delta, _ := choice["delta"].(map[string]interface{})
+		if role, ok := delta["role"].(string); ok {
+			c.role = role
+		}
+		if text, ok := delta["content"].(string); ok {
+			c.content.WriteString(text)
+		}
+		if text, ok := delta["reasoning_content"].(string); ok {
+			c.reasoning.WriteString(text)
+		}
+
+		calls, _ := delta["tool_calls"].([]interface{})
+		for _, tc := range calls {
+			call, ok := tc.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			ti, _ := call["index"].(float64)
+			agg := c.toolCalls[int(ti)]
+			if agg == nil {
+				agg = map[string]interface{}{"type": "function", "function": map[string]interface{}{}}
+				c.toolCalls[int(ti)] = agg
+				c.toolArgs[int(ti)] = &strings.Builder{}
+			}
+			for _, field := range []string{"id", "type"} {
+				if v, ok := call[field].(string); ok && v != "" {
+					agg[field] = v
+				}
+			}
+			fn, _ := call["function"].(map[string]interface{})
+			aggFn := agg["function"].(map[string]interface{})
+			if name, ok := fn["name"].(string); ok && name != "" {
+				aggFn["name"] = name
+			}
+			if args, ok := fn["arguments"].(string); ok {
+				c.toolArgs[int(ti)].WriteString(args)
+			}
+		}
+	}
+	return finished
+}
+
+// synthesizeStream turns a chat.completion into the SSE stream a streaming
+// request would have received: one chunk carrying each choice's message,
+// one with its finish reason, a usage chunk and [DONE]
+func synthesizeStream(resp map[string]interface{}) []byte {
+	base := func() map[string]interface{} {
+		chunk := map[string]interface{}{"object": "chat.completion.chunk"}
+		for _, field := range []string{"id", "created", "model", "system_fingerprint"} {
+			if v, ok := resp[field]; ok {
+				chunk[field] = v
+			}
+		}
+		return chunk
+	}
+
+	var out bytes.Buffer
+	write := func(chunk map[string]interface{}) {
+		data, _ := json.Marshal(chunk)
+		out.WriteString("data: ")
+		out.Write(data)
+		out.WriteString("\n\n")
+	}
+
+	choices, _ := resp["choices"].([]interface{})
+	for _, item := range choices {
+		choice, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		message, _ := choice["message"].(map[string]interface{})
+		delta := map[string]interface{}{}
+		for k, v := range message {
+			delta[k] = v
+		}
+		if calls, ok := delta["tool_calls"].([]interface{}); ok {
+			indexed := make([]interface{}, 0, len(calls))
+			for i, c := range calls {
+				call, _ := c.(map[string]interface{})
+				withIndex := map[string]interface{}{"index": i}
+				for k, v := range call {
+					withIndex[k] = v
+				}
+				indexed = append(indexed, withIndex)
+			}
+			delta["tool_calls"] = indexed
+		}
+
+		chunk := base()
+		chunk["choices"] = []interface{}{map[string]interface{}{"index": choice["index"], "delta": delta, "finish_reason": nil}}
+		write(chunk)
+
+		chunk = base()
+		chunk["choices"] = []interface{}{map[string]interface{}{"index": choice["index"], "delta": map[string]interface{}{}, "finish_reason": choice["finish_reason"]}}
+		write(chunk)
+	}
+
+	if usage, ok := resp["usage"]; ok {
+		chunk := base()
+		chunk["choices"] = []interface{}{}
+		chunk["usage"] = usage
+		write(chunk)
+	}
+
+	out.WriteString("data: [DONE]\n\n")
+	return out.Bytes()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConnectTimeout = 30 * time.Second
+	defaultRequestTimeout = 120 * time.Second
+	// Reasoning models on a busy upstream can take minutes to answer
+	defaultStreamFirstByte = 300 * time.Second
+)
+
+// timeoutError reports which phase of an upstream request ran out of time
+type timeoutError struct {
+	phase string
+	after time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return "upstream timed out during " + e.phase + " after " + e.after.String()
+}
+
+// upstreamTimeouts are the deadlines for one upstream request. Zero means
+// no limit.
+type upstreamTimeouts struct {
+	Connect   time.Duration // dial and TLS handshake, per attempt
+	FirstByte time.Duration // until response headers, per attempt
+	Total     time.Duration // the whole request including the body
+}
+
+type timeoutsKey struct{}
+
+// connectTimeout returns the configured connect/TLS timeout
+func connectTimeout(config Config) time.Duration {
+	if config.ConnectTimeoutSec <= 0 {
+		return defaultConnectTimeout
+	}
+	return time.Duration(config.ConnectTimeoutSec) * time.Second
+}
+
+// streamFirstByteTimeout returns how long a stream may wait for the
+// upstream's response headers
+func streamFirstByteTimeout(config Config) time.Duration {
+	if config.StreamFirstByteSec <= 0 {
+		return defaultStreamFirstByte
+	}
+	return time.Duration(config.StreamFirstByteSec) * time.Second
+}
+
+// requestTimeouts picks the timeouts for a request. Streams have no total
+// deadline, as reasoning models can legitimately think for minutes, but must
+// start answering within Config.StreamFirstByteSec. The client gets no
+// keep-alives while it waits: nothing is sent until the upstream answers,
+// so a failure can still be retried or returned as a plain JSON error.
+// Non-streaming requests get the configured total instead. An
+// X-Request-Timeout header (seconds) can only shorten these.
+func requestTimeouts(r *http.Request, config Config, stream bool) upstreamTimeouts {
+	t := upstreamTimeouts{Connect: connectTimeout(config)}
+	if stream {
+		t.FirstByte = streamFirstByteTimeout(config)
+	} else if config.RequestTimeoutSec > 0 {
+		t.Total = time.Duration(config.RequestTimeoutSec) * time.Second
+	} else {
+		t.Total = defaultRequestTimeout
+	}
+
+	if secs, err := strconv.ParseFloat(r.Header.Get("X-Request-Timeout"), 64); err == nil && secs > 0 {
+		limit := time.Duration(secs * float64(time.Second))
+		if t.Total == 0 || limit < t.Total {
+			t.Total = limit
+		}
+		if t.FirstByte > 0 {
+			t.FirstByte = min(t.FirstByte, limit)
+		}
+	}
+	return t
+}
+
+// withTimeouts applies the total deadline to ctx and records the timeouts
+// so each upstream attempt can enforce the rest
+func withTimeouts(ctx context.Context, t upstreamTimeouts) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, timeoutsKey{}, t)
+	if t.Total > 0 {
+		return context.WithTimeout(ctx, t.Total)
+	}
+	return context.WithCancel(ctx)
+}
+
+// cancelOnClose releases an attempt's context once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelCauseFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel(nil)
+	return err
+}
+
+// sendAttempt performs one upstream attempt, enforcing the first-byte
+// timeout and naming the phase when any timeout fires
+func sendAttempt(client *http.Client, req *http.Request) (*http.Response, error) {
+	t, _ := req.Context().Value(timeoutsKey{}).(upstreamTimeouts)
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+	if t.FirstByte > 0 {
+		timer := time.AfterFunc(t.FirstByte, func() {
+			cancel(&timeoutError{phase: "first byte", after: t.FirstByte})
+		})
+		defer timer.Stop()
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		var te *timeoutError
+		if cause := context.Cause(ctx); errors.As(cause, &te) {
+			err = te
+		} else if phase := timeoutPhase(req.Context(), err); phase != "" {
+			after := t.Connect
+			if phase == "request" {
+				after = t.Total
+			}
+			err = &timeoutError{phase: phase, after: after}
+		}
+		cancel(nil)
+		return nil, err
+	}
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
+
+// timeoutPhase names the phase a transport error timed out in, or returns
+// "" when err isn't a timeout
+func timeoutPhase(ctx context.Context, err error) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "request"
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return ""
+	}
+	if strings.Contains(err.Error(), "TLS handshake") {
+		return "TLS handshake"
+	}
+	return "connect"
+}
+
+// bodyTimeout converts a deadline hit while reading a response body into a
+// timeoutError
+func bodyTimeout(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	t, _ := ctx.Value(timeoutsKey{}).(upstreamTimeouts)
+	return &timeoutError{phase: "response body", after: t.Total}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRequestTimeouts checks the stream first-byte deadline is its own
+// setting, not the connect timeout, and X-Request-Timeout only shortens
+func TestRequestTimeouts(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		stream bool
+		header string
+		want   upstreamTimeouts
+	}{
+		{"stream defaults", Config{}, true, "", upstreamTimeouts{Connect: 30 * time.Second, FirstByte: 300 * time.Second}},
+		{"stream short connect", Config{ConnectTimeoutSec: 5}, true, "", upstreamTimeouts{Connect: 5 * time.Second, FirstByte: 300 * time.Second}},
+		{"stream first byte set", Config{StreamFirstByteSec: 600}, true, "", upstreamTimeouts{Connect: 30 * time.Second, FirstByte: 600 * time.Second}},
+		{"stream header shortens", Config{}, true, "90", upstreamTimeouts{Connect: 30 * time.Second, FirstByte: 90 * time.Second, Total: 90 * time.Second}},
+		{"non-stream defaults", Config{}, false, "", upstreamTimeouts{Connect: 30 * time.Second, Total: 120 * time.Second}},
+		{"non-stream set", Config{RequestTimeoutSec: 60, StreamFirstByteSec: 600}, false, "", upstreamTimeouts{Connect: 30 * time.Second, Total: 60 * time.Second}},
+		{"header can't lengthen", Config{RequestTimeoutSec: 60}, false, "600", upstreamTimeouts{Connect: 30 * time.Second, Total: 60 * time.Second}},
+		{"bad header ignored", Config{}, false, "soon", upstreamTimeouts{Connect: 30 * time.Second, Total: 120 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Request-Timeout", tt.header)
+			}
+			if got := requestTimeouts(r, tt.config, tt.stream); got != tt.want {
+				t.Errorf("requestTimeouts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// hourlyBuckets and dailyBuckets are how far back each series goes
+	hourlyBuckets = 48
+	dailyBuckets  = 30
+)
+
+// usageBucket counts the activity of one hour or day. Tokens include
+// estimates for responses that came back without usage.
+type usageBucket struct {
+	Start            time.Time `json:"timestamp"`
+	Messages         int       `json:"messages"`
+	PromptTokens     int       `json:"promptTokens"`
+	CompletionTokens int       `json:"completionTokens"`
+	Errors           int       `json:"errors"`
+}
+
+// usageSeries keeps rolling hourly and daily buckets in device local time.
+// Buckets are keyed by their start time, so they stay correct across
+// restarts and simply age out.
+type usageSeries struct {
+	Hourly []usageBucket `json:"hourly"`
+	Daily  []usageBucket `json:"daily"`
+}
+
+// startOfHour and startOfDay truncate t in its own location; time.Truncate
+// would work in UTC and misplace buckets in zones with half-hour offsets
+func startOfHour(t time.Time) time.Time {
+	// Round(0) drops the monotonic reading, which would otherwise make
+	// starts computed from different times compare unequal
+	t = t.Round(0)
+	return t.Add(-time.Duration(t.Minute())*time.Minute -
+		time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// bucketAt returns the bucket starting at start, appending it if needed,
+// and drops buckets older than oldest
+func bucketAt(buckets []usageBucket, start, oldest time.Time) ([]usageBucket, *usageBucket) {
+	i := 0
+	for i < len(buckets) && buckets[i].Start.Before(oldest) {
+		i++
+	}
+	buckets = buckets[i:]
+	for j := len(buckets) - 1; j >= 0; j-- {
+		if buckets[j].Start.Equal(start) {
+			return buckets, &buckets[j]
+		}
+	}
+	buckets = append(buckets, usageBucket{Start: start})
+	return buckets, &buckets[len(buckets)-1]
+}
+
+// add applies fn to the current hourly and daily buckets
+func (s *usageSeries) add(now time.Time, fn func(b *usageBucket)) {
+	hour := startOfHour(now)
+	day := startOfDay(now)
+
+	var b *usageBucket
+	s.Hourly, b = bucketAt(s.Hourly, hour, hour.Add(-(hourlyBuckets-1)*time.Hour))
+	fn(b)
+	s.Daily, b = bucketAt(s.Daily, day, day.AddDate(0, 0, -(dailyBuckets-1)))
+	fn(b)
+}
+
+// series returns the buckets of one granularity ending now, oldest first,
+// with empty buckets filled in so charts get a continuous axis
+func (s *usageSeries) series(now time.Time, daily bool) []usageBucket {
+	stored, n := s.Hourly, hourlyBuckets
+	start := startOfHour(now).Add(-(hourlyBuckets - 1) * time.Hour)
+	step := func(t time.Time) time.Time { return t.Add(time.Hour) }
+	if daily {
+		stored, n = s.Daily, dailyBuckets
+		start = startOfDay(now).AddDate(0, 0, -(dailyBuckets - 1))
+		step = func(t time.Time) time.Time { return startOfDay(t.AddDate(0, 0, 1)) }
+	}
+
+	byStart := make(map[int64]usageBucket, len(stored))
+	for _, b := range stored {
+		byStart[b.Start.Unix()] = b
+	}
+	out := make([]usageBucket, 0, n)
+	for t := start; !t.After(now); t = step(t) {
+		b, ok := byStart[t.Unix()]
+		if !ok {
+			b = usageBucket{Start: t}
+		}
+		b.Start = b.Start.In(now.Location())
+		out = append(out, b)
+	}
+	return out
+}
+
+// recordSeriesLocked adds to the current buckets and marks them, and the
+// budget that changes along with them, for the next flush. Callers must
+// hold a.mu.
+func (a *App) recordSeriesLocked(fn func(b *usageBucket)) {
+	a.series.add(time.Now(), fn)
+	a.usageDirty = true
+}
+
+func (a *App) seriesPath() string {
+	return filepath.Join(a.settingsDir, "timeseries.json")
+}
+
+func (a *App) loadSeries() {
+	data, err := os.ReadFile(a.seriesPath())
+	if err != nil {
+		return
+	}
+	var saved usageSeries
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return
+	}
+	a.mu.Lock()
+	a.series = saved
+	a.mu.Unlock()
+}
+
+func (a *App) saveSeries() error {
+	a.mu.RLock()
+	data, err := json.Marshal(a.series)
+	a.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(a.seriesPath(), data, 0644)
+}
+
+// handleTimeseries returns hourly (default) or daily usage buckets
+func (a *App) handleTimeseries(w http.ResponseWriter, r *http.Request) {
+	granularity := r.URL.Query().Get("granularity")
+	switch granularity {
+	case "":
+		granularity = "hour"
+	case "hour", "day":
+	default:
+		http.Error(w, "granularity must be hour or day", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	a.mu.RLock()
+	buckets := a.series.series(now, granularity == "day")
+	a.mu.RUnlock()
+
+	_, offset := now.Zone()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"granularity":   granularity,
+		"utcOffset":     now.Format("-07:00"),
+		"offsetSeconds": offset,
+		"buckets":       buckets,
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// TokenCounter estimates how many tokens a piece of text uses. The default
+// is a rough characters/4 heuristic; a real BPE tokenizer can be plugged in
+// by assigning tokenCounter.
+type TokenCounter interface {
+	Count(text string) int
+}
+
+// charCounter approximates tokens as four characters each
+type charCounter struct{}
+
+func (charCounter) Count(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// tokenCounter is the estimator used throughout the proxy
+var tokenCounter TokenCounter = charCounter{}
+
+// messageOverhead approximates the per-message framing tokens added by chat templates
+const messageOverhead = 4
+
+// messageText returns the text of a chat message, including text content
+// parts and tool call arguments
+func messageText(msg map[string]interface{}) string {
+	var text string
+	switch content := msg["content"].(type) {
+	case string:
+		text = content
+	case []interface{}:
+		for _, p := range content {
+			if part, ok := p.(map[string]interface{}); ok {
+				if t, ok := part["text"].(string); ok {
+					text += t
+				}
+			}
+		}
+	}
+
+	if calls, ok := msg["tool_calls"].([]interface{}); ok {
+		for _, c := range calls {
+			call, _ := c.(map[string]interface{})
+			fn, _ := call["function"].(map[string]interface{})
+			if args, ok := fn["arguments"].(string); ok {
+				text += args
+			}
+		}
+	}
+	return text
+}
+
+// estimateMessageTokens estimates the prompt tokens of one chat message
+func estimateMessageTokens(msg map[string]interface{}) int {
+	return tokenCounter.Count(messageText(msg)) + messageOverhead
+}
+
+// estimateMessagesTokens estimates the prompt tokens of a message list
+func estimateMessagesTokens(messages []interface{}) int {
+	total := 0
+	for _, m := range messages {
+		if msg, ok := m.(map[string]interface{}); ok {
+			total += estimateMessageTokens(msg)
+		}
+	}
+	return total
+}
+
+// estimatePromptTokens estimates the prompt tokens of an upstream payload,
+// either a chat message list or a legacy completion prompt
+func estimatePromptTokens(payload map[string]interface{}) int {
+	if messages, ok := payload["messages"].([]interface{}); ok {
+		return estimateMessagesTokens(messages)
+	}
+	if prompt, ok := payload["prompt"].(string); ok {
+		return tokenCounter.Count(prompt)
+	}
+	return 0
+}
+
+// choiceText returns the generated text of a response choice or stream
+// chunk choice: message, delta or legacy text, reasoning included
+func choiceText(choice map[string]interface{}) string {
+	var text string
+	for _, field := range []string{"message", "delta"} {
+		if msg, ok := choice[field].(map[string]interface{}); ok {
+			text += messageText(msg)
+			if rc, ok := msg["reasoning_content"].(string); ok {
+				text += rc
+			}
+		}
+	}
+	if t, ok := choice["text"].(string); ok {
+		text += t
+	}
+	return text
+}
+
+// responseText concatenates the generated text of every choice in a
+// response or chunk
+func responseText(resp map[string]interface{}) string {
+	var text string
+	choices, _ := resp["choices"].([]interface{})
+	for _, c := range choices {
+		if choice, ok := c.(map[string]interface{}); ok {
+			text += choiceText(choice)
+		}
+	}
+	return text
+}
+
+// handleEstimateTokens estimates the prompt tokens of a message list (or a
+// plain prompt) so clients can budget their context before sending it
+func (a *App) handleEstimateTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Messages []interface{} `json:"messages"`
+		Prompt   string        `json:"prompt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	perMessage := make([]int, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		msg, _ := m.(map[string]interface{})
+		perMessage = append(perMessage, estimateMessageTokens(msg))
+	}
+
+	total := estimateMessagesTokens(req.Messages)
+	if req.Prompt != "" {
+		total += tokenCounter.Count(req.Prompt)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tokens":    total,
+		"messages":  perMessage,
+		"estimated": true,
+	})
+}
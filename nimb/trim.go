@@ -0,0 +1,104 @@
+package main
+
+import "fmt"
+
+// Context handling modes for conversations longer than ContextSize
+const (
+	ContextModePassthrough = "passthrough" // forward as-is
+	ContextModeTrim        = "trim"        // drop the oldest turns until it fits
+	ContextModeReject      = "reject"      // fail with 400
+)
+
+// contextBudget returns the prompt tokens available for a request: the
+// context size minus the tokens reserved for the completion
+func contextBudget(nimReq map[string]interface{}, config Config) int {
+	reserved := 0
+	if maxTok, ok := nimReq["max_tokens"].(int); ok {
+		reserved = maxTok
+	}
+	return config.ContextSize - reserved
+}
+
+// trimMessages drops the oldest non-system messages until the estimate fits
+// within budget. System messages and the most recent user message (with
+// everything after it) are always kept, and tool results are dropped along
+// with the assistant turn that requested them. It returns the remaining
+// messages and how many were removed.
+func trimMessages(messages []interface{}, budget int) ([]interface{}, int) {
+	total := estimateMessagesTokens(messages)
+	if total <= budget {
+		return messages, 0
+	}
+
+	lastUser := -1
+	for i, m := range messages {
+		if msg, ok := m.(map[string]interface{}); ok && msg["role"] == "user" {
+			lastUser = i
+		}
+	}
+
+	drop := make([]bool, len(messages))
+	removed := 0
+	for i := 0; i < len(messages) && total > budget; i++ {
+		if lastUser >= 0 && i >= lastUser {
+			break
+		}
+		msg, _ := messages[i].(map[string]interface{})
+		if msg["role"] == "system" || drop[i] {
+			continue
+		}
+
+		drop[i] = true
+		removed++
+		total -= estimateMessageTokens(msg)
+
+		// Tool results are meaningless without the call that produced them
+		for j := i + 1; j < len(messages) && (lastUser < 0 || j < lastUser); j++ {
+			next, _ := messages[j].(map[string]interface{})
+			if next["role"] != "tool" {
+				break
+			}
+			drop[j] = true
+			removed++
+			total -= estimateMessageTokens(next)
+		}
+	}
+
+	kept := make([]interface{}, 0, len(messages)-removed)
+	for i, m := range messages {
+		if !drop[i] {
+			kept = append(kept, m)
+		}
+	}
+	return kept, removed
+}
+
+// applyContextLimit enforces Config.ContextSize on an upstream payload
+// according to Config.ContextMode. It returns the number of messages trimmed,
+// or a request error when the conversation is rejected.
+func applyContextLimit(nimReq map[string]interface{}, config Config) (int, *requestError) {
+	if config.ContextSize <= 0 || (config.ContextMode != ContextModeTrim && config.ContextMode != ContextModeReject) {
+		return 0, nil
+	}
+	messages, ok := nimReq["messages"].([]interface{})
+	if !ok {
+		return 0, nil
+	}
+
+	budget := contextBudget(nimReq, config)
+	if config.ContextMode == ContextModeReject {
+		if total := estimateMessagesTokens(messages); total > budget {
+			return 0, &requestError{
+				fmt.Sprintf("conversation is about %d tokens, which exceeds the %d tokens available", total, budget),
+				"messages",
+			}
+		}
+		return 0, nil
+	}
+
+	kept, removed := trimMessages(messages, budget)
+	if removed > 0 {
+		nimReq["messages"] = kept
+	}
+	return removed, nil
+}
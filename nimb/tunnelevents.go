@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Tunnel transitions sent on /api/tunnel/events
+const (
+	TunnelEventStarting = "starting"     // a tunnel process was launched
+	TunnelEventURL      = "url-acquired" // its public URL is known
+	TunnelEventRunning  = "running"      // the URL answers, again or for the first time
+	TunnelEventDegraded = "degraded"     // the URL stopped answering
+	TunnelEventStopped  = "stopped"      // stopped by hand
+	TunnelEventError    = "error"        // failed to start or died on its own
+)
+
+// maxTunnelTransitions is how many transitions are kept for followers
+// that fall behind
+const maxTunnelTransitions = 100
+
+// tunnelTransition is one change of tunnel state
+type tunnelTransition struct {
+	Seq   int64  `json:"seq"`
+	Time  string `json:"time"`
+	Event string `json:"event"`
+	URL   string `json:"url,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// tunnelTransitions publishes tunnel state changes to the followers of
+// /api/tunnel/events. Like tunnelLog, followers are woken through changes
+// and catch up by sequence number, so a slow one doesn't hold up the
+// tunnel code.
+type tunnelTransitions struct {
+	mu      sync.Mutex
+	events  []tunnelTransition
+	seq     int64
+	changes statsHub
+}
+
+// publish records a transition. It doesn't block and is safe to call while
+// holding a.tunnel.mu, which keeps transitions in the order they happened.
+func (t *tunnelTransitions) publish(event, url, errText string) {
+	t.mu.Lock()
+	t.seq++
+	t.events = append(t.events, tunnelTransition{
+		Seq:   t.seq,
+		Time:  time.Now().Format(time.RFC3339),
+		Event: event,
+		URL:   url,
+		Error: scrubSecrets(errText),
+	})
+	if over := len(t.events) - maxTunnelTransitions; over > 0 {
+		t.events = append(t.events[:0:0], t.events[over:]...)
+	}
+	t.mu.Unlock()
+	t.changes.publish()
+}
+
+// latest returns the sequence number of the newest transition
+func (t *tunnelTransitions) latest() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.seq
+}
+
+// since returns the transitions after seq
+func (t *tunnelTransitions) since(seq int64) []tunnelTransition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, event := range t.events {
+		if event.Seq > seq {
+			return append([]tunnelTransition{}, t.events[i:]...)
+		}
+	}
+	return nil
+}
+
+// handleTunnelEvents streams tunnel state changes as server-sent events,
+// named after the transition. A "state" event with the current status
+// comes first, so a client needs no separate status request.
+func (a *App) handleTunnelEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", 500)
+		return
+	}
+
+	changes := a.tunnelTransitions.changes.subscribe()
+	defer a.tunnelTransitions.changes.unsubscribe(changes)
+	// Taken before the snapshot: a transition in between is sent twice
+	// rather than missed
+	last := a.tunnelTransitions.latest()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	write := func(event string, v interface{}) bool {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return true
+		}
+		_, err = w.Write([]byte("event: " + event + "\ndata: " + string(data) + "\n\n"))
+		return err == nil
+	}
+
+	a.tunnel.mu.Lock()
+	status, url := a.tunnelStatusLocked()
+	lastError := a.tunnel.LastExitError
+	a.tunnel.mu.Unlock()
+	if !write("state", map[string]interface{}{"status": status, "url": url, "error": lastError}) {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(statsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.draining:
+			return
+		case <-changes:
+			for _, event := range a.tunnelTransitions.since(last) {
+				if !write(event.Event, event) {
+					return
+				}
+				last = event.Seq
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
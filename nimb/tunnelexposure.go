@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Tunnel exposure modes for Config.TunnelExposure
+const (
+	TunnelExposureProxyOnly = "proxy-only" // only the proxy endpoints answer through the tunnel
+	TunnelExposureFull      = "full"       // the tunnel serves everything, UI included
+)
+
+// tunnelConnKey marks requests that arrived on the tunnel backend
+type tunnelConnKey struct{}
+
+// fromTunnel reports whether a request came in through a tunnel:
+// cloudflared adds CF-Connecting-IP and Cf-Ray to everything it forwards,
+// the other providers connect to the tunnel backend
+func fromTunnel(r *http.Request) bool {
+	return onTunnelBackend(r) || r.Header.Get("CF-Connecting-IP") != "" || r.Header.Get("Cf-Ray") != ""
+}
+
+// onTunnelBackend reports whether r arrived on the tunnel backend
+func onTunnelBackend(r *http.Request) bool {
+	return r.Context().Value(tunnelConnKey{}) != nil
+}
+
+// runningTunnelProvider names the provider of the running tunnel, empty
+// when none runs
+func (a *App) runningTunnelProvider() string {
+	a.tunnel.mu.Lock()
+	defer a.tunnel.mu.Unlock()
+	switch a.tunnel.provider.(type) {
+	case *cloudflaredTunnel:
+		return TunnelProviderCloudflare
+	case *ngrokTunnel:
+		return TunnelProviderNgrok
+	case *sshTunnel:
+		return TunnelProviderSSH
+	}
+	return ""
+}
+
+// tunnelBackend returns the address of a loopback listener that serves
+// the app to ngrok and ssh. Both connect from this device, so a separate
+// port is what tells their visitors apart from the owner. It is opened on
+// first use and kept.
+func (a *App) tunnelBackend() (string, error) {
+	a.tunnelListener.Lock()
+	defer a.tunnelListener.Unlock()
+	if a.tunnelListener.addr != "" {
+		return a.tunnelListener.addr, nil
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	server := &http.Server{
+		Handler: a.handler,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, tunnelConnKey{}, true)
+		},
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logErrorf("Tunnel backend error: %v", err)
+		}
+	}()
+	a.tunnelListener.addr = listener.Addr().String()
+	a.tunnelListener.server = server
+	return a.tunnelListener.addr, nil
+}
+
+// tunnelPublic reports whether a path is served through the tunnel in
+// proxy-only mode: the OpenAI and Ollama compatible endpoints
+func tunnelPublic(path string) bool {
+	return strings.HasPrefix(path, "/v1/") || path == "/api/chat" || path == "/api/tags"
+}
+
+// tunnelExposure returns the configured mode, proxy-only when unset
+func (a *App) tunnelExposure() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.config.TunnelExposure == TunnelExposureFull {
+		return TunnelExposureFull
+	}
+	return TunnelExposureProxyOnly
+}
+
+// restrictTunnel refuses admin routes and the UI to tunnel visitors unless
+// TunnelExposure is full. Local and LAN clients are not affected.
+func (a *App) restrictTunnel(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fromTunnel(r) && !tunnelPublic(r.URL.Path) && r.Method != "OPTIONS" && !isTunnelProbe(r) && a.tunnelExposure() != TunnelExposureFull {
+			writeAPIError(w, 403, "Only the proxy endpoints are reachable through the tunnel", "permission_error")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestRestrictTunnel simulates requests with and without the headers
+// cloudflared adds, in both exposure modes
+func TestRestrictTunnel(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		cf       bool
+		backend  bool
+		probe    bool
+		exposure string
+		status   int
+	}{
+		{"local admin", "GET", "/api/config", false, false, false, "", 200},
+		{"local UI", "GET", "/", false, false, false, "", 200},
+		{"tunnel admin", "GET", "/api/config", true, false, false, "", 403},
+		{"tunnel UI", "GET", "/settings", true, false, false, "", 403},
+		{"tunnel chat", "POST", "/v1/chat/completions", true, false, false, "", 200},
+		{"tunnel models", "GET", "/v1/models", true, false, false, "", 200},
+		{"tunnel ollama chat", "POST", "/api/chat", true, false, false, "", 200},
+		{"tunnel ollama tags", "GET", "/api/tags", true, false, false, "", 200},
+		{"tunnel preflight", "OPTIONS", "/api/config", true, false, false, "", 200},
+		{"tunnel probe", "GET", "/health", true, false, true, "", 200},
+		{"tunnel backend admin", "GET", "/api/config", false, true, false, "", 403},
+		{"tunnel admin, proxy-only", "GET", "/api/config", true, false, false, TunnelExposureProxyOnly, 403},
+		{"tunnel admin, full", "GET", "/api/config", true, false, false, TunnelExposureFull, 200},
+		{"tunnel backend UI, full", "GET", "/", false, true, false, TunnelExposureFull, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, nil)
+			a.config.TunnelExposure = tt.exposure
+			handler := a.restrictTunnel(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.cf {
+				r.Header.Set("CF-Connecting-IP", "203.0.113.7")
+				r.Header.Set("Cf-Ray", "8a1b2c3d4e5f-LHR")
+			}
+			if tt.backend {
+				r = r.WithContext(context.WithValue(r.Context(), tunnelConnKey{}, true))
+			}
+			if tt.probe {
+				r.Header.Set(tunnelProbeHeader, tunnelProbeToken)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+// TestClientIP checks the address a request is attributed to: the
+// connection's, unless a running tunnel says who it forwarded
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		remote   string
+		headers  map[string]string
+		backend  bool
+		provider TunnelProvider
+		want     string
+	}{
+		{"direct", "192.168.1.20:5000", nil, false, nil, "192.168.1.20"},
+		{"no port", "192.168.1.20", nil, false, nil, "192.168.1.20"},
+		{"cloudflared", "127.0.0.1:5000", map[string]string{"CF-Connecting-IP": "203.0.113.7"}, false, &cloudflaredTunnel{}, "203.0.113.7"},
+		{"cloudflared bad header", "127.0.0.1:5000", map[string]string{"CF-Connecting-IP": "nope"}, false, &cloudflaredTunnel{}, "0.0.0.0"},
+		{"spoofed from the LAN", "192.168.1.20:5000", map[string]string{"CF-Connecting-IP": "127.0.0.1"}, false, &cloudflaredTunnel{}, "192.168.1.20"},
+		{"spoofed without a tunnel", "127.0.0.1:5000", map[string]string{"CF-Connecting-IP": "203.0.113.7"}, false, nil, "127.0.0.1"},
+		{"ngrok", "127.0.0.1:5000", map[string]string{"X-Forwarded-For": "10.0.0.1, 203.0.113.8"}, true, &ngrokTunnel{}, "203.0.113.8"},
+		{"ngrok without header", "127.0.0.1:5000", nil, true, &ngrokTunnel{}, "0.0.0.0"},
+		{"ssh", "127.0.0.1:5000", map[string]string{"X-Forwarded-For": "203.0.113.8"}, true, &sshTunnel{}, "0.0.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, nil)
+			a.tunnel.provider = tt.provider
+			r := httptest.NewRequest("GET", "/v1/models", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if tt.backend {
+				r = r.WithContext(context.WithValue(r.Context(), tunnelConnKey{}, true))
+			}
+			if got := a.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTunnelStatusExposure(t *testing.T) {
+	for _, mode := range []string{"", TunnelExposureProxyOnly, TunnelExposureFull} {
+		a := newTestApp(t, nil)
+		a.config.TunnelExposure = mode
+		want := mode
+		if want == "" {
+			want = TunnelExposureProxyOnly
+		}
+		body := decodeBody(t, serve(a.handleTunnelStatus, "GET", "/api/tunnel/status", "").Body.String())
+		if body["exposure"] != want {
+			t.Errorf("TunnelExposure %q: exposure = %v, want %s", mode, body["exposure"], want)
+		}
+	}
+}
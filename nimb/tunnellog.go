@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxTunnelLogLines is how much tunnel output is kept
+	maxTunnelLogLines      = 500
+	defaultTunnelLogLimit  = 100
+	tunnelLogStreamBacklog = 50
+)
+
+// tunnelLogLine is one line of tunnel output. Stream is stdout or stderr,
+// or nimb for NIMB's own notes about the tunnel.
+type tunnelLogLine struct {
+	Seq    int64  `json:"seq"`
+	Time   string `json:"time"`
+	Stream string `json:"stream"`
+	Line   string `json:"line"`
+}
+
+// tunnelLog keeps the latest tunnel output. It is cleared when a tunnel
+// starts and kept when it stops, so a failed start can be looked into.
+type tunnelLog struct {
+	mu    sync.Mutex
+	lines []tunnelLogLine
+	seq   int64
+	// changes signals followers of /api/tunnel/logs/stream
+	changes statsHub
+}
+
+// add records a line with secrets masked
+func (l *tunnelLog) add(stream, line string) {
+	l.mu.Lock()
+	l.seq++
+	l.lines = append(l.lines, tunnelLogLine{
+		Seq:    l.seq,
+		Time:   time.Now().Format(time.RFC3339),
+		Stream: stream,
+		Line:   scrubSecrets(line),
+	})
+	if over := len(l.lines) - maxTunnelLogLines; over > 0 {
+		l.lines = append(l.lines[:0:0], l.lines[over:]...)
+	}
+	l.mu.Unlock()
+	l.changes.publish()
+}
+
+// reset drops the kept lines. Sequence numbers keep counting, so a
+// follower never mistakes new lines for ones it has sent.
+func (l *tunnelLog) reset() {
+	l.mu.Lock()
+	l.lines = nil
+	l.mu.Unlock()
+	l.changes.publish()
+}
+
+// tail returns up to limit of the newest lines
+func (l *tunnelLog) tail(limit int) []tunnelLogLine {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	start := max(len(l.lines)-limit, 0)
+	return append([]tunnelLogLine{}, l.lines[start:]...)
+}
+
+// since returns the lines after seq
+func (l *tunnelLog) since(seq int64) []tunnelLogLine {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, line := range l.lines {
+		if line.Seq > seq {
+			return append([]tunnelLogLine{}, l.lines[i:]...)
+		}
+	}
+	return nil
+}
+
+// handleTunnelLogs returns the newest tunnel output; ?limit= caps how many
+// lines (default 100)
+func (a *App) handleTunnelLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultTunnelLogLimit
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.tunnelLog.tail(limit))
+}
+
+// handleTunnelLogStream sends the last ?limit= lines (default 50) as
+// server-sent events and then follows new ones
+func (a *App) handleTunnelLogStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", 500)
+		return
+	}
+
+	limit := tunnelLogStreamBacklog
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n >= 0 {
+		limit = n
+	}
+
+	changes := a.tunnelLog.changes.subscribe()
+	defer a.tunnelLog.changes.unsubscribe(changes)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	var last int64
+	send := func(lines []tunnelLogLine) bool {
+		for _, line := range lines {
+			data, err := json.Marshal(line)
+			if err != nil {
+				continue
+			}
+			if _, err := w.Write([]byte("event: line\ndata: " + string(data) + "\n\n")); err != nil {
+				return false
+			}
+			last = line.Seq
+		}
+		flusher.Flush()
+		return true
+	}
+	backlog := a.tunnelLog.tail(limit)
+	if limit == 0 {
+		backlog = nil
+		a.tunnelLog.mu.Lock()
+		last = a.tunnelLog.seq
+		a.tunnelLog.mu.Unlock()
+	}
+	if !send(backlog) {
+		return
+	}
+
+	heartbeat := time.NewTicker(statsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.draining:
+			return
+		case <-changes:
+			if !send(a.tunnelLog.since(last)) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// termuxCommandTimeout bounds each termux-api call; they hang when the
+// Termux:API app isn't installed
+const termuxCommandTimeout = 10 * time.Second
+
+// termuxAPIHint is shown when the termux-api commands can't be found
+const termuxAPIHint = "Install with: pkg install termux-api, plus the Termux:API app"
+
+// tunnelNotice remembers the last tunnel URL announced and how that went,
+// shown in /api/tunnel/status
+type tunnelNotice struct {
+	mu           sync.Mutex
+	url          string
+	at           time.Time
+	notification string
+	clipboard    string
+	webhook      string
+}
+
+// status copies the last result for encoding
+func (n *tunnelNotice) status() map[string]interface{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.url == "" {
+		return nil
+	}
+	return map[string]interface{}{
+		"url":          n.url,
+		"at":           n.at.Format(time.RFC3339),
+		"notification": n.notification,
+		"clipboard":    n.clipboard,
+		"webhook":      n.webhook,
+	}
+}
+
+// announceTunnelURL tells the user about a new tunnel URL: a tunnel_url
+// webhook when one is set up, and with NotifyOnTunnelURL a notification
+// and the clipboard on Termux. A restart that keeps the URL, as named
+// tunnels do, announces nothing. It returns at once.
+func (a *App) announceTunnelURL(url string) {
+	a.tunnelNotice.mu.Lock()
+	if url == a.tunnelNotice.url {
+		a.tunnelNotice.mu.Unlock()
+		return
+	}
+	a.tunnelNotice.url, a.tunnelNotice.at = url, time.Now()
+	a.tunnelNotice.notification, a.tunnelNotice.clipboard = "disabled", "disabled"
+	a.tunnelNotice.webhook = "not configured"
+	a.tunnelNotice.mu.Unlock()
+
+	a.mu.RLock()
+	enabled := a.config.NotifyOnTunnelURL
+	webhook := a.config.WebhookURL != "" && slices.Contains(a.config.WebhookEvents, EventTunnelURL)
+	a.mu.RUnlock()
+
+	if webhook {
+		a.setNotice(url, func(n *tunnelNotice) { n.webhook = "sending" })
+		go func() {
+			result := "ok"
+			if err := a.sendWebhook(EventTunnelURL, "Tunnel URL is now "+url, time.Now()); err != nil {
+				result = err.Error()
+			}
+			a.setNotice(url, func(n *tunnelNotice) { n.webhook = result })
+		}()
+	}
+	if !enabled {
+		return
+	}
+	go func() {
+		notification := runTermux("termux-notification", "",
+			"--id", "nimb-tunnel", "--title", "NIMB tunnel URL", "--content", url)
+		clipboard := runTermux("termux-clipboard-set", url)
+		a.setNotice(url, func(n *tunnelNotice) {
+			n.notification, n.clipboard = notification, clipboard
+		})
+	}()
+}
+
+// setNotice updates the result for url unless a newer URL replaced it
+func (a *App) setNotice(url string, update func(n *tunnelNotice)) {
+	a.tunnelNotice.mu.Lock()
+	defer a.tunnelNotice.mu.Unlock()
+	if a.tunnelNotice.url == url {
+		update(&a.tunnelNotice)
+	}
+}
+
+// runTermux runs a termux-api command found by absolute path, with stdin
+// as its input, and describes the outcome: "ok" or what went wrong
+func runTermux(name, stdin string, args ...string) string {
+	path, err := findTunnelBinary("", name, termuxAPIHint)
+	if err != nil {
+		return err.Error()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), termuxCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		msg := strings.TrimSpace(string(out))
+		if ctx.Err() != nil {
+			msg = "timed out; is the Termux:API app installed?"
+		} else if msg == "" {
+			msg = err.Error()
+		}
+		logWarnf("%s failed: %s", name, msg)
+		return msg
+	}
+	return "ok"
+}
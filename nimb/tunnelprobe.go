@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	tunnelProbeTimeout = 10 * time.Second
+	// Probe often until the tunnel answers, then just keep an eye on it
+	tunnelProbeStartup  = 5 * time.Second
+	tunnelProbeInterval = 30 * time.Second
+	// tunnelProbeGrace is how long a new tunnel may go unanswered, as
+	// DNS for it spreads, before failed checks count
+	tunnelProbeGrace = time.Minute
+	// tunnelDegradedAfter is how many failed checks in a row make a
+	// tunnel degraded
+	tunnelDegradedAfter = 3
+)
+
+// tunnelProbeHeader marks NIMB's own checks of the tunnel URL. The value
+// is a secret made at startup, so visitors can't pass as the probe.
+const tunnelProbeHeader = "X-NIMB-Probe"
+
+var tunnelProbeToken = func() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}()
+
+// isTunnelProbe reports whether r is one of NIMB's own tunnel checks
+func isTunnelProbe(r *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(tunnelProbeHeader)), []byte(tunnelProbeToken)) == 1
+}
+
+// tunnelProbe is what the checks of the public URL found
+type tunnelProbe struct {
+	Reachable   bool
+	LastSuccess time.Time
+	LatencyMs   int64
+	// Failures counts failed checks in a row, once the tunnel has
+	// answered or tunnelProbeGrace has passed
+	Failures  int
+	LastError string
+}
+
+// status is the probe part of /api/tunnel/status
+func (p tunnelProbe) status() map[string]interface{} {
+	lastSuccess := ""
+	if !p.LastSuccess.IsZero() {
+		lastSuccess = p.LastSuccess.Format(time.RFC3339)
+	}
+	return map[string]interface{}{
+		"reachable":   p.Reachable,
+		"lastSuccess": lastSuccess,
+		"latencyMs":   p.LatencyMs,
+		"failures":    p.Failures,
+		"lastError":   p.LastError,
+	}
+}
+
+// tunnelProbeSettings returns the probe interval and whether an
+// unreachable tunnel is restarted
+func (a *App) tunnelProbeSettings() (time.Duration, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	interval := tunnelProbeInterval
+	if a.config.TunnelProbeIntervalSec > 0 {
+		interval = time.Duration(a.config.TunnelProbeIntervalSec) * time.Second
+	}
+	return interval, a.config.TunnelProbeRestart
+}
+
+// checkTunnel requests the tunnel's /health through the internet, using
+// the upstream client and so its resolver. Any answer from NIMB counts;
+// Cloudflare and ngrok answer 5xx themselves when the tunnel is down.
+func (a *App) checkTunnel(url string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tunnelProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url+"/health", nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(tunnelProbeHeader, tunnelProbeToken)
+	start := time.Now()
+	resp, err := a.upstreamClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return 0, fmt.Errorf("tunnel answered %d", resp.StatusCode)
+	}
+	return time.Since(start), nil
+}
+
+// probeTunnel checks the public URL while provider runs. The status reads
+// "running" until the first answer, then "connected", or "degraded" after
+// tunnelDegradedAfter failed checks in a row; with Config.TunnelProbeRestart
+// a degraded tunnel is restarted.
+func (a *App) probeTunnel(provider TunnelProvider, url string) {
+	started := time.Now()
+	interval := tunnelProbeStartup
+	for {
+		time.Sleep(interval)
+		latency, err := a.checkTunnel(url)
+		every, restart := a.tunnelProbeSettings()
+		_, maxRestarts := a.tunnelRestartSettings()
+
+		a.tunnel.mu.Lock()
+		if a.tunnel.provider != provider {
+			a.tunnel.mu.Unlock()
+			return
+		}
+		probe := &a.tunnel.probe
+		if err == nil {
+			if !probe.Reachable {
+				logInfof("Tunnel connected: %s", url)
+				a.tunnelLog.add("nimb", "Tunnel connected: "+url)
+				a.tunnelTransitions.publish(TunnelEventRunning, url, "")
+			}
+			*probe = tunnelProbe{Reachable: true, LastSuccess: time.Now(), LatencyMs: latency.Milliseconds()}
+			a.tunnel.mu.Unlock()
+			interval = every
+			continue
+		}
+
+		if probe.Reachable {
+			logWarnf("Tunnel stopped answering: %s %v", url, err)
+			a.tunnelLog.add("nimb", "Tunnel stopped answering: "+err.Error())
+		}
+		probe.Reachable = false
+		probe.LastError = err.Error()
+		if !probe.LastSuccess.IsZero() || time.Since(started) > tunnelProbeGrace {
+			probe.Failures++
+			interval = every
+			if probe.Failures == tunnelDegradedAfter {
+				a.tunnelTransitions.publish(TunnelEventDegraded, url, err.Error())
+			}
+		}
+		if probe.Failures >= tunnelDegradedAfter && restart {
+			reason := fmt.Sprintf("Tunnel URL unreachable after %d checks: %s", probe.Failures, err)
+			a.tunnelLog.add("nimb", reason)
+			a.tunnelTransitions.publish(TunnelEventError, url, reason)
+			a.tunnel.provider = nil
+			go provider.Stop()
+			// Restarting is what was asked for, whatever tunnelAutoRestart says
+			a.tunnelFailedLocked(reason, true, maxRestarts)
+			a.tunnel.mu.Unlock()
+			return
+		}
+		a.tunnel.mu.Unlock()
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Tunnel providers
+const (
+	TunnelProviderCloudflare = "cloudflare" // cloudflared, quick or named tunnel
+	TunnelProviderNgrok      = "ngrok"      // the ngrok agent
+	TunnelProviderSSH        = "ssh"        // ssh -R to a server of your own
+)
+
+// TunnelProvider runs one kind of tunnel. A provider is used for a single
+// Start; StartTunnel makes a new one each time.
+type TunnelProvider interface {
+	// Start launches the tunnel and returns once its process is running.
+	// Events are delivered from other goroutines, never from Start.
+	Start(events tunnelEvents) error
+	// Stop ends the tunnel and waits for it to exit; no exited event
+	// follows. It reports whether the tunnel shut down cleanly rather
+	// than being killed.
+	Stop() bool
+	// Status is "starting", "running" or "stopped"
+	Status() string
+	// URL is the public address, empty until it is known
+	URL() string
+}
+
+// tunnelEvents tell the app what a running tunnel is doing
+type tunnelEvents struct {
+	// up is called once the public URL is known
+	up func(url string)
+	// exited is called when the process ends without Stop
+	exited func(err error)
+	// output gets every line the process writes, stream being stdout or
+	// stderr
+	output func(stream, line string)
+}
+
+// newTunnelProvider builds the provider selected by Config.TunnelProvider
+func (a *App) newTunnelProvider() (TunnelProvider, error) {
+	a.mu.RLock()
+	cfg := a.config
+	a.mu.RUnlock()
+
+	if err := checkTunnelTarget(a.tunnelTarget()); err != nil {
+		return nil, err
+	}
+	switch cfg.TunnelProvider {
+	case TunnelProviderNgrok:
+		return &ngrokTunnel{authtoken: cfg.NgrokAuthtoken, upstream: a.tunnelUpstream()}, nil
+	case TunnelProviderSSH:
+		return newSSHTunnel(cfg, a.tunnelUpstream())
+	}
+	spec, err := a.tunnelSpec()
+	if err != nil {
+		return nil, err
+	}
+	return &cloudflaredTunnel{spec: spec, binDir: a.binDir()}, nil
+}
+
+// tunnelProvider returns the configured provider, cloudflare when unset
+func (a *App) tunnelProvider() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	switch a.config.TunnelProvider {
+	case TunnelProviderNgrok, TunnelProviderSSH:
+		return a.config.TunnelProvider
+	}
+	return TunnelProviderCloudflare
+}
+
+// cloudflaredInstallHint is shown when cloudflared can't be found
+const cloudflaredInstallHint = "Install with: pkg install cloudflared, or use Install cloudflared in the Tunnel panel"
+
+// findTunnelBinary locates a tunnel program, preferring one in preferDir
+// (NIMB's own downloads) when it is set. On Linux/Termux only absolute
+// paths are tried: exec.LookPath uses faccessat2, which Android lacks.
+func findTunnelBinary(preferDir, name, installHint string) (string, error) {
+	if runtime.GOOS == "windows" {
+		exePath, _ := os.Executable()
+		for _, dir := range []string{preferDir, filepath.Dir(exePath)} {
+			path := filepath.Join(dir, name+".exe")
+			if _, err := os.Stat(path); dir != "" && err == nil {
+				return path, nil
+			}
+		}
+		return "", fmt.Errorf("%s not found. Place it next to the executable.", name)
+	}
+	for _, dir := range []string{preferDir, "/data/data/com.termux/files/usr/bin", "/usr/bin", "/usr/local/bin"} {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			logDebugf("Using %s at: %s", name, path)
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found. %s", name, installHint)
+}
+
+// tunnelStopTimeout is how long a tunnel gets to close its connections
+// after SIGTERM before it is killed
+const tunnelStopTimeout = 5 * time.Second
+
+// tunnelProcess is the part shared by the providers: one child process
+// whose output is logged and whose exit is reported. Once started, only
+// the goroutine waiting on the process marks it stopped; Stop signals it
+// and waits for done.
+type tunnelProcess struct {
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	status  string
+	url     string
+	stopped bool
+	events  tunnelEvents
+	// done is closed when the process has exited
+	done chan struct{}
+}
+
+// run starts the process. Every output line is logged and passed to line,
+// which may be nil.
+func (p *tunnelProcess) run(name, path string, args, env []string, events tunnelEvents, line func(string)) error {
+	cmd := exec.Command(path, args...)
+	cmd.Env = env
+	stdout, _ := cmd.StdoutPipe()
+	stderr, _ := cmd.StderrPipe()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Failed to start %s: %w", name, err)
+	}
+
+	done := make(chan struct{})
+	p.mu.Lock()
+	p.cmd = cmd
+	p.status = "starting"
+	p.events = events
+	p.done = done
+	p.mu.Unlock()
+
+	// Tunnel programs may write to either stream
+	forward := func(stream string) func(string) {
+		return func(text string) {
+			events.output(stream, text)
+			if line != nil {
+				line(text)
+			}
+		}
+	}
+	go scanTunnelOutput(stderr, name, forward("stderr"))
+	go scanTunnelOutput(stdout, name, forward("stdout"))
+
+	go func() {
+		err := cmd.Wait()
+		p.mu.Lock()
+		stopped := p.stopped
+		// The URL is kept so the exit can be reported with it
+		p.status = "stopped"
+		p.mu.Unlock()
+		close(done)
+		if stopped {
+			return
+		}
+		if err != nil {
+			events.exited(fmt.Errorf("%s exited: %w", name, err))
+		} else {
+			events.exited(fmt.Errorf("%s exited", name))
+		}
+	}()
+	return nil
+}
+
+// setURL records the public URL and reports it the first time
+func (p *tunnelProcess) setURL(url string) {
+	p.mu.Lock()
+	if p.url != "" || p.status != "starting" {
+		p.mu.Unlock()
+		return
+	}
+	p.url = url
+	p.status = "running"
+	events := p.events
+	p.mu.Unlock()
+	go events.up(url)
+}
+
+func (p *tunnelProcess) Stop() bool {
+	p.mu.Lock()
+	p.stopped = true
+	cmd, done := p.cmd, p.done
+	p.mu.Unlock()
+	if cmd == nil {
+		return true
+	}
+
+	// Windows has no SIGTERM. A process that already exited closes done
+	// right away.
+	if runtime.GOOS != "windows" {
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-done:
+			return true
+		case <-time.After(tunnelStopTimeout):
+			logWarnf("Tunnel did not exit within %s - killing it", tunnelStopTimeout)
+		}
+	}
+	cmd.Process.Kill()
+	<-done
+	return false
+}
+
+func (p *tunnelProcess) Status() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.status == "" {
+		return "stopped"
+	}
+	return p.status
+}
+
+func (p *tunnelProcess) URL() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.url
+}
+
+// scanTunnelOutput logs a tunnel program's output line by line and hands
+// each line to line. Whole lines are matched, so a URL split across reads
+// is not missed.
+func scanTunnelOutput(r io.Reader, name string, line func(string)) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := scanner.Text()
+		logs.write(tunnelOutputLevel(text), name+": "+text)
+		line(text)
+	}
+}
+
+// tunnelOutputLevel picks the log level of a line of tunnel output:
+// cloudflared and ngrok mark warnings and errors, everything else is
+// chatter kept at debug. /api/tunnel/logs has every line regardless.
+func tunnelOutputLevel(text string) string {
+	for _, mark := range []string{" ERR ", " WRN ", "lvl=eror", "lvl=warn"} {
+		if strings.Contains(text, mark) {
+			return LogLevelWarn
+		}
+	}
+	return LogLevelDebug
+}
+
+// cloudflaredTunnel is a Cloudflare quick or named tunnel, see tunnelSpec
+type cloudflaredTunnel struct {
+	tunnelProcess
+	spec tunnelSpec
+	// binDir holds a cloudflared downloaded by NIMB, see installCloudflared
+	binDir string
+}
+
+func (t *cloudflaredTunnel) Start(events tunnelEvents) error {
+	path, err := findTunnelBinary(t.binDir, "cloudflared", cloudflaredInstallHint)
+	if err != nil {
+		return err
+	}
+	var line func(string)
+	if t.spec.hostname == "" {
+		line = func(text string) {
+			if url := tunnelURLFromLine(text); url != "" {
+				t.setURL(url)
+			}
+		}
+	}
+	if err := t.run("cloudflared", path, t.spec.args, t.spec.env, events, line); err != nil {
+		return err
+	}
+	if t.spec.hostname != "" {
+		// A named tunnel's address is known up front; the probe confirms
+		// it is reachable
+		t.setURL("https://" + t.spec.hostname)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTunnelURLFromLine(t *testing.T) {
+	tests := []struct {
+		name, line, want string
+	}{
+		{"banner", "2024-05-01T10:00:00Z INF |  https://quiet-fox-lake.trycloudflare.com                                  |", "https://quiet-fox-lake.trycloudflare.com"},
+		{"no URL", "2024-05-01T10:00:00Z INF Starting tunnel tunnelID=abc", ""},
+		{"API URL", `2024-05-01T10:00:00Z ERR Error unmarshaling QuickTunnel response: error="failed to POST https://api.trycloudflare.com/tunnel"`, ""},
+		{"other host", "see https://example.com for help", ""},
+		{"JSON message", `{"level":"info","time":"2024-05-01T10:00:00Z","message":"Your quick Tunnel has been created! Visit it at https://quiet-fox-lake.trycloudflare.com"}`, "https://quiet-fox-lake.trycloudflare.com"},
+		{"JSON url field", `{"level":"info","url":"https://quiet-fox-lake.trycloudflare.com","msg":"registered"}`, "https://quiet-fox-lake.trycloudflare.com"},
+		{"JSON API URL", `{"level":"error","url":"https://api.trycloudflare.com","message":"request failed"}`, ""},
+		{"broken JSON", `{"message":"https://quiet-fox-lake.trycloudflare.com`, "https://quiet-fox-lake.trycloudflare.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tunnelURLFromLine(tt.line); got != tt.want {
+				t.Errorf("tunnelURLFromLine = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestScanTunnelOutputSplitURL feeds cloudflared output split into two
+// writes at every offset of the URL line, as slow devices deliver it
+func TestScanTunnelOutputSplitURL(t *testing.T) {
+	const url = "https://quiet-fox-lake.trycloudflare.com"
+	output := "2024-05-01T10:00:00Z INF Requesting new quick Tunnel on trycloudflare.com...\n" +
+		"2024-05-01T10:00:01Z INF |  " + url + "  |\n" +
+		"2024-05-01T10:00:02Z INF Registered tunnel connection\n"
+	start := strings.Index(output, url)
+	for split := start - 1; split <= start+len(url); split++ {
+		r, w := io.Pipe()
+		go func() {
+			w.Write([]byte(output[:split]))
+			w.Write([]byte(output[split:]))
+			w.Close()
+		}()
+		var found []string
+		lines := 0
+		scanTunnelOutput(r, "cloudflared", func(text string) {
+			lines++
+			if u := tunnelURLFromLine(text); u != "" {
+				found = append(found, u)
+			}
+		})
+		if lines != 3 || len(found) != 1 || found[0] != url {
+			t.Errorf("split at %d: %d lines, URLs %q", split, lines, found)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// qrQuietZone is the light border scanners need, in modules
+	qrQuietZone = 4
+	// qrScale is the PNG size of one module in pixels
+	qrScale = 8
+)
+
+// qrPNG renders a symbol as a black-on-white PNG
+func qrPNG(q *qrCode) ([]byte, error) {
+	side := (q.size + 2*qrQuietZone) * qrScale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if !q.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < qrScale; dy++ {
+				for dx := 0; dx < qrScale; dx++ {
+					img.SetColorIndex((x+qrQuietZone)*qrScale+dx, (y+qrQuietZone)*qrScale+dy, 1)
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// qrSVG renders a symbol as an SVG path, one unit per module
+func qrSVG(q *qrCode) []byte {
+	side := q.size + 2*qrQuietZone
+	var path strings.Builder
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+qrQuietZone, y+qrQuietZone)
+			}
+		}
+	}
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`, side, side, path.String()))
+}
+
+// qrText renders a symbol with half-block characters, two rows per line,
+// light on dark so it scans from a dark terminal
+func qrText(q *qrCode) []byte {
+	dark := func(x, y int) bool {
+		x, y = x-qrQuietZone, y-qrQuietZone
+		return x >= 0 && y >= 0 && x < q.size && y < q.size && q.modules[y][x]
+	}
+	side := q.size + 2*qrQuietZone
+	var out strings.Builder
+	for y := 0; y < side; y += 2 {
+		for x := 0; x < side; x++ {
+			// Terminal text is light, so light modules are drawn
+			top, bottom := !dark(x, y), y+1 < side && !dark(x, y+1)
+			switch {
+			case top && bottom:
+				out.WriteString("█")
+			case top:
+				out.WriteString("▀")
+			case bottom:
+				out.WriteString("▄")
+			default:
+				out.WriteString(" ")
+			}
+		}
+		out.WriteString("\n")
+	}
+	return []byte(out.String())
+}
+
+// writeQR answers with a QR code of url: PNG by default, SVG with
+// ?format=svg, text with ?text=1. The ETag follows the URL, so a browser
+// refetches once the address changes.
+func writeQR(w http.ResponseWriter, r *http.Request, url string) {
+	format := r.URL.Query().Get("format")
+	if text, _ := strconv.ParseBool(r.URL.Query().Get("text")); text {
+		format = "text"
+	}
+	sum := sha256.Sum256([]byte(format + " " + url))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-QR-URL", url)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	q, err := encodeQR(url)
+	if err != nil {
+		writeQRError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	switch format {
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(qrText(q))
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(qrSVG(q))
+	default:
+		data, err := qrPNG(q)
+		if err != nil {
+			writeQRError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	}
+}
+
+func writeQRError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("ETag")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": msg})
+}
+
+// handleTunnelQR returns a QR code of the tunnel URL
+func (a *App) handleTunnelQR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, url := a.tunnelStatus()
+	if url == "" {
+		writeQRError(w, http.StatusNotFound, "No tunnel is running")
+		return
+	}
+	writeQR(w, r, url)
+}
+
+// handleLANQR returns a QR code of the LAN address, or of the one for
+// ?ip= when the device has several
+func (a *App) handleLANQR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	urls := a.lanURLs()
+	if len(urls) == 0 {
+		if a.listen.exposed() {
+			writeQRError(w, http.StatusNotFound, "No network address found; is Wi-Fi on?")
+			return
+		}
+		writeQRError(w, http.StatusNotFound, "NIMB only listens on this device; start it with --host 0.0.0.0 --insecure-lan to reach it from the network")
+		return
+	}
+	url := urls[0]
+	if ip := r.URL.Query().Get("ip"); ip != "" {
+		url = ""
+		hostPort := "://" + net.JoinHostPort(ip, strconv.Itoa(a.listen.Port))
+		for _, u := range urls {
+			if strings.HasSuffix(u, hostPort) {
+				url = u
+			}
+		}
+		if url == "" {
+			writeQRError(w, http.StatusNotFound, "NIMB is not reachable at "+ip)
+			return
+		}
+	}
+	writeQR(w, r, url)
+}
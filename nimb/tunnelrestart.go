@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTunnelStartTimeout is how long a tunnel gets to report its URL
+	defaultTunnelStartTimeout = 45 * time.Second
+	// tunnelErrorLines is how much output goes into a start timeout error
+	tunnelErrorLines = 5
+
+	defaultTunnelMaxRestarts = 5
+	tunnelRestartBaseDelay   = 5 * time.Second
+	tunnelRestartMaxDelay    = 5 * time.Minute
+)
+
+// tunnelRestartSettings returns whether a crashed tunnel is restarted and
+// how many times in a row
+func (a *App) tunnelRestartSettings() (bool, int) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	maxRestarts := a.config.TunnelMaxRestarts
+	if maxRestarts <= 0 {
+		maxRestarts = defaultTunnelMaxRestarts
+	}
+	return a.config.TunnelAutoRestart, maxRestarts
+}
+
+// tunnelStartTimeout returns how long a starting tunnel may go without a URL
+func (a *App) tunnelStartTimeout() time.Duration {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.config.TunnelStartTimeoutSec > 0 {
+		return time.Duration(a.config.TunnelStartTimeoutSec) * time.Second
+	}
+	return defaultTunnelStartTimeout
+}
+
+// startDeadline gives up on provider if it is still starting after
+// timeout: the process is killed and the tunnel goes to "error" with the
+// last lines it printed. It counts as a failure for auto-restart.
+func (a *App) startDeadline(provider TunnelProvider, timeout time.Duration) {
+	time.AfterFunc(timeout, func() {
+		autoRestart, maxRestarts := a.tunnelRestartSettings()
+		a.tunnel.mu.Lock()
+		defer a.tunnel.mu.Unlock()
+		if a.tunnel.provider != provider || provider.Status() != "starting" {
+			return
+		}
+		a.tunnel.provider = nil
+		go provider.Stop()
+
+		var output []string
+		for _, line := range a.tunnelLog.tail(maxTunnelLogLines) {
+			if line.Stream != "nimb" {
+				output = append(output, line.Line)
+			}
+		}
+		reason := fmt.Sprintf("No tunnel URL after %s", timeout)
+		if len(output) == 0 {
+			reason += " and no output"
+		} else {
+			reason += "; last output: " + strings.Join(output[max(len(output)-tunnelErrorLines, 0):], " | ")
+		}
+		logErrorf("%s", reason)
+		a.tunnelLog.add("nimb", reason)
+		a.tunnelTransitions.publish(TunnelEventError, "", reason)
+		a.tunnel.errored = true
+		a.tunnelFailedLocked(reason, autoRestart, maxRestarts)
+	})
+}
+
+// tunnelFailedLocked handles the tunnel exiting or failing to start when
+// nobody stopped it: it records why, then schedules a restart with doubling
+// delays until maxRestarts failures in a row. Callers must hold
+// a.tunnel.mu.
+func (a *App) tunnelFailedLocked(reason string, autoRestart bool, maxRestarts int) {
+	a.tunnel.LastExitError = reason
+	a.tunnel.failures++
+	if !autoRestart {
+		return
+	}
+	if a.tunnel.failures > maxRestarts {
+		msg := fmt.Sprintf("The tunnel failed %d times in a row, giving up: %s", a.tunnel.failures, reason)
+		logErrorf("%s", msg)
+		a.logError(msg, 502)
+		a.notify(EventTunnelDown, msg)
+		return
+	}
+
+	delay := min(tunnelRestartBaseDelay<<(a.tunnel.failures-1), tunnelRestartMaxDelay)
+	a.tunnel.NextRetryAt = time.Now().Add(delay)
+	logWarnf("Restarting the tunnel in %s (failure %d of %d)", delay, a.tunnel.failures, maxRestarts)
+
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		a.tunnel.mu.Lock()
+		if a.tunnel.retryTimer != timer {
+			// Stopped or started by hand in the meantime
+			a.tunnel.mu.Unlock()
+			return
+		}
+		a.tunnel.retryTimer = nil
+		a.tunnel.NextRetryAt = time.Time{}
+		a.tunnel.RestartCount++
+		a.tunnel.mu.Unlock()
+
+		if result := a.StartTunnel(); result["success"] != true {
+			reason, _ := result["error"].(string)
+			autoRestart, maxRestarts := a.tunnelRestartSettings()
+			a.tunnel.mu.Lock()
+			a.tunnelFailedLocked(reason, autoRestart, maxRestarts)
+			a.tunnel.mu.Unlock()
+		}
+	})
+	a.tunnel.retryTimer = timer
+}
+
+// disarmRestartLocked cancels a scheduled restart. Callers must hold
+// a.tunnel.mu.
+func (a *App) disarmRestartLocked() {
+	if a.tunnel.retryTimer != nil {
+		a.tunnel.retryTimer.Stop()
+		a.tunnel.retryTimer = nil
+	}
+	a.tunnel.NextRetryAt = time.Time{}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeCloudflared installs a script as the cloudflared NIMB downloaded,
+// and gives the tunnel a local server to point at
+func fakeCloudflared(t *testing.T, a *App, script string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+	if a.listen.Port == 0 {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		t.Cleanup(srv.Close)
+		addr := srv.Listener.Addr().(*net.TCPAddr)
+		a.listen.Host, a.listen.Port = addr.IP.String(), addr.Port
+	}
+	if err := os.MkdirAll(a.binDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(a.binDir(), "cloudflared"), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitTunnelStatus polls /api/tunnel/status until it reads want
+func waitTunnelStatus(t *testing.T, a *App, want string) map[string]interface{} {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		body := decodeBody(t, serve(a.handleTunnelStatus, "GET", "/api/tunnel/status", "").Body.String())
+		if body["status"] == want {
+			return body
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tunnel status = %v, want %s", body["status"], want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestTunnelStartTimeout uses cloudflared stand-ins that never print a
+// URL, and one that does
+func TestTunnelStartTimeout(t *testing.T) {
+	const url = "https://quiet-fox-lake.trycloudflare.com"
+	tests := []struct {
+		name    string
+		script  string
+		status  string
+		errText string
+	}{
+		{"silent", "exec sleep 30\n", "error", "No tunnel URL after 1s and no output"},
+		{"no URL", "echo 'ERR Failed to reach api.trycloudflare.com' >&2\necho 'INF Retrying' >&2\nexec sleep 30\n", "error", "last output: ERR Failed to reach api.trycloudflare.com | INF Retrying"},
+		{"URL", "echo 'INF |  " + url + "  |' >&2\nexec sleep 30\n", "running", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			a := newTestApp(t, nil)
+			a.config.TunnelStartTimeoutSec = 1
+			fakeCloudflared(t, a, tt.script)
+			t.Cleanup(func() { a.StopTunnel() })
+
+			if result := a.StartTunnel(); result["success"] != true {
+				t.Fatalf("StartTunnel = %v", result)
+			}
+			if tt.status == "running" {
+				// Unprobed, a tunnel with a URL stays "running"
+				waitTunnelStatus(t, a, "running")
+				time.Sleep(1500 * time.Millisecond)
+			}
+			body := waitTunnelStatus(t, a, tt.status)
+			lastErr, _ := body["lastExitError"].(string)
+			if tt.errText == "" && lastErr != "" || !strings.Contains(lastErr, tt.errText) {
+				t.Errorf("lastExitError = %q, want %q", lastErr, tt.errText)
+			}
+		})
+	}
+}
+
+// TestTunnelRestartAfterError checks a tunnel in "error" can be started
+// again, which clears the error
+func TestTunnelRestartAfterError(t *testing.T) {
+	a := newTestApp(t, nil)
+	a.config.TunnelStartTimeoutSec = 1
+	fakeCloudflared(t, a, "exec sleep 30\n")
+	t.Cleanup(func() { a.StopTunnel() })
+
+	a.StartTunnel()
+	waitTunnelStatus(t, a, "error")
+
+	fakeCloudflared(t, a, "echo 'INF |  https://quiet-fox-lake.trycloudflare.com  |' >&2\nexec sleep 30\n")
+	if result := a.StartTunnel(); result["success"] != true {
+		t.Fatalf("StartTunnel = %v", result)
+	}
+	body := waitTunnelStatus(t, a, "running")
+	if body["lastExitError"] != "" || body["url"] != "https://quiet-fox-lake.trycloudflare.com" {
+		t.Errorf("lastExitError = %q, url = %v", body["lastExitError"], body["url"])
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// tunnelTargetDialTimeout bounds the check that something answers at the
+// tunnel target before a tunnel is started
+const tunnelTargetDialTimeout = 2 * time.Second
+
+// tunnelTarget is the local URL the tunnel forwards to, and whether it
+// comes from Config.TunnelTargetURL rather than being NIMB itself
+func (a *App) tunnelTarget() (string, bool) {
+	a.mu.RLock()
+	target := a.config.TunnelTargetURL
+	a.mu.RUnlock()
+	if target != "" {
+		return strings.TrimSuffix(target, "/"), true
+	}
+	return a.listen.localURL(), false
+}
+
+// tunnelUpstream returns the URL ngrok and ssh forward to: the tunnel
+// backend when the tunnel fronts NIMB, the target itself otherwise
+func (a *App) tunnelUpstream() func() (string, error) {
+	if target, custom := a.tunnelTarget(); custom {
+		return func() (string, error) { return target, nil }
+	}
+	return func() (string, error) {
+		addr, err := a.tunnelBackend()
+		if err != nil {
+			return "", err
+		}
+		return "http://" + addr, nil
+	}
+}
+
+// targetAddr returns the host:port of a target URL, filling in the
+// scheme's default port
+func targetAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// validateTunnelTarget checks Config.TunnelTargetURL of a config being
+// saved; empty means NIMB itself
+func validateTunnelTarget(target string) error {
+	if target == "" {
+		return nil
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("tunnelTargetUrl must be a local URL like http://localhost:8080")
+	}
+	if u.Path != "" && u.Path != "/" || u.RawQuery != "" {
+		return errors.New("tunnelTargetUrl must not have a path; tunnels forward every path")
+	}
+	return nil
+}
+
+// checkTunnelTarget makes sure something accepts connections at the target,
+// so a typo fails here rather than as 502s through the tunnel
+func checkTunnelTarget(target string, custom bool) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("tunnel target %q is not a URL", target)
+	}
+	conn, err := net.DialTimeout("tcp", targetAddr(u), tunnelTargetDialTimeout)
+	if err != nil {
+		if custom {
+			return fmt.Errorf("nothing is answering at tunnelTargetUrl %s; start that service or clear the setting", target)
+		}
+		return fmt.Errorf("NIMB is not reachable at %s: %v", target, err)
+	}
+	conn.Close()
+	return nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultUpstreamBaseURL is the NVIDIA NIM OpenAI-compatible API
+const defaultUpstreamBaseURL = "https://integrate.api.nvidia.com/v1"
+
+// normalizeBaseURL validates an upstream base URL and strips trailing slashes
+// so paths can be appended without producing "//".
+func normalizeBaseURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return defaultUpstreamBaseURL, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errors.New("invalid upstream URL: " + err.Error())
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("upstream URL must start with http:// or https://")
+	}
+	if u.Host == "" {
+		return "", errors.New("upstream URL is missing a host")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("upstream URL must not contain a query or fragment")
+	}
+
+	return strings.TrimRight(raw, "/"), nil
+}
+
+// isLocalUpstream reports whether the base URL is plain http on this device,
+// in which case the custom DNS resolver is unnecessary.
+func isLocalUpstream(baseURL string) bool {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Scheme != "http" {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// upstreamBaseURL returns the configured upstream base URL
+func (a *App) upstreamBaseURL() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return configBaseURL(a.config)
+}
+
+// configBaseURL returns the upstream base URL of a config, which a request's
+// copy may point at another provider
+func configBaseURL(config Config) string {
+	if config.UpstreamBaseURL == "" {
+		return defaultUpstreamBaseURL
+	}
+	return config.UpstreamBaseURL
+}
+
+// upstreamURL joins the upstream base URL with an API path such as "/chat/completions"
+func (a *App) upstreamURL(path string) string {
+	return a.upstreamBaseURL() + path
+}
+
+// transportSettings are the config values baked into the upstream client;
+// the client is rebuilt whenever they change
+type transportSettings struct {
+	BaseURL          string
+	CABundlePath     string
+	AllowInsecureTLS bool
+	DNSMode          string
+	DNSServers       string // comma-separated, as slices aren't comparable
+	DoHURL           string
+	OutboundProxyURL string
+	ConnectTimeout   time.Duration
+}
+
+// transportSettings returns the current upstream connection settings
+func (a *App) transportSettings() transportSettings {
+	baseURL := a.upstreamBaseURL()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return transportSettings{
+		BaseURL:          baseURL,
+		CABundlePath:     a.config.CABundlePath,
+		AllowInsecureTLS: a.config.AllowInsecureTLS,
+		DNSMode:          a.config.DNSMode,
+		DNSServers:       strings.Join(a.config.DNSServers, ","),
+		DoHURL:           a.config.DoHURL,
+		OutboundProxyURL: a.config.OutboundProxyURL,
+		ConnectTimeout:   connectTimeout(a.config),
+	}
+}
+
+// newUpstreamClient builds the HTTP client used for calls to the upstream API
+// and reports its TLS verification mode. The returned resolver is nil when
+// lookups go through the system resolver.
+func newUpstreamClient(settings transportSettings) (*http.Client, string, *dnsResolver) {
+	dialer := &net.Dialer{
+		Timeout:   settings.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	proxy := outboundProxy(settings.OutboundProxyURL)
+
+	// Behind a proxy the proxy resolves the upstream, and its own host is
+	// often a name only the local network's DNS knows
+	var resolver *dnsResolver
+	dial := dialer.DialContext
+	if !isLocalUpstream(settings.BaseURL) && settings.DNSMode != DNSModeSystem && !usesProxy(proxy, settings.BaseURL) {
+		// Explicit DNS resolver (fixes Android IPv6 DNS issue)
+		resolver = newDNSResolver(settings.DNSMode, dnsServerList(settings.DNSServers), settings.DoHURL)
+		dial = resolver.dialContext(dialer)
+	}
+
+	tlsConfig, tlsMode := upstreamTLSConfig(settings.CABundlePath, settings.AllowInsecureTLS)
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   settings.ConnectTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+
+	// No overall timeout: deadlines are set per request, see requestTimeouts
+	return &http.Client{Transport: transport}, tlsMode, resolver
+}
+
+// normalizeProxyURL validates an outbound proxy URL. An empty URL means the
+// HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables apply.
+func normalizeProxyURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errors.New("invalid outbound proxy URL: " + err.Error())
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return "", errors.New("outbound proxy URL must use http, https, socks5 or socks5h")
+	}
+	if u.Host == "" {
+		return "", errors.New("outbound proxy URL is missing a host")
+	}
+	return raw, nil
+}
+
+// outboundProxy returns the transport proxy function: the configured proxy
+// when set, otherwise the environment's
+func outboundProxy(proxyURL string) func(*http.Request) (*url.URL, error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return http.ProxyFromEnvironment
+	}
+	return http.ProxyURL(u)
+}
+
+// usesProxy reports whether requests to baseURL go through the proxy
+func usesProxy(proxy func(*http.Request) (*url.URL, error), baseURL string) bool {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return false
+	}
+	p, err := proxy(&http.Request{URL: u})
+	return err == nil && p != nil
+}
+
+// describeConnError says whether a failed request died at the outbound
+// proxy or at the upstream itself
+func describeConnError(err error, client *http.Client, req *http.Request) error {
+	var te *timeoutError
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &te) {
+		return err
+	}
+
+	transport, _ := client.Transport.(*http.Transport)
+	if transport == nil || transport.Proxy == nil {
+		return fmt.Errorf("upstream request failed: %w", err)
+	}
+	proxy, perr := transport.Proxy(req)
+	if perr != nil || proxy == nil {
+		return fmt.Errorf("upstream request failed: %w", err)
+	}
+
+	var opErr *net.OpError
+	if (errors.As(err, &opErr) && opErr.Op == "proxyconnect") || strings.Contains(err.Error(), "socks connect") {
+		return fmt.Errorf("outbound proxy %s failed: %w", proxy.Host, err)
+	}
+	return fmt.Errorf("upstream request via proxy %s failed: %w", proxy.Host, err)
+}
+
+// upstreamClient returns the shared upstream client, building it on first use.
+// Reusing one client keeps connections to the upstream alive between requests;
+// it is rebuilt only when the transport settings change.
+func (a *App) upstreamClient() *http.Client {
+	settings := a.transportSettings()
+
+	a.clientMu.Lock()
+	defer a.clientMu.Unlock()
+
+	if a.client == nil || a.clientSettings != settings {
+		if a.client != nil {
+			a.client.CloseIdleConnections()
+		}
+		a.client, a.tlsMode, a.resolver = newUpstreamClient(settings)
+		a.clientSettings = settings
+	}
+	return a.client
+}
+
+// upstreamTLSMode reports how upstream certificates are verified
+func (a *App) upstreamTLSMode() string {
+	a.upstreamClient()
+
+	a.clientMu.Lock()
+	defer a.clientMu.Unlock()
+	return a.tlsMode
+}
+
+// dnsStatus reports the DNS mode and the resolver that answered last
+func (a *App) dnsStatus() map[string]string {
+	mode := a.transportSettings().DNSMode
+	if mode == "" {
+		mode = DNSModeUDP
+	}
+
+	a.clientMu.Lock()
+	defer a.clientMu.Unlock()
+
+	status := map[string]string{"mode": mode, "lastResolver": ""}
+	if a.resolver == nil {
+		status["mode"] = DNSModeSystem
+	} else {
+		status["lastResolver"] = a.resolver.lastUsed()
+	}
+	return status
+}
+
+// maxRetryDelay caps a single backoff wait, including server-sent Retry-After values
+const maxRetryDelay = 30 * time.Second
+
+// isRetryableStatus reports whether an upstream status is worth retrying
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests ||
+		code == http.StatusBadGateway ||
+		code == http.StatusServiceUnavailable
+}
+
+// retryDelay picks the wait before the given retry attempt (0-based), preferring
+// the upstream's Retry-After header and otherwise using exponential backoff with jitter.
+func retryDelay(attempt int, base time.Duration, resp *http.Response) time.Duration {
+	if resp != nil {
+		if ra := resp.Header.Get("Retry-After"); ra != "" {
+			if secs, err := strconv.Atoi(ra); err == nil && secs >= 0 {
+				return min(time.Duration(secs)*time.Second, maxRetryDelay)
+			}
+			if t, err := http.ParseTime(ra); err == nil {
+				return min(max(time.Until(t), 0), maxRetryDelay)
+			}
+		}
+	}
+
+	delay := base << attempt
+	if base > 0 {
+		delay += time.Duration(rand.Int63n(int64(base)))
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// doUpstream POSTs body to an upstream API path, retrying
+// rate-limit and gateway errors with backoff. Retries stop early when the
+// request context would expire before the next attempt. A stored key that
+// is rate limited or rejected is swapped for the next one in the pool
+// straight away, updating config.APIKey.
+func (a *App) doUpstream(ctx context.Context, path string, body []byte, config *Config) (*http.Response, error) {
+	client := a.upstreamClient()
+	maxRetries := config.MaxRetries
+	baseDelay := time.Duration(config.RetryBaseDelayMs) * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "POST", configBaseURL(*config)+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		setUpstreamHeaders(req.Header, *config)
+		if id := requestIDFrom(ctx); id != "" {
+			req.Header.Set("X-Request-Id", id)
+		}
+
+		resp, err := sendAttempt(client, req)
+		if err != nil {
+			return nil, describeConnError(err, client, req)
+		}
+		if isKeyFailure(resp.StatusCode) && slices.Contains(config.APIKeys, config.APIKey) {
+			if next, ok := a.keys.rotate(config.APIKey, *config); ok {
+				resp.Body.Close()
+				logWarnf("[NIMB] Key %s returned %d, rotating to %s", keyID(config.APIKey), resp.StatusCode, keyID(next))
+				config.APIKey = next
+				attempt--
+				continue
+			}
+		}
+		if attempt >= maxRetries || !isRetryableStatus(resp.StatusCode) {
+			return resp, err
+		}
+
+		delay := retryDelay(attempt, baseDelay, resp)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		logWarnf("[NIMB] Upstream returned %d, retrying in %v (%d/%d)", resp.StatusCode, delay, attempt+1, maxRetries)
+		a.mu.Lock()
+		a.stats.RetryCount++
+		a.mu.Unlock()
+		a.statsHub.publish()
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestUpstreamClientReused checks the shared client survives unrelated
+// config changes and is rebuilt when the connection settings change
+func TestUpstreamClientReused(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		rebuilt bool
+	}{
+		{"nothing changed", func(c *Config) {}, false},
+		{"model changed", func(c *Config) { c.CurrentModel = "meta/llama-3.1-8b-instruct" }, false},
+		{"temperature changed", func(c *Config) { c.Temperature = 0.2 }, false},
+		{"base URL changed", func(c *Config) { c.UpstreamBaseURL = "http://127.0.0.1:9/v1" }, true},
+		{"DNS mode changed", func(c *Config) { c.DNSMode = DNSModeSystem }, true},
+		{"proxy changed", func(c *Config) { c.OutboundProxyURL = "http://127.0.0.1:8080" }, true},
+		{"insecure TLS changed", func(c *Config) { c.AllowInsecureTLS = !c.AllowInsecureTLS }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewApp(options{DataDir: t.TempDir()})
+			before := a.upstreamClient()
+			if again := a.upstreamClient(); again != before {
+				t.Fatal("second call built a new client")
+			}
+			a.mu.Lock()
+			tt.change(&a.config)
+			a.mu.Unlock()
+			if rebuilt := a.upstreamClient() != before; rebuilt != tt.rebuilt {
+				t.Errorf("rebuilt = %v, want %v", rebuilt, tt.rebuilt)
+			}
+		})
+	}
+}
+
+// TestUpstreamConnReused sends completions one after another to a TLS
+// upstream and checks every one after the first reuses the connection,
+// which needs the shared transport and each response body read and closed
+func TestUpstreamConnReused(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(jsonUpstream))
+	defer srv.Close()
+	bundle := filepath.Join(t.TempDir(), "upstream.pem")
+	os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644)
+
+	a := newTestApp(t, nil)
+	a.config.UpstreamBaseURL = srv.URL
+	a.config.CABundlePath = bundle
+	a.config.ModelOverrideMode = ModelModeClient
+
+	for i := 0; i < 3; i++ {
+		var conns, reused int
+		trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+			conns++
+			if info.Reused {
+				reused++
+			}
+		}}
+		r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(chatBody(10)))
+		r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+		w := httptest.NewRecorder()
+		a.handleChatCompletions(w, r)
+		if w.Code != 200 {
+			t.Fatalf("request %d: status %d: %s", i, w.Code, w.Body)
+		}
+		if conns != 1 {
+			t.Fatalf("request %d: %d connections, want 1", i, conns)
+		}
+		if want := i > 0; (reused == 1) != want {
+			t.Errorf("request %d: connection reused = %v, want %v", i, reused == 1, want)
+		}
+	}
+}
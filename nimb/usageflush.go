@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// usageFlushInterval is how often usage history, the budget and virtual
+// key usage are written out while they change. Shutdown writes them once
+// more.
+const usageFlushInterval = 30 * time.Second
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so a crash mid-write never leaves a truncated file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// watchUsage writes usage history, the budget and virtual key usage every
+// usageFlushInterval when requests or errors have changed them, instead of
+// on every request
+func (a *App) watchUsage() {
+	ticker := time.NewTicker(usageFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.flushUsage()
+			a.flushVirtualKeys()
+		case <-a.draining:
+			return
+		}
+	}
+}
+
+// flushUsage saves usage history and the budget if they changed since the
+// last flush
+func (a *App) flushUsage() {
+	a.mu.Lock()
+	dirty := a.usageDirty
+	a.usageDirty = false
+	a.mu.Unlock()
+	if !dirty {
+		return
+	}
+	if err := a.saveSeries(); err != nil {
+		logWarnf("Could not save usage history: %v", err)
+	}
+	if err := a.saveBudget(); err != nil {
+		logWarnf("Could not save the budget: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// requestError describes an invalid client request and the field at fault
+type requestError struct {
+	Message string
+	Param   string
+}
+
+// validateChatRequest checks a chat completion request before it is proxied
+// so obviously broken requests fail with a readable error instead of an
+// opaque upstream one
+func validateChatRequest(reqBody map[string]interface{}) *requestError {
+	messages, ok := reqBody["messages"].([]interface{})
+	if !ok {
+		return &requestError{"messages must be an array", "messages"}
+	}
+	if len(messages) == 0 {
+		return &requestError{"messages must not be empty", "messages"}
+	}
+
+	for i, m := range messages {
+		param := fmt.Sprintf("messages[%d]", i)
+		msg, ok := m.(map[string]interface{})
+		if !ok {
+			return &requestError{param + " must be an object", param}
+		}
+		if role, _ := msg["role"].(string); role == "" {
+			return &requestError{param + " is missing a role", param + ".role"}
+		}
+		_, hasContent := msg["content"]
+		_, hasToolCalls := msg["tool_calls"]
+		if (!hasContent || msg["content"] == nil) && !hasToolCalls {
+			return &requestError{param + " must have content or tool_calls", param + ".content"}
+		}
+	}
+
+	if v, ok := reqBody["temperature"]; ok && v != nil {
+		temp, ok := v.(float64)
+		if !ok || temp < 0 || temp > 2 {
+			return &requestError{"temperature must be a number between 0 and 2", "temperature"}
+		}
+	}
+
+	if v, ok := reqBody["max_tokens"]; ok && v != nil {
+		maxTok, ok := v.(float64)
+		if !ok || maxTok < 1 || maxTok != math.Trunc(maxTok) {
+			return &requestError{"max_tokens must be a positive integer", "max_tokens"}
+		}
+	}
+
+	return nil
+}
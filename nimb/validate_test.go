@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestValidateChatRequest covers each rule with a request that breaks it
+func TestValidateChatRequest(t *testing.T) {
+	const user = `{"role":"user","content":"Hi"}`
+	tests := []struct {
+		name  string
+		body  string
+		param string // "" when the request is valid
+	}{
+		{"valid", `{"messages":[` + user + `]}`, ""},
+		{"tool call without content", `{"messages":[` + user + `,{"role":"assistant","tool_calls":[]}]}`, ""},
+		{"messages missing", `{}`, "messages"},
+		{"messages not an array", `{"messages":"Hi"}`, "messages"},
+		{"messages empty", `{"messages":[]}`, "messages"},
+		{"message not an object", `{"messages":["Hi"]}`, "messages[0]"},
+		{"role missing", `{"messages":[{"content":"Hi"}]}`, "messages[0].role"},
+		{"content missing", `{"messages":[` + user + `,{"role":"user"}]}`, "messages[1].content"},
+		{"content null", `{"messages":[{"role":"user","content":null}]}`, "messages[0].content"},
+		{"temperature too high", `{"messages":[` + user + `],"temperature":2.5}`, "temperature"},
+		{"temperature negative", `{"messages":[` + user + `],"temperature":-1}`, "temperature"},
+		{"temperature not a number", `{"messages":[` + user + `],"temperature":"hot"}`, "temperature"},
+		{"temperature at the limits", `{"messages":[` + user + `],"temperature":2}`, ""},
+		{"max_tokens zero", `{"messages":[` + user + `],"max_tokens":0}`, "max_tokens"},
+		{"max_tokens fractional", `{"messages":[` + user + `],"max_tokens":1.5}`, "max_tokens"},
+		{"max_tokens positive", `{"messages":[` + user + `],"max_tokens":64}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateChatRequest(decodeBody(t, tt.body))
+			switch {
+			case tt.param == "" && err != nil:
+				t.Errorf("got error %q on %s, want none", err.Message, err.Param)
+			case tt.param != "" && err == nil:
+				t.Errorf("got no error, want one on %s", tt.param)
+			case tt.param != "" && err.Param != tt.param:
+				t.Errorf("error on %s, want %s", err.Param, tt.param)
+			}
+		})
+	}
+}
+
+// TestInvalidRequestResponse checks an invalid request gets an OpenAI-style
+// 400 naming the param and lands in the error log
+func TestInvalidRequestResponse(t *testing.T) {
+	a := newTestApp(t, nil)
+	w := serve(a.handleChatCompletions, "POST", "/v1/chat/completions",
+		`{"model":"m","stream":false,"messages":[{"role":"user","content":"Hi"}],"temperature":3}`)
+	if w.Code != 400 {
+		t.Fatalf("status %d, want 400", w.Code)
+	}
+	var resp struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Param   string `json:"param"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("body is not JSON: %s", w.Body)
+	}
+	if resp.Error.Type != "invalid_request_error" || resp.Error.Param != "temperature" {
+		t.Errorf("error = %+v, want invalid_request_error on temperature", resp.Error)
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if len(a.stats.ErrorLog) != 1 || !strings.Contains(a.stats.ErrorLog[0].Message, "temperature") {
+		t.Errorf("error log = %+v, want one entry naming temperature", a.stats.ErrorLog)
+	}
+}
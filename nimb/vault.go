@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// vaultKDF names the key derivation in the credentials file. PBKDF2 is
+	// what the standard library can do without extra dependencies.
+	vaultKDF        = "pbkdf2-sha256"
+	vaultIterations = 600_000
+	vaultSaltBytes  = 16
+	minPassphrase   = 8
+	// maxUnlockDelay caps the wait after repeated wrong passphrases
+	maxUnlockDelay = 5 * time.Minute
+)
+
+var (
+	errWrongPassphrase = errors.New("wrong passphrase")
+	errNotLocked       = errors.New("credentials are not locked")
+)
+
+// sealedBox is the encrypted form of the credentials
+type sealedBox struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// vault holds the passphrase-derived key while credentials are encrypted.
+// It is locked while the stored box has not been opened yet.
+type vault struct {
+	mu         sync.Mutex
+	sealed     *sealedBox
+	key        []byte
+	salt       []byte
+	iterations int
+	failures   int
+	retryAt    time.Time
+}
+
+func (v *vault) lockedLocked() bool {
+	return v.sealed != nil && v.key == nil
+}
+
+// status reports whether encryption is on and whether it is still locked
+func (v *vault) status() (enabled, locked bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.sealed != nil || v.key != nil, v.lockedLocked()
+}
+
+func (v *vault) isLocked() bool {
+	_, locked := v.status()
+	return locked
+}
+
+// pbkdf2SHA256 derives keyLen bytes from a passphrase (RFC 8018)
+func pbkdf2SHA256(passphrase, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, passphrase)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the keys with AES-256-GCM under a fresh nonce
+func seal(key, salt []byte, iterations int, keys []string) (*sealedBox, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := json.Marshal(credentials{APIKeys: keys})
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &sealedBox{
+		KDF:        vaultKDF,
+		Iterations: iterations,
+		Salt:       salt,
+		Nonce:      nonce,
+		Data:       aead.Seal(nil, nonce, plain, nil),
+	}, nil
+}
+
+// open decrypts a box; a wrong key fails GCM authentication
+func (box *sealedBox) open(key []byte) ([]string, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, box.Nonce, box.Data, nil)
+	if err != nil {
+		return nil, errWrongPassphrase
+	}
+	var creds credentials
+	if err := json.Unmarshal(plain, &creds); err != nil {
+		return nil, err
+	}
+	return creds.APIKeys, nil
+}
+
+// derive turns a passphrase into a key for a box
+func (box *sealedBox) derive(passphrase string) ([]byte, error) {
+	if box.KDF != vaultKDF || box.Iterations < 1 {
+		return nil, fmt.Errorf("unsupported key derivation %q", box.KDF)
+	}
+	return pbkdf2SHA256([]byte(passphrase), box.Salt, box.Iterations, 32), nil
+}
+
+// attemptLocked checks the wrong-passphrase back-off, returning how long
+// to wait when an attempt is not allowed yet
+func (v *vault) attemptLocked() time.Duration {
+	if wait := time.Until(v.retryAt); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// failLocked records a wrong passphrase; each one doubles the wait
+func (v *vault) failLocked() {
+	v.failures++
+	delay := time.Second << min(v.failures-1, 16)
+	v.retryAt = time.Now().Add(min(delay, maxUnlockDelay))
+}
+
+// unlock opens the stored credentials with a passphrase
+func (a *App) unlock(passphrase string) (time.Duration, error) {
+	a.vault.mu.Lock()
+	if !a.vault.lockedLocked() {
+		a.vault.mu.Unlock()
+		return 0, errNotLocked
+	}
+	if wait := a.vault.attemptLocked(); wait > 0 {
+		a.vault.mu.Unlock()
+		return wait, errWrongPassphrase
+	}
+	box := a.vault.sealed
+	key, err := box.derive(passphrase)
+	var keys []string
+	if err == nil {
+		keys, err = box.open(key)
+	}
+	if err != nil {
+		a.vault.failLocked()
+		failures := a.vault.failures
+		a.vault.mu.Unlock()
+		logWarnf("Unlock failed (%d in a row): %v", failures, err)
+		a.logError("Unlock failed: "+err.Error(), 401)
+		return 0, err
+	}
+	a.vault.key, a.vault.salt, a.vault.iterations = key, box.Salt, box.Iterations
+	a.vault.sealed = nil
+	a.vault.failures = 0
+	a.vault.mu.Unlock()
+
+	a.mu.Lock()
+	a.config.APIKeys = keys
+	a.env.apply(&a.config)
+	logScrubber.useConfig(a.config)
+	a.mu.Unlock()
+	logInfof("Credentials unlocked")
+	return 0, nil
+}
+
+// enableEncryption encrypts the stored keys under a new passphrase
+func (a *App) enableEncryption(passphrase string) error {
+	salt := make([]byte, vaultSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	key := pbkdf2SHA256([]byte(passphrase), salt, vaultIterations, 32)
+
+	a.vault.mu.Lock()
+	a.vault.key, a.vault.salt, a.vault.iterations = key, salt, vaultIterations
+	a.vault.mu.Unlock()
+	return a.saveSettings()
+}
+
+// disableEncryption checks the passphrase and writes the keys in plain text
+func (a *App) disableEncryption(passphrase string) (time.Duration, error) {
+	a.vault.mu.Lock()
+	if wait := a.vault.attemptLocked(); wait > 0 {
+		a.vault.mu.Unlock()
+		return wait, errWrongPassphrase
+	}
+	box := sealedBox{KDF: vaultKDF, Iterations: a.vault.iterations, Salt: a.vault.salt}
+	key, err := box.derive(passphrase)
+	if err != nil || !hmac.Equal(key, a.vault.key) {
+		a.vault.failLocked()
+		a.vault.mu.Unlock()
+		logWarnf("Disabling encryption failed: wrong passphrase")
+		a.logError("Disabling encryption failed: wrong passphrase", 401)
+		return 0, errWrongPassphrase
+	}
+	a.vault.key, a.vault.salt = nil, nil
+	a.vault.failures = 0
+	a.vault.mu.Unlock()
+	return 0, a.saveSettings()
+}
+
+// writeLockedError answers proxy requests while the credentials are locked
+func writeLockedError(w http.ResponseWriter) {
+	writeAPIError(w, 503, "NIMB is locked; unlock it with POST /api/unlock or set NIMB_PASSPHRASE", "locked")
+}
+
+// writeRetryLater answers an attempt made during the back-off
+func writeRetryLater(w http.ResponseWriter, wait time.Duration) {
+	secs := int(wait.Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeAPIError(w, 429, fmt.Sprintf("Too many wrong passphrases, retry in %ds", secs), "rate_limit_error")
+}
+
+// handleUnlock opens encrypted credentials with POST {passphrase}
+func (a *App) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wait, err := a.unlock(req.Passphrase)
+	switch {
+	case wait > 0:
+		writeRetryLater(w, wait)
+	case errors.Is(err, errNotLocked):
+		writeAPIError(w, 409, "Credentials are not locked", "invalid_request_error")
+	case err != nil:
+		writeAPIError(w, 401, "Wrong passphrase", "authentication_error")
+	default:
+		a.statsHub.publish()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+	}
+}
+
+// handleEncryption reports the encryption state on GET, encrypts the
+// stored keys on POST {passphrase} and decrypts them on DELETE {passphrase}
+func (a *App) handleEncryption(w http.ResponseWriter, r *http.Request) {
+	enabled, locked := a.vault.status()
+	if r.Method == "GET" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"enabled": enabled, "locked": locked})
+		return
+	}
+	if r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if locked {
+		writeLockedError(w)
+		return
+	}
+
+	var err error
+	if r.Method == "POST" {
+		if enabled {
+			writeAPIError(w, 409, "Encryption is already on", "invalid_request_error")
+			return
+		}
+		if len(req.Passphrase) < minPassphrase {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "passphrase must be at least " + strconv.Itoa(minPassphrase) + " characters",
+			})
+			return
+		}
+		err = a.enableEncryption(req.Passphrase)
+	} else {
+		if !enabled {
+			writeAPIError(w, 409, "Encryption is not on", "invalid_request_error")
+			return
+		}
+		var wait time.Duration
+		wait, err = a.disableEncryption(req.Passphrase)
+		if wait > 0 {
+			writeRetryLater(w, wait)
+			return
+		}
+		if errors.Is(err, errWrongPassphrase) {
+			writeAPIError(w, 401, "Wrong passphrase", "authentication_error")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "enabled": r.Method == "POST"})
+}
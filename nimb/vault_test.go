@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// TestPBKDF2SHA256 checks the key derivation against the PBKDF2-HMAC-SHA256
+// vectors published alongside RFC 6070's SHA-1 ones, and RFC 7914's
+func TestPBKDF2SHA256(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iterations     int
+		keyLen         int
+		want           string
+	}{
+		{"password", "salt", 1, 32, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, 32, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 40,
+			"348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+		{"pass\x00word", "sa\x00lt", 4096, 16, "89b69d0516f829893c696226650a8687"},
+		{"passwd", "salt", 1, 64,
+			"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iterations, tt.keyLen))
+		if got != tt.want {
+			t.Errorf("pbkdf2SHA256(%q, %q, %d, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, tt.keyLen, got, tt.want)
+		}
+	}
+}
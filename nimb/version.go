@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Build info, set when building a release with
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them commit and buildDate come from the Go toolchain's VCS
+// stamp when there is one.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+const (
+	// nimbReleaseURL describes the newest NIMB release
+	nimbReleaseURL = "https://api.github.com/repos/Noobcoder191/NIMB-Mobile/releases/latest"
+	// updateCheckInterval is how often CheckUpdates asks GitHub
+	updateCheckInterval = 24 * time.Hour
+	// updatePollInterval is how often the update loop wakes to see if a
+	// check is due, so turning CheckUpdates on takes effect soon
+	updatePollInterval = time.Hour
+	updateCheckTimeout = 30 * time.Second
+)
+
+// buildInfo returns the version, commit and build date of this binary
+func buildInfo() (string, string, string) {
+	c, d := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok && (c == "" || d == "") {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && c == "":
+				c = s.Value
+				if len(c) > 12 {
+					c = c[:12]
+				}
+			case s.Key == "vcs.time" && d == "":
+				d = s.Value
+			}
+		}
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	if d == "" {
+		d = "unknown"
+	}
+	return version, c, d
+}
+
+// updateState is the cached result of the last update check
+type updateState struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	latest    string
+	url       string
+	available bool
+	err       string
+}
+
+// snapshot copies the state for encoding
+func (s *updateState) snapshot() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]interface{}{
+		"updateAvailable": s.available,
+		"latestVersion":   s.latest,
+		"releaseUrl":      s.url,
+		"updateCheckedAt": "",
+	}
+	if !s.checkedAt.IsZero() {
+		out["updateCheckedAt"] = s.checkedAt.Format(time.RFC3339)
+	}
+	if s.err != "" {
+		out["updateError"] = s.err
+	}
+	return out
+}
+
+// versionInfo is the body of /api/version, also shown in /api/health
+func (a *App) versionInfo() map[string]interface{} {
+	v, c, d := buildInfo()
+	a.mu.RLock()
+	enabled := a.config.CheckUpdates
+	a.mu.RUnlock()
+
+	out := a.update.snapshot()
+	out["version"] = v
+	out["commit"] = c
+	out["buildDate"] = d
+	out["goVersion"] = runtime.Version()
+	out["checkUpdates"] = enabled
+	return out
+}
+
+// handleVersion shows which build is running and whether a newer one is
+// out
+func (a *App) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.versionInfo())
+}
+
+// watchUpdates checks for a new release once a day while CheckUpdates is
+// on, retrying hourly after a failure. It runs in the background from
+// startup; a slow or failed check only shows up in /api/version.
+func (a *App) watchUpdates() {
+	ticker := time.NewTicker(updatePollInterval)
+	defer ticker.Stop()
+	for {
+		a.mu.RLock()
+		enabled := a.config.CheckUpdates
+		a.mu.RUnlock()
+		a.update.mu.Lock()
+		due := time.Since(a.update.checkedAt) >= updateCheckInterval || a.update.err != ""
+		a.update.mu.Unlock()
+		if enabled && due {
+			a.checkForUpdate()
+		}
+		select {
+		case <-ticker.C:
+		case <-a.draining:
+			return
+		}
+	}
+}
+
+// checkForUpdate asks GitHub for the latest release and caches the answer
+func (a *App) checkForUpdate() {
+	ctx, cancel := context.WithTimeout(context.Background(), updateCheckTimeout)
+	defer cancel()
+	tag, url, err := a.latestRelease(ctx)
+
+	a.update.mu.Lock()
+	defer a.update.mu.Unlock()
+	a.update.checkedAt = time.Now()
+	if err != nil {
+		a.update.err = err.Error()
+		logWarnf("Update check failed: %v", err)
+		return
+	}
+	a.update.err = ""
+	a.update.latest, a.update.url = tag, url
+	a.update.available = newerVersion(tag, version)
+	if a.update.available {
+		logInfof("NIMB %s is out (running %s): %s", tag, version, url)
+	}
+}
+
+// latestRelease returns the tag and page of the newest NIMB release. It
+// goes through the upstream client so custom DNS works for it too.
+func (a *App) latestRelease(ctx context.Context) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", nimbReleaseURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := a.upstreamClient().Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot reach GitHub: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", "", fmt.Errorf("GitHub answered %d for the latest release", resp.StatusCode)
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&release); err != nil {
+		return "", "", fmt.Errorf("cannot read the latest release: %w", err)
+	}
+	return release.TagName, release.HTMLURL, nil
+}
+
+// parseVersion splits a tag like v1.2.3 or 1.2.3-beta into its numbers
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	v, _, _ = strings.Cut(v, "-")
+	v, _, _ = strings.Cut(v, "+")
+	if v == "" {
+		return nil, false
+	}
+	var parts []int
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
+
+// newerVersion reports whether latest is a later release than current.
+// Dev builds and other unparseable versions are never out of date.
+func newerVersion(latest, current string) bool {
+	l, ok := parseVersion(latest)
+	c, ok2 := parseVersion(current)
+	if !ok || !ok2 {
+		return false
+	}
+	for i := 0; i < max(len(l), len(c)); i++ {
+		var x, y int
+		if i < len(l) {
+			x = l[i]
+		}
+		if i < len(c) {
+			y = c[i]
+		}
+		if x != y {
+			return x > y
+		}
+	}
+	return false
+}
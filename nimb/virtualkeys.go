@@ -0,0 +1,298 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// virtualKeyUsage counts what a virtual key used, today and in total
+type virtualKeyUsage struct {
+	Date          string `json:"date"`
+	Tokens        int    `json:"tokens"`
+	Requests      int    `json:"requests"`
+	TotalTokens   int    `json:"totalTokens"`
+	TotalRequests int    `json:"totalRequests"`
+}
+
+// virtualKey is a key handed out for the /v1 endpoints, with its own daily
+// quotas. Only the SHA-256 of the key is kept.
+type virtualKey struct {
+	ID            string          `json:"id"`
+	Label         string          `json:"label"`
+	Hash          string          `json:"hash"`
+	Hint          string          `json:"hint"`
+	DailyTokens   int             `json:"dailyTokenQuota"`
+	DailyRequests int             `json:"dailyRequestQuota"`
+	CreatedAt     string          `json:"createdAt"`
+	Usage         virtualKeyUsage `json:"usage"`
+}
+
+// rollLocked starts a new quota day when the date has changed
+func (k *virtualKey) rollLocked(today string) {
+	if k.Usage.Date != today {
+		k.Usage.Date, k.Usage.Tokens, k.Usage.Requests = today, 0, 0
+	}
+}
+
+// virtualKeyStore holds the virtual keys, by hash
+type virtualKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]*virtualKey
+	// dirty is set when usage changed since the store was last written
+	dirty bool
+}
+
+func (a *App) virtualKeysPath() string {
+	return filepath.Join(a.settingsDir, "virtualkeys.json")
+}
+
+func (a *App) loadVirtualKeys() {
+	data, err := os.ReadFile(a.virtualKeysPath())
+	if err != nil {
+		return
+	}
+	var keys []*virtualKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		logWarnf("Ignoring unreadable virtual keys file: %v", err)
+		return
+	}
+	a.vkeys.mu.Lock()
+	a.vkeys.keys = map[string]*virtualKey{}
+	for _, k := range keys {
+		a.vkeys.keys[k.Hash] = k
+	}
+	a.vkeys.mu.Unlock()
+}
+
+// saveVirtualKeysLocked writes the store. Callers must hold a.vkeys.mu.
+func (a *App) saveVirtualKeysLocked() error {
+	keys := make([]*virtualKey, 0, len(a.vkeys.keys))
+	for _, k := range a.vkeys.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt < keys[j].CreatedAt })
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	a.vkeys.dirty = false
+	return writeFileAtomic(a.virtualKeysPath(), data, 0600)
+}
+
+// flushVirtualKeys writes the store if usage changed since the last write.
+// Usage is only counted in memory as requests come in.
+func (a *App) flushVirtualKeys() {
+	a.vkeys.mu.Lock()
+	defer a.vkeys.mu.Unlock()
+	if !a.vkeys.dirty {
+		return
+	}
+	if err := a.saveVirtualKeysLocked(); err != nil {
+		logWarnf("Could not save virtual key usage: %v", err)
+	}
+}
+
+func hashVirtualKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// admitVirtualKey checks a presented key against the store. With charge
+// set, a known key within its quotas is charged one request; without it
+// the key is let in regardless of its quotas. found is false for keys
+// that aren't virtual keys at all.
+func (a *App) admitVirtualKey(key string, charge bool) (found bool, quotaErr string, resetAt time.Time) {
+	if key == "" {
+		return false, "", time.Time{}
+	}
+	a.vkeys.mu.Lock()
+	defer a.vkeys.mu.Unlock()
+	k := a.vkeys.keys[hashVirtualKey(key)]
+	if k == nil {
+		return false, "", time.Time{}
+	}
+	if !charge {
+		return true, "", time.Time{}
+	}
+
+	now := time.Now()
+	k.rollLocked(now.Format("2006-01-02"))
+	resetAt = nextMidnight(now)
+	switch {
+	case k.DailyTokens > 0 && k.Usage.Tokens >= k.DailyTokens:
+		return true, "Daily token quota of this key reached, resets at " + resetAt.Format(time.RFC3339), resetAt
+	case k.DailyRequests > 0 && k.Usage.Requests >= k.DailyRequests:
+		return true, "Daily request quota of this key reached, resets at " + resetAt.Format(time.RFC3339), resetAt
+	}
+	k.Usage.Requests++
+	k.Usage.TotalRequests++
+	a.vkeys.dirty = true
+	return true, "", resetAt
+}
+
+// isVirtualKey reports whether key is one of the virtual keys
+func (a *App) isVirtualKey(key string) bool {
+	a.vkeys.mu.Lock()
+	defer a.vkeys.mu.Unlock()
+	return a.vkeys.keys[hashVirtualKey(key)] != nil
+}
+
+// chargeVirtualKey attributes tokens to the virtual key the request was
+// made with, if any
+func (a *App) chargeVirtualKey(r *http.Request, tokens int) {
+	key := presentedProxyKey(r)
+	if key == "" || tokens <= 0 {
+		return
+	}
+	a.vkeys.mu.Lock()
+	defer a.vkeys.mu.Unlock()
+	k := a.vkeys.keys[hashVirtualKey(key)]
+	if k == nil {
+		return
+	}
+	k.rollLocked(time.Now().Format("2006-01-02"))
+	k.Usage.Tokens += tokens
+	k.Usage.TotalTokens += tokens
+	a.vkeys.dirty = true
+}
+
+// usageTotalTokens reads total_tokens from an OpenAI-style usage object
+func usageTotalTokens(usage map[string]interface{}) int {
+	tt, _ := usage["total_tokens"].(float64)
+	return int(tt)
+}
+
+// virtualKeyView is a key as GET /api/keys lists it
+func virtualKeyView(k virtualKey) map[string]interface{} {
+	if k.Usage.Date != time.Now().Format("2006-01-02") {
+		k.Usage.Tokens, k.Usage.Requests = 0, 0
+	}
+	return map[string]interface{}{
+		"id":                k.ID,
+		"label":             k.Label,
+		"key":               k.Hint,
+		"dailyTokenQuota":   k.DailyTokens,
+		"dailyRequestQuota": k.DailyRequests,
+		"createdAt":         k.CreatedAt,
+		"usage":             k.Usage,
+	}
+}
+
+// handleVirtualKeys lists virtual keys (GET) or creates one (POST). The
+// new key is only ever shown in the POST response.
+func (a *App) handleVirtualKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		a.vkeys.mu.Lock()
+		keys := make([]map[string]interface{}, 0, len(a.vkeys.keys))
+		for _, k := range a.vkeys.keys {
+			keys = append(keys, virtualKeyView(*k))
+		}
+		a.vkeys.mu.Unlock()
+		sort.Slice(keys, func(i, j int) bool { return keys[i]["createdAt"].(string) < keys[j]["createdAt"].(string) })
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys, "resetAt": nextMidnight(time.Now()).Format(time.RFC3339)})
+	case "POST":
+		var req struct {
+			Label         string `json:"label"`
+			DailyTokens   int    `json:"dailyTokenQuota"`
+			DailyRequests int    `json:"dailyRequestQuota"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Label = strings.TrimSpace(req.Label)
+		if req.Label == "" || req.DailyTokens < 0 || req.DailyRequests < 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "label is required and quotas must be at least 0"})
+			return
+		}
+
+		b := make([]byte, 24)
+		rand.Read(b)
+		key := "nimb-vk-" + hex.EncodeToString(b)
+		id := make([]byte, 6)
+		rand.Read(id)
+		k := &virtualKey{
+			ID:            "vk_" + hex.EncodeToString(id),
+			Label:         req.Label,
+			Hash:          hashVirtualKey(key),
+			Hint:          keyID(key),
+			DailyTokens:   req.DailyTokens,
+			DailyRequests: req.DailyRequests,
+			CreatedAt:     time.Now().Format(time.RFC3339Nano),
+		}
+
+		a.vkeys.mu.Lock()
+		if a.vkeys.keys == nil {
+			a.vkeys.keys = map[string]*virtualKey{}
+		}
+		a.vkeys.keys[k.Hash] = k
+		err := a.saveVirtualKeysLocked()
+		a.vkeys.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		logInfof("Created virtual key %s (%s)", k.ID, k.Label)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": k.ID, "key": key})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleVirtualKey revokes a virtual key: DELETE /api/keys/{id}
+func (a *App) handleVirtualKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/keys/")
+
+	a.vkeys.mu.Lock()
+	var removed *virtualKey
+	for hash, k := range a.vkeys.keys {
+		if k.ID == id {
+			removed = k
+			delete(a.vkeys.keys, hash)
+		}
+	}
+	var err error
+	if removed != nil {
+		err = a.saveVirtualKeysLocked()
+	}
+	a.vkeys.mu.Unlock()
+
+	if removed == nil {
+		writeAPIError(w, 404, "No virtual key "+id, "not_found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	logInfof("Revoked virtual key %s (%s)", removed.ID, removed.Label)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// writeQuotaExceeded answers a virtual key that used up its quota
+func writeQuotaExceeded(w http.ResponseWriter, msg string, resetAt time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(resetAt).Seconds())+1))
+	writeAPIError(w, 429, msg, "quota_exceeded")
+}
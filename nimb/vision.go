@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"slices"
+	"strings"
+
+	_ "image/gif"
+	_ "image/png"
+)
+
+// defaultTextOnlyModels are models known to reject image input
+var defaultTextOnlyModels = []string{
+	"deepseek-ai/deepseek-v3.2",
+	"deepseek-ai/deepseek-v3.1",
+	"deepseek-ai/deepseek-r1",
+	"qwen/qwq-32b",
+	"qwen/qwen2.5-coder-32b-instruct",
+	"meta/llama-3.3-70b-instruct",
+	"meta/llama-3.1-8b-instruct",
+}
+
+// minImageSide stops downscaling before images become useless
+const minImageSide = 256
+
+// imageParts returns the image_url content parts of a message
+func imageParts(msg map[string]interface{}) []map[string]interface{} {
+	content, ok := msg["content"].([]interface{})
+	if !ok {
+		return nil
+	}
+	var parts []map[string]interface{}
+	for _, p := range content {
+		if part, ok := p.(map[string]interface{}); ok && part["type"] == "image_url" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// prepareImages inspects the image parts of an upstream payload. It returns
+// how many messages carry images, or a request error when the model is
+// text-only. Data-URI images above maxBytes are recompressed in place.
+func prepareImages(nimReq map[string]interface{}, config Config) (int, *requestError) {
+	messages, _ := nimReq["messages"].([]interface{})
+	model, _ := nimReq["model"].(string)
+
+	imageMessages := 0
+	for _, m := range messages {
+		msg, _ := m.(map[string]interface{})
+		parts := imageParts(msg)
+		if len(parts) == 0 {
+			continue
+		}
+		imageMessages++
+
+		if slices.Contains(config.TextOnlyModels, model) {
+			return imageMessages, &requestError{model + " does not accept image input", "messages"}
+		}
+		if config.ImageMaxBytes <= 0 {
+			continue
+		}
+
+		for _, part := range parts {
+			imageURL, _ := part["image_url"].(map[string]interface{})
+			uri, _ := imageURL["url"].(string)
+			if shrunk, ok := shrinkDataURI(uri, config.ImageMaxBytes); ok {
+				imageURL["url"] = shrunk
+			}
+		}
+	}
+	return imageMessages, nil
+}
+
+// shrinkDataURI re-encodes a base64 data-URI image as JPEG, halving its size
+// until it fits within maxBytes. It returns false when the image is already
+// small enough or can't be decoded, leaving the original untouched.
+func shrinkDataURI(uri string, maxBytes int) (string, bool) {
+	header, payload, ok := strings.Cut(uri, ",")
+	if !ok || !strings.HasPrefix(header, "data:image/") || !strings.HasSuffix(header, ";base64") {
+		return "", false
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil || len(data) <= maxBytes {
+		return "", false
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", false
+	}
+
+	var buf bytes.Buffer
+	for {
+		buf.Reset()
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+			return "", false
+		}
+		b := img.Bounds()
+		if buf.Len() <= maxBytes || b.Dx()/2 < minImageSide || b.Dy()/2 < minImageSide {
+			break
+		}
+		img = halveImage(img)
+	}
+
+	if buf.Len() >= len(data) {
+		return "", false
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), true
+}
+
+// halveImage downscales an image by two in each dimension, averaging each
+// 2x2 block of pixels
+func halveImage(src image.Image) image.Image {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx()/2, b.Dy()/2))
+	for y := 0; y < b.Dy()/2; y++ {
+		for x := 0; x < b.Dx()/2; x++ {
+			var r, g, bl, al uint32
+			for dy := 0; dy < 2; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					pr, pg, pb, pa := src.At(b.Min.X+2*x+dx, b.Min.Y+2*y+dy).RGBA()
+					r, g, bl, al = r+pr, g+pg, bl+pb, al+pa
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / 4), uint16(g / 4), uint16(bl / 4), uint16(al / 4)})
+		}
+	}
+	return dst
+}
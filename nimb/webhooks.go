@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Webhook event types
+const (
+	EventUpstreamErrorBurst = "upstream_error_burst"
+	EventBudgetReached      = "daily_budget_reached"
+	EventTunnelDown         = "tunnel_down"
+	EventKeyInvalid         = "key_invalid"
+	EventTunnelURL          = "tunnel_url"
+)
+
+// Webhook payload formats
+const (
+	WebhookFormatJSON    = "json"
+	WebhookFormatDiscord = "discord"
+	WebhookFormatSlack   = "slack"
+)
+
+const (
+	// webhookCooldown is the least time between two notifications of the
+	// same event, so a flapping upstream sends one message, not hundreds
+	webhookCooldown = 15 * time.Minute
+	// webhookAttempts and webhookRetryDelay bound delivery retries; the
+	// delay grows with each attempt
+	webhookAttempts   = 3
+	webhookRetryDelay = 2 * time.Second
+	webhookTimeout    = 10 * time.Second
+
+	// errorBurstCount upstream errors within errorBurstWindow make a burst
+	errorBurstCount  = 5
+	errorBurstWindow = time.Minute
+)
+
+var webhookEvents = []string{EventUpstreamErrorBurst, EventBudgetReached, EventTunnelDown, EventKeyInvalid, EventTunnelURL}
+
+// webhookState rate limits notifications and spots upstream error bursts
+type webhookState struct {
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+	errors   []time.Time
+	client   *http.Client
+}
+
+// allow reports whether event may be sent now, and if so reserves the slot
+func (s *webhookState) allow(event string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSent[event]) < webhookCooldown {
+		return false
+	}
+	if s.lastSent == nil {
+		s.lastSent = make(map[string]time.Time)
+	}
+	s.lastSent[event] = now
+	return true
+}
+
+// upstreamError records an upstream failure and reports whether it
+// completes a burst
+func (s *webhookState) upstreamError(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := 0
+	for i < len(s.errors) && now.Sub(s.errors[i]) > errorBurstWindow {
+		i++
+	}
+	s.errors = append(s.errors[i:], now)
+	return len(s.errors) == errorBurstCount
+}
+
+// noteUpstreamError counts an upstream failure towards an error burst
+func (a *App) noteUpstreamError() {
+	if a.webhooks.upstreamError(time.Now()) {
+		a.notify(EventUpstreamErrorBurst, fmt.Sprintf("%d upstream errors within %d seconds", errorBurstCount, int(errorBurstWindow.Seconds())))
+	}
+}
+
+// validateWebhook checks the webhook settings of a config being saved;
+// webhookFormat is checked with the other mode fields
+func validateWebhook(cfg Config) []fieldError {
+	var errs []fieldError
+	if cfg.WebhookURL != "" && cfg.WebhookURL != maskedValue {
+		u, err := url.Parse(cfg.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fieldError{"webhookUrl", "must be an http or https URL"})
+		}
+	}
+	for _, event := range cfg.WebhookEvents {
+		if !slices.Contains(webhookEvents, event) {
+			errs = append(errs, fieldError{"webhookEvents", fmt.Sprintf("has unknown event %q", event)})
+		}
+	}
+	return errs
+}
+
+// webhookBody builds the payload for one notification
+func webhookBody(format, event, message string, at time.Time) ([]byte, error) {
+	text := "NIMB: " + message + " (" + event + ")"
+	switch format {
+	case WebhookFormatDiscord:
+		return json.Marshal(map[string]interface{}{"content": text})
+	case WebhookFormatSlack:
+		return json.Marshal(map[string]interface{}{"text": text})
+	}
+	return json.Marshal(map[string]interface{}{
+		"service":   "NIMB Mobile",
+		"event":     event,
+		"message":   message,
+		"timestamp": at.Format(time.RFC3339),
+	})
+}
+
+// notify sends a webhook for event if one is configured for it. It returns
+// at once and is safe to call while holding a.mu; delivery happens in the
+// background.
+func (a *App) notify(event, message string) {
+	go a.sendWebhook(event, message, time.Now())
+}
+
+// sendWebhook delivers one notification, returning the error of the last
+// attempt. Every new tunnel URL is worth sending, so tunnel_url skips the
+// cooldown.
+func (a *App) sendWebhook(event, message string, at time.Time) error {
+	a.mu.RLock()
+	target, format := a.config.WebhookURL, a.config.WebhookFormat
+	wanted := slices.Contains(a.config.WebhookEvents, event)
+	a.mu.RUnlock()
+
+	if target == "" || !wanted || (event != EventTunnelURL && !a.webhooks.allow(event, at)) {
+		return nil
+	}
+	body, err := webhookBody(format, event, message, at)
+	if err != nil {
+		return err
+	}
+
+	client := a.webhooks.client
+	if client == nil {
+		client = &http.Client{Timeout: webhookTimeout}
+	}
+	for attempt := 1; ; attempt++ {
+		err = postWebhook(client, target, body)
+		if err == nil {
+			return nil
+		}
+		if attempt == webhookAttempts {
+			break
+		}
+		time.Sleep(webhookRetryDelay * time.Duration(attempt))
+	}
+	a.logError("Webhook "+event+" failed: "+err.Error(), 502)
+	return err
+}
+
+// postWebhook makes one delivery attempt. Errors leave out the URL, which
+// carries the webhook token for Discord and Slack.
+func postWebhook(client *http.Client, target string, body []byte) error {
+	resp, err := client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			return ue.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
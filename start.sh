@@ -33,9 +33,15 @@ SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
 tmux kill-session -t nimb 2>/dev/null || true
 tmux kill-session -t search-proxy 2>/dev/null || true
 
-# Start NIMB (port 3000)
-echo "Starting NIMB on port 3000..."
-tmux new-session -d -s nimb -c "$SCRIPT_DIR/nimb" "./nimb-mobile"
+# Start NIMB (port 3000 unless NIMB_PORT is set). It only accepts
+# connections from this phone unless NIMB_LAN=1.
+NIMB_PORT="${NIMB_PORT:-3000}"
+NIMB_ARGS="--port $NIMB_PORT"
+if [ "$NIMB_LAN" = "1" ]; then
+    NIMB_ARGS="$NIMB_ARGS --host 0.0.0.0 --insecure-lan"
+fi
+echo "Starting NIMB on port $NIMB_PORT..."
+tmux new-session -d -s nimb -c "$SCRIPT_DIR/nimb" "./nimb-mobile $NIMB_ARGS"
 
 # Start Search Proxy (port 4000)
 echo "Starting Search Proxy on port 4000..."
@@ -49,13 +55,17 @@ echo "============================================="
 echo "                 RUNNING!                    "
 echo "============================================="
 echo ""
-echo "  NIMB:         http://localhost:3000"
+echo "  NIMB:         http://localhost:$NIMB_PORT"
 echo "  Search Proxy: http://localhost:4000"
 echo ""
 echo "  Access from phone browser or LAN:"
 PHONE_IP=$(ip -4 addr show wlan0 2>/dev/null | grep -oP '(?<=inet\s)\d+(\.\d+){3}' | head -1)
 if [ -n "$PHONE_IP" ]; then
-    echo "  NIMB:         http://$PHONE_IP:3000"
+    if [ "$NIMB_LAN" = "1" ]; then
+        echo "  NIMB:         http://$PHONE_IP:$NIMB_PORT"
+    else
+        echo "  NIMB:         this phone only (NIMB_LAN=1 to share)"
+    fi
     echo "  Search Proxy: http://$PHONE_IP:4000"
 fi
 echo ""